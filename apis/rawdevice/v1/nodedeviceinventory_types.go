@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeDeviceInventorySpec defines the node a NodeDeviceInventory describes
+type NodeDeviceInventorySpec struct {
+	NodeName string `json:"nodeName"`
+}
+
+// InventoryDevice is a block device found on the node
+type InventoryDevice struct {
+	// Name is the device name
+	Name string `json:"name"`
+	// KernelName is the kernel name of the device, e.g. sdb or dm-0
+	KernelName string `json:"kernelName,omitempty"`
+	// Parent is the kernel name of the parent device
+	Parent string `json:"parent,omitempty"`
+	// RealPath is the device pathname, e.g. /dev/sdb
+	RealPath string `json:"realPath"`
+	// DevLinks are the persistent device paths on the host
+	DevLinks []string `json:"devLinks,omitempty"`
+	Type     string   `json:"type"`
+	// Size is the device capacity in bytes
+	Size        int64  `json:"size"`
+	Major       uint32 `json:"major"`
+	Minor       uint32 `json:"minor"`
+	UUID        string `json:"uuid,omitempty"`
+	Serial      string `json:"serial,omitempty"`
+	Vendor      string `json:"vendor,omitempty"`
+	Model       string `json:"model,omitempty"`
+	WWN         string `json:"wwn,omitempty"`
+	Rotational  bool   `json:"rotational,omitempty"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+	HasChildren bool   `json:"hasChildren,omitempty"`
+	Filesystem  string `json:"filesystem,omitempty"`
+	MountPoint  string `json:"mountPoint,omitempty"`
+	// Available is whether the device may be used for raw devices and volume groups
+	Available bool `json:"available"`
+	// Message tells why the device is not available
+	Message string `json:"message,omitempty"`
+	// Reasons are machine readable codes telling why the device is not
+	// available, e.g. Mounted, LVMPhysicalVolume or DeviceMapperHolder
+	Reasons []string `json:"reasons,omitempty"`
+	// Holders are the kernel names of the devices built on top of the device
+	Holders []string `json:"holders,omitempty"`
+	// Health is the last health reported by the device
+	Health *DeviceHealth `json:"health,omitempty"`
+	// Multipath is the state of the paths of a multipath device
+	Multipath *MultipathStatus `json:"multipath,omitempty"`
+	// Topology tells where the device is attached and how it does IO
+	Topology *DeviceTopology `json:"topology,omitempty"`
+}
+
+// MultipathStatus is the state of the paths of a multipath device, the paths
+// themselves are not listed as devices
+type MultipathStatus struct {
+	Paths []MultipathPath `json:"paths,omitempty"`
+	// ActivePaths is the number of paths in running state
+	ActivePaths int `json:"activePaths"`
+	// Degraded is whether some paths are not running
+	Degraded bool `json:"degraded"`
+}
+
+// MultipathPath is one path of a multipath device
+type MultipathPath struct {
+	// Name is the kernel name of the path, e.g. sdb
+	Name string `json:"name"`
+	// State is the scsi device state of the path, e.g. running or offline
+	State string `json:"state"`
+}
+
+// NodeDeviceInventoryStatus defines the devices last discovered on the node
+type NodeDeviceInventoryStatus struct {
+	Devices []InventoryDevice `json:"devices,omitempty"`
+	// DeviceCount is the number of devices found
+	DeviceCount int `json:"deviceCount"`
+	// AvailableCount is the number of available devices
+	AvailableCount int `json:"availableCount"`
+	// LastUpdateTime is when the devices last changed
+	LastUpdateTime *metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+//+genclient
+//+genclient:nonNamespaced
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,shortName=ndi
+//+kubebuilder:selectablefield:JSONPath=`.spec.nodeName`
+//+kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.spec.nodeName`
+//+kubebuilder:printcolumn:name="Devices",type=integer,JSONPath=`.status.deviceCount`
+//+kubebuilder:printcolumn:name="Available",type=integer,JSONPath=`.status.availableCount`
+//+kubebuilder:printcolumn:name="Updated",type=date,JSONPath=`.status.lastUpdateTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NodeDeviceInventory is the Schema for the nodedeviceinventories API, there
+// is one per node named after it
+type NodeDeviceInventory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeDeviceInventorySpec   `json:"spec,omitempty"`
+	Status NodeDeviceInventoryStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NodeDeviceInventoryList contains a list of NodeDeviceInventory
+type NodeDeviceInventoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeDeviceInventory `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodeDeviceInventory{}, &NodeDeviceInventoryList{})
+}
@@ -35,6 +35,33 @@ type RawDeviceSpec struct {
 	Minor     uint32 `json:"minor"`
 	UUID      string `json:"uuid"`
 	Available bool   `json:"available"`
+	// Rotational is true for spinning disks.
+	Rotational bool `json:"rotational,omitempty"`
+	// Lost is set when a claimed device is no longer found on its node.
+	Lost bool `json:"lost,omitempty"`
+	// Topology tells where the device is attached and how it does IO.
+	Topology *DeviceTopology `json:"topology,omitempty"`
+}
+
+// DeviceTopology tells where a device is attached and how it does IO, values
+// the device does not report are left empty
+type DeviceTopology struct {
+	// NVMeNamespaceID is the namespace id of a NVMe namespace
+	NVMeNamespaceID uint32 `json:"nvmeNamespaceId,omitempty"`
+	// NVMeController is the kernel name of the controller of a NVMe namespace, e.g. nvme0
+	NVMeController string `json:"nvmeController,omitempty"`
+	// PCIAddress is the address of the PCI function the device is attached to, e.g. 0000:00:04.0
+	PCIAddress string `json:"pciAddress,omitempty"`
+	// NUMANode is the NUMA node of the PCI function
+	NUMANode *int32 `json:"numaNode,omitempty"`
+	// LogicalBlockSize is the smallest unit the device addresses in bytes, 4096 for 4Kn devices
+	LogicalBlockSize int64 `json:"logicalBlockSize,omitempty"`
+	// PhysicalBlockSize is the smallest unit the device writes without a read-modify-write in bytes
+	PhysicalBlockSize int64 `json:"physicalBlockSize,omitempty"`
+	// Discard is whether the device supports discard
+	Discard bool `json:"discard,omitempty"`
+	// QueueDepth is the depth of the device queue
+	QueueDepth int64 `json:"queueDepth,omitempty"`
 }
 
 // RawDeviceStatus defines the observed state of RawDevice
@@ -42,6 +69,41 @@ type RawDeviceStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	Name string `json:"name"`
+	// Health is the last health reported by the device
+	Health *DeviceHealth `json:"health,omitempty"`
+	// Conditions holds the Healthy condition of the device
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ConditionHealthy tells whether the device reports itself healthy
+	ConditionHealthy = "Healthy"
+)
+
+// DeviceHealth is the health a device reports through SMART or the NVMe
+// smart log, counters the device does not report are left empty
+type DeviceHealth struct {
+	// Healthy is false when the device failed its self assessment or a
+	// counter shows it is wearing out
+	Healthy bool `json:"healthy"`
+	// Reason tells why the device is unhealthy
+	Reason string `json:"reason,omitempty"`
+	// SmartPassed is the overall SMART self assessment
+	SmartPassed *bool `json:"smartPassed,omitempty"`
+	// Temperature is the current temperature in Celsius
+	Temperature *int64 `json:"temperature,omitempty"`
+	// PercentageUsed is the NVMe estimate of the device life used
+	PercentageUsed *int64 `json:"percentageUsed,omitempty"`
+	// MediaErrors is the NVMe count of unrecovered data integrity errors
+	MediaErrors *int64 `json:"mediaErrors,omitempty"`
+	// CriticalWarning is the NVMe critical warning bitmap
+	CriticalWarning *int64 `json:"criticalWarning,omitempty"`
+	// ReallocatedSectors is the count of remapped sectors
+	ReallocatedSectors *int64 `json:"reallocatedSectors,omitempty"`
+	// PendingSectors is the count of unstable sectors waiting to be remapped
+	PendingSectors *int64      `json:"pendingSectors,omitempty"`
+	PowerOnHours   *int64      `json:"powerOnHours,omitempty"`
+	LastCheckTime  metav1.Time `json:"lastCheckTime"`
 }
 
 //+genclient
@@ -1,3 +1,5 @@
 // +kubebuilder:rbac:groups=nativestor.alauda.io,resources=rawdevices,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=nativestor.alauda.io,resources=rawdevices/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=nativestor.alauda.io,resources=nodedeviceinventories,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=nativestor.alauda.io,resources=nodedeviceinventories/status,verbs=get;update;patch
 package v1
@@ -22,16 +22,273 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RawDevice) DeepCopyInto(out *RawDevice) {
+func (in *DeviceHealth) DeepCopyInto(out *DeviceHealth) {
+	*out = *in
+	if in.SmartPassed != nil {
+		in, out := &in.SmartPassed, &out.SmartPassed
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Temperature != nil {
+		in, out := &in.Temperature, &out.Temperature
+		*out = new(int64)
+		**out = **in
+	}
+	if in.PercentageUsed != nil {
+		in, out := &in.PercentageUsed, &out.PercentageUsed
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MediaErrors != nil {
+		in, out := &in.MediaErrors, &out.MediaErrors
+		*out = new(int64)
+		**out = **in
+	}
+	if in.CriticalWarning != nil {
+		in, out := &in.CriticalWarning, &out.CriticalWarning
+		*out = new(int64)
+		**out = **in
+	}
+	if in.ReallocatedSectors != nil {
+		in, out := &in.ReallocatedSectors, &out.ReallocatedSectors
+		*out = new(int64)
+		**out = **in
+	}
+	if in.PendingSectors != nil {
+		in, out := &in.PendingSectors, &out.PendingSectors
+		*out = new(int64)
+		**out = **in
+	}
+	if in.PowerOnHours != nil {
+		in, out := &in.PowerOnHours, &out.PowerOnHours
+		*out = new(int64)
+		**out = **in
+	}
+	in.LastCheckTime.DeepCopyInto(&out.LastCheckTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceHealth.
+func (in *DeviceHealth) DeepCopy() *DeviceHealth {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceHealth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceTopology) DeepCopyInto(out *DeviceTopology) {
+	*out = *in
+	if in.NUMANode != nil {
+		in, out := &in.NUMANode, &out.NUMANode
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceTopology.
+func (in *DeviceTopology) DeepCopy() *DeviceTopology {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceTopology)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InventoryDevice) DeepCopyInto(out *InventoryDevice) {
+	*out = *in
+	if in.DevLinks != nil {
+		in, out := &in.DevLinks, &out.DevLinks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Reasons != nil {
+		in, out := &in.Reasons, &out.Reasons
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Holders != nil {
+		in, out := &in.Holders, &out.Holders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Health != nil {
+		in, out := &in.Health, &out.Health
+		*out = new(DeviceHealth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Multipath != nil {
+		in, out := &in.Multipath, &out.Multipath
+		*out = new(MultipathStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Topology != nil {
+		in, out := &in.Topology, &out.Topology
+		*out = new(DeviceTopology)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InventoryDevice.
+func (in *InventoryDevice) DeepCopy() *InventoryDevice {
+	if in == nil {
+		return nil
+	}
+	out := new(InventoryDevice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MultipathPath) DeepCopyInto(out *MultipathPath) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MultipathPath.
+func (in *MultipathPath) DeepCopy() *MultipathPath {
+	if in == nil {
+		return nil
+	}
+	out := new(MultipathPath)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MultipathStatus) DeepCopyInto(out *MultipathStatus) {
+	*out = *in
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]MultipathPath, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MultipathStatus.
+func (in *MultipathStatus) DeepCopy() *MultipathStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MultipathStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDeviceInventory) DeepCopyInto(out *NodeDeviceInventory) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDeviceInventory.
+func (in *NodeDeviceInventory) DeepCopy() *NodeDeviceInventory {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDeviceInventory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NodeDeviceInventory) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDeviceInventoryList) DeepCopyInto(out *NodeDeviceInventoryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NodeDeviceInventory, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDeviceInventoryList.
+func (in *NodeDeviceInventoryList) DeepCopy() *NodeDeviceInventoryList {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDeviceInventoryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NodeDeviceInventoryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDeviceInventorySpec) DeepCopyInto(out *NodeDeviceInventorySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDeviceInventorySpec.
+func (in *NodeDeviceInventorySpec) DeepCopy() *NodeDeviceInventorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDeviceInventorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDeviceInventoryStatus) DeepCopyInto(out *NodeDeviceInventoryStatus) {
+	*out = *in
+	if in.Devices != nil {
+		in, out := &in.Devices, &out.Devices
+		*out = make([]InventoryDevice, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastUpdateTime != nil {
+		in, out := &in.LastUpdateTime, &out.LastUpdateTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDeviceInventoryStatus.
+func (in *NodeDeviceInventoryStatus) DeepCopy() *NodeDeviceInventoryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDeviceInventoryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RawDevice) DeepCopyInto(out *RawDevice) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RawDevice.
@@ -87,6 +344,11 @@ func (in *RawDeviceList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RawDeviceSpec) DeepCopyInto(out *RawDeviceSpec) {
 	*out = *in
+	if in.Topology != nil {
+		in, out := &in.Topology, &out.Topology
+		*out = new(DeviceTopology)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RawDeviceSpec.
@@ -102,6 +364,18 @@ func (in *RawDeviceSpec) DeepCopy() *RawDeviceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RawDeviceStatus) DeepCopyInto(out *RawDeviceStatus) {
 	*out = *in
+	if in.Health != nil {
+		in, out := &in.Health, &out.Health
+		*out = new(DeviceHealth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RawDeviceStatus.
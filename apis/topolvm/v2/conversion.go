@@ -0,0 +1,519 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	topolvmv3 "github.com/alauda/nativestor/apis/topolvm/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+const (
+	// V3SpecAnnotation keeps the v3 spec of a cluster read as v2 when v2 can
+	// not hold all of it, e.g. class templates and node group names
+	V3SpecAnnotation = "topolvm.cybozu.com/v3-spec"
+	// V2SpecAnnotation keeps the v2 spec of a cluster read as v3 when v3 can
+	// not hold all of it, e.g. useAllDevices and the order of loops
+	V2SpecAnnotation = "topolvm.cybozu.com/v2-spec"
+
+	// AllNodesGroup is the v3 node group of the nodes of useAllNodes
+	AllNodesGroup = "all-nodes"
+
+	diskType = "disk"
+	loopType = "loop"
+)
+
+var _ conversion.Convertible = &TopolvmCluster{}
+
+// keptSpec is the spec of the other version kept in an annotation, it is
+// restored as long as the spec it was converted to keeps its hash
+type keptSpec struct {
+	Hash string          `json:"hash"`
+	Spec json.RawMessage `json:"spec"`
+}
+
+// ConvertTo converts the cluster to the v3 hub.
+func (src *TopolvmCluster) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*topolvmv3.TopolvmCluster)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	removeAnnotation(&dst.ObjectMeta, V3SpecAnnotation)
+
+	if !restoreSpec(src.Annotations[V3SpecAnnotation], &src.Spec, &dst.Spec) {
+		dst.Spec = SpecToV3(&src.Spec)
+	}
+	if back, err := SpecFromV3(&dst.Spec); err != nil || !sameJSON(back, &src.Spec) {
+		if err := keepSpec(&dst.ObjectMeta, V2SpecAnnotation, &dst.Spec, &src.Spec); err != nil {
+			return err
+		}
+	}
+	return transcode(&src.Status, &dst.Status)
+}
+
+// ConvertFrom converts the v3 hub to the cluster, it fails for clusters
+// using what v2 can not express.
+func (dst *TopolvmCluster) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*topolvmv3.TopolvmCluster)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	removeAnnotation(&dst.ObjectMeta, V2SpecAnnotation)
+
+	if !restoreSpec(src.Annotations[V2SpecAnnotation], &src.Spec, &dst.Spec) {
+		spec, err := SpecFromV3(&src.Spec)
+		if err != nil {
+			return fmt.Errorf("cluster %s can not be converted to %s: %v", src.Name, GroupVersion, err)
+		}
+		dst.Spec = *spec
+	}
+	if back := SpecToV3(&dst.Spec); !sameJSON(&back, &src.Spec) {
+		if err := keepSpec(&dst.ObjectMeta, V3SpecAnnotation, &dst.Spec, &src.Spec); err != nil {
+			return err
+		}
+	}
+	return transcode(&src.Status, &dst.Status)
+}
+
+// SpecToV3 converts a v2 spec. useAllNodes becomes a node group of every
+// node, every device class node a group of one node and every node class
+// selector a group of the nodes it selects.
+func SpecToV3(in *TopolvmClusterSpec) topolvmv3.TopolvmClusterSpec {
+	spec := topolvmv3.TopolvmClusterSpec{
+		TopoLVM: topolvmv3.TopoLVMSpec{
+			Image:           in.TopolvmVersion,
+			CertsSecret:     in.CertsSecret,
+			UpgradeStrategy: upgradeStrategyToV3(in.UpgradeStrategy),
+		},
+		CleanUp:      in.CleanUp,
+		Decommission: decommissionToV3(in.Decommission),
+	}
+
+	storage := &in.Storage
+	if storage.UseAllNodes || storage.UseAllDevices || storage.Devices != nil {
+		class := topolvmv3.DeviceClass{
+			Name:          storage.ClassName,
+			VolumeGroup:   storage.VolumeGroupName,
+			ClassSettings: topolvmv3.ClassSettings{Default: true},
+		}
+		class.Devices, class.Loops = devicesToV3(storage.Devices)
+		if storage.UseAllDevices {
+			class.DeviceSelector = &topolvmv3.DeviceSelector{}
+		}
+		spec.NodeGroups = append(spec.NodeGroups, topolvmv3.NodeGroup{Name: AllNodesGroup, Classes: []topolvmv3.DeviceClass{class}})
+	}
+
+	for _, node := range storage.DeviceClasses {
+		group := topolvmv3.NodeGroup{Name: node.NodeName, NodeName: node.NodeName, Classes: []topolvmv3.DeviceClass{}}
+		for _, c := range node.DeviceClasses {
+			class := topolvmv3.DeviceClass{
+				Name:        c.ClassName,
+				VolumeGroup: c.VgName,
+				ClassSettings: topolvmv3.ClassSettings{
+					Default:    c.Default,
+					SpareGb:    c.SpareGb,
+					Stripe:     c.Stripe,
+					StripeSize: c.StripeSize,
+					ThinPool:   thinPoolToV3(c.ThinPool),
+					Raid:       raidToV3(c.Raid),
+				},
+				Adopt:        c.Adopt,
+				Cache:        cacheToV3(c.Cache),
+				RepairDevice: c.RepairDevice,
+			}
+			class.Devices, class.Loops = devicesToV3(c.Device)
+			group.Classes = append(group.Classes, class)
+		}
+		spec.NodeGroups = append(spec.NodeGroups, group)
+	}
+
+	for index, selector := range storage.NodeClasses {
+		group := topolvmv3.NodeGroup{Name: fmt.Sprintf("node-classes-%d", index), NodeSelector: selector.NodeSelector.DeepCopy(), Classes: []topolvmv3.DeviceClass{}}
+		for _, c := range selector.Classes {
+			deviceSelector := topolvmv3.DeviceSelector(*c.DeviceSelector.DeepCopy())
+			group.Classes = append(group.Classes, topolvmv3.DeviceClass{
+				Name:        c.ClassName,
+				VolumeGroup: c.VgName,
+				ClassSettings: topolvmv3.ClassSettings{
+					Default:    c.Default,
+					SpareGb:    c.SpareGb,
+					Stripe:     c.Stripe,
+					StripeSize: c.StripeSize,
+					ThinPool:   thinPoolToV3(c.ThinPool),
+					Raid:       raidToV3(c.Raid),
+				},
+				Adopt:          c.Adopt,
+				Cache:          cacheToV3(c.Cache),
+				DeviceSelector: &deviceSelector,
+			})
+		}
+		spec.NodeGroups = append(spec.NodeGroups, group)
+	}
+	return spec
+}
+
+// SpecFromV3 converts a v3 spec. Class templates are filled into the classes
+// using them, node groups of a named node with classes picking devices by
+// selector become node class selectors of the node hostname label.
+func SpecFromV3(in *topolvmv3.TopolvmClusterSpec) (*TopolvmClusterSpec, error) {
+
+	templates := make(map[string]*topolvmv3.ClassSettings)
+	for index := range in.ClassTemplates {
+		template := &in.ClassTemplates[index]
+		if _, ok := templates[template.Name]; ok {
+			return nil, fmt.Errorf("class template %s is defined twice", template.Name)
+		}
+		templates[template.Name] = &template.ClassSettings
+	}
+
+	spec := &TopolvmClusterSpec{
+		TopolvmVersion:  in.TopoLVM.Image,
+		CertsSecret:     in.TopoLVM.CertsSecret,
+		CleanUp:         in.CleanUp,
+		UpgradeStrategy: upgradeStrategyFromV3(in.TopoLVM.UpgradeStrategy),
+		Decommission:    decommissionFromV3(in.Decommission),
+	}
+	groups := make(map[string]bool)
+	for _, group := range in.NodeGroups {
+		if groups[group.Name] {
+			return nil, fmt.Errorf("node group %s is defined twice", group.Name)
+		}
+		groups[group.Name] = true
+		if group.NodeName != "" && group.NodeSelector != nil {
+			return nil, fmt.Errorf("node group %s should not set both nodeName and nodeSelector", group.Name)
+		}
+
+		selecting := 0
+		for _, c := range group.Classes {
+			if c.DeviceSelector == nil {
+				continue
+			}
+			if len(c.Devices) > 0 || len(c.Loops) > 0 {
+				return nil, fmt.Errorf("class %s of node group %s should not use a device selector along with devices or loops", c.Name, group.Name)
+			}
+			selecting++
+		}
+		if selecting > 0 && selecting != len(group.Classes) {
+			return nil, fmt.Errorf("node group %s should not mix classes picking devices by selector with classes listing devices", group.Name)
+		}
+
+		switch {
+		case selecting > 0:
+			selector := NodeClassSelector{NodeSelector: group.NodeSelector.DeepCopy(), Classes: []SelectedDeviceClass{}}
+			if group.NodeName != "" {
+				selector.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelHostname: group.NodeName}}
+			}
+			for index := range group.Classes {
+				c := &group.Classes[index]
+				if c.RepairDevice != "" {
+					return nil, fmt.Errorf("class %s of node group %s picks its devices by selector and can not set repairDevice", c.Name, group.Name)
+				}
+				settings, err := classSettings(c, templates)
+				if err != nil {
+					return nil, err
+				}
+				selector.Classes = append(selector.Classes, SelectedDeviceClass{
+					ClassName:      c.Name,
+					VgName:         c.VolumeGroup,
+					Default:        settings.Default,
+					SpareGb:        settings.SpareGb,
+					Stripe:         settings.Stripe,
+					StripeSize:     settings.StripeSize,
+					Adopt:          c.Adopt,
+					ThinPool:       thinPoolFromV3(settings.ThinPool),
+					Raid:           raidFromV3(settings.Raid),
+					Cache:          cacheFromV3(c.Cache),
+					DeviceSelector: DeviceSelector(*c.DeviceSelector.DeepCopy()),
+				})
+			}
+			spec.NodeClasses = append(spec.NodeClasses, selector)
+
+		case group.NodeName != "":
+			node := NodeDevices{NodeName: group.NodeName, DeviceClasses: []DeviceClass{}}
+			for index := range group.Classes {
+				c := &group.Classes[index]
+				settings, err := classSettings(c, templates)
+				if err != nil {
+					return nil, err
+				}
+				node.DeviceClasses = append(node.DeviceClasses, DeviceClass{
+					ClassName:    c.Name,
+					VgName:       c.VolumeGroup,
+					Device:       devicesFromV3(c.Devices, c.Loops),
+					Default:      settings.Default,
+					SpareGb:      settings.SpareGb,
+					Stripe:       settings.Stripe,
+					StripeSize:   settings.StripeSize,
+					Adopt:        c.Adopt,
+					ThinPool:     thinPoolFromV3(settings.ThinPool),
+					Raid:         raidFromV3(settings.Raid),
+					RepairDevice: c.RepairDevice,
+					Cache:        cacheFromV3(c.Cache),
+				})
+				if len(c.Loops) > 0 {
+					spec.UseLoop = true
+				}
+			}
+			spec.DeviceClasses = append(spec.DeviceClasses, node)
+
+		case group.NodeSelector != nil:
+			return nil, fmt.Errorf("classes of node group %s selecting nodes by label should pick their devices by selector", group.Name)
+
+		default:
+			if spec.UseAllNodes {
+				return nil, fmt.Errorf("node group %s lists devices for every node, only one node group can", group.Name)
+			}
+			if len(group.Classes) != 1 {
+				return nil, fmt.Errorf("node group %s lists devices for every node and should have exactly one class", group.Name)
+			}
+			c := &group.Classes[0]
+			settings, err := classSettings(c, templates)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(*settings, topolvmv3.ClassSettings{Default: settings.Default}) || c.Adopt || c.Cache != nil || c.RepairDevice != "" {
+				return nil, fmt.Errorf("class %s of node group %s lists devices for every node and can only set its devices and loops", c.Name, group.Name)
+			}
+			spec.UseAllNodes = true
+			spec.ClassName = c.Name
+			spec.VolumeGroupName = c.VolumeGroup
+			spec.Devices = devicesFromV3(c.Devices, c.Loops)
+			if len(c.Loops) > 0 {
+				spec.UseLoop = true
+			}
+		}
+	}
+	return spec, nil
+}
+
+// classSettings returns the settings of the class, those of its template if
+// it names one.
+func classSettings(class *topolvmv3.DeviceClass, templates map[string]*topolvmv3.ClassSettings) (*topolvmv3.ClassSettings, error) {
+	if class.Template == "" {
+		return &class.ClassSettings, nil
+	}
+	template, ok := templates[class.Template]
+	if !ok {
+		return nil, fmt.Errorf("class %s uses unknown class template %s", class.Name, class.Template)
+	}
+	if !reflect.DeepEqual(class.ClassSettings, topolvmv3.ClassSettings{}) {
+		return nil, fmt.Errorf("class %s takes its settings from class template %s and should not set them itself", class.Name, class.Template)
+	}
+	return template, nil
+}
+
+func devicesToV3(disks []Disk) ([]topolvmv3.Device, []topolvmv3.LoopDevice) {
+	var devices []topolvmv3.Device
+	var loops []topolvmv3.LoopDevice
+	for _, d := range disks {
+		if d.Type != loopType {
+			devices = append(devices, topolvmv3.Device{Name: d.Name})
+			continue
+		}
+		loop := topolvmv3.LoopDevice{Name: d.Name}
+		if d.Auto {
+			loop.File = &topolvmv3.LoopFile{Path: d.Path, SizeGb: d.Size}
+		}
+		loops = append(loops, loop)
+	}
+	return devices, loops
+}
+
+func devicesFromV3(devices []topolvmv3.Device, loops []topolvmv3.LoopDevice) []Disk {
+	var disks []Disk
+	for _, d := range devices {
+		disks = append(disks, Disk{Name: d.Name, Type: diskType})
+	}
+	for _, l := range loops {
+		disk := Disk{Name: l.Name, Type: loopType}
+		if l.File != nil {
+			disk.Auto = true
+			disk.Path = l.File.Path
+			disk.Size = l.File.SizeGb
+		}
+		disks = append(disks, disk)
+	}
+	return disks
+}
+
+func upgradeStrategyToV3(in *UpgradeStrategy) *topolvmv3.UpgradeStrategy {
+	if in == nil {
+		return nil
+	}
+	out := topolvmv3.UpgradeStrategy(*in.DeepCopy())
+	return &out
+}
+
+func upgradeStrategyFromV3(in *topolvmv3.UpgradeStrategy) *UpgradeStrategy {
+	if in == nil {
+		return nil
+	}
+	out := UpgradeStrategy(*in.DeepCopy())
+	return &out
+}
+
+func decommissionToV3(in *DecommissionPolicy) *topolvmv3.DecommissionPolicy {
+	if in == nil {
+		return nil
+	}
+	out := topolvmv3.DecommissionPolicy(*in)
+	return &out
+}
+
+func decommissionFromV3(in *topolvmv3.DecommissionPolicy) *DecommissionPolicy {
+	if in == nil {
+		return nil
+	}
+	out := DecommissionPolicy(*in)
+	return &out
+}
+
+func thinPoolToV3(in *ThinPoolConfig) *topolvmv3.ThinPoolConfig {
+	if in == nil {
+		return nil
+	}
+	out := topolvmv3.ThinPoolConfig(*in)
+	return &out
+}
+
+func thinPoolFromV3(in *topolvmv3.ThinPoolConfig) *ThinPoolConfig {
+	if in == nil {
+		return nil
+	}
+	out := ThinPoolConfig(*in)
+	return &out
+}
+
+func raidToV3(in *RaidConfig) *topolvmv3.RaidConfig {
+	if in == nil {
+		return nil
+	}
+	return &topolvmv3.RaidConfig{Type: topolvmv3.RaidType(in.Type), Mirrors: in.Mirrors, Stripes: in.Stripes}
+}
+
+func raidFromV3(in *topolvmv3.RaidConfig) *RaidConfig {
+	if in == nil {
+		return nil
+	}
+	return &RaidConfig{Type: RaidType(in.Type), Mirrors: in.Mirrors, Stripes: in.Stripes}
+}
+
+func cacheToV3(in *CacheConfig) *topolvmv3.CacheConfig {
+	if in == nil {
+		return nil
+	}
+	out := &topolvmv3.CacheConfig{
+		Type:        topolvmv3.CacheType(in.Type),
+		Mode:        topolvmv3.CacheMode(in.Mode),
+		SizePercent: in.SizePercent,
+		Devices:     []topolvmv3.Device{},
+	}
+	for _, d := range in.Devices {
+		out.Devices = append(out.Devices, topolvmv3.Device{Name: d.Name})
+	}
+	return out
+}
+
+func cacheFromV3(in *topolvmv3.CacheConfig) *CacheConfig {
+	if in == nil {
+		return nil
+	}
+	out := &CacheConfig{
+		Type:        CacheType(in.Type),
+		Mode:        CacheMode(in.Mode),
+		SizePercent: in.SizePercent,
+		Devices:     []Disk{},
+	}
+	for _, d := range in.Devices {
+		out.Devices = append(out.Devices, Disk{Name: d.Name, Type: diskType})
+	}
+	return out
+}
+
+// keepSpec keeps spec in the annotation key of meta, along with the hash of
+// the spec it was converted to.
+func keepSpec(meta *metav1.ObjectMeta, key string, converted, spec interface{}) error {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	hash, err := specHash(converted)
+	if err != nil {
+		return err
+	}
+	kept, err := json.Marshal(keptSpec{Hash: hash, Spec: raw})
+	if err != nil {
+		return err
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[key] = string(kept)
+	return nil
+}
+
+// restoreSpec restores the spec kept in annotation into spec, if converted
+// is still the spec it was converted to.
+func restoreSpec(annotation string, converted, spec interface{}) bool {
+	if annotation == "" {
+		return false
+	}
+	kept := keptSpec{}
+	if err := json.Unmarshal([]byte(annotation), &kept); err != nil {
+		return false
+	}
+	if hash, err := specHash(converted); err != nil || hash != kept.Hash {
+		return false
+	}
+	return json.Unmarshal(kept.Spec, spec) == nil
+}
+
+func specHash(spec interface{}) (string, error) {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func sameJSON(a, b interface{}) bool {
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+func removeAnnotation(meta *metav1.ObjectMeta, key string) {
+	delete(meta.Annotations, key)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+}
+
+// transcode copies between the status types of v2 and v3, which have the
+// same fields.
+func transcode(in, out interface{}) error {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
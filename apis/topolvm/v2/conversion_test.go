@@ -0,0 +1,213 @@
+package v2
+
+import (
+	"testing"
+
+	topolvmv3 "github.com/alauda/nativestor/apis/topolvm/v3"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func v2Cluster(storage Storage) *TopolvmCluster {
+	maxUnavailable := intstr.FromString("25%")
+	return &TopolvmCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "nativestor-system", Generation: 2},
+		Spec: TopolvmClusterSpec{
+			TopolvmVersion:  "topolvm:v1",
+			CertsSecret:     "certs",
+			Storage:         storage,
+			CleanUp:         true,
+			UpgradeStrategy: &UpgradeStrategy{MaxUnavailable: &maxUnavailable, NodeTimeoutSeconds: 300},
+			Decommission:    &DecommissionPolicy{WipeDevices: true},
+		},
+		Status: TopolvmClusterStatus{
+			Phase:              ConditionReady,
+			ObservedGeneration: 2,
+			NodeStorageStatus: []NodeStorageState{{
+				Node:           "node1",
+				Phase:          ConditionReady,
+				SuccessClasses: []ClassState{{Name: "hdd", VgName: "hdd", State: ClassReady}},
+				Loops:          []LoopState{{Name: "loop", File: "/data/f", DeviceName: "/dev/loop0", Status: "successful"}},
+			}},
+			NodePluginRollout:    &NodePluginRollout{Image: "topolvm:v1", Phase: RolloutPaused, TotalNodes: 1, FailedNodes: []string{"node1"}},
+			DecommissioningNodes: []NodeDecommission{{Node: "node2", Phase: DecommissionDraining, LogicalVolumes: 1, Blockers: []string{"logical volumes pvc-1 are left"}}},
+		},
+	}
+}
+
+func roundTripV2(t *testing.T, cluster *TopolvmCluster) *topolvmv3.TopolvmCluster {
+	hub := &topolvmv3.TopolvmCluster{}
+	assert.NoError(t, cluster.ConvertTo(hub))
+	back := &TopolvmCluster{}
+	assert.NoError(t, back.ConvertFrom(hub))
+	assert.Equal(t, cluster, back)
+	return hub
+}
+
+func TestConvertDeviceClasses(t *testing.T) {
+	minSize := resource.MustParse("100Gi")
+	cluster := v2Cluster(Storage{
+		UseLoop: true,
+		DeviceClasses: []NodeDevices{{
+			NodeName: "node1",
+			DeviceClasses: []DeviceClass{
+				{
+					ClassName:    "hdd",
+					VgName:       "hdd",
+					Device:       []Disk{{Name: "/dev/sdb", Type: "disk"}, {Name: "loop", Type: "loop", Auto: true, Path: "/data", Size: 5}},
+					Default:      true,
+					SpareGb:      10,
+					Raid:         &RaidConfig{Type: Raid1, Mirrors: 1},
+					RepairDevice: "/dev/sdb",
+					Cache:        &CacheConfig{Type: CacheTypeWritecache, Devices: []Disk{{Name: "/dev/nvme0n1", Type: "disk"}}},
+				},
+				{
+					ClassName: "thin",
+					VgName:    "thin",
+					Device:    []Disk{{Name: "/dev/sdc", Type: "disk"}},
+					ThinPool:  &ThinPoolConfig{Name: "pool", SizePercent: 90, OverprovisionRatio: "5.0"},
+				},
+			},
+		}},
+		NodeClasses: []NodeClassSelector{{
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "ssd"}},
+			Classes: []SelectedDeviceClass{{
+				ClassName:      "ssd",
+				VgName:         "ssd",
+				Cache:          &CacheConfig{SizePercent: 20, Devices: []Disk{{Name: "/dev/pmem0", Type: "disk"}}},
+				DeviceSelector: DeviceSelector{MinSize: &minSize},
+			}},
+		}},
+	})
+
+	hub := roundTripV2(t, cluster)
+	assert.Empty(t, hub.Annotations)
+	maxUnavailable := intstr.FromString("25%")
+	assert.Equal(t, topolvmv3.TopoLVMSpec{
+		Image:           "topolvm:v1",
+		CertsSecret:     "certs",
+		UpgradeStrategy: &topolvmv3.UpgradeStrategy{MaxUnavailable: &maxUnavailable, NodeTimeoutSeconds: 300},
+	}, hub.Spec.TopoLVM)
+	assert.Len(t, hub.Spec.NodeGroups, 2)
+	node := hub.Spec.NodeGroups[0]
+	assert.Equal(t, "node1", node.NodeName)
+	assert.Equal(t, []topolvmv3.Device{{Name: "/dev/sdb"}}, node.Classes[0].Devices)
+	assert.Equal(t, []topolvmv3.LoopDevice{{Name: "loop", File: &topolvmv3.LoopFile{Path: "/data", SizeGb: 5}}}, node.Classes[0].Loops)
+	assert.Equal(t, "/dev/sdb", node.Classes[0].RepairDevice)
+	selected := hub.Spec.NodeGroups[1]
+	assert.Equal(t, map[string]string{"pool": "ssd"}, selected.NodeSelector.MatchLabels)
+	assert.Equal(t, &minSize, selected.Classes[0].DeviceSelector.MinSize)
+	assert.Equal(t, &topolvmv3.CacheConfig{SizePercent: 20, Devices: []topolvmv3.Device{{Name: "/dev/pmem0"}}}, selected.Classes[0].Cache)
+	assert.Equal(t, cluster.Status.NodeStorageStatus[0].Loops[0].DeviceName, hub.Status.NodeStorageStatus[0].Loops[0].DeviceName)
+	assert.Equal(t, []string{"node1"}, hub.Status.NodePluginRollout.FailedNodes)
+	assert.Equal(t, &topolvmv3.DecommissionPolicy{WipeDevices: true}, hub.Spec.Decommission)
+	assert.Equal(t, int32(1), hub.Status.DecommissioningNodes[0].LogicalVolumes)
+}
+
+func TestConvertUseAllNodes(t *testing.T) {
+	cluster := v2Cluster(Storage{UseAllNodes: true, ClassName: "hdd", VolumeGroupName: "hdd", Devices: []Disk{{Name: "/dev/sdb", Type: "disk"}}})
+	hub := roundTripV2(t, cluster)
+	assert.Empty(t, hub.Annotations)
+	assert.Equal(t, []topolvmv3.NodeGroup{{
+		Name: AllNodesGroup,
+		Classes: []topolvmv3.DeviceClass{{
+			Name:          "hdd",
+			VolumeGroup:   "hdd",
+			ClassSettings: topolvmv3.ClassSettings{Default: true},
+			Devices:       []topolvmv3.Device{{Name: "/dev/sdb"}},
+		}},
+	}}, hub.Spec.NodeGroups)
+
+	// v3 picks every device with an empty device selector, which reads back
+	// as a node class selector, so the v2 spec is kept
+	cluster = v2Cluster(Storage{UseAllNodes: true, UseAllDevices: true, ClassName: "hdd", VolumeGroupName: "hdd"})
+	hub = roundTripV2(t, cluster)
+	assert.Contains(t, hub.Annotations, V2SpecAnnotation)
+	assert.Equal(t, &topolvmv3.DeviceSelector{}, hub.Spec.NodeGroups[0].Classes[0].DeviceSelector)
+
+	// once the v3 spec changes it is converted again
+	hub.Spec.NodeGroups[0].Classes[0].VolumeGroup = "hdd2"
+	back := &TopolvmCluster{}
+	assert.NoError(t, back.ConvertFrom(hub))
+	assert.False(t, back.Spec.UseAllNodes)
+	assert.Equal(t, []NodeClassSelector{{Classes: []SelectedDeviceClass{{ClassName: "hdd", VgName: "hdd2", Default: true}}}}, back.Spec.NodeClasses)
+	assert.NotContains(t, back.Annotations, V2SpecAnnotation)
+}
+
+func TestConvertV3(t *testing.T) {
+	hub := &topolvmv3.TopolvmCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "nativestor-system", Annotations: map[string]string{"a": "b"}},
+		Spec: topolvmv3.TopolvmClusterSpec{
+			TopoLVM:        topolvmv3.TopoLVMSpec{Image: "topolvm:v1"},
+			ClassTemplates: []topolvmv3.ClassTemplate{{Name: "fast", ClassSettings: topolvmv3.ClassSettings{Default: true, SpareGb: 5}}},
+			NodeGroups: []topolvmv3.NodeGroup{
+				{
+					Name:     "first",
+					NodeName: "node1",
+					Classes:  []topolvmv3.DeviceClass{{Name: "ssd", VolumeGroup: "ssd", Template: "fast", DeviceSelector: &topolvmv3.DeviceSelector{Paths: []string{"/dev/nvme*"}}}},
+				},
+				{
+					Name:     "second",
+					NodeName: "node2",
+					Classes:  []topolvmv3.DeviceClass{{Name: "ssd", VolumeGroup: "ssd", Template: "fast", Loops: []topolvmv3.LoopDevice{{Name: "/dev/loop1"}}}},
+				},
+			},
+		},
+	}
+
+	cluster := &TopolvmCluster{}
+	assert.NoError(t, cluster.ConvertFrom(hub))
+	assert.Contains(t, cluster.Annotations, V3SpecAnnotation)
+	assert.Equal(t, "b", cluster.Annotations["a"])
+	assert.True(t, cluster.Spec.UseLoop)
+	assert.Equal(t, []NodeClassSelector{{
+		NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelHostname: "node1"}},
+		Classes:      []SelectedDeviceClass{{ClassName: "ssd", VgName: "ssd", Default: true, SpareGb: 5, DeviceSelector: DeviceSelector{Paths: []string{"/dev/nvme*"}}}},
+	}}, cluster.Spec.NodeClasses)
+	assert.Equal(t, []NodeDevices{{
+		NodeName:      "node2",
+		DeviceClasses: []DeviceClass{{ClassName: "ssd", VgName: "ssd", Default: true, SpareGb: 5, Device: []Disk{{Name: "/dev/loop1", Type: "loop"}}}},
+	}}, cluster.Spec.DeviceClasses)
+
+	back := &topolvmv3.TopolvmCluster{}
+	assert.NoError(t, cluster.ConvertTo(back))
+	assert.Equal(t, hub, back)
+}
+
+func TestConvertV3Unsupported(t *testing.T) {
+	for name, spec := range map[string]topolvmv3.TopolvmClusterSpec{
+		"selected nodes listing devices": {NodeGroups: []topolvmv3.NodeGroup{{
+			Name:         "group",
+			NodeSelector: &metav1.LabelSelector{},
+			Classes:      []topolvmv3.DeviceClass{{Name: "hdd", VolumeGroup: "hdd", Devices: []topolvmv3.Device{{Name: "/dev/sdb"}}}},
+		}}},
+		"every node with two classes": {NodeGroups: []topolvmv3.NodeGroup{{
+			Name: "group",
+			Classes: []topolvmv3.DeviceClass{
+				{Name: "hdd", VolumeGroup: "hdd", Devices: []topolvmv3.Device{{Name: "/dev/sdb"}}},
+				{Name: "ssd", VolumeGroup: "ssd", Devices: []topolvmv3.Device{{Name: "/dev/sdc"}}},
+			},
+		}}},
+		"unknown template": {NodeGroups: []topolvmv3.NodeGroup{{
+			Name:     "group",
+			NodeName: "node1",
+			Classes:  []topolvmv3.DeviceClass{{Name: "hdd", VolumeGroup: "hdd", Template: "missing"}},
+		}}},
+		"repair device of selected devices": {NodeGroups: []topolvmv3.NodeGroup{{
+			Name:     "group",
+			NodeName: "node1",
+			Classes:  []topolvmv3.DeviceClass{{Name: "hdd", VolumeGroup: "hdd", RepairDevice: "/dev/sdb", DeviceSelector: &topolvmv3.DeviceSelector{}}},
+		}}},
+		"selector and devices": {NodeGroups: []topolvmv3.NodeGroup{{
+			Name:     "group",
+			NodeName: "node1",
+			Classes:  []topolvmv3.DeviceClass{{Name: "hdd", VolumeGroup: "hdd", Devices: []topolvmv3.Device{{Name: "/dev/sdb"}}, DeviceSelector: &topolvmv3.DeviceSelector{}}},
+		}}},
+	} {
+		cluster := &TopolvmCluster{}
+		assert.Error(t, cluster.ConvertFrom(&topolvmv3.TopolvmCluster{Spec: spec}), name)
+	}
+}
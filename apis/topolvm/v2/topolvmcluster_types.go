@@ -17,7 +17,9 @@ limitations under the License.
 package v2
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -33,6 +35,41 @@ type TopolvmClusterSpec struct {
 	CertsSecret string `json:"certsSecret"`
 	Storage     `json:"storage"`
 	CleanUp     bool `json:"cleanup"`
+	// UpgradeStrategy is how the node plugins roll out a new TopoLVM
+	// version or lvmd config
+	//+optional
+	UpgradeStrategy *UpgradeStrategy `json:"upgradeStrategy,omitempty"`
+	// Decommission is how the nodes leaving the cluster are torn down
+	//+optional
+	Decommission *DecommissionPolicy `json:"decommission,omitempty"`
+}
+
+// UpgradeStrategy is the rollout of the node plugins. The nodes are updated
+// in batches once the TopoLVM controller is rolled out, a node counts as
+// updated when its node plugin is available, registered in its CSINode and
+// its lvmd is ready.
+type UpgradeStrategy struct {
+	// MaxUnavailable is the number or percentage of nodes whose node plugin
+	// may be unavailable during the rollout, defaults to 1
+	//+kubebuilder:validation:XIntOrString
+	//+optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// NodeTimeoutSeconds is how long an updated node may take to become
+	// healthy before the rollout pauses, defaults to 600
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	NodeTimeoutSeconds int32 `json:"nodeTimeoutSeconds,omitempty"`
+}
+
+// DecommissionPolicy is the teardown of a node the cluster no longer claims.
+// New volumes stop being provisioned on the node, and once its logical
+// volumes are gone its node plugin and lvmd configmap are removed.
+type DecommissionPolicy struct {
+	// WipeDevices removes the volume groups of the node and wipes their
+	// physical volumes after its node plugin is removed, adopted volume
+	// groups are kept
+	//+optional
+	WipeDevices bool `json:"wipeDevices,omitempty"`
 }
 
 type Storage struct {
@@ -43,6 +80,58 @@ type Storage struct {
 	VolumeGroupName string        `json:"volumeGroupName,omitempty"`
 	ClassName       string        `json:"className,omitempty"`
 	UseLoop         bool          `json:"useLoop"`
+	// NodeClasses give the nodes matching a label selector their device
+	// classes, the devices of a class are picked from the devices discovered
+	// on the node. Nodes joining later get their classes without an edit.
+	NodeClasses []NodeClassSelector `json:"nodeClasses,omitempty"`
+}
+
+// NodeClassSelector gives the device classes to the nodes it selects
+type NodeClassSelector struct {
+	// NodeSelector selects the nodes by label, all nodes are selected if nil
+	//+optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	Classes      []SelectedDeviceClass `json:"classes"`
+}
+
+// SelectedDeviceClass is a device class whose devices are the available
+// devices of the node matching its device selector
+type SelectedDeviceClass struct {
+	ClassName  string `json:"className"`
+	VgName     string `json:"volumeGroup"`
+	Default    bool   `json:"default,omitempty"`
+	SpareGb    uint64 `json:"spareGb,omitempty"`
+	Stripe     uint   `json:"stripe,omitempty"`
+	StripeSize string `json:"stripeSize,omitempty"`
+	// Adopt takes over the volume group where it already exists, no device
+	// is picked for it
+	Adopt bool `json:"adopt,omitempty"`
+	// ThinPool makes the class thin provisioned
+	ThinPool *ThinPoolConfig `json:"thinPool,omitempty"`
+	// Raid makes the volumes of the class raid volumes
+	Raid *RaidConfig `json:"raid,omitempty"`
+	// Cache puts a cache on the listed fast devices in front of every volume
+	// of the class, the selector never picks them
+	Cache *CacheConfig `json:"cache,omitempty"`
+	// DeviceSelector picks the devices of the class, every available device
+	// is picked if empty
+	//+optional
+	DeviceSelector DeviceSelector `json:"deviceSelector,omitempty"`
+}
+
+// DeviceSelector matches discovered devices, a device matches when it
+// matches every field set
+type DeviceSelector struct {
+	// Paths are globs matched against the device path, e.g. /dev/nvme*
+	Paths []string `json:"paths,omitempty"`
+	// ByIDs are globs matched against the persistent links of the device,
+	// e.g. /dev/disk/by-id/wwn-*
+	ByIDs      []string           `json:"byIds,omitempty"`
+	MinSize    *resource.Quantity `json:"minSize,omitempty"`
+	MaxSize    *resource.Quantity `json:"maxSize,omitempty"`
+	Rotational *bool              `json:"rotational,omitempty"`
+	// Models are globs matched against the device model
+	Models []string `json:"models,omitempty"`
 }
 
 type NodeDevices struct {
@@ -58,6 +147,98 @@ type DeviceClass struct {
 	SpareGb    uint64 `json:"spareGb,omitempty" yaml:"spare-gb,omitempty"`
 	Stripe     uint   `json:"stripe,omitempty" yaml:"stripe,omitempty"`
 	StripeSize string `json:"stripeSize,omitempty" yaml:"stripe-size,omitempty"`
+	// Adopt takes over the existing volume group VgName instead of creating
+	// it, its physical volumes are never created, removed or wiped
+	Adopt bool `json:"adopt,omitempty" yaml:"-"`
+	// ThinPool makes the class thin provisioned, the volumes of the class
+	// are thin volumes of a thin pool in the volume group
+	ThinPool *ThinPoolConfig `json:"thinPool,omitempty" yaml:"-"`
+	// Raid makes the volumes of the class raid volumes spread over the
+	// physical volumes, so they survive the loss of a device
+	Raid *RaidConfig `json:"raid,omitempty" yaml:"-"`
+	// RepairDevice is the device of a raid class the volumes missing a
+	// device are rebuilt on, they are only reported while it is empty
+	RepairDevice string `json:"repairDevice,omitempty" yaml:"-"`
+	// Cache puts a cache on fast devices in front of every volume of the
+	// class
+	Cache *CacheConfig `json:"cache,omitempty" yaml:"-"`
+}
+
+type RaidType string
+
+const (
+	Raid1  RaidType = "raid1"
+	Raid10 RaidType = "raid10"
+	Raid5  RaidType = "raid5"
+)
+
+// RaidConfig is the raid layout of the volumes of a device class
+type RaidConfig struct {
+	//+kubebuilder:validation:Enum=raid1;raid10;raid5
+	Type RaidType `json:"type"`
+	// Mirrors is the number of extra copies of raid1 and raid10 volumes,
+	// defaults to 1
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Mirrors uint `json:"mirrors,omitempty"`
+	// Stripes is the number of data stripes of raid10 and raid5 volumes,
+	// defaults to 2
+	//+kubebuilder:validation:Minimum=2
+	//+optional
+	Stripes uint `json:"stripes,omitempty"`
+}
+
+// ThinPoolConfig is the thin pool of a thin provisioned device class
+type ThinPoolConfig struct {
+	// Name is the name of the thin pool logical volume
+	Name string `json:"name"`
+	// SizePercent is the share of the volume group the pool data takes,
+	// defaults to 90. The pool grows along with the volume group
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	//+optional
+	SizePercent uint `json:"sizePercent,omitempty"`
+	// OverprovisionRatio is how many times the pool size the volumes of the
+	// class may add up to, e.g. "5.0"
+	//+kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?$`
+	OverprovisionRatio string `json:"overprovisionRatio"`
+}
+
+type CacheType string
+
+const (
+	CacheTypeCache      CacheType = "cache"
+	CacheTypeWritecache CacheType = "writecache"
+)
+
+type CacheMode string
+
+const (
+	CacheWritethrough CacheMode = "writethrough"
+	CacheWriteback    CacheMode = "writeback"
+)
+
+// CacheConfig is the cache of the volumes of a device class
+type CacheConfig struct {
+	// Type is cache for dm-cache or writecache for dm-writecache, defaults
+	// to cache
+	//+kubebuilder:validation:Enum=cache;writecache
+	//+optional
+	Type CacheType `json:"type,omitempty"`
+	// Mode is the write mode of dm-cache, defaults to writethrough.
+	// dm-writecache always writes back
+	//+kubebuilder:validation:Enum=writethrough;writeback
+	//+optional
+	Mode CacheMode `json:"mode,omitempty"`
+	// SizePercent is the cache size of a volume as a share of its size,
+	// defaults to 10
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	//+optional
+	SizePercent uint `json:"sizePercent,omitempty"`
+	// Devices are the fast devices the caches are allocated on, they join
+	// the volume group but never hold volume data
+	Devices []Disk `json:"devices"`
 }
 
 type Disk struct {
@@ -74,8 +255,96 @@ type TopolvmClusterStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	Phase             ConditionType      `json:"phase"`
 	NodeStorageStatus []NodeStorageState `json:"nodeStorageState"`
+	// ObservedGeneration is the generation of the spec last reconciled
+	// without error
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Available, Progressing, Degraded and
+	// ReconcileFailed conditions of the cluster
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// NodePluginRollout is the progress of the node plugins rolling out
+	//+optional
+	NodePluginRollout *NodePluginRollout `json:"nodePluginRollout,omitempty"`
+	// DecommissioningNodes are the nodes leaving the cluster which are not
+	// torn down yet
+	//+optional
+	DecommissioningNodes []NodeDecommission `json:"decommissioningNodes,omitempty"`
+}
+
+type RolloutPhase string
+
+const (
+	RolloutProgressing RolloutPhase = "Progressing"
+	RolloutPaused      RolloutPhase = "Paused"
+	RolloutComplete    RolloutPhase = "Complete"
+)
+
+type DecommissionPhase string
+
+const (
+	DecommissionDraining           DecommissionPhase = "Draining"
+	DecommissionWipingDevices      DecommissionPhase = "WipingDevices"
+	DecommissionRemovingNodePlugin DecommissionPhase = "RemovingNodePlugin"
+)
+
+// NodeDecommission is the progress of tearing a node down, a node drains
+// until none of its logical volumes is left
+type NodeDecommission struct {
+	Node  string            `json:"node"`
+	Phase DecommissionPhase `json:"phase"`
+	// LogicalVolumes is the number of logical volumes left on the node
+	//+optional
+	LogicalVolumes int32 `json:"logicalVolumes,omitempty"`
+	// Blockers are what keeps the node from being torn down
+	//+optional
+	Blockers []string `json:"blockers,omitempty"`
+}
+
+// NodePluginRollout is the progress of rolling out the node plugins, a
+// rollout pauses while an updated node is not healthy past the node timeout
+// and resumes once it is healthy or the node plugins change again
+type NodePluginRollout struct {
+	// Image is the TopoLVM image rolled out
+	Image string       `json:"image"`
+	Phase RolloutPhase `json:"phase"`
+	// UpdatedNodes is the number of nodes whose node plugin is updated and
+	// healthy
+	UpdatedNodes int32 `json:"updatedNodes"`
+	TotalNodes   int32 `json:"totalNodes"`
+	// UpdatingNodes are the nodes being updated
+	//+optional
+	UpdatingNodes []string `json:"updatingNodes,omitempty"`
+	// FailedNodes are the updated nodes not healthy past the node timeout
+	//+optional
+	FailedNodes []string `json:"failedNodes,omitempty"`
+	//+optional
+	Message string `json:"message,omitempty"`
 }
 
+const (
+	// ClusterAvailable is true while a node serves volumes
+	ClusterAvailable = "Available"
+	// ClusterProgressing is true while volume group jobs run, node plugins
+	// roll out or the spec is not reconciled yet
+	ClusterProgressing = "Progressing"
+	// ClusterDegraded is true while a node is not ready or has failed
+	// classes
+	ClusterDegraded = "Degraded"
+	// ClusterReconcileFailed is true when the last reconcile failed
+	ClusterReconcileFailed = "ReconcileFailed"
+	// ClusterNodeConflict is true while nodes the cluster claims are owned
+	// by another cluster
+	ClusterNodeConflict = "NodeConflict"
+
+	// NodeVolumeGroupsReady is the state of the volume group job of a node
+	NodeVolumeGroupsReady = "VolumeGroupsReady"
+	// NodePluginReady is the rollout state of the node plugin of a node
+	NodePluginReady = "NodePluginReady"
+)
+
 type ConditionType string
 
 const (
@@ -94,6 +363,15 @@ type NodeStorageState struct {
 	SuccessClasses []ClassState `json:"successClasses"`
 	//+optional
 	Loops []LoopState `json:"loops"`
+	// Conditions are the VolumeGroupsReady and NodePluginReady conditions
+	// of the node
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastError is the last error seen on the node
+	//+optional
+	LastError string `json:"lastError,omitempty"`
 }
 
 type LoopState struct {
@@ -117,6 +395,37 @@ type ClassState struct {
 	State        ClassStateType `json:"state,omitempty"`
 	Message      string         `json:"message,omitempty"`
 	DeviceStates []DeviceState  `json:"deviceStates,omitempty"`
+	// Adopted is set for volume groups the operator took over, they are
+	// never shrunk or removed
+	Adopted bool `json:"adopted,omitempty"`
+	// Size is the size of an adopted volume group in bytes
+	Size uint64 `json:"size,omitempty"`
+	// ThinPool is the usage of the thin pool of the class
+	ThinPool *ThinPoolState `json:"thinPool,omitempty"`
+	// DegradedVolumes are the raid volumes of the class missing a device
+	// or out of sync
+	DegradedVolumes []RaidVolumeState `json:"degradedVolumes,omitempty"`
+}
+
+// RaidVolumeState is the health of a raid logical volume
+type RaidVolumeState struct {
+	Name string `json:"name"`
+	// Health is the lvm health status, e.g. partial or refresh needed
+	Health string `json:"health,omitempty"`
+	// SyncPercent is the synced share of the volume, e.g. 100.00
+	SyncPercent string `json:"syncPercent,omitempty"`
+}
+
+// ThinPoolState is the usage of a thin pool when its volume group was last
+// prepared
+type ThinPoolState struct {
+	Name string `json:"name"`
+	// Size is the data size of the pool in bytes
+	Size uint64 `json:"size"`
+	// DataPercent and MetadataPercent are the used shares of the pool data
+	// and metadata, e.g. 12.50
+	DataPercent     string `json:"dataPercent,omitempty"`
+	MetadataPercent string `json:"metadataPercent,omitempty"`
 }
 
 type DeviceStateType string
@@ -133,6 +442,7 @@ type DeviceState struct {
 }
 
 //+genclient
+//+kubebuilder:storageversion
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
@@ -22,9 +22,31 @@ limitations under the License.
 package v2
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheConfig) DeepCopyInto(out *CacheConfig) {
+	*out = *in
+	if in.Devices != nil {
+		in, out := &in.Devices, &out.Devices
+		*out = make([]Disk, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheConfig.
+func (in *CacheConfig) DeepCopy() *CacheConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClassState) DeepCopyInto(out *ClassState) {
 	*out = *in
@@ -33,6 +55,16 @@ func (in *ClassState) DeepCopyInto(out *ClassState) {
 		*out = make([]DeviceState, len(*in))
 		copy(*out, *in)
 	}
+	if in.ThinPool != nil {
+		in, out := &in.ThinPool, &out.ThinPool
+		*out = new(ThinPoolState)
+		**out = **in
+	}
+	if in.DegradedVolumes != nil {
+		in, out := &in.DegradedVolumes, &out.DegradedVolumes
+		*out = make([]RaidVolumeState, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClassState.
@@ -45,6 +77,21 @@ func (in *ClassState) DeepCopy() *ClassState {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DecommissionPolicy) DeepCopyInto(out *DecommissionPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DecommissionPolicy.
+func (in *DecommissionPolicy) DeepCopy() *DecommissionPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(DecommissionPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DeviceClass) DeepCopyInto(out *DeviceClass) {
 	*out = *in
@@ -53,6 +100,21 @@ func (in *DeviceClass) DeepCopyInto(out *DeviceClass) {
 		*out = make([]Disk, len(*in))
 		copy(*out, *in)
 	}
+	if in.ThinPool != nil {
+		in, out := &in.ThinPool, &out.ThinPool
+		*out = new(ThinPoolConfig)
+		**out = **in
+	}
+	if in.Raid != nil {
+		in, out := &in.Raid, &out.Raid
+		*out = new(RaidConfig)
+		**out = **in
+	}
+	if in.Cache != nil {
+		in, out := &in.Cache, &out.Cache
+		*out = new(CacheConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceClass.
@@ -65,6 +127,51 @@ func (in *DeviceClass) DeepCopy() *DeviceClass {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceSelector) DeepCopyInto(out *DeviceSelector) {
+	*out = *in
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ByIDs != nil {
+		in, out := &in.ByIDs, &out.ByIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MinSize != nil {
+		in, out := &in.MinSize, &out.MinSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.MaxSize != nil {
+		in, out := &in.MaxSize, &out.MaxSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Rotational != nil {
+		in, out := &in.Rotational, &out.Rotational
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Models != nil {
+		in, out := &in.Models, &out.Models
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceSelector.
+func (in *DeviceSelector) DeepCopy() *DeviceSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DeviceState) DeepCopyInto(out *DeviceState) {
 	*out = *in
@@ -110,6 +217,53 @@ func (in *LoopState) DeepCopy() *LoopState {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeClassSelector) DeepCopyInto(out *NodeClassSelector) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Classes != nil {
+		in, out := &in.Classes, &out.Classes
+		*out = make([]SelectedDeviceClass, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeClassSelector.
+func (in *NodeClassSelector) DeepCopy() *NodeClassSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeClassSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDecommission) DeepCopyInto(out *NodeDecommission) {
+	*out = *in
+	if in.Blockers != nil {
+		in, out := &in.Blockers, &out.Blockers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDecommission.
+func (in *NodeDecommission) DeepCopy() *NodeDecommission {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDecommission)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeDevices) DeepCopyInto(out *NodeDevices) {
 	*out = *in
@@ -132,6 +286,31 @@ func (in *NodeDevices) DeepCopy() *NodeDevices {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePluginRollout) DeepCopyInto(out *NodePluginRollout) {
+	*out = *in
+	if in.UpdatingNodes != nil {
+		in, out := &in.UpdatingNodes, &out.UpdatingNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.FailedNodes != nil {
+		in, out := &in.FailedNodes, &out.FailedNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePluginRollout.
+func (in *NodePluginRollout) DeepCopy() *NodePluginRollout {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePluginRollout)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeStorageState) DeepCopyInto(out *NodeStorageState) {
 	*out = *in
@@ -154,6 +333,13 @@ func (in *NodeStorageState) DeepCopyInto(out *NodeStorageState) {
 		*out = make([]LoopState, len(*in))
 		copy(*out, *in)
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStorageState.
@@ -166,6 +352,67 @@ func (in *NodeStorageState) DeepCopy() *NodeStorageState {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RaidConfig) DeepCopyInto(out *RaidConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RaidConfig.
+func (in *RaidConfig) DeepCopy() *RaidConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RaidConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RaidVolumeState) DeepCopyInto(out *RaidVolumeState) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RaidVolumeState.
+func (in *RaidVolumeState) DeepCopy() *RaidVolumeState {
+	if in == nil {
+		return nil
+	}
+	out := new(RaidVolumeState)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SelectedDeviceClass) DeepCopyInto(out *SelectedDeviceClass) {
+	*out = *in
+	if in.ThinPool != nil {
+		in, out := &in.ThinPool, &out.ThinPool
+		*out = new(ThinPoolConfig)
+		**out = **in
+	}
+	if in.Raid != nil {
+		in, out := &in.Raid, &out.Raid
+		*out = new(RaidConfig)
+		**out = **in
+	}
+	if in.Cache != nil {
+		in, out := &in.Cache, &out.Cache
+		*out = new(CacheConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	in.DeviceSelector.DeepCopyInto(&out.DeviceSelector)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SelectedDeviceClass.
+func (in *SelectedDeviceClass) DeepCopy() *SelectedDeviceClass {
+	if in == nil {
+		return nil
+	}
+	out := new(SelectedDeviceClass)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Storage) DeepCopyInto(out *Storage) {
 	*out = *in
@@ -181,6 +428,13 @@ func (in *Storage) DeepCopyInto(out *Storage) {
 		*out = make([]Disk, len(*in))
 		copy(*out, *in)
 	}
+	if in.NodeClasses != nil {
+		in, out := &in.NodeClasses, &out.NodeClasses
+		*out = make([]NodeClassSelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Storage.
@@ -193,6 +447,36 @@ func (in *Storage) DeepCopy() *Storage {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThinPoolConfig) DeepCopyInto(out *ThinPoolConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThinPoolConfig.
+func (in *ThinPoolConfig) DeepCopy() *ThinPoolConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ThinPoolConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThinPoolState) DeepCopyInto(out *ThinPoolState) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThinPoolState.
+func (in *ThinPoolState) DeepCopy() *ThinPoolState {
+	if in == nil {
+		return nil
+	}
+	out := new(ThinPoolState)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TopolvmCluster) DeepCopyInto(out *TopolvmCluster) {
 	*out = *in
@@ -256,6 +540,16 @@ func (in *TopolvmClusterList) DeepCopyObject() runtime.Object {
 func (in *TopolvmClusterSpec) DeepCopyInto(out *TopolvmClusterSpec) {
 	*out = *in
 	in.Storage.DeepCopyInto(&out.Storage)
+	if in.UpgradeStrategy != nil {
+		in, out := &in.UpgradeStrategy, &out.UpgradeStrategy
+		*out = new(UpgradeStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Decommission != nil {
+		in, out := &in.Decommission, &out.Decommission
+		*out = new(DecommissionPolicy)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TopolvmClusterSpec.
@@ -278,6 +572,25 @@ func (in *TopolvmClusterStatus) DeepCopyInto(out *TopolvmClusterStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NodePluginRollout != nil {
+		in, out := &in.NodePluginRollout, &out.NodePluginRollout
+		*out = new(NodePluginRollout)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DecommissioningNodes != nil {
+		in, out := &in.DecommissioningNodes, &out.DecommissioningNodes
+		*out = make([]NodeDecommission, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TopolvmClusterStatus.
@@ -289,3 +602,23 @@ func (in *TopolvmClusterStatus) DeepCopy() *TopolvmClusterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradeStrategy) DeepCopyInto(out *UpgradeStrategy) {
+	*out = *in
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradeStrategy.
+func (in *UpgradeStrategy) DeepCopy() *UpgradeStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradeStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
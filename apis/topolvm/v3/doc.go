@@ -0,0 +1,3 @@
+// +groupName=topolvm.cybozu.com
+
+package v3
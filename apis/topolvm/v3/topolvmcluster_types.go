@@ -0,0 +1,484 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// TopolvmClusterSpec defines the desired state of TopolvmCluster
+type TopolvmClusterSpec struct {
+	// TopoLVM are the settings of the TopoLVM controller and node plugins
+	TopoLVM TopoLVMSpec `json:"topolvm"`
+	// ClassTemplates are the settings device classes share, a class names
+	// the template it takes its settings from
+	//+optional
+	ClassTemplates []ClassTemplate `json:"classTemplates,omitempty"`
+	// NodeGroups give the nodes they hold their device classes
+	NodeGroups []NodeGroup `json:"nodeGroups"`
+	// CleanUp removes the volume groups and wipes their physical volumes
+	// when the cluster is deleted
+	//+optional
+	CleanUp bool `json:"cleanup,omitempty"`
+	// Decommission is how the nodes leaving the cluster are torn down
+	//+optional
+	Decommission *DecommissionPolicy `json:"decommission,omitempty"`
+}
+
+// TopoLVMSpec are the settings of the TopoLVM components
+type TopoLVMSpec struct {
+	// Image is the TopoLVM image of the controller and node plugins
+	Image string `json:"image"`
+	// CertsSecret is the secret holding the webhook certificate of the
+	// TopoLVM controller, a self signed one is made if empty
+	//+optional
+	CertsSecret string `json:"certsSecret,omitempty"`
+	// UpgradeStrategy is how the node plugins roll out a new image or lvmd
+	// config
+	//+optional
+	UpgradeStrategy *UpgradeStrategy `json:"upgradeStrategy,omitempty"`
+}
+
+// UpgradeStrategy is the rollout of the node plugins. The nodes are updated
+// in batches once the TopoLVM controller is rolled out, a node counts as
+// updated when its node plugin is available, registered in its CSINode and
+// its lvmd is ready.
+type UpgradeStrategy struct {
+	// MaxUnavailable is the number or percentage of nodes whose node plugin
+	// may be unavailable during the rollout, defaults to 1
+	//+kubebuilder:validation:XIntOrString
+	//+optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// NodeTimeoutSeconds is how long an updated node may take to become
+	// healthy before the rollout pauses, defaults to 600
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	NodeTimeoutSeconds int32 `json:"nodeTimeoutSeconds,omitempty"`
+}
+
+// DecommissionPolicy is the teardown of a node the cluster no longer claims.
+// New volumes stop being provisioned on the node, and once its logical
+// volumes are gone its node plugin and lvmd configmap are removed.
+type DecommissionPolicy struct {
+	// WipeDevices removes the volume groups of the node and wipes their
+	// physical volumes after its node plugin is removed, adopted volume
+	// groups are kept
+	//+optional
+	WipeDevices bool `json:"wipeDevices,omitempty"`
+}
+
+// NodeGroup is a set of nodes sharing their device classes. A group holds
+// the node named by NodeName, or the nodes NodeSelector selects, or every
+// node when both are empty.
+type NodeGroup struct {
+	// Name identifies the group in the cluster
+	Name string `json:"name"`
+	//+optional
+	NodeName string `json:"nodeName,omitempty"`
+	//+optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	Classes      []DeviceClass         `json:"classes"`
+}
+
+// ClassSettings are the settings of a device class besides its devices
+type ClassSettings struct {
+	// Default makes the class hold the volumes created without a class
+	Default bool `json:"default,omitempty"`
+	// SpareGb is the space in GiB kept free in the volume group
+	SpareGb    uint64 `json:"spareGb,omitempty"`
+	Stripe     uint   `json:"stripe,omitempty"`
+	StripeSize string `json:"stripeSize,omitempty"`
+	// ThinPool makes the class thin provisioned
+	ThinPool *ThinPoolConfig `json:"thinPool,omitempty"`
+	// Raid makes the volumes of the class raid volumes
+	Raid *RaidConfig `json:"raid,omitempty"`
+}
+
+// ClassTemplate are settings device classes share
+type ClassTemplate struct {
+	Name          string `json:"name"`
+	ClassSettings `json:",inline"`
+}
+
+// DeviceClass is a volume group on each node of a group. Its physical
+// volumes are the devices and loops it lists, or the devices discovered on
+// the node matching its device selector.
+type DeviceClass struct {
+	Name        string `json:"name"`
+	VolumeGroup string `json:"volumeGroup"`
+	// Template names the class template the settings of the class come
+	// from, the class then sets none of them itself
+	//+optional
+	Template      string `json:"template,omitempty"`
+	ClassSettings `json:",inline"`
+	// Adopt takes over the volume group where it already exists, its
+	// physical volumes are never created, removed or wiped
+	Adopt bool `json:"adopt,omitempty"`
+	// Devices are the devices of the class by path, e.g. /dev/sdb
+	//+optional
+	Devices []Device `json:"devices,omitempty"`
+	// Loops are the loop devices of the class
+	//+optional
+	Loops []LoopDevice `json:"loops,omitempty"`
+	// RepairDevice is the device of a raid class the volumes missing a
+	// device are rebuilt on, they are only reported while it is empty
+	//+optional
+	RepairDevice string `json:"repairDevice,omitempty"`
+	// DeviceSelector picks the devices of the class among the available
+	// devices discovered on the node, every one is picked if empty. It is
+	// not used along with devices and loops
+	//+optional
+	DeviceSelector *DeviceSelector `json:"deviceSelector,omitempty"`
+	// Cache puts a cache on fast devices in front of every volume of the
+	// class
+	//+optional
+	Cache *CacheConfig `json:"cache,omitempty"`
+}
+
+// Device is a block device of a node
+type Device struct {
+	Name string `json:"name"`
+}
+
+// LoopDevice is a loop device used as a physical volume. Without File it is
+// an existing loop device, e.g. /dev/loop0
+type LoopDevice struct {
+	Name string `json:"name"`
+	// File makes a new loop device backed by a file
+	//+optional
+	File *LoopFile `json:"file,omitempty"`
+}
+
+// LoopFile is the backing file of a loop device the operator makes
+type LoopFile struct {
+	// Path is the directory of the file
+	Path string `json:"path"`
+	// SizeGb is the size of the file in GiB
+	//+kubebuilder:validation:Minimum=1
+	SizeGb uint64 `json:"sizeGb"`
+}
+
+// DeviceSelector matches discovered devices, a device matches when it
+// matches every field set
+type DeviceSelector struct {
+	// Paths are globs matched against the device path, e.g. /dev/nvme*
+	Paths []string `json:"paths,omitempty"`
+	// ByIDs are globs matched against the persistent links of the device,
+	// e.g. /dev/disk/by-id/wwn-*
+	ByIDs      []string           `json:"byIds,omitempty"`
+	MinSize    *resource.Quantity `json:"minSize,omitempty"`
+	MaxSize    *resource.Quantity `json:"maxSize,omitempty"`
+	Rotational *bool              `json:"rotational,omitempty"`
+	// Models are globs matched against the device model
+	Models []string `json:"models,omitempty"`
+}
+
+type RaidType string
+
+const (
+	Raid1  RaidType = "raid1"
+	Raid10 RaidType = "raid10"
+	Raid5  RaidType = "raid5"
+)
+
+// RaidConfig is the raid layout of the volumes of a device class
+type RaidConfig struct {
+	//+kubebuilder:validation:Enum=raid1;raid10;raid5
+	Type RaidType `json:"type"`
+	// Mirrors is the number of extra copies of raid1 and raid10 volumes,
+	// defaults to 1
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Mirrors uint `json:"mirrors,omitempty"`
+	// Stripes is the number of data stripes of raid10 and raid5 volumes,
+	// defaults to 2
+	//+kubebuilder:validation:Minimum=2
+	//+optional
+	Stripes uint `json:"stripes,omitempty"`
+}
+
+// ThinPoolConfig is the thin pool of a thin provisioned device class
+type ThinPoolConfig struct {
+	// Name is the name of the thin pool logical volume
+	Name string `json:"name"`
+	// SizePercent is the share of the volume group the pool data takes,
+	// defaults to 90. The pool grows along with the volume group
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	//+optional
+	SizePercent uint `json:"sizePercent,omitempty"`
+	// OverprovisionRatio is how many times the pool size the volumes of the
+	// class may add up to, e.g. "5.0"
+	//+kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?$`
+	OverprovisionRatio string `json:"overprovisionRatio"`
+}
+
+type CacheType string
+
+const (
+	CacheTypeCache      CacheType = "cache"
+	CacheTypeWritecache CacheType = "writecache"
+)
+
+type CacheMode string
+
+const (
+	CacheWritethrough CacheMode = "writethrough"
+	CacheWriteback    CacheMode = "writeback"
+)
+
+// CacheConfig is the cache of the volumes of a device class
+type CacheConfig struct {
+	// Type is cache for dm-cache or writecache for dm-writecache, defaults
+	// to cache
+	//+kubebuilder:validation:Enum=cache;writecache
+	//+optional
+	Type CacheType `json:"type,omitempty"`
+	// Mode is the write mode of dm-cache, defaults to writethrough.
+	// dm-writecache always writes back
+	//+kubebuilder:validation:Enum=writethrough;writeback
+	//+optional
+	Mode CacheMode `json:"mode,omitempty"`
+	// SizePercent is the cache size of a volume as a share of its size,
+	// defaults to 10
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	//+optional
+	SizePercent uint `json:"sizePercent,omitempty"`
+	// Devices are the fast devices the caches are allocated on, they join
+	// the volume group but never hold volume data
+	Devices []Device `json:"devices"`
+}
+
+// TopolvmClusterStatus defines the observed state of TopolvmCluster
+type TopolvmClusterStatus struct {
+	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+	Phase             ConditionType      `json:"phase"`
+	NodeStorageStatus []NodeStorageState `json:"nodeStorageState"`
+	// ObservedGeneration is the generation of the spec last reconciled
+	// without error
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Available, Progressing, Degraded and
+	// ReconcileFailed conditions of the cluster
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// NodePluginRollout is the progress of the node plugins rolling out
+	//+optional
+	NodePluginRollout *NodePluginRollout `json:"nodePluginRollout,omitempty"`
+	// DecommissioningNodes are the nodes leaving the cluster which are not
+	// torn down yet
+	//+optional
+	DecommissioningNodes []NodeDecommission `json:"decommissioningNodes,omitempty"`
+}
+
+type RolloutPhase string
+
+const (
+	RolloutProgressing RolloutPhase = "Progressing"
+	RolloutPaused      RolloutPhase = "Paused"
+	RolloutComplete    RolloutPhase = "Complete"
+)
+
+type DecommissionPhase string
+
+const (
+	DecommissionDraining           DecommissionPhase = "Draining"
+	DecommissionWipingDevices      DecommissionPhase = "WipingDevices"
+	DecommissionRemovingNodePlugin DecommissionPhase = "RemovingNodePlugin"
+)
+
+// NodeDecommission is the progress of tearing a node down, a node drains
+// until none of its logical volumes is left
+type NodeDecommission struct {
+	Node  string            `json:"node"`
+	Phase DecommissionPhase `json:"phase"`
+	// LogicalVolumes is the number of logical volumes left on the node
+	//+optional
+	LogicalVolumes int32 `json:"logicalVolumes,omitempty"`
+	// Blockers are what keeps the node from being torn down
+	//+optional
+	Blockers []string `json:"blockers,omitempty"`
+}
+
+// NodePluginRollout is the progress of rolling out the node plugins, a
+// rollout pauses while an updated node is not healthy past the node timeout
+// and resumes once it is healthy or the node plugins change again
+type NodePluginRollout struct {
+	// Image is the TopoLVM image rolled out
+	Image string       `json:"image"`
+	Phase RolloutPhase `json:"phase"`
+	// UpdatedNodes is the number of nodes whose node plugin is updated and
+	// healthy
+	UpdatedNodes int32 `json:"updatedNodes"`
+	TotalNodes   int32 `json:"totalNodes"`
+	// UpdatingNodes are the nodes being updated
+	//+optional
+	UpdatingNodes []string `json:"updatingNodes,omitempty"`
+	// FailedNodes are the updated nodes not healthy past the node timeout
+	//+optional
+	FailedNodes []string `json:"failedNodes,omitempty"`
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// ClusterAvailable is true while a node serves volumes
+	ClusterAvailable = "Available"
+	// ClusterProgressing is true while volume group jobs run, node plugins
+	// roll out or the spec is not reconciled yet
+	ClusterProgressing = "Progressing"
+	// ClusterDegraded is true while a node is not ready or has failed
+	// classes
+	ClusterDegraded = "Degraded"
+	// ClusterReconcileFailed is true when the last reconcile failed
+	ClusterReconcileFailed = "ReconcileFailed"
+	// ClusterNodeConflict is true while nodes the cluster claims are owned
+	// by another cluster
+	ClusterNodeConflict = "NodeConflict"
+
+	// NodeVolumeGroupsReady is the state of the volume group job of a node
+	NodeVolumeGroupsReady = "VolumeGroupsReady"
+	// NodePluginReady is the rollout state of the node plugin of a node
+	NodePluginReady = "NodePluginReady"
+)
+
+type ConditionType string
+
+const (
+	ConditionReady   ConditionType = "Ready"
+	ConditionFailure ConditionType = "Failure"
+	ConditionUnknown ConditionType = "Unknown"
+	ConditionPending ConditionType = "Pending"
+)
+
+type NodeStorageState struct {
+	Node  string        `json:"node"`
+	Phase ConditionType `json:"phase"`
+	//+optional
+	FailClasses []ClassState `json:"failClasses"`
+	//+optional
+	SuccessClasses []ClassState `json:"successClasses"`
+	//+optional
+	Loops []LoopState `json:"loops"`
+	// Conditions are the VolumeGroupsReady and NodePluginReady conditions
+	// of the node
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastError is the last error seen on the node
+	//+optional
+	LastError string `json:"lastError,omitempty"`
+}
+
+type LoopState struct {
+	Name       string `json:"name"`
+	File       string `json:"file"`
+	DeviceName string `json:"deviceName"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+}
+
+type ClassStateType string
+
+const (
+	ClassReady   ClassStateType = "Ready"
+	ClassUnReady ClassStateType = "UnReady"
+)
+
+type ClassState struct {
+	Name         string         `json:"className,omitempty"`
+	VgName       string         `json:"vgName,omitempty"`
+	State        ClassStateType `json:"state,omitempty"`
+	Message      string         `json:"message,omitempty"`
+	DeviceStates []DeviceState  `json:"deviceStates,omitempty"`
+	// Adopted is set for volume groups the operator took over, they are
+	// never shrunk or removed
+	Adopted bool `json:"adopted,omitempty"`
+	// Size is the size of an adopted volume group in bytes
+	Size uint64 `json:"size,omitempty"`
+	// ThinPool is the usage of the thin pool of the class
+	ThinPool *ThinPoolState `json:"thinPool,omitempty"`
+	// DegradedVolumes are the raid volumes of the class missing a device
+	// or out of sync
+	DegradedVolumes []RaidVolumeState `json:"degradedVolumes,omitempty"`
+}
+
+// RaidVolumeState is the health of a raid logical volume
+type RaidVolumeState struct {
+	Name string `json:"name"`
+	// Health is the lvm health status, e.g. partial or refresh needed
+	Health string `json:"health,omitempty"`
+	// SyncPercent is the synced share of the volume, e.g. 100.00
+	SyncPercent string `json:"syncPercent,omitempty"`
+}
+
+// ThinPoolState is the usage of a thin pool when its volume group was last
+// prepared
+type ThinPoolState struct {
+	Name string `json:"name"`
+	// Size is the data size of the pool in bytes
+	Size uint64 `json:"size"`
+	// DataPercent and MetadataPercent are the used shares of the pool data
+	// and metadata, e.g. 12.50
+	DataPercent     string `json:"dataPercent,omitempty"`
+	MetadataPercent string `json:"metadataPercent,omitempty"`
+}
+
+type DeviceStateType string
+
+const (
+	DeviceOnline  DeviceStateType = "Online"
+	DeviceOffline DeviceStateType = "Offline"
+)
+
+type DeviceState struct {
+	Name    string          `json:"name,omitempty"`
+	State   DeviceStateType `json:"state,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// TopolvmCluster is the Schema for the topolvmclusters API
+type TopolvmCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TopolvmClusterSpec   `json:"spec,omitempty"`
+	Status TopolvmClusterStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TopolvmClusterList contains a list of TopolvmCluster
+type TopolvmClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TopolvmCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TopolvmCluster{}, &TopolvmClusterList{})
+}
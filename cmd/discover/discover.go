@@ -30,6 +30,10 @@ import (
 	"github.com/alauda/nativestor/pkg/cluster"
 	topolvmcluster "github.com/alauda/nativestor/pkg/cluster/topolvm"
 	opediscover "github.com/alauda/nativestor/pkg/operator/discover"
+	"github.com/alauda/nativestor/pkg/raw_device/runner"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -39,6 +43,8 @@ import (
 var (
 	scheme                  = runtime.NewScheme()
 	discoverDevicesInterval time.Duration
+	metricsAddr             string
+	logger                  = capnslog.NewPackageLogger("topolvm/operator", "discover-cmd")
 )
 
 var DiscoverCmd = &cobra.Command{
@@ -52,6 +58,7 @@ const (
 
 func init() {
 	DiscoverCmd.Flags().DurationVar(&discoverDevicesInterval, "discover-interval", 60*time.Second, "interval between discovering devices (default 60m)")
+	DiscoverCmd.Flags().StringVar(&metricsAddr, "metrics-addr", ":9108", "address the metrics and health probes are served at")
 	utilruntime.Must(topolvmv2.AddToScheme(scheme))
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(rawdevicev1.AddToScheme(scheme))
@@ -83,9 +90,50 @@ func discover(cmd *cobra.Command, args []string) error {
 	rawDeviceLister := factory.Rawdevice().V1().RawDevices().Lister()
 	udevEventPeriod := time.Duration(5) * time.Second
 
-	deviceManager := opediscover.NewDeviceManager(ctx, udevEventPeriod, discoverDevicesInterval, rawDeviceLister, nodeName, namespace, useLoop)
+	backend := os.Getenv(opediscover.DiscoverDeviceBackendEnv)
+	enumerator, err := sys.NewDeviceEnumerator(backend, ctx.Executor)
+	if err != nil {
+		topolvm.TerminateOnError(err, "create device enumerator failed")
+		return err
+	}
+
+	rules, err := sys.ParseDeviceRules(os.Getenv(sys.DeviceRulesEnv))
+	if err != nil {
+		topolvm.TerminateOnError(err, "parse device eligibility rules failed")
+		return err
+	}
+
+	deviceManager := opediscover.NewDeviceManager(ctx, enumerator, rules, udevEventPeriod, discoverDevicesInterval, rawDeviceLister, nodeName, namespace, useLoop)
+
+	healthInterval := opediscover.DefaultHealthInterval
+	if interval := os.Getenv(opediscover.DiscoverHealthIntervalEnv); interval != "" {
+		healthInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			topolvm.TerminateOnError(err, "parse health interval failed")
+			return err
+		}
+	}
+	blockUnhealthy := os.Getenv(opediscover.DiscoverHealthBlockUnhealthyEnv) != "false"
+	deviceManager.EnableHealthCheck(sys.NewHealthCollector(ctx.Executor), healthInterval, blockUnhealthy)
+
+	registry := prometheus.NewRegistry()
+	if err := opediscover.RegisterMetrics(registry); err != nil {
+		topolvm.TerminateOnError(err, "register metrics failed")
+		return err
+	}
+	go func() {
+		err := runner.NewMetricsRunner(metricsAddr, registry,
+			runner.PathHandler{Path: opediscover.LivenessPath, Handler: deviceManager.LivenessHandler()},
+			runner.PathHandler{Path: opediscover.ReadinessPath, Handler: deviceManager.ReadinessHandler()},
+		).Start(context.TODO())
+		if err != nil {
+			logger.Errorf("metrics server stopped. %v", err)
+		}
+	}()
 
 	factory.Start(context.TODO().Done())
+	// the first scan compares against the raw devices already published
+	factory.WaitForCacheSync(context.TODO().Done())
 	err = deviceManager.Run()
 	if err != nil {
 		topolvm.TerminateFatal(err)
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/lvcache"
+	"github.com/alauda/nativestor/pkg/raw_device/runner"
+	"github.com/alauda/nativestor/pkg/util/exec"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logger      = capnslog.NewPackageLogger("topolvm/operator", "lvcache-cmd")
+	configPath  string
+	interval    time.Duration
+	metricsAddr string
+)
+
+var LvcacheCmd = &cobra.Command{
+	Use:   "lvcache",
+	Short: "Cache the volumes of cached device classes",
+}
+
+func init() {
+	LvcacheCmd.Flags().StringVar(&configPath, "config", topolvm.LvcacheConfigPath, "cached device classes written by the volume group job")
+	LvcacheCmd.Flags().DurationVar(&interval, "interval", time.Minute, "interval between caching new volumes")
+	LvcacheCmd.Flags().StringVar(&metricsAddr, "metrics-addr", fmt.Sprintf(":%d", topolvm.LvcacheMetricsPort), "address the cache metrics are served at")
+	LvcacheCmd.RunE = lvcacheRun
+}
+
+func lvcacheRun(cmd *cobra.Command, args []string) error {
+
+	topolvm.SetLogLevel()
+
+	registry := prometheus.NewRegistry()
+	if err := lvcache.RegisterMetrics(registry); err != nil {
+		logger.Errorf("register metrics failed %v", err)
+		return err
+	}
+	go func() {
+		if err := runner.NewMetricsRunner(metricsAddr, registry).Start(context.TODO()); err != nil {
+			logger.Errorf("metrics server stopped. %v", err)
+		}
+	}()
+
+	return lvcache.NewCacher(&exec.CommandExecutor{}, configPath, interval).Run(context.TODO())
+}
@@ -26,6 +26,7 @@ import (
 	rawdev_csi "github.com/alauda/nativestor/pkg/operator/raw_device/csi"
 	topolvmctr "github.com/alauda/nativestor/pkg/operator/topolvm/controller"
 	topolvm_csi "github.com/alauda/nativestor/pkg/operator/topolvm/csi"
+	topolvmwebhook "github.com/alauda/nativestor/pkg/operator/topolvm/webhook"
 	"github.com/pkg/errors"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,10 +34,13 @@ import (
 
 	rawv1 "github.com/alauda/nativestor/apis/rawdevice/v1"
 	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	topolvmv3 "github.com/alauda/nativestor/apis/topolvm/v3"
 	"github.com/alauda/nativestor/cmd/topolvm"
+	rawclient "github.com/alauda/nativestor/generated/nativestore/rawdevice/clientset/versioned"
 	"github.com/alauda/nativestor/pkg/cluster"
 	"github.com/coreos/pkg/capnslog"
 	"github.com/spf13/cobra"
+	topolvmv1 "github.com/topolvm/topolvm/api/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -63,7 +67,9 @@ func init() {
 func addScheme() {
 	_ = clientgoscheme.AddToScheme(scheme)
 	_ = topolvmv2.AddToScheme(scheme)
+	_ = topolvmv3.AddToScheme(scheme)
 	_ = rawv1.AddToScheme(scheme)
+	_ = topolvmv1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -72,6 +78,7 @@ var AddToManagerFuncs = []func(manager.Manager, *cluster.Context, context.Contex
 	topolvmctr.Add,
 	rawdev_csi.Add,
 	topolvm_csi.Add,
+	topolvmwebhook.Add,
 }
 
 func startOperator(cmd *cobra.Command, args []string) error {
@@ -93,8 +100,8 @@ func startOperator(cmd *cobra.Command, args []string) error {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	topolvmcommon.NameSpace = os.Getenv(topolvmcommon.PodNameSpaceEnv)
-	if topolvmcommon.NameSpace == "" {
+	namespace := os.Getenv(topolvmcommon.PodNameSpaceEnv)
+	if namespace == "" {
 		logger.Errorf("unable get env %s ", topolvmcommon.PodNameSpaceEnv)
 		return fmt.Errorf("get env:%s failed ", topolvmcommon.PodNameSpaceEnv)
 	}
@@ -102,8 +109,8 @@ func startOperator(cmd *cobra.Command, args []string) error {
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		Namespace:              topolvmcommon.NameSpace,
+		Port:                   topolvmcommon.WebhookPort,
+		Namespace:              namespace,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "c6b32c27.cybozu.com",
@@ -115,6 +122,10 @@ func startOperator(cmd *cobra.Command, args []string) error {
 
 	ctx := cluster.NewContext()
 	ctx.Client = mgr.GetClient()
+	ctx.RawDeviceClientset, err = rawclient.NewForConfig(ctx.KubeConfig)
+	if err != nil {
+		return errors.Wrap(err, "failed to create raw device clientset")
+	}
 	err = topolvmctr.RemoveNodeCapacityAnnotations(ctx.Clientset)
 	if err != nil {
 		logger.Errorf("RemoveNodeCapacityAnnotations failed err %v", err)
@@ -124,12 +135,12 @@ func startOperator(cmd *cobra.Command, args []string) error {
 	operatorImage := topolvm.GetOperatorImage(ctx.Clientset, "")
 	config := operator.OperatorConfig{
 		Image:             operatorImage,
-		NamespaceToWatch:  topolvmcommon.NameSpace,
-		OperatorNamespace: topolvmcommon.NameSpace,
+		NamespaceToWatch:  namespace,
+		OperatorNamespace: namespace,
 	}
 
 	opctx := context.TODO()
-	setting, err := ctx.Clientset.CoreV1().ConfigMaps(topolvmcommon.NameSpace).Get(opctx, operator.OperatorSettingConfigMapName, metav1.GetOptions{})
+	setting, err := ctx.Clientset.CoreV1().ConfigMaps(namespace).Get(opctx, operator.OperatorSettingConfigMapName, metav1.GetOptions{})
 	if err != nil {
 		if kerrors.IsNotFound(err) {
 			logger.Debug("operator's configmap resource not found. will use default value or env var.")
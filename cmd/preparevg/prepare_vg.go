@@ -23,8 +23,10 @@ import (
 	"os"
 
 	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	rawclient "github.com/alauda/nativestor/generated/nativestore/rawdevice/clientset/versioned"
 	topolvmclient "github.com/alauda/nativestor/generated/nativestore/topolvm/clientset/versioned"
 	"github.com/alauda/nativestor/pkg/cluster"
+	"github.com/alauda/nativestor/pkg/util/sys"
 	"github.com/coreos/pkg/capnslog"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -70,6 +72,12 @@ func prepareVg(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("get env %s failed", topolvm.ClusterNameEnv)
 	}
 
+	rules, err := sys.ParseDeviceRules(os.Getenv(sys.DeviceRulesEnv))
+	if err != nil {
+		logger.Errorf("parse device eligibility rules failed %v", err)
+		return err
+	}
+
 	context := cluster.NewContext()
 
 	topolvmClientset, err := topolvmclient.NewForConfig(context.KubeConfig)
@@ -77,6 +85,13 @@ func prepareVg(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	context.TopolvmClusterClientset = topolvmClientset
-	c := volumegroup.NewPrepareVg(nodeName, namespace, topolvmClusterName, context)
+
+	// node classes pick their devices from the discovered inventory
+	rawClientset, err := rawclient.NewForConfig(context.KubeConfig)
+	if err != nil {
+		return err
+	}
+	context.RawDeviceClientset = rawClientset
+	c := volumegroup.NewPrepareVg(nodeName, namespace, topolvmClusterName, context, rules)
 	return c.Start()
 }
@@ -6,36 +6,52 @@ import (
 	"fmt"
 	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
 	"github.com/alauda/nativestor/csi"
+	rawinformer "github.com/alauda/nativestor/generated/nativestore/rawdevice/informers/externalversions/rawdevice/v1"
 	lister "github.com/alauda/nativestor/generated/nativestore/rawdevice/listers/rawdevice/v1"
 	clientctx "github.com/alauda/nativestor/pkg/cluster"
 	"github.com/alauda/nativestor/pkg/raw_device"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"strconv"
 	"strings"
 )
 
 var ctrlLogger = ctrl.Log.WithName("driver").WithName("controller")
 
 // NewControllerService returns a new ControllerServer.
-func NewControllerService(ctx *clientctx.Context, deviceLister lister.RawDeviceLister) csi.ControllerServer {
+//
+// The informer must have RawDeviceIndexers registered before it is started.
+// Provisioning decisions are reported through recorder on the PVC and the
+// RawDevice involved.
+func NewControllerService(ctx *clientctx.Context, deviceInformer rawinformer.RawDeviceInformer, recorder record.EventRecorder) csi.ControllerServer {
 	return &controllerService{
-		ctx:             ctx,
-		rawDeviceLister: deviceLister,
+		ctx:              ctx,
+		rawDeviceLister:  deviceInformer.Lister(),
+		rawDeviceIndexer: deviceInformer.Informer().GetIndexer(),
+		recorder:         recorder,
 	}
 }
 
 type controllerService struct {
 	csi.UnimplementedControllerServer
-	ctx             *clientctx.Context
-	rawDeviceLister lister.RawDeviceLister
+	ctx              *clientctx.Context
+	rawDeviceLister  lister.RawDeviceLister
+	rawDeviceIndexer cache.Indexer
+	recorder         record.EventRecorder
 }
 
-func (s controllerService) getMaxCapacity(ctx context.Context) (node string, capacity int64, err error) {
+// getMaxCapacity returns the node holding the largest device which can serve
+// the requirements.
+func (s controllerService) getMaxCapacity(ctx context.Context, requirements deviceRequirements) (node string, capacity int64, err error) {
 
 	// list RawDevice find out max size
 	rawDevicelist, err := s.rawDeviceLister.List(labels.Everything())
@@ -44,7 +60,7 @@ func (s controllerService) getMaxCapacity(ctx context.Context) (node string, cap
 	}
 
 	for _, ele := range rawDevicelist {
-		if (ele.Status.Name != "") || (!ele.Spec.Available) {
+		if rejectReason(ele, 0, requirements) != "" {
 			continue
 		}
 		if ele.Spec.Size > capacity {
@@ -56,52 +72,134 @@ func (s controllerService) getMaxCapacity(ctx context.Context) (node string, cap
 	return
 }
 
-func (s controllerService) createVolume(ctx context.Context, node string, requestGb int64, name string) (volumeId string, err error) {
+// deviceRequirements are the StorageClass parameters a raw device must match.
+type deviceRequirements struct {
+	deviceType       string
+	numaNode         *int32
+	logicalBlockSize int64
+	discard          bool
+}
+
+func parseDeviceRequirements(params map[string]string) (deviceRequirements, error) {
+	requirements := deviceRequirements{deviceType: params[raw_device.DeviceTypeKey]}
+	if v, ok := params[raw_device.NUMANodeKey]; ok {
+		node, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || node < 0 {
+			return requirements, fmt.Errorf("invalid %s %q", raw_device.NUMANodeKey, v)
+		}
+		n := int32(node)
+		requirements.numaNode = &n
+	}
+	if v, ok := params[raw_device.LogicalBlockSizeKey]; ok {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size <= 0 {
+			return requirements, fmt.Errorf("invalid %s %q", raw_device.LogicalBlockSizeKey, v)
+		}
+		requirements.logicalBlockSize = size
+	}
+	if v, ok := params[raw_device.DiscardKey]; ok {
+		discard, err := strconv.ParseBool(v)
+		if err != nil {
+			return requirements, fmt.Errorf("invalid %s %q", raw_device.DiscardKey, v)
+		}
+		requirements.discard = discard
+	}
+	return requirements, nil
+}
+
+// match tells whether dev satisfies the requirements, devices reporting no
+// topology only match requirements on their type.
+func (r deviceRequirements) match(dev *v1.RawDevice) bool {
+	if r.deviceType != "" && dev.Spec.Type != r.deviceType {
+		return false
+	}
+	topology := dev.Spec.Topology
+	if topology == nil {
+		topology = &v1.DeviceTopology{}
+	}
+	if r.numaNode != nil && (topology.NUMANode == nil || *topology.NUMANode != *r.numaNode) {
+		return false
+	}
+	if r.logicalBlockSize != 0 && topology.LogicalBlockSize != r.logicalBlockSize {
+		return false
+	}
+	if r.discard && !topology.Discard {
+		return false
+	}
+	return true
+}
+
+func (s controllerService) createVolume(ctx context.Context, node string, requestGb int64, name string, requirements deviceRequirements, pvc *corev1.PersistentVolumeClaim) (volumeId string, err error) {
 
 	// find rawdevice that match the requirement
-	set := labels.Set{"node": node}
-	rawDevicelist, err := s.rawDeviceLister.List(labels.SelectorFromSet(set))
+	rawDevicelist, err := byIndex(s.rawDeviceIndexer, NodeNameIndex, node)
 	if err != nil {
 		return "", err
 	}
-	matchIndex := -1
-	var matchSize int64
 
-	for index, dev := range rawDevicelist {
-		if (dev.Status.Name != "") || (!dev.Spec.Available) {
+	var match *v1.RawDevice
+	rejected := make(rejections)
+	for _, dev := range rawDevicelist {
+		if reason := rejectReason(dev, requestGb, requirements); reason != "" {
+			rejected[reason]++
 			continue
 		}
-		if (dev.Spec.Size >> 30) >= requestGb {
-			if matchSize == 0 {
-				matchSize = dev.Spec.Size
-				matchIndex = index
-			}
-
-			if dev.Spec.Size < matchSize {
-				matchSize = dev.Spec.Size
-				matchIndex = index
-			}
+		if match == nil || dev.Spec.Size < match.Spec.Size {
+			match = dev
 		}
 	}
 
-	if matchIndex >= 0 {
-		// update rawdevice
+	if match == nil {
+		msg := fmt.Sprintf("not found match device on node %s for %dGi: %s", node, requestGb, rejected)
+		s.pvcEvent(pvc, corev1.EventTypeWarning, EventReasonNoMatchingDevice, msg)
+		return "", status.Error(codes.Internal, msg)
+	}
 
-		device := rawDevicelist[matchIndex].DeepCopy()
-		volumeId = device.Name
-		device.Status.Name = volumeId
-		_, err = s.ctx.RawDeviceClientset.RawdeviceV1().RawDevices().UpdateStatus(ctx, device, metav1.UpdateOptions{})
-		if err != nil {
-			volumeId = ""
-			return
+	// update rawdevice
+	device := match.DeepCopy()
+	volumeId = device.Name
+	device.Status.Name = volumeId
+	_, err = s.ctx.RawDeviceClientset.RawdeviceV1().RawDevices().UpdateStatus(ctx, device, metav1.UpdateOptions{})
+	if err != nil {
+		s.pvcEvent(pvc, corev1.EventTypeWarning, EventReasonClaimFailed,
+			fmt.Sprintf("failed to claim raw device %s on node %s: %v", device.Name, node, err))
+		return "", err
+	}
+
+	size := resource.NewQuantity(device.Spec.Size, resource.BinarySI)
+	s.pvcEvent(pvc, corev1.EventTypeNormal, EventReasonDeviceClaimed,
+		fmt.Sprintf("claimed raw device %s (%s, %s) on node %s", device.Name, device.Spec.RealPath, size, node))
+	if s.recorder != nil {
+		claimer := name
+		if pvc != nil {
+			claimer = pvc.Namespace + "/" + pvc.Name
 		}
+		s.recorder.Eventf(device, corev1.EventTypeNormal, EventReasonDeviceClaimed, "claimed by %s", claimer)
+	}
 
-	} else {
+	return volumeId, nil
+}
 
-		return "", status.Error(codes.Internal, "not found match device")
+// getPVC returns the claim a CreateVolume request provisions for, or nil when
+// the provisioner does not pass it or it can not be read.
+func (s controllerService) getPVC(ctx context.Context, params map[string]string) *corev1.PersistentVolumeClaim {
+	name, namespace := params[pvcNameKey], params[pvcNamespaceKey]
+	if name == "" || namespace == "" || s.ctx.Clientset == nil {
+		return nil
+	}
+	pvc, err := s.ctx.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		ctrlLogger.Error(err, "get pvc for events failed", "namespace", namespace, "name", name)
+		return nil
 	}
+	return pvc
+}
 
-	return
+func (s controllerService) pvcEvent(pvc *corev1.PersistentVolumeClaim, eventType, reason, message string) {
+	if pvc == nil || s.recorder == nil {
+		return
+	}
+	s.recorder.Event(pvc, eventType, reason, message)
 }
 
 func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
@@ -155,6 +253,13 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	deviceReq, err := parseDeviceRequirements(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	pvc := s.getPVC(ctx, req.GetParameters())
+
 	// process topology
 	var node string
 	requirements := req.GetAccessibilityRequirements()
@@ -164,17 +269,22 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		// - https://github.com/container-storage-interface/spec/blob/release-1.1/spec.md#createvolume
 		// - https://github.com/kubernetes-csi/csi-test/blob/6738ab2206eac88874f0a3ede59b40f680f59f43/pkg/sanity/controller.go#L404-L428
 		ctrlLogger.Info("decide node because accessibility_requirements not found")
-		nodeName, capacity, err := s.getMaxCapacity(ctx)
+		nodeName, capacity, err := s.getMaxCapacity(ctx, deviceReq)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to get max capacity node %v", err)
 		}
 		if nodeName == "" {
+			s.pvcEvent(pvc, corev1.EventTypeWarning, EventReasonNoMatchingDevice, "no free raw device on any node")
 			return nil, status.Error(codes.Internal, "can not find any node")
 		}
 		if capacity < (requestGb << 30) {
+			s.pvcEvent(pvc, corev1.EventTypeWarning, EventReasonNoMatchingDevice,
+				fmt.Sprintf("largest free raw device is %s, smaller than %dGi", resource.NewQuantity(capacity, resource.BinarySI), requestGb))
 			return nil, status.Errorf(codes.ResourceExhausted, "can not find enough volume space %d", capacity)
 		}
 		node = nodeName
+		s.pvcEvent(pvc, corev1.EventTypeNormal, EventReasonNodeSelected,
+			fmt.Sprintf("selected node %s holding the largest free raw device", node))
 	} else {
 		for _, topo := range requirements.Preferred {
 			if v, ok := topo.GetSegments()[raw_device.TopologyNodeKey]; ok {
@@ -193,6 +303,8 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		if node == "" {
 			return nil, status.Errorf(codes.InvalidArgument, "cannot find key '%s' in accessibility_requirements", raw_device.TopologyNodeKey)
 		}
+		s.pvcEvent(pvc, corev1.EventTypeNormal, EventReasonNodeSelected,
+			fmt.Sprintf("selected node %s from accessibility requirements", node))
 	}
 
 	name := req.GetName()
@@ -202,7 +314,7 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 
 	name = strings.ToLower(name)
 
-	volumeID, err := s.createVolume(ctx, node, requestGb, name)
+	volumeID, err := s.createVolume(ctx, node, requestGb, name, deviceReq, pvc)
 	if err != nil {
 		_, ok := status.FromError(err)
 		if !ok {
@@ -246,45 +358,34 @@ func convertRequestCapacity(requestBytes, limitBytes int64) (int64, error) {
 
 func (s controllerService) deleteVolume(ctx context.Context, volumeId string) error {
 
-	rawDevicelist, err := s.rawDeviceLister.List(labels.Everything())
+	rawDevice, err := s.getVolume(ctx, volumeId)
 	if err != nil {
 		return err
 	}
-	matchIndex := -1
-	for index, ele := range rawDevicelist {
-		if ele.Status.Name == volumeId {
-			matchIndex = index
-		}
-	}
-
-	if matchIndex < 0 {
-		return status.Error(codes.NotFound, "")
-	}
-	rawDevice := rawDevicelist[matchIndex].DeepCopy()
 	rawDevice.Status.Name = ""
 	_, err = s.ctx.RawDeviceClientset.RawdeviceV1().RawDevices().UpdateStatus(ctx, rawDevice, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
+	if s.recorder != nil {
+		s.recorder.Eventf(rawDevice, corev1.EventTypeNormal, EventReasonDeviceReleased, "released from volume %s", volumeId)
+	}
 	return nil
 }
 
 func (s controllerService) getVolume(ctx context.Context, volumeId string) (*v1.RawDevice, error) {
 
-	rawDevices, err := s.rawDeviceLister.List(labels.Everything())
+	rawDevices, err := byIndex(s.rawDeviceIndexer, VolumeIDIndex, volumeId)
 	if err != nil {
 		return nil, err
 	}
-	matchIndex := -1
-	for index, ele := range rawDevices {
-		if ele.Status.Name == volumeId {
-			matchIndex = index
-		}
-	}
-	if matchIndex < 0 {
+	if len(rawDevices) == 0 {
 		return nil, status.Error(codes.NotFound, "")
 	}
-	return rawDevices[matchIndex].DeepCopy(), nil
+	if len(rawDevices) > 1 {
+		ctrlLogger.Info("volume claimed by more than one raw device", "volume_id", volumeId, "count", len(rawDevices))
+	}
+	return rawDevices[0].DeepCopy(), nil
 
 }
 
@@ -354,6 +455,11 @@ func (s controllerService) GetCapacity(ctx context.Context, req *csi.GetCapacity
 		ctrlLogger.Info("capability argument is not nil, but TopoLVM ignores it")
 	}
 
+	requirements, err := parseDeviceRequirements(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	var (
 		capacity          int64
 		maximumVolumeSize int64
@@ -369,8 +475,7 @@ func (s controllerService) GetCapacity(ctx context.Context, req *csi.GetCapacity
 			ctrlLogger.Error(err, "target node key is not found")
 			return &csi.GetCapacityResponse{AvailableCapacity: 0}, nil
 		}
-		var err error
-		capacity, maximumVolumeSize, minimumVolumeSize, err = s.getCapacityByTopologyLabel(ctx, v)
+		capacity, maximumVolumeSize, minimumVolumeSize, err = s.getCapacityByTopologyLabel(ctx, v, requirements)
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
@@ -383,22 +488,23 @@ func (s controllerService) GetCapacity(ctx context.Context, req *csi.GetCapacity
 	}, nil
 }
 
-func (s controllerService) getCapacityByTopologyLabel(ctx context.Context, node string) (availableCapacity int64, maximumVolumeSize int64, minimumVolumeSize int64, err error) {
+// getCapacityByTopologyLabel sums up the devices of node which can serve the
+// requirements, the same devices createVolume picks from.
+func (s controllerService) getCapacityByTopologyLabel(ctx context.Context, node string, requirements deviceRequirements) (availableCapacity int64, maximumVolumeSize int64, minimumVolumeSize int64, err error) {
 
-	set := labels.Set{"node": node}
-	rawDevicelist, err := s.rawDeviceLister.List(labels.SelectorFromSet(set))
+	rawDevicelist, err := byIndex(s.rawDeviceIndexer, NodeNameIndex, node)
 	if err != nil {
 		return 0, 0, 0, err
 	}
 
-	for index, dev := range rawDevicelist {
-		if dev.Status.Name != "" || !dev.Spec.Available {
+	for _, dev := range rawDevicelist {
+		if rejectReason(dev, 0, requirements) != "" {
 			continue
 		}
-		availableCapacity += dev.Spec.Size
-		if index == 0 {
+		if availableCapacity == 0 {
 			minimumVolumeSize = dev.Spec.Size
 		}
+		availableCapacity += dev.Spec.Size
 		if dev.Spec.Size > maximumVolumeSize {
 			maximumVolumeSize = dev.Spec.Size
 		}
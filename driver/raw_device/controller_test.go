@@ -0,0 +1,254 @@
+package raw_device
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	"github.com/alauda/nativestor/generated/nativestore/rawdevice/clientset/versioned/fake"
+	lister "github.com/alauda/nativestor/generated/nativestore/rawdevice/listers/rawdevice/v1"
+	clientctx "github.com/alauda/nativestor/pkg/cluster"
+	"github.com/alauda/nativestor/pkg/raw_device"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestRawDevice(node string, index int, size int64, volume string) *v1.RawDevice {
+	return &v1.RawDevice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   fmt.Sprintf("%s-dev-%d", node, index),
+			Labels: map[string]string{"node": node},
+		},
+		Spec: v1.RawDeviceSpec{
+			NodeName:  node,
+			Size:      size,
+			RealPath:  fmt.Sprintf("/dev/sd%d", index),
+			Available: true,
+		},
+		Status: v1.RawDeviceStatus{Name: volume},
+	}
+}
+
+func newTestControllerService(t testing.TB, devices []*v1.RawDevice) *controllerService {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, RawDeviceIndexers())
+	objects := make([]runtime.Object, 0, len(devices))
+	for _, dev := range devices {
+		if err := indexer.Add(dev); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, dev)
+	}
+	return &controllerService{
+		ctx: &clientctx.Context{
+			Clientset:          k8sfake.NewSimpleClientset(),
+			RawDeviceClientset: fake.NewSimpleClientset(objects...),
+		},
+		rawDeviceLister:  lister.NewRawDeviceLister(indexer),
+		rawDeviceIndexer: indexer,
+		recorder:         record.NewFakeRecorder(100),
+	}
+}
+
+func TestGetVolume(t *testing.T) {
+	s := newTestControllerService(t, []*v1.RawDevice{
+		newTestRawDevice("node1", 0, 10<<30, "pvc-a"),
+		newTestRawDevice("node1", 1, 10<<30, ""),
+		newTestRawDevice("node2", 0, 10<<30, "pvc-b"),
+	})
+
+	dev, err := s.getVolume(context.TODO(), "pvc-b")
+	assert.NoError(t, err)
+	assert.Equal(t, "node2-dev-0", dev.Name)
+
+	_, err = s.getVolume(context.TODO(), "pvc-c")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestCreateVolumePicksSmallestFitOnNode(t *testing.T) {
+	s := newTestControllerService(t, []*v1.RawDevice{
+		newTestRawDevice("node1", 0, 20<<30, ""),
+		newTestRawDevice("node1", 1, 5<<30, ""),
+		newTestRawDevice("node1", 2, 10<<30, ""),
+		newTestRawDevice("node1", 3, 8<<30, "pvc-a"),
+		newTestRawDevice("node2", 0, 6<<30, ""),
+	})
+
+	volumeID, err := s.createVolume(context.TODO(), "node1", 6, "pvc-b", deviceRequirements{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1-dev-2", volumeID)
+
+	_, err = s.createVolume(context.TODO(), "node2", 7, "pvc-c", deviceRequirements{}, nil)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestCreateVolumeEvents(t *testing.T) {
+	quarantined := newTestRawDevice("node1", 3, 50<<30, "")
+	quarantined.Spec.Available = false
+	native := newTestRawDevice("node1", 4, 50<<30, "")
+	native.Spec.Topology = &v1.DeviceTopology{LogicalBlockSize: 4096}
+	devices := []*v1.RawDevice{
+		newTestRawDevice("node1", 0, 5<<30, ""),
+		newTestRawDevice("node1", 1, 5<<30, ""),
+		newTestRawDevice("node1", 2, 50<<30, "pvc-a"),
+	}
+	for _, dev := range devices {
+		dev.Spec.Topology = &v1.DeviceTopology{LogicalBlockSize: 512}
+	}
+	s := newTestControllerService(t, append(devices, quarantined, native))
+	recorder := s.recorder.(*record.FakeRecorder)
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "ns"}}
+
+	_, err := s.createVolume(context.TODO(), "node1", 10, "pvc-b", deviceRequirements{logicalBlockSize: 512}, pvc)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "Warning NoMatchingDevice not found match device on node node1 for 10Gi: "+
+		"1 claimed, 1 parameter mismatch, 1 quarantined, 2 too small", <-recorder.Events)
+
+	volumeID, err := s.createVolume(context.TODO(), "node1", 10, "pvc-b", deviceRequirements{logicalBlockSize: 4096}, pvc)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1-dev-4", volumeID)
+	assert.Equal(t, "Normal DeviceClaimed claimed raw device node1-dev-4 (/dev/sd4, 50Gi) on node node1", <-recorder.Events)
+	assert.Equal(t, "Normal DeviceClaimed claimed by ns/data", <-recorder.Events)
+}
+
+func TestCreateVolumeMatchesTopology(t *testing.T) {
+	local := newTestRawDevice("node1", 0, 10<<30, "")
+	numaNode := int32(1)
+	local.Spec.Topology = &v1.DeviceTopology{NUMANode: &numaNode, LogicalBlockSize: 4096, Discard: true}
+	remote := newTestRawDevice("node1", 1, 10<<30, "")
+	remote.Spec.Topology = &v1.DeviceTopology{LogicalBlockSize: 512}
+	s := newTestControllerService(t, []*v1.RawDevice{remote, newTestRawDevice("node1", 2, 10<<30, ""), local})
+
+	requirements, err := parseDeviceRequirements(map[string]string{
+		raw_device.NUMANodeKey:         "1",
+		raw_device.LogicalBlockSizeKey: "4096",
+		raw_device.DiscardKey:          "true",
+	})
+	assert.NoError(t, err)
+	volumeID, err := s.createVolume(context.TODO(), "node1", 5, "pvc-a", requirements, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1-dev-0", volumeID)
+
+	remote.Spec.Type = "part"
+	requirements, err = parseDeviceRequirements(map[string]string{raw_device.DeviceTypeKey: "part"})
+	assert.NoError(t, err)
+	volumeID, err = s.createVolume(context.TODO(), "node1", 5, "pvc-b", requirements, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1-dev-1", volumeID)
+
+	for _, params := range []map[string]string{
+		{raw_device.NUMANodeKey: "-1"},
+		{raw_device.LogicalBlockSizeKey: "4k"},
+		{raw_device.DiscardKey: "yes please"},
+	} {
+		_, err := parseDeviceRequirements(params)
+		assert.Error(t, err, params)
+	}
+}
+
+func TestGetCapacityByTopologyLabel(t *testing.T) {
+	lost := newTestRawDevice("node1", 3, 40<<30, "")
+	lost.Spec.Lost = true
+	quarantined := newTestRawDevice("node1", 4, 2<<30, "")
+	quarantined.Spec.Available = false
+	ssd := newTestRawDevice("node1", 5, 8<<30, "")
+	ssd.Spec.Type = "ssd"
+	s := newTestControllerService(t, []*v1.RawDevice{
+		lost,
+		newTestRawDevice("node1", 0, 20<<30, ""),
+		newTestRawDevice("node1", 1, 5<<30, "pvc-a"),
+		newTestRawDevice("node1", 2, 10<<30, ""),
+		quarantined,
+		ssd,
+		newTestRawDevice("node2", 0, 6<<30, ""),
+	})
+
+	for _, tt := range []struct {
+		name         string
+		requirements deviceRequirements
+		available    int64
+		maximum      int64
+		minimum      int64
+	}{
+		{"any device", deviceRequirements{}, 38 << 30, 20 << 30, 8 << 30},
+		{"ssd only", deviceRequirements{deviceType: "ssd"}, 8 << 30, 8 << 30, 8 << 30},
+		{"no match", deviceRequirements{deviceType: "nvme"}, 0, 0, 0},
+	} {
+		available, maximum, minimum, err := s.getCapacityByTopologyLabel(context.TODO(), "node1", tt.requirements)
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.available, available, tt.name)
+		assert.Equal(t, tt.maximum, maximum, tt.name)
+		assert.Equal(t, tt.minimum, minimum, tt.name)
+	}
+}
+
+func TestGetMaxCapacity(t *testing.T) {
+	lost := newTestRawDevice("node1", 0, 40<<30, "")
+	lost.Spec.Lost = true
+	ssd := newTestRawDevice("node2", 1, 8<<30, "")
+	ssd.Spec.Type = "ssd"
+	s := newTestControllerService(t, []*v1.RawDevice{
+		lost,
+		newTestRawDevice("node1", 1, 30<<30, "pvc-a"),
+		newTestRawDevice("node2", 0, 10<<30, ""),
+		ssd,
+	})
+
+	node, capacity, err := s.getMaxCapacity(context.TODO(), deviceRequirements{})
+	assert.NoError(t, err)
+	assert.Equal(t, "node2", node)
+	assert.Equal(t, int64(10<<30), capacity)
+
+	node, capacity, err = s.getMaxCapacity(context.TODO(), deviceRequirements{deviceType: "ssd"})
+	assert.NoError(t, err)
+	assert.Equal(t, "node2", node)
+	assert.Equal(t, int64(8<<30), capacity)
+}
+
+func BenchmarkGetVolume10k(b *testing.B) {
+	const nodes, devicesPerNode = 200, 50
+	devices := make([]*v1.RawDevice, 0, nodes*devicesPerNode)
+	for n := 0; n < nodes; n++ {
+		for d := 0; d < devicesPerNode; d++ {
+			volume := ""
+			if d%2 == 0 {
+				volume = fmt.Sprintf("pvc-%d-%d", n, d)
+			}
+			devices = append(devices, newTestRawDevice(fmt.Sprintf("node%d", n), d, 10<<30, volume))
+		}
+	}
+	s := newTestControllerService(b, devices)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		volume := fmt.Sprintf("pvc-%d-%d", i%nodes, (i%devicesPerNode)&^1)
+		if _, err := s.getVolume(context.TODO(), volume); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetCapacityByTopologyLabel10k(b *testing.B) {
+	const nodes, devicesPerNode = 200, 50
+	devices := make([]*v1.RawDevice, 0, nodes*devicesPerNode)
+	for n := 0; n < nodes; n++ {
+		for d := 0; d < devicesPerNode; d++ {
+			devices = append(devices, newTestRawDevice(fmt.Sprintf("node%d", n), d, 10<<30, ""))
+		}
+	}
+	s := newTestControllerService(b, devices)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := s.getCapacityByTopologyLabel(context.TODO(), fmt.Sprintf("node%d", i%nodes), deviceRequirements{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package raw_device
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+)
+
+// reasons of the events emitted by the controller service
+const (
+	EventReasonNodeSelected     = "NodeSelected"
+	EventReasonNoMatchingDevice = "NoMatchingDevice"
+	EventReasonDeviceClaimed    = "DeviceClaimed"
+	EventReasonDeviceReleased   = "DeviceReleased"
+	EventReasonClaimFailed      = "ClaimFailed"
+)
+
+// reasons a candidate device is rejected
+const (
+	rejectClaimed      = "claimed"
+	rejectLost         = "unavailable"
+	rejectQuarantined  = "quarantined"
+	rejectTooSmall     = "too small"
+	rejectTypeMismatch = "parameter mismatch"
+)
+
+// keys of the PVC metadata passed by external-provisioner with --extra-create-metadata
+const (
+	pvcNameKey      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+)
+
+// rejections counts why devices of a node could not serve a request.
+type rejections map[string]int
+
+// rejectReason returns why dev can not serve a request of requestGb
+// gigabytes with the given requirements, or "" if it can.
+func rejectReason(dev *v1.RawDevice, requestGb int64, requirements deviceRequirements) string {
+	switch DeviceState(dev) {
+	case DeviceStateLost:
+		return rejectLost
+	case DeviceStateClaimed:
+		return rejectClaimed
+	case DeviceStateQuarantined:
+		return rejectQuarantined
+	}
+	if !requirements.match(dev) {
+		return rejectTypeMismatch
+	}
+	if (dev.Spec.Size >> 30) < requestGb {
+		return rejectTooSmall
+	}
+	return ""
+}
+
+func (r rejections) String() string {
+	if len(r) == 0 {
+		return "no device found"
+	}
+	reasons := make([]string, 0, len(r))
+	for reason := range r {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	parts := make([]string, 0, len(r))
+	for _, reason := range reasons {
+		parts = append(parts, fmt.Sprintf("%d %s", r[reason], reason))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,62 @@
+package raw_device
+
+import (
+	"fmt"
+
+	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// VolumeIDIndex indexes RawDevices by the volume id that claimed them.
+	VolumeIDIndex = "volumeID"
+	// NodeNameIndex indexes RawDevices by the node they are attached to.
+	NodeNameIndex = "nodeName"
+)
+
+// RawDeviceIndexers returns the indexers the CSI services rely on to look up
+// RawDevices without walking the whole informer cache.
+func RawDeviceIndexers() cache.Indexers {
+	return cache.Indexers{
+		VolumeIDIndex: volumeIDIndexFunc,
+		NodeNameIndex: nodeNameIndexFunc,
+	}
+}
+
+func volumeIDIndexFunc(obj interface{}) ([]string, error) {
+	dev, ok := obj.(*v1.RawDevice)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	if dev.Status.Name == "" {
+		return nil, nil
+	}
+	return []string{dev.Status.Name}, nil
+}
+
+func nodeNameIndexFunc(obj interface{}) ([]string, error) {
+	dev, ok := obj.(*v1.RawDevice)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	if dev.Spec.NodeName == "" {
+		return nil, nil
+	}
+	return []string{dev.Spec.NodeName}, nil
+}
+
+func byIndex(indexer cache.Indexer, indexName, value string) ([]*v1.RawDevice, error) {
+	objs, err := indexer.ByIndex(indexName, value)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*v1.RawDevice, 0, len(objs))
+	for _, obj := range objs {
+		dev, ok := obj.(*v1.RawDevice)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object type %T in index %s", obj, indexName)
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
@@ -0,0 +1,133 @@
+package raw_device
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	lister "github.com/alauda/nativestor/generated/nativestore/rawdevice/listers/rawdevice/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	metricsNamespace = "rawdevice"
+
+	// device states reported by the pool gauges
+	DeviceStateFree        = "free"
+	DeviceStateClaimed     = "claimed"
+	DeviceStateQuarantined = "quarantined"
+	DeviceStateLost        = "lost"
+)
+
+var metricsLogger = ctrl.Log.WithName("driver").WithName("metrics")
+
+var (
+	devicesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "pool", "devices"),
+		"Number of raw devices per node, state, type and rotational.",
+		[]string{"node", "state", "type", "rotational"}, nil)
+	bytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "pool", "bytes"),
+		"Capacity in bytes of raw devices per node, state, type and rotational.",
+		[]string{"node", "state", "type", "rotational"}, nil)
+
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "csi",
+		Name:      "requests_total",
+		Help:      "Number of CSI RPCs by method and gRPC status code.",
+	}, []string{"method", "code"})
+	grpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "csi",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of CSI RPCs by method.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"method"})
+)
+
+// DeviceState classifies a RawDevice for the pool gauges. Claimed devices that
+// disappeared are lost, unclaimed devices that discovery found unusable are
+// quarantined.
+func DeviceState(dev *v1.RawDevice) string {
+	switch {
+	case dev.Spec.Lost:
+		return DeviceStateLost
+	case dev.Status.Name != "":
+		return DeviceStateClaimed
+	case !dev.Spec.Available:
+		return DeviceStateQuarantined
+	default:
+		return DeviceStateFree
+	}
+}
+
+type poolKey struct {
+	node, state, deviceType, rotational string
+}
+
+// poolCollector computes the pool gauges from the informer cache on every
+// scrape, so they never go stale when devices are deleted.
+type poolCollector struct {
+	lister lister.RawDeviceLister
+}
+
+// NewPoolCollector returns a collector exporting raw device pool gauges.
+func NewPoolCollector(deviceLister lister.RawDeviceLister) prometheus.Collector {
+	return &poolCollector{lister: deviceLister}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- devicesDesc
+	ch <- bytesDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	devices, err := c.lister.List(labels.Everything())
+	if err != nil {
+		metricsLogger.Error(err, "list raw devices for metrics failed")
+		return
+	}
+	counts := make(map[poolKey]float64)
+	sizes := make(map[poolKey]float64)
+	for _, dev := range devices {
+		key := poolKey{
+			node:       dev.Spec.NodeName,
+			state:      DeviceState(dev),
+			deviceType: dev.Spec.Type,
+			rotational: strconv.FormatBool(dev.Spec.Rotational),
+		}
+		counts[key]++
+		sizes[key] += float64(dev.Spec.Size)
+	}
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(devicesDesc, prometheus.GaugeValue, count,
+			key.node, key.state, key.deviceType, key.rotational)
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, sizes[key],
+			key.node, key.state, key.deviceType, key.rotational)
+	}
+}
+
+// RegisterRPCMetrics registers the CSI RPC metrics into registry.
+func RegisterRPCMetrics(registry prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{grpcRequests, grpcDuration} {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MetricsInterceptor records the count and latency of every CSI RPC.
+func MetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return resp, err
+}
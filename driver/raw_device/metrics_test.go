@@ -0,0 +1,42 @@
+package raw_device
+
+import (
+	"strings"
+	"testing"
+
+	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	lister "github.com/alauda/nativestor/generated/nativestore/rawdevice/listers/rawdevice/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPoolCollector(t *testing.T) {
+	quarantined := newTestRawDevice("node1", 2, 5<<30, "")
+	quarantined.Spec.Available = false
+	lost := newTestRawDevice("node1", 3, 5<<30, "pvc-b")
+	lost.Spec.Lost = true
+	hdd := newTestRawDevice("node2", 0, 100<<30, "")
+	hdd.Spec.Rotational = true
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, RawDeviceIndexers())
+	for _, dev := range []*v1.RawDevice{
+		newTestRawDevice("node1", 0, 10<<30, ""),
+		newTestRawDevice("node1", 1, 10<<30, "pvc-a"),
+		quarantined, lost, hdd,
+	} {
+		assert.NoError(t, indexer.Add(dev))
+	}
+
+	expected := `
+# HELP rawdevice_pool_devices Number of raw devices per node, state, type and rotational.
+# TYPE rawdevice_pool_devices gauge
+rawdevice_pool_devices{node="node1",rotational="false",state="claimed",type=""} 1
+rawdevice_pool_devices{node="node1",rotational="false",state="free",type=""} 1
+rawdevice_pool_devices{node="node1",rotational="false",state="lost",type=""} 1
+rawdevice_pool_devices{node="node1",rotational="false",state="quarantined",type=""} 1
+rawdevice_pool_devices{node="node2",rotational="true",state="free",type=""} 1
+`
+	collector := NewPoolCollector(lister.NewRawDeviceLister(indexer))
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected), "rawdevice_pool_devices"))
+}
@@ -2,6 +2,7 @@ package raw_device
 
 import (
 	"context"
+	"fmt"
 	"github.com/alauda/nativestor/csi"
 	lister "github.com/alauda/nativestor/generated/nativestore/rawdevice/listers/rawdevice/v1"
 	clientctx "github.com/alauda/nativestor/pkg/cluster"
@@ -10,6 +11,7 @@ import (
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	mountutil "k8s.io/mount-utils"
 	utilexec "k8s.io/utils/exec"
@@ -17,6 +19,7 @@ import (
 	"path"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sync"
+	"time"
 )
 
 const (
@@ -26,11 +29,14 @@ const (
 var nodeLogger = ctrl.Log.WithName("driver").WithName("node")
 
 // NewNodeService returns a new NodeServer.
-func NewNodeService(ctx *clientctx.Context, deviceLister lister.RawDeviceLister, nodeName string) csi.NodeServer {
+// volumeCache keeps staged and published volumes on local disk so they can be
+// staged and published again while the API server is unreachable.
+func NewNodeService(ctx *clientctx.Context, deviceLister lister.RawDeviceLister, volumeCache *VolumeCache, nodeName string) csi.NodeServer {
 	return &nodeService{
 		nodeName:        nodeName,
 		ctx:             ctx,
 		rawDeviceLister: deviceLister,
+		volumeCache:     volumeCache,
 		mounter: mountutil.SafeFormatAndMount{
 			Interface: mountutil.New(""),
 			Exec:      utilexec.New(),
@@ -42,6 +48,7 @@ type nodeService struct {
 	csi.UnimplementedNodeServer
 	ctx             *clientctx.Context
 	rawDeviceLister lister.RawDeviceLister
+	volumeCache     *VolumeCache
 	nodeName        string
 	mu              sync.Mutex
 	mounter         mountutil.SafeFormatAndMount
@@ -94,14 +101,23 @@ func (s *nodeService) nodePublishBlockVolume(ctx context.Context, req *csi.NodeP
 	}
 
 	//get device major and minor
-	rawDevice, err := s.ctx.RawDeviceClientset.RawdeviceV1().RawDevices().Get(ctx, req.VolumeId, metav1.GetOptions{})
+	vol, err := s.resolveVolume(ctx, req.GetVolumeId())
 	if err != nil {
 		return err
 	}
 
-	devno := unix.Mkdev(rawDevice.Spec.Major, rawDevice.Spec.Minor)
-	if err := filesystem.Mknod(target, devicePermission, int(devno)); err != nil {
-		return status.Errorf(codes.Internal, "mknod failed for %s: error=%v", target, err)
+	devno := unix.Mkdev(vol.Major, vol.Minor)
+	published, err := isPublished(target, devno)
+	if err != nil {
+		return status.Errorf(codes.Internal, "stat failed for %s: error=%v", target, err)
+	}
+	if !published {
+		if err := filesystem.Mknod(target, devicePermission, int(devno)); err != nil {
+			return status.Errorf(codes.Internal, "mknod failed for %s: error=%v", target, err)
+		}
+	}
+	if err := s.volumeCache.Publish(vol, target); err != nil {
+		nodeLogger.Error(err, "failed to record volume in cache", "volume_id", req.GetVolumeId())
 	}
 
 	nodeLogger.Info("NodePublishVolume(block) succeeded",
@@ -110,6 +126,63 @@ func (s *nodeService) nodePublishBlockVolume(ctx context.Context, req *csi.NodeP
 	return nil
 }
 
+// resolveVolume resolves the device of a volume from its RawDevice. When the
+// API server cannot be reached the locally cached entry is used, the device is
+// then found through the identity recorded while the API server was reachable.
+func (s *nodeService) resolveVolume(ctx context.Context, volumeID string) (CachedVolume, error) {
+	rawDevice, err := s.ctx.RawDeviceClientset.RawdeviceV1().RawDevices().Get(ctx, volumeID, metav1.GetOptions{})
+	if err == nil {
+		vol := CachedVolume{
+			VolumeID: volumeID,
+			RealPath: rawDevice.Spec.RealPath,
+			Major:    rawDevice.Spec.Major,
+			Minor:    rawDevice.Spec.Minor,
+			Size:     rawDevice.Spec.Size,
+			Verified: time.Now(),
+		}
+		if vol.ID, err = deviceIdentity(vol.Major, vol.Minor); err != nil {
+			nodeLogger.Error(err, "no stable identity of device, it can not be published while the API server is unavailable",
+				"volume_id", volumeID,
+				"real_path", vol.RealPath)
+		}
+		return vol, nil
+	}
+	if kerrors.IsNotFound(err) {
+		return CachedVolume{}, status.Errorf(codes.NotFound, "raw device %s not found", volumeID)
+	}
+
+	cached, ok := s.volumeCache.Get(volumeID)
+	if !ok {
+		return CachedVolume{}, status.Errorf(codes.Unavailable, "failed to get raw device %s and it is not cached: %v", volumeID, err)
+	}
+	resolved, rerr := resolveCached(cached)
+	if rerr != nil {
+		return CachedVolume{}, status.Errorf(codes.Unavailable, "failed to get raw device %s and cached entry is stale: %v", volumeID, rerr)
+	}
+	nodeLogger.Info("API server unavailable, use volume from cache",
+		"volume_id", volumeID,
+		"id", resolved.ID,
+		"real_path", resolved.RealPath,
+		"error", err.Error())
+	return resolved, nil
+}
+
+// isPublished reports whether target already is the block device devno, so a
+// repeated NodePublishVolume call after a restart succeeds.
+func isPublished(target string, devno uint64) (bool, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(target, &st); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFBLK || uint64(st.Rdev) != devno {
+		return false, fmt.Errorf("%s exists and is not the expected device", target)
+	}
+	return true, nil
+}
+
 func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	volID := req.GetVolumeId()
 	target := req.GetTargetPath()
@@ -131,17 +204,83 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 }
 
 func (s *nodeService) nodeUnpublishBlockVolume(req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	if err := os.Remove(req.GetTargetPath()); err != nil {
+	if err := os.Remove(req.GetTargetPath()); err != nil && !os.IsNotExist(err) {
 		return nil, status.Errorf(codes.Internal, "remove failed for %s: error=%v", req.GetTargetPath(), err)
 	}
+	if err := s.volumeCache.Unpublish(req.GetVolumeId(), req.GetTargetPath()); err != nil {
+		nodeLogger.Error(err, "failed to remove volume from cache", "volume_id", req.GetVolumeId())
+	}
 	nodeLogger.Info("NodeUnpublishVolume(block) is succeeded",
 		"volume_id", req.GetVolumeId(),
 		"target_path", req.GetTargetPath())
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// NodeStageVolume only records the device of the volume in the cache, a raw
+// device is published as it is.
+func (s *nodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	nodeLogger.Info("NodeStageVolume called",
+		"volume_id", volumeID,
+		"staging_target_path", req.GetStagingTargetPath())
+
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
+	}
+	if len(req.GetStagingTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no staging_target_path is provided")
+	}
+	if req.GetVolumeCapability().GetBlock() == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "no supported volume capability: %v", req.GetVolumeCapability())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vol, err := s.resolveVolume(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.volumeCache.Stage(vol, req.GetStagingTargetPath()); err != nil {
+		nodeLogger.Error(err, "failed to record volume in cache", "volume_id", volumeID)
+	}
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (s *nodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	nodeLogger.Info("NodeUnstageVolume called",
+		"volume_id", volumeID,
+		"staging_target_path", req.GetStagingTargetPath())
+
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
+	}
+	if len(req.GetStagingTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no staging_target_path is provided")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.volumeCache.Unstage(volumeID); err != nil {
+		nodeLogger.Error(err, "failed to remove volume from cache", "volume_id", volumeID)
+	}
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
 func (s *nodeService) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	return &csi.NodeGetCapabilitiesResponse{}, nil
+	return &csi.NodeGetCapabilitiesResponse{
+		Capabilities: []*csi.NodeServiceCapability{
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+					},
+				},
+			},
+		},
+	}, nil
 }
 
 func (s *nodeService) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
@@ -0,0 +1,380 @@
+package raw_device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	rawclient "github.com/alauda/nativestor/generated/nativestore/rawdevice/clientset/versioned"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sysDevBlock lists the block devices of the node by device number.
+var sysDevBlock = "/sys/dev/block"
+
+// CachedVolume is what the node plugin remembers about a staged or published
+// volume.
+type CachedVolume struct {
+	VolumeID string `json:"volumeID"`
+	RealPath string `json:"realPath"`
+	Major    uint32 `json:"major"`
+	Minor    uint32 `json:"minor"`
+	// ID is the stable identity of the device, see deviceIdentity, it is
+	// empty when the device reports none
+	ID string `json:"id,omitempty"`
+	// Size is the size of the device in bytes
+	Size        int64     `json:"size,omitempty"`
+	StagingPath string    `json:"stagingPath,omitempty"`
+	TargetPaths []string  `json:"targetPaths"`
+	Verified    time.Time `json:"verified"`
+}
+
+// VolumeCache is a durable record of the volumes staged or published on this
+// node.
+// It lets the node plugin republish a volume while the API server is
+// unreachable, for example right after a node reboot.
+type VolumeCache struct {
+	path    string
+	mu      sync.Mutex
+	volumes map[string]*CachedVolume
+}
+
+// NewVolumeCache loads the cache stored at path. A missing file yields an
+// empty cache.
+func NewVolumeCache(path string) (*VolumeCache, error) {
+	c := &VolumeCache{
+		path:    path,
+		volumes: make(map[string]*CachedVolume),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("failed to read volume cache %s: %v", path, err)
+	}
+	if len(data) == 0 {
+		return c, nil
+	}
+	volumes := make([]*CachedVolume, 0)
+	if err := json.Unmarshal(data, &volumes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal volume cache %s: %v", path, err)
+	}
+	for _, vol := range volumes {
+		c.volumes[vol.VolumeID] = vol
+	}
+	return c, nil
+}
+
+// Get returns a copy of the cached volume.
+func (c *VolumeCache) Get(volumeID string) (CachedVolume, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vol, ok := c.volumes[volumeID]
+	if !ok {
+		return CachedVolume{}, false
+	}
+	res := *vol
+	res.TargetPaths = append([]string(nil), vol.TargetPaths...)
+	return res, true
+}
+
+// Stage records that the device described by v is staged at stagingPath.
+func (c *VolumeCache) Stage(v CachedVolume, stagingPath string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record(v).StagingPath = stagingPath
+	return c.save()
+}
+
+// Unstage forgets the staging path, and the whole volume once it is not
+// published either.
+func (c *VolumeCache) Unstage(volumeID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vol, ok := c.volumes[volumeID]
+	if !ok {
+		return nil
+	}
+	vol.StagingPath = ""
+	if len(vol.TargetPaths) == 0 {
+		delete(c.volumes, volumeID)
+	}
+	return c.save()
+}
+
+// Publish records that the device described by v is published at target.
+func (c *VolumeCache) Publish(v CachedVolume, target string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vol := c.record(v)
+	if !containsString(vol.TargetPaths, target) {
+		vol.TargetPaths = append(vol.TargetPaths, target)
+	}
+	return c.save()
+}
+
+// record updates the device of the volume. The caller must hold c.mu.
+func (c *VolumeCache) record(v CachedVolume) *CachedVolume {
+	vol, ok := c.volumes[v.VolumeID]
+	if !ok {
+		vol = &CachedVolume{VolumeID: v.VolumeID}
+		c.volumes[v.VolumeID] = vol
+	}
+	vol.RealPath = v.RealPath
+	vol.Major = v.Major
+	vol.Minor = v.Minor
+	vol.ID = v.ID
+	vol.Size = v.Size
+	if v.Verified.After(vol.Verified) {
+		vol.Verified = v.Verified
+	}
+	return vol
+}
+
+// Unpublish forgets target, and the whole volume once no target is left and
+// it is not staged.
+func (c *VolumeCache) Unpublish(volumeID string, target string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vol, ok := c.volumes[volumeID]
+	if !ok {
+		return nil
+	}
+	targets := vol.TargetPaths[:0]
+	for _, t := range vol.TargetPaths {
+		if t != target {
+			targets = append(targets, t)
+		}
+	}
+	vol.TargetPaths = targets
+	if len(vol.TargetPaths) == 0 && vol.StagingPath == "" {
+		delete(c.volumes, volumeID)
+	}
+	return c.save()
+}
+
+// Reconcile checks every cached volume against the API server. Volumes whose
+// RawDevice is gone, released or moved to another node are dropped, the others
+// are refreshed. It stops at the first connectivity error so the remaining
+// entries are kept until the next round.
+func (c *VolumeCache) Reconcile(ctx context.Context, clientset rawclient.Interface, nodeName string) error {
+	for _, volumeID := range c.volumeIDs() {
+		device, err := clientset.RawdeviceV1().RawDevices().Get(ctx, volumeID, metav1.GetOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		if err := c.reconcileVolume(volumeID, device, err == nil, nodeName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run reconciles the cache every interval until ctx is done.
+func (c *VolumeCache) Run(ctx context.Context, clientset rawclient.Interface, nodeName string, interval time.Duration) {
+	for {
+		if err := c.Reconcile(ctx, clientset, nodeName); err != nil {
+			nodeLogger.Error(err, "reconcile volume cache failed, will retry")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *VolumeCache) reconcileVolume(volumeID string, device *v1.RawDevice, found bool, nodeName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vol, ok := c.volumes[volumeID]
+	if !ok {
+		return nil
+	}
+	if !found || device.Spec.NodeName != nodeName || device.Status.Name != volumeID {
+		nodeLogger.Info("drop stale volume from cache", "volume_id", volumeID, "target_paths", vol.TargetPaths)
+		delete(c.volumes, volumeID)
+		return c.save()
+	}
+	// the identity stays the one of the device that was published, the
+	// numbers of the raw device may lag behind a reboot
+	vol.RealPath = device.Spec.RealPath
+	vol.Major = device.Spec.Major
+	vol.Minor = device.Spec.Minor
+	vol.Verified = time.Now()
+	return c.save()
+}
+
+func (c *VolumeCache) volumeIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, 0, len(c.volumes))
+	for id := range c.volumes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// save writes the cache through a temporary file so a crash never leaves a
+// truncated file behind. The caller must hold c.mu.
+func (c *VolumeCache) save() error {
+	volumes := make([]*CachedVolume, 0, len(c.volumes))
+	for _, id := range sortedKeys(c.volumes) {
+		volumes = append(volumes, c.volumes[id])
+	}
+	data, err := json.Marshal(volumes)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// blockDevice is a block device of the node as sysfs lists it.
+type blockDevice struct {
+	name  string
+	major uint32
+	minor uint32
+	size  int64
+}
+
+// deviceIdentity returns the stable identity of the block device major:minor.
+// Kernel names and device numbers are reassigned across reboots and hotplug,
+// the identity is not: the device mapper uuid, or the wwid or serial the
+// hardware reports, with the partition number for partitions.
+func deviceIdentity(major, minor uint32) (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysDevBlock, fmt.Sprintf("%d:%d", major, minor)))
+	if err != nil {
+		return "", err
+	}
+	return identityOf(dir)
+}
+
+func identityOf(dir string) (string, error) {
+	if uuid := readAttribute(filepath.Join(dir, "dm", "uuid")); uuid != "" {
+		return "dm-uuid-" + uuid, nil
+	}
+	if partition := readAttribute(filepath.Join(dir, "partition")); partition != "" {
+		disk, err := identityOf(filepath.Dir(dir))
+		if err != nil {
+			return "", err
+		}
+		return disk + "-part" + partition, nil
+	}
+	for _, attr := range []string{"wwid", "device/wwid", "serial", "device/serial"} {
+		if id := readAttribute(filepath.Join(dir, attr)); id != "" {
+			return filepath.Base(attr) + "-" + id, nil
+		}
+	}
+	return "", fmt.Errorf("%s reports neither a wwid nor a serial", filepath.Base(dir))
+}
+
+// findDevice looks up the block device of the node with the identity. It
+// fails unless exactly one device has it.
+func findDevice(id string) (blockDevice, error) {
+	entries, err := os.ReadDir(sysDevBlock)
+	if err != nil {
+		return blockDevice{}, err
+	}
+	var found []blockDevice
+	for _, entry := range entries {
+		dir, err := filepath.EvalSymlinks(filepath.Join(sysDevBlock, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if got, err := identityOf(dir); err != nil || got != id {
+			continue
+		}
+		dev := blockDevice{name: filepath.Base(dir)}
+		if _, err := fmt.Sscanf(entry.Name(), "%d:%d", &dev.major, &dev.minor); err != nil {
+			continue
+		}
+		// sysfs counts 512 byte sectors whatever the block size
+		if sectors, err := strconv.ParseInt(readAttribute(filepath.Join(dir, "size")), 10, 64); err == nil {
+			dev.size = sectors << 9
+		}
+		found = append(found, dev)
+	}
+	switch len(found) {
+	case 0:
+		return blockDevice{}, fmt.Errorf("no device is %s", id)
+	case 1:
+		return found[0], nil
+	default:
+		return blockDevice{}, fmt.Errorf("%d devices are %s", len(found), id)
+	}
+}
+
+// resolveCached finds the device of a cached volume through its identity,
+// it refuses volumes without one and devices whose size changed.
+func resolveCached(vol CachedVolume) (CachedVolume, error) {
+	if vol.ID == "" {
+		return vol, fmt.Errorf("no stable identity of %s was recorded", vol.RealPath)
+	}
+	dev, err := findDevice(vol.ID)
+	if err != nil {
+		return vol, err
+	}
+	if vol.Size != 0 && dev.size != vol.Size {
+		return vol, fmt.Errorf("%s is %d bytes but cache recorded %d", dev.name, dev.size, vol.Size)
+	}
+	vol.RealPath = "/dev/" + dev.name
+	vol.Major = dev.major
+	vol.Minor = dev.minor
+	return vol, nil
+}
+
+func readAttribute(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func sortedKeys(m map[string]*CachedVolume) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
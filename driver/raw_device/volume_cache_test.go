@@ -0,0 +1,152 @@
+package raw_device
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alauda/nativestor/generated/nativestore/rawdevice/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeCachePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "volumes.json")
+	c, err := NewVolumeCache(path)
+	assert.NoError(t, err)
+
+	vol := CachedVolume{VolumeID: "vol-a", RealPath: "/dev/sdb", Major: 8, Minor: 16}
+	assert.NoError(t, c.Publish(vol, "/target/1"))
+	assert.NoError(t, c.Publish(vol, "/target/2"))
+
+	reloaded, err := NewVolumeCache(path)
+	assert.NoError(t, err)
+	got, ok := reloaded.Get("vol-a")
+	assert.True(t, ok)
+	assert.Equal(t, "/dev/sdb", got.RealPath)
+	assert.Equal(t, []string{"/target/1", "/target/2"}, got.TargetPaths)
+
+	assert.NoError(t, reloaded.Unpublish("vol-a", "/target/1"))
+	_, ok = reloaded.Get("vol-a")
+	assert.True(t, ok)
+	assert.NoError(t, reloaded.Unpublish("vol-a", "/target/2"))
+	_, ok = reloaded.Get("vol-a")
+	assert.False(t, ok)
+
+	reloaded, err = NewVolumeCache(path)
+	assert.NoError(t, err)
+	_, ok = reloaded.Get("vol-a")
+	assert.False(t, ok)
+}
+
+func TestVolumeCacheReconcile(t *testing.T) {
+	kept := newTestRawDevice("node1", 0, 10<<30, "")
+	kept.Name = "vol-kept"
+	kept.Status.Name = "vol-kept"
+	kept.Spec.Major, kept.Spec.Minor = 8, 32
+	released := newTestRawDevice("node1", 1, 10<<30, "")
+	released.Name = "vol-released"
+	moved := newTestRawDevice("node2", 0, 10<<30, "")
+	moved.Name = "vol-moved"
+	moved.Status.Name = "vol-moved"
+
+	c, err := NewVolumeCache(filepath.Join(t.TempDir(), "volumes.json"))
+	assert.NoError(t, err)
+	for _, id := range []string{"vol-kept", "vol-released", "vol-moved", "vol-deleted"} {
+		assert.NoError(t, c.Publish(CachedVolume{VolumeID: id, RealPath: "/dev/sdx", Major: 8, Minor: 16}, "/target/"+id))
+	}
+
+	clientset := fake.NewSimpleClientset(kept, released, moved)
+	assert.NoError(t, c.Reconcile(context.TODO(), clientset, "node1"))
+
+	assert.Equal(t, []string{"vol-kept"}, c.volumeIDs())
+	got, _ := c.Get("vol-kept")
+	assert.Equal(t, kept.Spec.RealPath, got.RealPath)
+	assert.Equal(t, uint32(32), got.Minor)
+}
+
+func TestVolumeCacheStage(t *testing.T) {
+	c, err := NewVolumeCache(filepath.Join(t.TempDir(), "volumes.json"))
+	assert.NoError(t, err)
+
+	vol := CachedVolume{VolumeID: "vol-a", RealPath: "/dev/sdb", Major: 8, Minor: 16, ID: "wwid-naa.5000c500a1b2c3d4"}
+	assert.NoError(t, c.Stage(vol, "/staging"))
+	assert.NoError(t, c.Publish(vol, "/target"))
+	assert.NoError(t, c.Unpublish("vol-a", "/target"))
+	got, ok := c.Get("vol-a")
+	assert.True(t, ok)
+	assert.Equal(t, "/staging", got.StagingPath)
+	assert.Equal(t, "wwid-naa.5000c500a1b2c3d4", got.ID)
+
+	assert.NoError(t, c.Unstage("vol-a"))
+	_, ok = c.Get("vol-a")
+	assert.False(t, ok)
+}
+
+// fakeSysfs lays out block devices the way /sys/dev/block links to them.
+func fakeSysfs(t *testing.T, devices map[string]map[string]string) {
+	root := t.TempDir()
+	devBlock := filepath.Join(root, "dev", "block")
+	assert.NoError(t, os.MkdirAll(devBlock, 0755))
+	for number, attrs := range devices {
+		dir := filepath.Join(root, "devices", attrs["path"])
+		assert.NoError(t, os.MkdirAll(dir, 0755))
+		for name, value := range attrs {
+			if name == "path" {
+				continue
+			}
+			assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644))
+		}
+		assert.NoError(t, os.Symlink(dir, filepath.Join(devBlock, number)))
+	}
+	old := sysDevBlock
+	sysDevBlock = devBlock
+	t.Cleanup(func() { sysDevBlock = old })
+}
+
+func TestResolveCachedByIdentity(t *testing.T) {
+	fakeSysfs(t, map[string]map[string]string{
+		"8:16":   {"path": "sdb", "device/wwid": "naa.5000c500a1b2c3d4", "size": "20971520"},
+		"8:17":   {"path": "sdb/sdb1", "partition": "1", "size": "2048"},
+		"8:32":   {"path": "sdc", "device/wwid": "naa.5000c500ffffffff", "size": "20971520"},
+		"259:0":  {"path": "nvme0n1", "wwid": "eui.0025388b71b2c3d4", "size": "20971520"},
+		"253:0":  {"path": "dm-0", "dm/uuid": "mpath-3600a0980383030", "size": "20971520"},
+		"252:0":  {"path": "vda", "serial": "abc"},
+		"252:16": {"path": "vdb", "serial": "abc"},
+		"7:0":    {"path": "loop0"},
+	})
+
+	id, err := deviceIdentity(8, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, "wwid-naa.5000c500a1b2c3d4", id)
+	id, err = deviceIdentity(8, 17)
+	assert.NoError(t, err)
+	assert.Equal(t, "wwid-naa.5000c500a1b2c3d4-part1", id)
+	id, err = deviceIdentity(259, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "wwid-eui.0025388b71b2c3d4", id)
+	id, err = deviceIdentity(253, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "dm-uuid-mpath-3600a0980383030", id)
+	_, err = deviceIdentity(7, 0)
+	assert.Error(t, err)
+
+	// the kernel renamed and renumbered the disk since it was published
+	vol, err := resolveCached(CachedVolume{VolumeID: "vol-a", RealPath: "/dev/sdc", Major: 8, Minor: 32,
+		ID: "wwid-naa.5000c500a1b2c3d4", Size: 10 << 30})
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/sdb", vol.RealPath)
+	assert.Equal(t, uint32(8), vol.Major)
+	assert.Equal(t, uint32(16), vol.Minor)
+
+	for name, vol := range map[string]CachedVolume{
+		"no identity":  {RealPath: "/dev/sdb", Major: 8, Minor: 16, Size: 10 << 30},
+		"gone":         {ID: "wwid-naa.5000c500deadbeef"},
+		"size changed": {ID: "wwid-naa.5000c500a1b2c3d4", Size: 20 << 30},
+		"ambiguous":    {ID: "serial-abc"},
+	} {
+		_, err := resolveCached(vol)
+		assert.Error(t, err, name)
+	}
+}
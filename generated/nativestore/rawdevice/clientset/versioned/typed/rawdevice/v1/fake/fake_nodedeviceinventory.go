@@ -0,0 +1,132 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	rawdevicev1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeNodeDeviceInventories implements NodeDeviceInventoryInterface
+type FakeNodeDeviceInventories struct {
+	Fake *FakeRawdeviceV1
+}
+
+var nodedeviceinventoriesResource = schema.GroupVersionResource{Group: "nativestor.alauda.io", Version: "v1", Resource: "nodedeviceinventories"}
+
+var nodedeviceinventoriesKind = schema.GroupVersionKind{Group: "nativestor.alauda.io", Version: "v1", Kind: "NodeDeviceInventory"}
+
+// Get takes name of the nodeDeviceInventory, and returns the corresponding nodeDeviceInventory object, and an error if there is any.
+func (c *FakeNodeDeviceInventories) Get(ctx context.Context, name string, options v1.GetOptions) (result *rawdevicev1.NodeDeviceInventory, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootGetAction(nodedeviceinventoriesResource, name), &rawdevicev1.NodeDeviceInventory{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*rawdevicev1.NodeDeviceInventory), err
+}
+
+// List takes label and field selectors, and returns the list of NodeDeviceInventories that match those selectors.
+func (c *FakeNodeDeviceInventories) List(ctx context.Context, opts v1.ListOptions) (result *rawdevicev1.NodeDeviceInventoryList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootListAction(nodedeviceinventoriesResource, nodedeviceinventoriesKind, opts), &rawdevicev1.NodeDeviceInventoryList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &rawdevicev1.NodeDeviceInventoryList{ListMeta: obj.(*rawdevicev1.NodeDeviceInventoryList).ListMeta}
+	for _, item := range obj.(*rawdevicev1.NodeDeviceInventoryList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested nodeDeviceInventories.
+func (c *FakeNodeDeviceInventories) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewRootWatchAction(nodedeviceinventoriesResource, opts))
+}
+
+// Create takes the representation of a nodeDeviceInventory and creates it.  Returns the server's representation of the nodeDeviceInventory, and an error, if there is any.
+func (c *FakeNodeDeviceInventories) Create(ctx context.Context, nodeDeviceInventory *rawdevicev1.NodeDeviceInventory, opts v1.CreateOptions) (result *rawdevicev1.NodeDeviceInventory, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootCreateAction(nodedeviceinventoriesResource, nodeDeviceInventory), &rawdevicev1.NodeDeviceInventory{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*rawdevicev1.NodeDeviceInventory), err
+}
+
+// Update takes the representation of a nodeDeviceInventory and updates it. Returns the server's representation of the nodeDeviceInventory, and an error, if there is any.
+func (c *FakeNodeDeviceInventories) Update(ctx context.Context, nodeDeviceInventory *rawdevicev1.NodeDeviceInventory, opts v1.UpdateOptions) (result *rawdevicev1.NodeDeviceInventory, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateAction(nodedeviceinventoriesResource, nodeDeviceInventory), &rawdevicev1.NodeDeviceInventory{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*rawdevicev1.NodeDeviceInventory), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeNodeDeviceInventories) UpdateStatus(ctx context.Context, nodeDeviceInventory *rawdevicev1.NodeDeviceInventory, opts v1.UpdateOptions) (*rawdevicev1.NodeDeviceInventory, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateSubresourceAction(nodedeviceinventoriesResource, "status", nodeDeviceInventory), &rawdevicev1.NodeDeviceInventory{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*rawdevicev1.NodeDeviceInventory), err
+}
+
+// Delete takes name of the nodeDeviceInventory and deletes it. Returns an error if one occurs.
+func (c *FakeNodeDeviceInventories) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewRootDeleteAction(nodedeviceinventoriesResource, name), &rawdevicev1.NodeDeviceInventory{})
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeNodeDeviceInventories) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewRootDeleteCollectionAction(nodedeviceinventoriesResource, listOpts)
+
+	_, err := c.Fake.Invokes(action, &rawdevicev1.NodeDeviceInventoryList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched nodeDeviceInventory.
+func (c *FakeNodeDeviceInventories) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *rawdevicev1.NodeDeviceInventory, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootPatchSubresourceAction(nodedeviceinventoriesResource, name, pt, data, subresources...), &rawdevicev1.NodeDeviceInventory{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*rawdevicev1.NodeDeviceInventory), err
+}
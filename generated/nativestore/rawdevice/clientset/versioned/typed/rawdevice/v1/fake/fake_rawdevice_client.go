@@ -27,6 +27,10 @@ type FakeRawdeviceV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeRawdeviceV1) NodeDeviceInventories() v1.NodeDeviceInventoryInterface {
+	return &FakeNodeDeviceInventories{c}
+}
+
 func (c *FakeRawdeviceV1) RawDevices() v1.RawDeviceInterface {
 	return &FakeRawDevices{c}
 }
@@ -17,4 +17,6 @@ limitations under the License.
 
 package v1
 
+type NodeDeviceInventoryExpansion interface{}
+
 type RawDeviceExpansion interface{}
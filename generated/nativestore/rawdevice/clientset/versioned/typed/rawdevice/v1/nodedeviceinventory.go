@@ -0,0 +1,183 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	scheme "github.com/alauda/nativestor/generated/nativestore/rawdevice/clientset/versioned/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// NodeDeviceInventoriesGetter has a method to return a NodeDeviceInventoryInterface.
+// A group's client should implement this interface.
+type NodeDeviceInventoriesGetter interface {
+	NodeDeviceInventories() NodeDeviceInventoryInterface
+}
+
+// NodeDeviceInventoryInterface has methods to work with NodeDeviceInventory resources.
+type NodeDeviceInventoryInterface interface {
+	Create(ctx context.Context, nodeDeviceInventory *v1.NodeDeviceInventory, opts metav1.CreateOptions) (*v1.NodeDeviceInventory, error)
+	Update(ctx context.Context, nodeDeviceInventory *v1.NodeDeviceInventory, opts metav1.UpdateOptions) (*v1.NodeDeviceInventory, error)
+	UpdateStatus(ctx context.Context, nodeDeviceInventory *v1.NodeDeviceInventory, opts metav1.UpdateOptions) (*v1.NodeDeviceInventory, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1.NodeDeviceInventory, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*v1.NodeDeviceInventoryList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *v1.NodeDeviceInventory, err error)
+	NodeDeviceInventoryExpansion
+}
+
+// nodeDeviceInventories implements NodeDeviceInventoryInterface
+type nodeDeviceInventories struct {
+	client rest.Interface
+}
+
+// newNodeDeviceInventories returns a NodeDeviceInventories
+func newNodeDeviceInventories(c *RawdeviceV1Client) *nodeDeviceInventories {
+	return &nodeDeviceInventories{
+		client: c.RESTClient(),
+	}
+}
+
+// Get takes name of the nodeDeviceInventory, and returns the corresponding nodeDeviceInventory object, and an error if there is any.
+func (c *nodeDeviceInventories) Get(ctx context.Context, name string, options metav1.GetOptions) (result *v1.NodeDeviceInventory, err error) {
+	result = &v1.NodeDeviceInventory{}
+	err = c.client.Get().
+		Resource("nodedeviceinventories").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of NodeDeviceInventories that match those selectors.
+func (c *nodeDeviceInventories) List(ctx context.Context, opts metav1.ListOptions) (result *v1.NodeDeviceInventoryList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1.NodeDeviceInventoryList{}
+	err = c.client.Get().
+		Resource("nodedeviceinventories").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested nodeDeviceInventories.
+func (c *nodeDeviceInventories) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Resource("nodedeviceinventories").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a nodeDeviceInventory and creates it.  Returns the server's representation of the nodeDeviceInventory, and an error, if there is any.
+func (c *nodeDeviceInventories) Create(ctx context.Context, nodeDeviceInventory *v1.NodeDeviceInventory, opts metav1.CreateOptions) (result *v1.NodeDeviceInventory, err error) {
+	result = &v1.NodeDeviceInventory{}
+	err = c.client.Post().
+		Resource("nodedeviceinventories").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(nodeDeviceInventory).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a nodeDeviceInventory and updates it. Returns the server's representation of the nodeDeviceInventory, and an error, if there is any.
+func (c *nodeDeviceInventories) Update(ctx context.Context, nodeDeviceInventory *v1.NodeDeviceInventory, opts metav1.UpdateOptions) (result *v1.NodeDeviceInventory, err error) {
+	result = &v1.NodeDeviceInventory{}
+	err = c.client.Put().
+		Resource("nodedeviceinventories").
+		Name(nodeDeviceInventory.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(nodeDeviceInventory).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *nodeDeviceInventories) UpdateStatus(ctx context.Context, nodeDeviceInventory *v1.NodeDeviceInventory, opts metav1.UpdateOptions) (result *v1.NodeDeviceInventory, err error) {
+	result = &v1.NodeDeviceInventory{}
+	err = c.client.Put().
+		Resource("nodedeviceinventories").
+		Name(nodeDeviceInventory.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(nodeDeviceInventory).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the nodeDeviceInventory and deletes it. Returns an error if one occurs.
+func (c *nodeDeviceInventories) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Resource("nodedeviceinventories").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *nodeDeviceInventories) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Resource("nodedeviceinventories").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched nodeDeviceInventory.
+func (c *nodeDeviceInventories) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *v1.NodeDeviceInventory, err error) {
+	result = &v1.NodeDeviceInventory{}
+	err = c.client.Patch(pt).
+		Resource("nodedeviceinventories").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
@@ -25,6 +25,7 @@ import (
 
 type RawdeviceV1Interface interface {
 	RESTClient() rest.Interface
+	NodeDeviceInventoriesGetter
 	RawDevicesGetter
 }
 
@@ -33,6 +34,10 @@ type RawdeviceV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *RawdeviceV1Client) NodeDeviceInventories() NodeDeviceInventoryInterface {
+	return newNodeDeviceInventories(c)
+}
+
 func (c *RawdeviceV1Client) RawDevices() RawDeviceInterface {
 	return newRawDevices(c)
 }
@@ -52,6 +52,8 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=nativestor.alauda.io, Version=v1
+	case v1.SchemeGroupVersion.WithResource("nodedeviceinventories"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Rawdevice().V1().NodeDeviceInventories().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("rawdevices"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Rawdevice().V1().RawDevices().Informer()}, nil
 
@@ -23,6 +23,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// NodeDeviceInventories returns a NodeDeviceInventoryInformer.
+	NodeDeviceInventories() NodeDeviceInventoryInformer
 	// RawDevices returns a RawDeviceInformer.
 	RawDevices() RawDeviceInformer
 }
@@ -38,6 +40,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// NodeDeviceInventories returns a NodeDeviceInventoryInformer.
+func (v *version) NodeDeviceInventories() NodeDeviceInventoryInformer {
+	return &nodeDeviceInventoryInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // RawDevices returns a RawDeviceInformer.
 func (v *version) RawDevices() RawDeviceInformer {
 	return &rawDeviceInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
@@ -17,6 +17,10 @@ limitations under the License.
 
 package v1
 
+// NodeDeviceInventoryListerExpansion allows custom methods to be added to
+// NodeDeviceInventoryLister.
+type NodeDeviceInventoryListerExpansion interface{}
+
 // RawDeviceListerExpansion allows custom methods to be added to
 // RawDeviceLister.
 type RawDeviceListerExpansion interface{}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeDeviceInventoryLister helps list NodeDeviceInventories.
+// All objects returned here must be treated as read-only.
+type NodeDeviceInventoryLister interface {
+	// List lists all NodeDeviceInventories in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1.NodeDeviceInventory, err error)
+	// Get retrieves the NodeDeviceInventory from the index for a given name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*v1.NodeDeviceInventory, error)
+	NodeDeviceInventoryListerExpansion
+}
+
+// nodeDeviceInventoryLister implements the NodeDeviceInventoryLister interface.
+type nodeDeviceInventoryLister struct {
+	indexer cache.Indexer
+}
+
+// NewNodeDeviceInventoryLister returns a new NodeDeviceInventoryLister.
+func NewNodeDeviceInventoryLister(indexer cache.Indexer) NodeDeviceInventoryLister {
+	return &nodeDeviceInventoryLister{indexer: indexer}
+}
+
+// List lists all NodeDeviceInventories in the indexer.
+func (s *nodeDeviceInventoryLister) List(selector labels.Selector) (ret []*v1.NodeDeviceInventory, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.NodeDeviceInventory))
+	})
+	return ret, err
+}
+
+// Get retrieves the NodeDeviceInventory from the index for a given name.
+func (s *nodeDeviceInventoryLister) Get(name string) (*v1.NodeDeviceInventory, error) {
+	obj, exists, err := s.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1.Resource("nodedeviceinventory"), name)
+	}
+	return obj.(*v1.NodeDeviceInventory), nil
+}
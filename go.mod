@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/banzaicloud/k8s-objectmatcher v1.6.1
 	github.com/coreos/pkg v0.0.0-20180928190104-399ea9e2e55f
+	github.com/go-logr/logr v0.4.0
 	github.com/google/go-cmp v0.5.6
 	github.com/google/uuid v1.1.2
 	github.com/kubernetes-csi/csi-lib-utils v0.10.0
@@ -21,6 +21,7 @@ import (
 	"github.com/alauda/nativestor/cmd/cleanup"
 
 	discovercmd "github.com/alauda/nativestor/cmd/discover"
+	"github.com/alauda/nativestor/cmd/lvcache"
 	"github.com/alauda/nativestor/cmd/operator"
 	"github.com/alauda/nativestor/cmd/preparevg"
 	"github.com/alauda/nativestor/cmd/topolvm"
@@ -35,5 +36,5 @@ func main() {
 }
 
 func addCommands() {
-	topolvm.RootCmd.AddCommand(operator.OperatorCmd, preparevg.PrepareVgCmd, discovercmd.DiscoverCmd, cleanup.CleanCmd)
+	topolvm.RootCmd.AddCommand(operator.OperatorCmd, preparevg.PrepareVgCmd, discovercmd.DiscoverCmd, cleanup.CleanCmd, lvcache.LvcacheCmd)
 }
@@ -19,10 +19,6 @@ package topolvm
 import "time"
 
 var (
-	TopolvmImage          string
-	CertsSecret           string
-	NameSpace             string
-	ClusterName           string
 	CSIKubeletRootDir     string
 	IsOperatorHub         bool
 	EnableDiscoverDevices string
@@ -47,10 +43,11 @@ const (
 	LvmdConfigMapLabelKey           = "topolvm/lvmdconfig"
 	LvmdConfigMapLabelValue         = "lvmdconfig"
 	LvmdConfigMapKey                = "lvmd.yaml"
-	LocalDiskCMData                 = "devices"
 	VgStatusConfigMapKey            = "status.json"
+	LvmdCacheConfigMapKey           = "cache.json"
 	LvmdAnnotationsNodeKey          = "node-name"
 	LvmdSocketPath                  = "/run/topolvm/lvmd.sock"
+	LvmdDeviceClassTypeThin         = "thin"
 	TopolvmDiscoverDeviceMemRequest = "50Mi"
 	TopolvmDiscoverDeviceMemLimit   = "50Mi"
 	TopolvmDiscoverDeviceCPURequest = "50m"
@@ -77,8 +74,47 @@ const (
 	CapacityKeyPrefix      = "capacity.topolvm.cybozu.com/"
 	DiscoverDevicesAccount = "nativestor-discover"
 	DiscoverContainerName  = "discover"
+	LvcacheContainerName   = "lvcache"
+	LvcacheConfigPath      = "/etc/topolvm/cache.json"
+	LvcacheMetricsPort     = 9109
 	UseLoop                = "true"
 	LoopCreateSuccessful   = "successful"
 	LoopAnnotationsKey     = "loop"
 	LoopAnnotationsVal     = "true"
+
+	WebhookServiceName  = "nativestor-webhook"
+	WebhookCertSecret   = "nativestor-webhook-cert"
+	WebhookConfigName   = "nativestor-topolvmcluster"
+	WebhookCertDir      = "/tmp/k8s-webhook-server/serving-certs"
+	WebhookPort         = 9443
+	MutateClusterPath   = "/mutate-topolvm-cybozu-com-v2-topolvmcluster"
+	ValidateClusterPath = "/validate-topolvm-cybozu-com-v2-topolvmcluster"
+	MutateNodePath      = "/mutate-v1-node"
+	ConvertClusterPath  = "/convert"
+	ClusterCRDName      = "topolvmclusters.topolvm.cybozu.com"
+	// SkipValidationLabel keeps a cluster from being validated, e.g. to
+	// remove its finalizer while the operator is gone
+	SkipValidationLabel = "topolvm.cybozu.com/skip-validation"
+
+	TopolvmControllerDeploymentName = "topolvm-controller"
+	LvmdContainerName               = "lvmd"
+	NodePluginContainerName         = "csi-topolvm-plugin"
+	RegistrarContainerName          = "driver-registrar"
+	// NodePluginRevisionAnnotation is the revision of the pod template of a
+	// node plugin deployment, NodePluginUpdatedAnnotation the time it was
+	// last updated at
+	NodePluginRevisionAnnotation = "topolvm.cybozu.com/node-plugin-revision"
+	NodePluginUpdatedAnnotation  = "topolvm.cybozu.com/node-plugin-updated"
+	// LvmdConfigHashAnnotation restarts the node plugin pods when the lvmd
+	// config changes
+	LvmdConfigHashAnnotation = "topolvm.cybozu.com/lvmd-config-hash"
+	DefaultMaxUnavailable    = 1
+	DefaultNodeTimeout       = 600 * time.Second
+	RolloutInterval          = 30 * time.Second
+	// DecommissionAnnotation marks the lvmd configmap of a node leaving its
+	// cluster with the time its decommission started
+	DecommissionAnnotation = "topolvm.cybozu.com/decommission"
+	// DecommissionLabel marks a node being decommissioned with its cluster,
+	// the webhook keeps the capacity annotations off the nodes carrying it
+	DecommissionLabel = "topolvm.cybozu.com/decommission"
 )
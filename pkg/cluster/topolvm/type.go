@@ -28,6 +28,28 @@ type DeviceClass struct {
 	Default     bool   `yaml:"default"`
 	Stripe      uint   `yaml:"stripe,omitempty"`
 	StripeSize  string `yaml:"stripe-size,omitempty"`
+	// Type is thin for thin provisioned classes
+	Type           string          `yaml:"type,omitempty"`
+	ThinPoolConfig *ThinPoolConfig `yaml:"thin-pool,omitempty"`
+	// LVCreateOptions are passed to lvcreate, e.g. the raid layout
+	LVCreateOptions []string `yaml:"lvcreate-options,omitempty"`
+}
+
+// ThinPoolConfig is the thin pool of a thin device class in lvmd.yaml
+type ThinPoolConfig struct {
+	Name               string  `yaml:"name"`
+	OverprovisionRatio float64 `yaml:"overprovision-ratio"`
+}
+
+// CacheClass is a cached device class in cache.json, the lvcache sidecar
+// caches its volumes on the devices
+type CacheClass struct {
+	Name        string   `json:"name"`
+	VolumeGroup string   `json:"volumeGroup"`
+	Type        string   `json:"type"`
+	Mode        string   `json:"mode,omitempty"`
+	SizePercent uint     `json:"sizePercent"`
+	Devices     []string `json:"devices"`
 }
 
 type Metrics struct {
@@ -24,6 +24,7 @@ import (
 	controllerutil "github.com/alauda/nativestor/pkg/operator/controller"
 	"github.com/alauda/nativestor/pkg/operator/csi"
 	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/alauda/nativestor/pkg/util/sys"
 	_ "github.com/coreos/pkg/capnslog"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/apps/v1"
@@ -31,6 +32,7 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"os"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -38,6 +40,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+	"time"
 )
 
 const (
@@ -45,6 +48,16 @@ const (
 	discoverDeviceTolerationsEnv  = "DISCOVER_DEVICE_TOLERATIONS"
 	discoverDeviceNodeAffinityEnv = "DISCOVER_DEVICE_NODE_AFFINITY"
 	discoverDeviceResource        = "DISCOVER_DEVICE_RESOURCE"
+	// DiscoverDeviceBackendEnv selects how the discover daemon enumerates block devices
+	DiscoverDeviceBackendEnv = "DISCOVER_DEVICE_BACKEND"
+	// DiscoverHealthIntervalEnv is how often the discover daemon collects device health, 0 disables it
+	DiscoverHealthIntervalEnv = "DISCOVER_HEALTH_INTERVAL"
+	// DiscoverHealthBlockUnhealthyEnv makes unhealthy devices unavailable for new allocations
+	DiscoverHealthBlockUnhealthyEnv = "DISCOVER_HEALTH_BLOCK_UNHEALTHY"
+	// discoverMetricsPort serves the metrics and health probes on the host network
+	discoverMetricsPort = 9108
+	// DefaultHealthInterval is used when no health interval is set
+	DefaultHealthInterval = 30 * time.Minute
 )
 
 func Add(mgr manager.Manager, context *cluster.Context, opManagerContext context.Context, opConfig operator.OperatorConfig) error {
@@ -117,7 +130,26 @@ func (r *DiscoverDeviceController) startDiscoverDeviceDaemonset() error {
 
 	ownerInfo := k8sutil.NewOwnerInfoWithOwnerRef(ownerRef, r.opConfig.OperatorNamespace)
 
-	daemon := getDaemonset(operator.DiscoverAppName, r.opConfig.Image, false, true)
+	daemon := getDaemonset(operator.DiscoverAppName, r.opConfig.OperatorNamespace, r.opConfig.Image, false, true)
+	container := &daemon.Spec.Template.Spec.Containers[0]
+	if backend := k8sutil.GetValue(r.opConfig.Parameters, DiscoverDeviceBackendEnv, ""); backend != "" {
+		container.Env = append(container.Env, corev1.EnvVar{Name: DiscoverDeviceBackendEnv, Value: backend})
+	}
+	if interval := k8sutil.GetValue(r.opConfig.Parameters, DiscoverHealthIntervalEnv, ""); interval != "" {
+		if _, err := time.ParseDuration(interval); err != nil {
+			return errors.Wrap(err, "invalid health interval")
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: DiscoverHealthIntervalEnv, Value: interval})
+	}
+	if block := k8sutil.GetValue(r.opConfig.Parameters, DiscoverHealthBlockUnhealthyEnv, ""); block != "" {
+		container.Env = append(container.Env, corev1.EnvVar{Name: DiscoverHealthBlockUnhealthyEnv, Value: block})
+	}
+	if rules := k8sutil.GetValue(r.opConfig.Parameters, sys.DeviceRulesEnv, ""); rules != "" {
+		if _, err := sys.ParseDeviceRules(rules); err != nil {
+			return errors.Wrap(err, "invalid device eligibility rules")
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: sys.DeviceRulesEnv, Value: rules})
+	}
 
 	tolerations := csi.GetToleration(r.opConfig.Parameters, discoverDeviceTolerationsEnv, []corev1.Toleration{})
 	nodeAffinity := csi.GetNodeAffinity(r.opConfig.Parameters, discoverDeviceNodeAffinityEnv, &corev1.NodeAffinity{})
@@ -152,7 +184,7 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	return nil
 }
 
-func getDaemonset(appName string, image string, useLoop bool, enableRawDevice bool) *v1.DaemonSet {
+func getDaemonset(appName string, namespace string, image string, useLoop bool, enableRawDevice bool) *v1.DaemonSet {
 
 	var volumes []corev1.Volume
 	devVolume := corev1.Volume{Name: "devices", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/dev"}}}
@@ -201,7 +233,7 @@ func getDaemonset(appName string, image string, useLoop bool, enableRawDevice bo
 	daemonset := &v1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        appName,
-			Namespace:   topolvm.NameSpace,
+			Namespace:   namespace,
 			Annotations: annotate,
 		},
 		Spec: v1.DaemonSetSpec{
@@ -234,10 +266,18 @@ func getDaemonset(appName string, image string, useLoop bool, enableRawDevice bo
 							},
 							VolumeMounts: volumeMount,
 							Env:          env,
+							Ports: []corev1.ContainerPort{
+								{Name: "metrics", ContainerPort: discoverMetricsPort, Protocol: corev1.ProtocolTCP},
+							},
+							LivenessProbe:  discoverProbe(LivenessPath, 60),
+							ReadinessProbe: discoverProbe(ReadinessPath, 10),
 						},
 					},
-					Volumes:     volumes,
-					HostPID:     true,
+					Volumes: volumes,
+					HostPID: true,
+					// udev only broadcasts uevents to the host network namespace
+					HostNetwork: true,
+					DNSPolicy:   corev1.DNSClusterFirstWithHostNet,
 					Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
 				},
 			},
@@ -245,3 +285,19 @@ func getDaemonset(appName string, image string, useLoop bool, enableRawDevice bo
 	}
 	return daemonset
 }
+
+// discoverProbe checks path on the metrics port, the first full scan may take
+// a while on nodes with many devices.
+func discoverProbe(path string, periodSeconds int32) *corev1.Probe {
+	probe := &corev1.Probe{
+		InitialDelaySeconds: 10,
+		PeriodSeconds:       periodSeconds,
+		TimeoutSeconds:      5,
+		FailureThreshold:    3,
+	}
+	probe.HTTPGet = &corev1.HTTPGetAction{
+		Path: path,
+		Port: intstr.FromString("metrics"),
+	}
+	return probe
+}
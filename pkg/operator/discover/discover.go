@@ -17,16 +17,16 @@ limitations under the License.
 package discover
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/alauda/nativestor/pkg/cluster/topolvm"
 	"k8s.io/apimachinery/pkg/labels"
 	"os"
-	"os/exec"
 	"os/signal"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -37,10 +37,11 @@ import (
 	"github.com/alauda/nativestor/pkg/cluster"
 	"github.com/alauda/nativestor/pkg/operator/k8sutil"
 	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/alauda/nativestor/pkg/util/uevent"
 	"github.com/coreos/pkg/capnslog"
 	"github.com/pkg/errors"
-	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -62,62 +63,107 @@ type DeviceManager struct {
 	namespace       string
 	useLoop         bool
 	cmName          string
+	enumerator      sys.DeviceEnumerator
+	rules           *sys.DeviceRules
+	// devices is the last known state of the node's devices keyed by kernel
+	// name, full scans replace it and uevents patch it
+	devices map[string]*sys.LocalDiskAppendInfo
+	// health is the last health reported by the disks keyed by kernel name
+	health          map[string]*rawapi.DeviceHealth
+	healthCollector sys.HealthCollector
+	healthInterval  time.Duration
+	// blockUnhealthy makes unhealthy disks unavailable so that nothing new
+	// is allocated on them
+	blockUnhealthy bool
+	probes         *probeState
+	// monitor delivers the uevents of block devices
+	monitor *uevent.Monitor
 }
 
-func NewDeviceManager(context *cluster.Context, udevEventPeriod, probeInterval time.Duration, rawDeviceLister rawv1.RawDeviceLister, nodeName, namespace string, useLoop bool) *DeviceManager {
+func NewDeviceManager(context *cluster.Context, enumerator sys.DeviceEnumerator, rules *sys.DeviceRules, udevEventPeriod, probeInterval time.Duration, rawDeviceLister rawv1.RawDeviceLister, nodeName, namespace string, useLoop bool) *DeviceManager {
 	return &DeviceManager{
 		context:         context,
+		enumerator:      enumerator,
+		rules:           rules,
 		udevEventPeriod: udevEventPeriod,
 		probeInterval:   probeInterval,
 		rawDeviceLister: rawDeviceLister,
 		nodeName:        nodeName,
 		namespace:       namespace,
 		useLoop:         useLoop,
+		devices:         make(map[string]*sys.LocalDiskAppendInfo),
+		health:          make(map[string]*rawapi.DeviceHealth),
+		probes:          &probeState{},
+		monitor:         uevent.NewMonitor(uevent.SubsystemBlock),
 	}
 }
 
-// Monitors udev for block device changes, and collapses these events such that
-// only one event is emitted per period in order to deal with flapping.
-func udevBlockMonitor(c chan string, period time.Duration) {
-	defer close(c)
-	var udevFilter []string
+// EnableHealthCheck makes the manager collect the health of the disks every
+// interval. Unhealthy disks are made unavailable if blockUnhealthy is set.
+func (m *DeviceManager) EnableHealthCheck(collector sys.HealthCollector, interval time.Duration, blockUnhealthy bool) {
+	m.healthCollector = collector
+	m.healthInterval = interval
+	m.blockUnhealthy = blockUnhealthy
+}
 
-	// return any add or remove events, but none that match device mapper
-	// events. string matching is case-insensitive
-	events := make(chan string)
+// Monitors uevents for block device changes, and collapses the events of one
+// period into a batch in order to deal with flapping. It stops when ctx is
+// done or the monitor gives up, and returns why the monitor gave up.
+func udevBlockMonitor(ctx context.Context, monitor *uevent.Monitor, c chan []*uevent.Event, period time.Duration) error {
+	defer close(c)
 
 	// get discoverDaemonUdevBlacklist from the environment variable
 	// if user doesn't provide any regex; generate the default regex
 	// else use the regex provided by user
 	discoverUdev := os.Getenv(discoverDaemonUdev)
 	if discoverUdev == "" {
-		discoverUdev = "(?i)dm-[0-9]+,(?i)rbd[0-9]+,(?i)nbd[0-9]+"
+		discoverUdev = "(?i)rbd[0-9]+,(?i)nbd[0-9]+"
 	}
-	udevFilter = strings.Split(discoverUdev, ",")
-	logger.Infof("using the regular expressions %q", udevFilter)
+	logger.Infof("using the regular expressions %q", discoverUdev)
+	filter := newUdevFilter(compileExclusions(strings.Split(discoverUdev, ",")), sys.IsMultipathMap)
 
-	go rawUdevBlockMonitor(events,
-		[]string{"(?i)add", "(?i)remove"},
-		udevFilter)
+	// return any add, remove or change events, but none of device mapper
+	// devices other than multipath maps
+	events := make(chan *uevent.Event)
+	monitorErr := make(chan error, 1)
+	go func() {
+		monitorErr <- monitor.Run(ctx, events)
+	}()
 
 	for {
 		event, ok := <-events
 		if !ok {
-			return
+			return <-monitorErr
+		}
+		if !filter.match(event) {
+			continue
 		}
+		batch := []*uevent.Event{event}
 		timeout := time.NewTimer(period)
+	collect:
 		for {
 			select {
 			case <-timeout.C:
-			case _, ok := <-events:
+				break collect
+			case event, ok := <-events:
 				if !ok {
-					return
+					timeout.Stop()
+					return <-monitorErr
+				}
+				if filter.match(event) {
+					batch = append(batch, event)
 				}
-				continue
 			}
-			break
 		}
-		c <- event
+		select {
+		case c <- batch:
+		case <-ctx.Done():
+			// nobody reads the batches anymore, wait for the monitor to
+			// close events
+			for range events {
+			}
+			return <-monitorErr
+		}
 	}
 }
 
@@ -126,12 +172,14 @@ func (m *DeviceManager) Run() error {
 	logger.Debugf("device discovery interval is %q", m.probeInterval.String())
 
 	m.cmName = k8sutil.TruncateNodeName(topolvm.LvmdConfigMapFmt, m.nodeName)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGTERM)
 
-	err := m.updateDeviceCM()
+	err := m.scan()
 	if err != nil {
-		logger.Infof("failed to update device configmap: %v", err)
+		logger.Infof("failed to update devices: %v", err)
 		return err
 	}
 
@@ -142,43 +190,158 @@ func (m *DeviceManager) Run() error {
 		}
 	}
 
-	udevEvents := make(chan string)
-	go udevBlockMonitor(udevEvents, m.udevEventPeriod)
+	var healthCheck <-chan time.Time
+	if m.healthCollector != nil && m.healthInterval > 0 {
+		m.checkHealth()
+		ticker := time.NewTicker(m.healthInterval)
+		defer ticker.Stop()
+		healthCheck = ticker.C
+	}
+
+	udevEvents := make(chan []*uevent.Event)
+	monitorErr := make(chan error, 1)
+	go func() {
+		monitorErr <- udevBlockMonitor(ctx, m.monitor, udevEvents, m.udevEventPeriod)
+	}()
 	for {
 		select {
 		case <-sigc:
 			logger.Infof("shutdown signal received, exiting...")
 			return nil
 		case <-time.After(m.probeInterval):
-			if err := m.updateDeviceCM(); err != nil {
-				logger.Errorf("failed to update device configmap during probe interval. %v", err)
+			if err := m.scan(); err != nil {
+				logger.Errorf("failed to update devices during probe interval. %v", err)
 			}
 			m.checkDeviceClass()
-		case _, ok := <-udevEvents:
+		case <-healthCheck:
+			m.checkHealth()
+			m.probes.active(time.Now())
+		case events, ok := <-udevEvents:
 			if ok {
-				logger.Info("trigger probe from udev event")
-				if err := m.updateDeviceCM(); err != nil {
-					logger.Errorf("failed to update device configmap triggered from udev event. %v", err)
+				logger.Infof("applying %d udev events", len(events))
+				ueventsProcessed.WithLabelValues(m.nodeName).Add(float64(len(events)))
+				if err := m.applyUdevEvents(events); err != nil {
+					updateErrors.WithLabelValues(m.nodeName, errorSourceUevent).Inc()
+					logger.Errorf("failed to update devices triggered from udev event. %v", err)
 				}
+				m.probes.active(time.Now())
 			} else {
-				logger.Warningf("disabling udev monitoring")
+				err := <-monitorErr
+				logger.Errorf("disabling udev monitoring. %v", err)
 				udevEvents = nil
+				m.probes.monitorDied(err)
 			}
 		}
 	}
 }
 
-func (m *DeviceManager) createOrUpdateRawDevice(devices []*sys.LocalDiskAppendInfo) error {
+// scan runs a full scan and records its outcome for the probes and metrics.
+func (m *DeviceManager) scan() error {
+	start := time.Now()
+	err := m.updateDevices()
+	scanDuration.WithLabelValues(m.nodeName).Observe(time.Since(start).Seconds())
+	m.probes.scanned(time.Now(), err)
+	if err != nil {
+		updateErrors.WithLabelValues(m.nodeName, errorSourceScan).Inc()
+		return err
+	}
+	lastScanTimestamp.WithLabelValues(m.nodeName).SetToCurrentTime()
+	return nil
+}
 
-	ctx := context.TODO()
+func (m *DeviceManager) createOrUpdateRawDevice(devices []*sys.LocalDiskAppendInfo) error {
 	for _, disk := range devices {
-		device := convertDiskToRawDevice(m.nodeName, disk)
-		_, err := k8sutil.CreateOrUpdateRawDevice(ctx, m.context.RawDeviceClientset, device)
+		m.applyRawDevice(disk)
+	}
+	return m.checkRawDeviceDeleted(devices)
+}
+
+// applyRawDevice creates or updates the raw device of disk, devices whose
+// spec and health did not change are left alone.
+func (m *DeviceManager) applyRawDevice(disk *sys.LocalDiskAppendInfo) {
+	device := convertDiskToRawDevice(m.nodeName, disk)
+	current, err := m.rawDeviceLister.Get(device.Name)
+	if err != nil || !reflect.DeepEqual(current.Spec, device.Spec) || current.Labels["node"] != m.nodeName {
+		current, err = k8sutil.CreateOrUpdateRawDevice(context.TODO(), m.context.RawDeviceClientset, device)
 		if err != nil {
 			logger.Errorf("create raw device %s failed err %v", device.Name, err)
+			return
 		}
 	}
-	return m.checkRawDeviceDeleted(devices)
+	m.applyRawDeviceHealth(current, m.health[disk.KernelName])
+}
+
+// applyRawDeviceHealth records the health of the device and its Healthy
+// condition in the raw device status.
+func (m *DeviceManager) applyRawDeviceHealth(current *rawapi.RawDevice, health *rawapi.DeviceHealth) {
+	if health == nil || reflect.DeepEqual(current.Status.Health, health) {
+		return
+	}
+	device := current.DeepCopy()
+	device.Status.Health = health.DeepCopy()
+	condition := metav1.Condition{
+		Type:    rawapi.ConditionHealthy,
+		Status:  metav1.ConditionTrue,
+		Reason:  "HealthCheckPassed",
+		Message: "the device reports no failure",
+	}
+	if !health.Healthy {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "HealthCheckFailed"
+		condition.Message = health.Reason
+	}
+	meta.SetStatusCondition(&device.Status.Conditions, condition)
+	_, err := m.context.RawDeviceClientset.RawdeviceV1().RawDevices().UpdateStatus(context.TODO(), device, metav1.UpdateOptions{})
+	if err != nil {
+		logger.Errorf("update health of raw device %s failed err %v", device.Name, err)
+	}
+}
+
+// checkHealth collects the health of the disks and applies it to their raw
+// devices, the inventory and the metrics.
+func (m *DeviceManager) checkHealth() {
+	// the api server keeps seconds only, a finer time would never compare
+	// equal to the one read back
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+	health := make(map[string]*rawapi.DeviceHealth)
+	for name, disk := range m.devices {
+		if !sys.SupportsHealth(&disk.LocalDisk) {
+			continue
+		}
+		h, err := m.healthCollector.DeviceHealth(&disk.LocalDisk)
+		if err != nil {
+			logger.Warningf("failed to collect health of device %q. %v", name, err)
+			continue
+		}
+		h.LastCheckTime = now
+		if !h.Healthy {
+			logger.Warningf("device %q is unhealthy: %s", name, h.Reason)
+		}
+		health[name] = h
+	}
+	m.health = health
+	exportHealth(m.nodeName, m.devices, m.health)
+
+	for _, disk := range m.devices {
+		m.applyHealth(disk)
+		m.applyRawDevice(disk)
+	}
+	if err := m.syncInventory(); err != nil {
+		updateErrors.WithLabelValues(m.nodeName, errorSourceHealth).Inc()
+		logger.Errorf("failed to update device inventory after health check. %v", err)
+	}
+}
+
+// applyHealth makes an unhealthy disk unavailable when unhealthy disks are
+// blocked.
+func (m *DeviceManager) applyHealth(disk *sys.LocalDiskAppendInfo) {
+	health, ok := m.health[disk.KernelName]
+	if !ok || health.Healthy || !m.blockUnhealthy || !disk.Available {
+		return
+	}
+	disk.Available = false
+	disk.Message = fmt.Sprintf("unhealthy: %s", health.Reason)
+	disk.Reasons = append(disk.Reasons, sys.ReasonUnhealthy)
 }
 
 func (m *DeviceManager) checkRawDeviceDeleted(devices []*sys.LocalDiskAppendInfo) error {
@@ -196,19 +359,115 @@ func (m *DeviceManager) checkRawDeviceDeleted(devices []*sys.LocalDiskAppendInfo
 				found = true
 			}
 		}
-		if !found && dev.Status.Name == "" {
-			logger.Infof("device %s disappear should delete raw device %s", dev.Spec.RealPath, dev.Name)
-			err = m.context.RawDeviceClientset.RawdeviceV1().RawDevices().Delete(context.TODO(), dev.Name, metav1.DeleteOptions{})
-			if err != nil {
-				logger.Errorf("delete raw device %s failed err %v", dev.Name, err)
-			}
+		if !found {
+			m.removeRawDevice(dev)
+		}
+	}
+
+	return nil
+}
+
+// removeRawDevice deletes the raw device of a vanished disk, claimed devices
+// are kept and marked lost instead.
+func (m *DeviceManager) removeRawDevice(dev *rawapi.RawDevice) {
+	if dev.Status.Name == "" {
+		logger.Infof("device %s disappear should delete raw device %s", dev.Spec.RealPath, dev.Name)
+		err := m.context.RawDeviceClientset.RawdeviceV1().RawDevices().Delete(context.TODO(), dev.Name, metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			logger.Errorf("delete raw device %s failed err %v", dev.Name, err)
+		}
+	} else if !dev.Spec.Lost {
+		logger.Warningf("device %s of claimed raw device %s disappear, mark it lost", dev.Spec.RealPath, dev.Name)
+		lost := dev.DeepCopy()
+		lost.Spec.Lost = true
+		_, err := m.context.RawDeviceClientset.RawdeviceV1().RawDevices().Update(context.TODO(), lost, metav1.UpdateOptions{})
+		if err != nil {
+			logger.Errorf("mark raw device %s lost failed err %v", dev.Name, err)
+		}
+	}
+}
+
+// applyUdevEvents refreshes only the devices named by events, their parents
+// and the raw devices that changed because of them.
+func (m *DeviceManager) applyUdevEvents(events []*uevent.Event) error {
+	removed := make(map[string]bool)
+	changed := make(map[string]bool)
+	for _, event := range events {
+		if event.DevName == "" {
+			continue
+		}
+		if event.Action == uevent.ActionRemove {
+			removed[event.DevName] = true
+			delete(changed, event.DevName)
+		} else {
+			changed[event.DevName] = true
+			delete(removed, event.DevName)
 		}
 	}
 
-	return err
+	// a partition appearing or vanishing changes whether its disk has children
+	parents := make(map[string]bool)
+	for name := range removed {
+		if old := m.forgetDevice(name); old != nil && old.Parent != "" {
+			parents[old.Parent] = true
+		}
+	}
+	for name := range changed {
+		if disk := m.refreshDevice(name); disk != nil && disk.Parent != "" {
+			parents[disk.Parent] = true
+		}
+	}
+	for name := range parents {
+		if !changed[name] && !removed[name] {
+			m.refreshDevice(name)
+		}
+	}
+
+	return m.syncInventory()
+}
+
+// forgetDevice drops a device that is no longer reported along with its raw
+// device, it returns the device if it was known.
+func (m *DeviceManager) forgetDevice(name string) *sys.LocalDiskAppendInfo {
+	old, ok := m.devices[name]
+	if !ok {
+		return nil
+	}
+	delete(m.devices, name)
+	raw, err := m.rawDeviceLister.Get(k8sutil.Hash(m.nodeName + old.RealPath))
+	if err == nil {
+		m.removeRawDevice(raw)
+	} else if !kerrors.IsNotFound(err) {
+		logger.Errorf("get raw device of %s failed err %v", old.RealPath, err)
+	}
+	return old
+}
+
+// refreshDevice discovers the device again and applies its raw device. It
+// returns nil if the device could not be discovered, the next full scan
+// repairs whatever was missed.
+func (m *DeviceManager) refreshDevice(name string) *sys.LocalDiskAppendInfo {
+	disk, err := m.enumerator.DiscoverDevice(name)
+	if err != nil {
+		logger.Warningf("failed to discover device %q. %v", name, err)
+		return nil
+	}
+	info := sys.CheckDevice(disk, m.rules)
+	if info == nil {
+		// e.g. a disk that became a path of a multipath device
+		m.forgetDevice(name)
+		return nil
+	}
+	m.applyHealth(info)
+	m.devices[name] = info
+	m.applyRawDevice(info)
+	return info
 }
 
 func convertDiskToRawDevice(nodeName string, disk *sys.LocalDiskAppendInfo) *rawapi.RawDevice {
+	// stale signatures are only wiped when a volume group is built on the
+	// device, a raw device is handed to the pod as it is
+	available := disk.Available && len(disk.StaleSignatures) == 0
 
 	return &rawapi.RawDevice{
 		ObjectMeta: metav1.ObjectMeta{
@@ -218,18 +477,41 @@ func convertDiskToRawDevice(nodeName string, disk *sys.LocalDiskAppendInfo) *raw
 			},
 		},
 		Spec: rawapi.RawDeviceSpec{
-			NodeName:  nodeName,
-			Size:      int64(disk.Size),
-			Type:      disk.Type,
-			RealPath:  disk.RealPath,
-			UUID:      disk.UUID,
-			Available: disk.Available,
-			Major:     disk.Major,
-			Minor:     disk.Minor,
+			NodeName:   nodeName,
+			Size:       int64(disk.Size),
+			Type:       disk.Type,
+			RealPath:   disk.RealPath,
+			UUID:       disk.UUID,
+			Available:  available,
+			Major:      disk.Major,
+			Minor:      disk.Minor,
+			Rotational: disk.Rotational,
+			Topology:   convertDiskTopology(&disk.LocalDisk),
 		},
 	}
 }
 
+// convertDiskTopology returns nil for devices reporting no topology at all.
+func convertDiskTopology(disk *sys.LocalDisk) *rawapi.DeviceTopology {
+	topology := &rawapi.DeviceTopology{
+		NVMeNamespaceID:   disk.NVMeNamespaceID,
+		NVMeController:    disk.NVMeController,
+		PCIAddress:        disk.PCIAddress,
+		LogicalBlockSize:  int64(disk.LogicalBlockSize),
+		PhysicalBlockSize: int64(disk.PhysicalBlockSize),
+		Discard:           disk.Discard,
+		QueueDepth:        int64(disk.QueueDepth),
+	}
+	if disk.NUMANode != nil {
+		node := int32(*disk.NUMANode)
+		topology.NUMANode = &node
+	}
+	if reflect.DeepEqual(topology, &rawapi.DeviceTopology{}) {
+		return nil
+	}
+	return topology
+}
+
 func (m *DeviceManager) retryLoopDevice() {
 	for {
 		err := m.checkLoopDevice()
@@ -284,72 +566,130 @@ func (m *DeviceManager) checkLoopDevice() error {
 	return nil
 }
 
-func (m *DeviceManager) updateDeviceCM() error {
-	ctx := context.TODO()
-	logger.Infof("updating device configmap")
-	devices, err := sys.GetAllDevices(m.context)
+// updateDevices scans all devices of the node and repairs whatever drifted
+// from the raw devices and the device inventory.
+func (m *DeviceManager) updateDevices() error {
+	logger.Infof("updating devices")
+	devices, err := sys.GetAllDevicesWith(m.enumerator, m.rules)
 	if err != nil {
 		logger.Errorf("can not list disk err:%v", err)
 		return err
 	}
+	m.devices = make(map[string]*sys.LocalDiskAppendInfo, len(devices))
+	for _, disk := range devices {
+		m.applyHealth(disk)
+		m.devices[disk.KernelName] = disk
+	}
 	err = m.createOrUpdateRawDevice(devices)
 	if err != nil {
 		logger.Errorf("can not create or update raw device err:%v", err)
 		return err
 	}
-	deviceJSON, err := json.Marshal(devices)
-	if err != nil {
-		logger.Infof("failed to marshal: %v", err)
-		return err
-	}
-	deviceStr := string(deviceJSON)
-	cm, err := m.context.Clientset.CoreV1().ConfigMaps(m.namespace).Get(ctx, m.cmName, metav1.GetOptions{})
-	if err == nil {
-		lastDevice := cm.Data[topolvm.LocalDiskCMData]
-		logger.Debugf("last devices %s", lastDevice)
-		if lastDevice != deviceStr {
-			newcm := cm.DeepCopy()
-			newcm.Data[topolvm.LocalDiskCMData] = deviceStr
-			err = k8sutil.PatchConfigMap(m.context.Clientset, newcm.Namespace, cm, newcm)
-			if err != nil {
-				logger.Errorf("failed to update configmap %s: %v", m.cmName, err)
-				return err
-			}
+	return m.syncInventory()
+}
+
+// syncInventory writes the known devices to the node's device inventory if
+// they changed.
+func (m *DeviceManager) syncInventory() error {
+	ctx := context.TODO()
+	exportDevices(m.nodeName, m.devices)
+	devices := make([]rawapi.InventoryDevice, 0, len(m.devices))
+	available := 0
+	for _, disk := range m.devices {
+		devices = append(devices, convertDiskToInventoryDevice(disk, m.health[disk.KernelName]))
+		if disk.Available {
+			available++
 		}
-	} else {
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].KernelName < devices[j].KernelName
+	})
+
+	client := m.context.RawDeviceClientset.RawdeviceV1().NodeDeviceInventories()
+	inventory, err := client.Get(ctx, m.nodeName, metav1.GetOptions{})
+	if err != nil {
 		if !kerrors.IsNotFound(err) {
-			logger.Infof("failed to get configmap: %v", err)
-			return err
+			return errors.Wrapf(err, "failed to get device inventory %s", m.nodeName)
 		}
-
-		data := make(map[string]string, 1)
-		data[topolvm.LocalDiskCMData] = deviceStr
-
-		// the map doesn't exist yet, create it now
-		annotations := make(map[string]string)
-		annotations[topolvm.LvmdAnnotationsNodeKey] = m.nodeName
-		cm = &v1.ConfigMap{
+		inventory = &rawapi.NodeDeviceInventory{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      m.cmName,
-				Namespace: m.namespace,
-				Labels: map[string]string{
-					topolvm.LvmdConfigMapLabelKey: topolvm.LvmdConfigMapLabelValue,
-				},
-				Annotations: annotations,
+				Name:   m.nodeName,
+				Labels: map[string]string{"node": m.nodeName},
 			},
-			Data: data,
+			Spec: rawapi.NodeDeviceInventorySpec{NodeName: m.nodeName},
 		}
-
-		_, err = m.context.Clientset.CoreV1().ConfigMaps(m.namespace).Create(ctx, cm, metav1.CreateOptions{})
+		inventory, err = client.Create(ctx, inventory, metav1.CreateOptions{})
 		if err != nil {
-			logger.Infof("failed to create configmap: %v", err)
-			return fmt.Errorf("failed to create local device map %s: %+v", m.cmName, err)
+			return errors.Wrapf(err, "failed to create device inventory %s", m.nodeName)
 		}
+	}
+
+	if inventory.Status.LastUpdateTime != nil && reflect.DeepEqual(inventory.Status.Devices, devices) {
 		return nil
 	}
+	logger.Infof("updating device inventory of node %s, %d devices %d available", m.nodeName, len(devices), available)
+	now := metav1.Now()
+	newInventory := inventory.DeepCopy()
+	newInventory.Status = rawapi.NodeDeviceInventoryStatus{
+		Devices:        devices,
+		DeviceCount:    len(devices),
+		AvailableCount: available,
+		LastUpdateTime: &now,
+	}
+	if _, err := client.UpdateStatus(ctx, newInventory, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to update device inventory %s", m.nodeName)
+	}
 	return nil
 }
 
+func convertDiskToInventoryDevice(disk *sys.LocalDiskAppendInfo, health *rawapi.DeviceHealth) rawapi.InventoryDevice {
+	device := rawapi.InventoryDevice{
+		Name:        disk.Name,
+		KernelName:  disk.KernelName,
+		Parent:      disk.Parent,
+		RealPath:    disk.RealPath,
+		Type:        disk.Type,
+		Size:        int64(disk.Size),
+		Major:       disk.Major,
+		Minor:       disk.Minor,
+		UUID:        disk.UUID,
+		Serial:      disk.Serial,
+		Vendor:      disk.Vendor,
+		Model:       disk.Model,
+		WWN:         disk.WWN,
+		Rotational:  disk.Rotational,
+		ReadOnly:    disk.Readonly,
+		HasChildren: disk.HasChildren,
+		Filesystem:  disk.Filesystem,
+		MountPoint:  disk.MountPoint,
+		Available:   disk.Available,
+		Message:     disk.Message,
+		Health:      health.DeepCopy(),
+		Topology:    convertDiskTopology(&disk.LocalDisk),
+	}
+	// leave the links nil when empty so that the status compares equal after
+	// a round trip through the api server
+	if links := strings.Fields(disk.DevLinks); len(links) > 0 {
+		device.DevLinks = links
+	}
+	for _, reason := range disk.Reasons {
+		device.Reasons = append(device.Reasons, string(reason))
+	}
+	if len(disk.Holders) > 0 {
+		device.Holders = append([]string(nil), disk.Holders...)
+	}
+	if disk.Type == sys.MultiPath {
+		device.Multipath = &rawapi.MultipathStatus{
+			ActivePaths: disk.ActivePaths(),
+			Degraded:    disk.ActivePaths() < len(disk.MultipathPaths),
+		}
+		for _, p := range disk.MultipathPaths {
+			device.Multipath.Paths = append(device.Multipath.Paths, rawapi.MultipathPath{Name: p.Name, State: p.State})
+		}
+	}
+	return device
+}
+
 func (m *DeviceManager) checkDeviceClass() error {
 	logger.Info("check device status")
 	ctx := context.TODO()
@@ -405,70 +745,77 @@ func (m *DeviceManager) checkDeviceClass() error {
 	return nil
 }
 
-// Scans `udevadm monitor` output for block sub-system events. Each line of
-// output matching a set of substrings is sent to the provided channel. An event
-// is returned if it passes any matches tests, and passes all exclusion tests.
-func rawUdevBlockMonitor(c chan string, matches, exclusions []string) {
-	defer close(c)
-
-	// stdbuf -oL performs line buffered output
-	cmd := exec.Command("stdbuf", "-oL", "udevadm", "monitor", "-u", "-k", "-s", "block")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		logger.Warningf("Cannot open udevadm stdout: %v", err)
-		return
+func compileExclusions(patterns []string) []*regexp.Regexp {
+	exclusions := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warningf("ignoring invalid udev exclusion %q. %v", pattern, err)
+			continue
+		}
+		exclusions = append(exclusions, re)
 	}
+	return exclusions
+}
 
-	err = cmd.Start()
-	if err != nil {
-		logger.Warningf("Cannot start udevadm monitoring: %v", err)
-		return
+// dmDevice matches the kernel names of device mapper devices
+var dmDevice = regexp.MustCompile(`^dm-[0-9]+$`)
+
+// udevFilter drops the events of excluded devices and of device mapper
+// devices other than multipath maps, e.g. logical volumes. It is used by the
+// monitor goroutine only.
+type udevFilter struct {
+	exclusions  []*regexp.Regexp
+	isMultipath func(name string) bool
+	// multipathMaps are the multipath maps seen since the monitor started,
+	// their sysfs entries are gone by the time they are removed
+	multipathMaps map[string]bool
+}
+
+func newUdevFilter(exclusions []*regexp.Regexp, isMultipath func(name string) bool) *udevFilter {
+	return &udevFilter{
+		exclusions:    exclusions,
+		isMultipath:   isMultipath,
+		multipathMaps: make(map[string]bool),
 	}
+}
 
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		text := scanner.Text()
-		logger.Debugf("udevadm monitor: %s", text)
-		match, err := matchUdevEvent(text, matches, exclusions)
-		if err != nil {
-			logger.Warningf("udevadm filtering failed: %v", err)
-			return
-		}
-		if match {
-			c <- text
+// match reports whether event adds, removes or changes a block device that
+// is not excluded.
+func (f *udevFilter) match(event *uevent.Event) bool {
+	if event.Action != uevent.ActionAdd && event.Action != uevent.ActionRemove && event.Action != uevent.ActionChange {
+		return false
+	}
+	for _, exclusion := range f.exclusions {
+		if exclusion.MatchString(event.DevName) || exclusion.MatchString(event.DevPath) {
+			return false
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		logger.Warningf("udevadm monitor scanner error: %v", err)
+	if dmDevice.MatchString(event.DevName) && !f.matchMultipath(event) {
+		return false
 	}
-
-	logger.Info("udevadm monitor finished")
+	logger.Infof("uevent monitor: matched event: %s", event)
+	return true
 }
 
-func matchUdevEvent(text string, matches, exclusions []string) (bool, error) {
-	for _, match := range matches {
-		matched, err := regexp.MatchString(match, text)
-		if err != nil {
-			return false, fmt.Errorf("failed to search string: %v", err)
-		}
-		if matched {
-			hasExclusion := false
-			for _, exclusion := range exclusions {
-				matched, err = regexp.MatchString(exclusion, text)
-				if err != nil {
-					return false, fmt.Errorf("failed to search string: %v", err)
-				}
-				if matched {
-					hasExclusion = true
-					break
-				}
-			}
-			if !hasExclusion {
-				logger.Infof("udevadm monitor: matched event: %s", text)
-				return true, nil
-			}
-		}
+// matchMultipath tells whether the device mapper device of event is a
+// multipath map. Maps removed before they were seen are left to the next
+// full scan.
+func (f *udevFilter) matchMultipath(event *uevent.Event) bool {
+	name := event.DevName
+	if event.Action == uevent.ActionRemove {
+		seen := f.multipathMaps[name]
+		delete(f.multipathMaps, name)
+		return seen
+	}
+	if dmUUID, ok := event.Env["DM_UUID"]; ok {
+		f.multipathMaps[name] = sys.IsMultipathUUID(dmUUID)
+	} else {
+		f.multipathMaps[name] = f.isMultipath(name)
+	}
+	if !f.multipathMaps[name] {
+		delete(f.multipathMaps, name)
+		return false
 	}
-	return false, nil
+	return true
 }
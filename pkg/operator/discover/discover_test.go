@@ -0,0 +1,314 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	rawapi "github.com/alauda/nativestor/apis/rawdevice/v1"
+	"github.com/alauda/nativestor/generated/nativestore/rawdevice/clientset/versioned/fake"
+	rawv1 "github.com/alauda/nativestor/generated/nativestore/rawdevice/listers/rawdevice/v1"
+	"github.com/alauda/nativestor/pkg/cluster"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/alauda/nativestor/pkg/util/uevent"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// fakeEnumerator serves devices from a map and records what was asked.
+type fakeEnumerator struct {
+	devices    map[string]*sys.LocalDisk
+	fullScans  int
+	discovered []string
+}
+
+func (e *fakeEnumerator) DiscoverDevices(listParent bool) ([]*sys.LocalDisk, error) {
+	e.fullScans++
+	var disks []*sys.LocalDisk
+	for _, d := range e.devices {
+		disks = append(disks, d)
+	}
+	return disks, nil
+}
+
+func (e *fakeEnumerator) DiscoverDevice(name string) (*sys.LocalDisk, error) {
+	e.discovered = append(e.discovered, name)
+	d, ok := e.devices[name]
+	if !ok {
+		return nil, fmt.Errorf("device %s not found", name)
+	}
+	return d, nil
+}
+
+// fakeHealthCollector reports the health set for a device name.
+type fakeHealthCollector map[string]*rawapi.DeviceHealth
+
+func (c fakeHealthCollector) DeviceHealth(device *sys.LocalDisk) (*rawapi.DeviceHealth, error) {
+	h, ok := c[device.Name]
+	if !ok {
+		return nil, fmt.Errorf("no health for %s", device.Name)
+	}
+	return h.DeepCopy(), nil
+}
+
+func newTestDisk(name string, size uint64) *sys.LocalDisk {
+	return &sys.LocalDisk{Name: name, KernelName: name, RealPath: "/dev/" + name, Type: sys.DiskType, Size: size}
+}
+
+type testDeviceManager struct {
+	*DeviceManager
+	enumerator *fakeEnumerator
+	rawClient  *fake.Clientset
+	indexer    cache.Indexer
+}
+
+func newTestDeviceManager(disks ...*sys.LocalDisk) *testDeviceManager {
+	enumerator := &fakeEnumerator{devices: make(map[string]*sys.LocalDisk)}
+	for _, d := range disks {
+		enumerator.devices[d.Name] = d
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	rawClient := fake.NewSimpleClientset()
+	ctx := &cluster.Context{Clientset: k8sfake.NewSimpleClientset(), RawDeviceClientset: rawClient}
+	m := NewDeviceManager(ctx, enumerator, sys.DefaultDeviceRules(), 0, 0, rawv1.NewRawDeviceLister(indexer), "node1", "nativestor-system", false)
+	m.cmName = "lvmdconfig-node1"
+	return &testDeviceManager{DeviceManager: m, enumerator: enumerator, rawClient: rawClient, indexer: indexer}
+}
+
+// syncLister mirrors the fake api server into the lister like an informer.
+func (m *testDeviceManager) syncLister(t *testing.T) {
+	list, err := m.rawClient.RawdeviceV1().RawDevices().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	objs := make([]interface{}, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	assert.NoError(t, m.indexer.Replace(objs, ""))
+	m.rawClient.ClearActions()
+}
+
+// writeActions returns the verbs of the writes to raw devices.
+func writeActions(actions []k8stesting.Action) []string {
+	var res []string
+	for _, a := range actions {
+		if a.GetResource().Resource != "rawdevices" {
+			continue
+		}
+		if a.GetVerb() != "get" && a.GetVerb() != "list" && a.GetVerb() != "watch" {
+			res = append(res, a.GetVerb())
+		}
+	}
+	return res
+}
+
+func TestFullScanSkipsUnchangedRawDevices(t *testing.T) {
+	m := newTestDeviceManager(newTestDisk("sda", 10<<30), newTestDisk("sdb", 20<<30))
+
+	assert.NoError(t, m.updateDevices())
+	assert.Equal(t, []string{"create", "create"}, writeActions(m.rawClient.Actions()))
+
+	m.syncLister(t)
+	assert.NoError(t, m.updateDevices())
+	assert.Empty(t, writeActions(m.rawClient.Actions()))
+
+	m.enumerator.devices["sdb"].Rotational = true
+	assert.NoError(t, m.updateDevices())
+	assert.Equal(t, []string{"create", "update"}, writeActions(m.rawClient.Actions()))
+}
+
+func TestStaleSignaturesAreNotRawDevices(t *testing.T) {
+	stale := newTestDisk("sdb", 20<<30)
+	stale.Filesystem = "xfs"
+	m := newTestDeviceManager(stale)
+	m.rules = &sys.DeviceRules{AllowStaleSignatures: true}
+	assert.NoError(t, m.updateDevices())
+
+	inventory, err := m.rawClient.RawdeviceV1().NodeDeviceInventories().Get(context.TODO(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inventory.Status.AvailableCount)
+	raw, err := m.rawClient.RawdeviceV1().RawDevices().Get(context.TODO(), k8sutil.Hash("node1/dev/sdb"), metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.False(t, raw.Spec.Available)
+}
+
+func TestSyncInventory(t *testing.T) {
+	m := newTestDeviceManager(newTestDisk("sdb", 20<<30), newTestDisk("sda", 1<<30))
+	assert.NoError(t, m.updateDevices())
+
+	inventory, err := m.rawClient.RawdeviceV1().NodeDeviceInventories().Get(context.TODO(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", inventory.Spec.NodeName)
+	assert.Equal(t, 2, inventory.Status.DeviceCount)
+	assert.Equal(t, 1, inventory.Status.AvailableCount)
+	assert.NotNil(t, inventory.Status.LastUpdateTime)
+	assert.Equal(t, "sda", inventory.Status.Devices[0].Name)
+	assert.Equal(t, "size less than 2Gi", inventory.Status.Devices[0].Message)
+	assert.True(t, inventory.Status.Devices[1].Available)
+
+	// nothing changed, nothing written
+	m.syncLister(t)
+	assert.NoError(t, m.updateDevices())
+	for _, a := range m.rawClient.Actions() {
+		assert.Contains(t, []string{"get", "list", "watch"}, a.GetVerb())
+	}
+
+	m.enumerator.devices["sdb"].DevLinks = "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4 /dev/disk/by-path/pci-0000:00:10.0-scsi-0:0:1:0"
+	assert.NoError(t, m.updateDevices())
+	inventory, err = m.rawClient.RawdeviceV1().NodeDeviceInventories().Get(context.TODO(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, inventory.Status.Devices[1].DevLinks, 2)
+}
+
+func TestApplyUdevEventsOnlyTouchesChangedDevices(t *testing.T) {
+	m := newTestDeviceManager(newTestDisk("sda", 10<<30), newTestDisk("sdb", 20<<30))
+	assert.NoError(t, m.updateDevices())
+	m.syncLister(t)
+	m.enumerator.fullScans = 0
+
+	// a partition shows up on sdb
+	m.enumerator.devices["sdb"].HasChildren = true
+	sdb1 := newTestDisk("sdb1", 20<<30)
+	sdb1.Type, sdb1.Parent = sys.PartType, "sdb"
+	m.enumerator.devices["sdb1"] = sdb1
+
+	assert.NoError(t, m.applyUdevEvents([]*uevent.Event{{Action: uevent.ActionAdd, DevName: "sdb1"}}))
+	assert.Equal(t, 0, m.enumerator.fullScans)
+	assert.Equal(t, []string{"sdb1", "sdb"}, m.enumerator.discovered)
+	assert.Equal(t, []string{"create", "create", "update"}, writeActions(m.rawClient.Actions()))
+
+	sdb, err := m.rawClient.RawdeviceV1().RawDevices().Get(context.TODO(), k8sutil.Hash("node1/dev/sdb"), metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.False(t, sdb.Spec.Available)
+
+	inventory, err := m.rawClient.RawdeviceV1().NodeDeviceInventories().Get(context.TODO(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, inventory.Status.DeviceCount)
+	assert.Equal(t, "sdb1", inventory.Status.Devices[2].Name)
+
+	// sda is claimed and unplugged
+	m.syncLister(t)
+	sda, err := m.rawDeviceLister.Get(k8sutil.Hash("node1/dev/sda"))
+	assert.NoError(t, err)
+	sda.Status.Name = "pvc-a"
+	delete(m.enumerator.devices, "sda")
+
+	assert.NoError(t, m.applyUdevEvents([]*uevent.Event{{Action: uevent.ActionRemove, DevName: "sda"}}))
+	assert.Equal(t, []string{"update"}, writeActions(m.rawClient.Actions()))
+	lost, err := m.rawClient.RawdeviceV1().RawDevices().Get(context.TODO(), sda.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.True(t, lost.Spec.Lost)
+	_, ok := m.devices["sda"]
+	assert.False(t, ok)
+}
+
+func TestMultipathReplacesItsPaths(t *testing.T) {
+	m := newTestDeviceManager(newTestDisk("sdb", 20<<30), newTestDisk("sdc", 20<<30))
+	assert.NoError(t, m.updateDevices())
+	m.syncLister(t)
+
+	// multipathd assembles a map of both paths
+	for _, name := range []string{"sdb", "sdc"} {
+		m.enumerator.devices[name].MultipathMember = true
+		m.enumerator.devices[name].Holders = []string{"dm-0"}
+	}
+	mpath := newTestDisk("dm-0", 20<<30)
+	mpath.Type, mpath.Parent, mpath.RealPath = sys.MultiPath, "sdb", "/dev/mapper/mpatha"
+	mpath.MultipathPaths = []sys.MultipathPath{{Name: "sdb", State: sys.PathStateRunning}, {Name: "sdc", State: "offline"}}
+	m.enumerator.devices["dm-0"] = mpath
+
+	assert.NoError(t, m.applyUdevEvents([]*uevent.Event{
+		{Action: uevent.ActionAdd, DevName: "dm-0"},
+		{Action: uevent.ActionChange, DevName: "sdc"},
+	}))
+	list, err := m.rawClient.RawdeviceV1().RawDevices().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, "/dev/mapper/mpatha", list.Items[0].Spec.RealPath)
+
+	inventory, err := m.rawClient.RawdeviceV1().NodeDeviceInventories().Get(context.TODO(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inventory.Status.DeviceCount)
+	multipath := inventory.Status.Devices[0].Multipath
+	assert.NotNil(t, multipath)
+	assert.Equal(t, 1, multipath.ActivePaths)
+	assert.True(t, multipath.Degraded)
+	assert.Len(t, multipath.Paths, 2)
+}
+
+func TestCheckHealthBlocksUnhealthyDisks(t *testing.T) {
+	m := newTestDeviceManager(newTestDisk("sda", 10<<30), newTestDisk("sdb", 20<<30))
+	mediaErrors := int64(7)
+	m.EnableHealthCheck(fakeHealthCollector{
+		"sda": {Healthy: true},
+		"sdb": {Healthy: false, Reason: "7 media errors", MediaErrors: &mediaErrors},
+	}, time.Minute, true)
+	assert.NoError(t, m.updateDevices())
+	m.syncLister(t)
+
+	m.checkHealth()
+	m.syncLister(t)
+
+	sdb, err := m.rawDeviceLister.Get(k8sutil.Hash("node1/dev/sdb"))
+	assert.NoError(t, err)
+	assert.False(t, sdb.Spec.Available)
+	assert.Equal(t, &mediaErrors, sdb.Status.Health.MediaErrors)
+	cond := meta.FindStatusCondition(sdb.Status.Conditions, rawapi.ConditionHealthy)
+	assert.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, "7 media errors", cond.Message)
+
+	sda, err := m.rawDeviceLister.Get(k8sutil.Hash("node1/dev/sda"))
+	assert.NoError(t, err)
+	assert.True(t, sda.Spec.Available)
+	assert.True(t, meta.IsStatusConditionTrue(sda.Status.Conditions, rawapi.ConditionHealthy))
+
+	inventory, err := m.rawClient.RawdeviceV1().NodeDeviceInventories().Get(context.TODO(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inventory.Status.AvailableCount)
+	assert.Equal(t, "unhealthy: 7 media errors", inventory.Status.Devices[1].Message)
+	assert.False(t, inventory.Status.Devices[1].Health.Healthy)
+
+	// a full scan keeps the disk blocked and writes nothing
+	assert.NoError(t, m.updateDevices())
+	assert.Empty(t, writeActions(m.rawClient.Actions()))
+
+	// only reported when blocking is off
+	m.blockUnhealthy = false
+	assert.NoError(t, m.updateDevices())
+	assert.Equal(t, []string{"create", "update"}, writeActions(m.rawClient.Actions()))
+}
+
+func TestUdevFilter(t *testing.T) {
+	multipath := map[string]bool{"dm-0": true}
+	f := newUdevFilter(compileExclusions([]string{"(?i)rbd[0-9]+"}), func(name string) bool {
+		return multipath[name]
+	})
+
+	for _, tt := range []struct {
+		event *uevent.Event
+		match bool
+	}{
+		{&uevent.Event{Action: uevent.ActionAdd, DevName: "sdb"}, true},
+		{&uevent.Event{Action: "bind", DevName: "sdb"}, false},
+		{&uevent.Event{Action: uevent.ActionAdd, DevName: "rbd0"}, false},
+		// a multipath map and a logical volume
+		{&uevent.Event{Action: uevent.ActionAdd, DevName: "dm-0"}, true},
+		{&uevent.Event{Action: uevent.ActionAdd, DevName: "dm-1"}, false},
+		// the uuid of the event wins over sysfs
+		{&uevent.Event{Action: uevent.ActionChange, DevName: "dm-2", Env: map[string]string{"DM_UUID": "mpath-3600a0980"}}, true},
+		{&uevent.Event{Action: uevent.ActionChange, DevName: "dm-3", Env: map[string]string{"DM_UUID": "LVM-Kd0c"}}, false},
+		// sysfs is gone on removal, only maps seen before pass
+		{&uevent.Event{Action: uevent.ActionRemove, DevName: "dm-0"}, true},
+		{&uevent.Event{Action: uevent.ActionRemove, DevName: "dm-1"}, false},
+		{&uevent.Event{Action: uevent.ActionRemove, DevName: "dm-2"}, true},
+		{&uevent.Event{Action: uevent.ActionRemove, DevName: "dm-2"}, false},
+	} {
+		assert.Equal(t, tt.match, f.match(tt.event), "%s", tt.event)
+	}
+}
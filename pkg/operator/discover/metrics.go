@@ -0,0 +1,155 @@
+package discover
+
+import (
+	rawapi "github.com/alauda/nativestor/apis/rawdevice/v1"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace  = "nativestor"
+	metricsSubsystem  = "device"
+	discoverSubsystem = "discover"
+
+	// sources of update errors
+	errorSourceScan   = "scan"
+	errorSourceUevent = "uevent"
+	errorSourceHealth = "health"
+)
+
+var (
+	healthLabels = []string{"node", "device", "serial", "model"}
+
+	deviceHealthy            = newHealthGauge("healthy", "Whether the device reports itself healthy, 1 for healthy and 0 for unhealthy.")
+	deviceTemperature        = newHealthGauge("temperature_celsius", "Current temperature of the device in Celsius.")
+	devicePercentageUsed     = newHealthGauge("percentage_used", "NVMe estimate of the device endurance used in percent.")
+	deviceMediaErrors        = newHealthGauge("media_errors", "NVMe count of unrecovered data integrity errors.")
+	deviceCriticalWarning    = newHealthGauge("critical_warning", "NVMe critical warning bitmap.")
+	deviceReallocatedSectors = newHealthGauge("reallocated_sectors", "Number of sectors the device remapped.")
+	devicePendingSectors     = newHealthGauge("pending_sectors", "Number of unstable sectors waiting to be remapped.")
+	devicePowerOnHours       = newHealthGauge("power_on_hours", "Number of hours the device was powered on.")
+
+	healthGauges = []*prometheus.GaugeVec{
+		deviceHealthy,
+		deviceTemperature,
+		devicePercentageUsed,
+		deviceMediaErrors,
+		deviceCriticalWarning,
+		deviceReallocatedSectors,
+		devicePendingSectors,
+		devicePowerOnHours,
+	}
+)
+
+var (
+	scanDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: discoverSubsystem,
+		Name:      "scan_duration_seconds",
+		Help:      "Duration of the full device scans in seconds.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"node"})
+	lastScanTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: discoverSubsystem,
+		Name:      "last_scan_timestamp_seconds",
+		Help:      "Unix time of the last successful full device scan.",
+	}, []string{"node"})
+	devicesFound = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: discoverSubsystem,
+		Name:      "devices",
+		Help:      "Number of devices found on the node by availability.",
+	}, []string{"node", "available"})
+	ueventsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: discoverSubsystem,
+		Name:      "uevents_total",
+		Help:      "Number of block device uevents processed.",
+	}, []string{"node"})
+	updateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: discoverSubsystem,
+		Name:      "update_errors_total",
+		Help:      "Number of failed device updates by source, one of scan, uevent or health.",
+	}, []string{"node", "source"})
+
+	discoverCollectors = []prometheus.Collector{
+		scanDuration,
+		lastScanTimestamp,
+		devicesFound,
+		ueventsProcessed,
+		updateErrors,
+	}
+)
+
+func newHealthGauge(name, help string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      name,
+		Help:      help,
+	}, healthLabels)
+}
+
+// RegisterMetrics registers the discover and device health metrics into
+// registry.
+func RegisterMetrics(registry prometheus.Registerer) error {
+	for _, g := range healthGauges {
+		if err := registry.Register(g); err != nil {
+			return err
+		}
+	}
+	for _, c := range discoverCollectors {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportDevices counts the known devices by availability.
+func exportDevices(nodeName string, devices map[string]*sys.LocalDiskAppendInfo) {
+	available := 0
+	for _, d := range devices {
+		if d.Available {
+			available++
+		}
+	}
+	devicesFound.WithLabelValues(nodeName, "true").Set(float64(available))
+	devicesFound.WithLabelValues(nodeName, "false").Set(float64(len(devices) - available))
+}
+
+// exportHealth replaces the device health gauges with the last collected
+// health, so that removed devices do not leave stale series behind.
+func exportHealth(nodeName string, devices map[string]*sys.LocalDiskAppendInfo, health map[string]*rawapi.DeviceHealth) {
+	for _, g := range healthGauges {
+		g.Reset()
+	}
+	for name, h := range health {
+		disk, ok := devices[name]
+		if !ok {
+			continue
+		}
+		labels := prometheus.Labels{"node": nodeName, "device": name, "serial": disk.Serial, "model": disk.Model}
+		healthy := 0.0
+		if h.Healthy {
+			healthy = 1
+		}
+		deviceHealthy.With(labels).Set(healthy)
+		setGauge(deviceTemperature, labels, h.Temperature)
+		setGauge(devicePercentageUsed, labels, h.PercentageUsed)
+		setGauge(deviceMediaErrors, labels, h.MediaErrors)
+		setGauge(deviceCriticalWarning, labels, h.CriticalWarning)
+		setGauge(deviceReallocatedSectors, labels, h.ReallocatedSectors)
+		setGauge(devicePendingSectors, labels, h.PendingSectors)
+		setGauge(devicePowerOnHours, labels, h.PowerOnHours)
+	}
+}
+
+// setGauge leaves the gauge out when the device does not report the value.
+func setGauge(g *prometheus.GaugeVec, labels prometheus.Labels, value *int64) {
+	if value != nil {
+		g.With(labels).Set(float64(*value))
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discover
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// LivenessPath and ReadinessPath are served next to the metrics
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+
+	// stallGrace is added to the probe interval before the main loop is
+	// considered stuck, a health check of many disks takes a while
+	stallGrace = 5 * time.Minute
+)
+
+// probeState is what the liveness and readiness probes report on, it is
+// written by the main loop and read by the http server.
+type probeState struct {
+	mu sync.Mutex
+	// lastActivity is when the main loop last finished handling anything
+	lastActivity time.Time
+	// lastScan is when the last full scan succeeded
+	lastScan time.Time
+	// scanErr is the error of the last full scan
+	scanErr error
+	// monitorErr is why the udev monitor died
+	monitorErr error
+}
+
+func (p *probeState) active(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastActivity = now
+}
+
+func (p *probeState) scanned(now time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastActivity = now
+	p.scanErr = err
+	if err == nil {
+		p.lastScan = now
+	}
+}
+
+func (p *probeState) monitorDied(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		err = fmt.Errorf("events closed")
+	}
+	p.monitorErr = err
+}
+
+// alive fails when the udev monitor died or the main loop stopped, a restart
+// of the daemon brings both back.
+func (p *probeState) alive(now time.Time, stallTimeout time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.monitorErr != nil {
+		return fmt.Errorf("udev monitor stopped: %v", p.monitorErr)
+	}
+	if !p.lastActivity.IsZero() && now.Sub(p.lastActivity) > stallTimeout {
+		return fmt.Errorf("discover loop stalled since %s", p.lastActivity.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ready fails until a full scan succeeded and while the last one failed,
+// e.g. because the api server is unreachable.
+func (p *probeState) ready() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastScan.IsZero() {
+		return fmt.Errorf("no device scan succeeded yet")
+	}
+	if p.scanErr != nil {
+		return fmt.Errorf("last device scan failed: %v", p.scanErr)
+	}
+	return nil
+}
+
+// LivenessHandler serves the liveness probe of the daemon.
+func (m *DeviceManager) LivenessHandler() http.Handler {
+	return probeHandler(func() error {
+		return m.probes.alive(time.Now(), 2*m.probeInterval+stallGrace)
+	})
+}
+
+// ReadinessHandler serves the readiness probe of the daemon.
+func (m *DeviceManager) ReadinessHandler() http.Handler {
+	return probeHandler(m.probes.ready)
+}
+
+func probeHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
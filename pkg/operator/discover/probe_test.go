@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discover
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alauda/nativestor/pkg/util/uevent"
+	"github.com/stretchr/testify/assert"
+)
+
+func probeStatus(h http.Handler) int {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec.Code
+}
+
+func TestProbes(t *testing.T) {
+	m := newTestDeviceManager(newTestDisk("sda", 10<<30))
+	m.probeInterval = time.Minute
+
+	assert.Equal(t, http.StatusServiceUnavailable, probeStatus(m.ReadinessHandler()))
+	assert.Equal(t, http.StatusOK, probeStatus(m.LivenessHandler()))
+
+	assert.NoError(t, m.scan())
+	assert.Equal(t, http.StatusOK, probeStatus(m.ReadinessHandler()))
+
+	// the api server went away
+	m.probes.scanned(time.Now(), errors.New("connection refused"))
+	assert.Equal(t, http.StatusServiceUnavailable, probeStatus(m.ReadinessHandler()))
+	assert.Equal(t, http.StatusOK, probeStatus(m.LivenessHandler()))
+
+	m.probes.active(time.Now().Add(-time.Hour))
+	assert.Equal(t, http.StatusServiceUnavailable, probeStatus(m.LivenessHandler()))
+
+	m.probes.active(time.Now())
+	m.probes.monitorDied(errors.New("socket: protocol not supported"))
+	assert.Equal(t, http.StatusServiceUnavailable, probeStatus(m.LivenessHandler()))
+}
+
+func TestUdevBlockMonitorStops(t *testing.T) {
+	broken := func() (uevent.Source, error) {
+		return nil, errors.New("socket: protocol not supported")
+	}
+	monitor := uevent.NewMonitorWithDialer(broken, uevent.SubsystemBlock).WithRetry(time.Millisecond, time.Millisecond, 2)
+	c := make(chan []*uevent.Event)
+	err := udevBlockMonitor(context.Background(), monitor, c, time.Millisecond)
+	assert.EqualError(t, err, "uevent source failed 2 times in a row: socket: protocol not supported")
+	_, ok := <-c
+	assert.False(t, ok)
+
+	// the daemon is shutting down
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = make(chan []*uevent.Event)
+	assert.NoError(t, udevBlockMonitor(ctx, monitor, c, time.Millisecond))
+	_, ok = <-c
+	assert.False(t, ok)
+}
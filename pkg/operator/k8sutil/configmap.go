@@ -20,7 +20,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/alauda/nativestor/pkg/cluster/topolvm"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -106,10 +105,10 @@ func PatchConfigMap(clientset kubernetes.Interface, namespace string, oldConfigM
 
 // GetOperatorSetting gets the operator setting from ConfigMap or Env Var
 // returns defaultValue if setting is not found
-func GetOperatorSetting(clientset kubernetes.Interface, configMapName, settingName, defaultValue string) (string, error) {
+func GetOperatorSetting(clientset kubernetes.Interface, namespace, configMapName, settingName, defaultValue string) (string, error) {
 	// config must be in operator pod namespace
 	ctx := context.TODO()
-	cm, err := clientset.CoreV1().ConfigMaps(topolvm.NameSpace).Get(ctx, configMapName, metav1.GetOptions{})
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			if settingValue, ok := os.LookupEnv(settingName); ok {
@@ -109,6 +109,18 @@ func CreateOrUpdateDeployment(ctx context.Context, clientset kubernetes.Interfac
 	return newDep, nil
 }
 
+// DeploymentRolledOut tells whether all pods of the deployment run its latest
+// pod template and are available.
+func DeploymentRolledOut(dep *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	status := dep.Status
+	return status.ObservedGeneration >= dep.Generation && status.UpdatedReplicas >= replicas &&
+		status.AvailableReplicas >= replicas && status.Replicas <= status.UpdatedReplicas
+}
+
 // GetDeploymentOwnerReference returns an OwnerReference to the deployment that is running the given pod name
 func GetDeploymentOwnerReference(ctx context.Context, clientset kubernetes.Interface, podName, namespace string) (*metav1.OwnerReference, error) {
 	var deploymentRef *metav1.OwnerReference
@@ -121,3 +121,21 @@ func TestDeleteDeployment(t *testing.T) {
 	assert.Equal(t, true, kerrors.IsNotFound(err))
 
 }
+
+func TestDeploymentRolledOut(t *testing.T) {
+	dep := makeDeployment("test-deployment", "test-namespace", "test-image")
+	dep.Generation = 2
+	dep.Status = apps.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	assert.False(t, DeploymentRolledOut(dep))
+
+	dep.Status.ObservedGeneration = 2
+	assert.True(t, DeploymentRolledOut(dep))
+
+	// the pod of the old template is still there
+	dep.Status.Replicas = 2
+	assert.False(t, DeploymentRolledOut(dep))
+
+	dep.Status.Replicas = 1
+	dep.Status.AvailableReplicas = 0
+	assert.False(t, DeploymentRolledOut(dep))
+}
@@ -51,3 +51,16 @@ func TestTruncateNodeName(t *testing.T) {
 		assert.Equal(t, result, TruncateNodeName(params[0], params[1]))
 	}
 }
+
+func TestImageVersion(t *testing.T) {
+	v, err := ImageVersion("quay.io/topolvm/topolvm-with-sidecar:0.10.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.10.2", v.String())
+	v, err = ImageVersion("registry:5000/topolvm:v0.11@sha256:0123")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.11", v.String())
+	_, err = ImageVersion("registry:5000/topolvm")
+	assert.Error(t, err)
+	_, err = ImageVersion("topolvm:latest")
+	assert.Error(t, err)
+}
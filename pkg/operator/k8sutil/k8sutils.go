@@ -20,10 +20,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
+
 	"github.com/alauda/nativestor/pkg/cluster"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -51,6 +54,19 @@ func Hash(s string) string {
 	return hex.EncodeToString(h[:16])
 }
 
+// ImageVersion returns the version an image is tagged with, e.g. 0.10.2 of
+// quay.io/topolvm/topolvm-with-sidecar:0.10.2.
+func ImageVersion(image string) (*version.Version, error) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	tag := ""
+	if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+		tag = image[i+1:]
+	}
+	return version.ParseGeneric(tag)
+}
+
 // StartOperatorSettingsWatch starts the watch for Operator Settings ConfigMap
 func StartOperatorSettingsWatch(context *cluster.Context, operatorNamespace, operatorSettingConfigMapName string,
 	addFunc func(obj interface{}), updateFunc func(oldObj, newObj interface{}), deleteFunc func(obj interface{}), stopCh chan struct{}) {
@@ -87,6 +87,35 @@ func CreateOrUpdateServiceMonitor(serviceMonitorDefinition *monitoringv1.Service
 	return sm, nil
 }
 
+// CreateOrUpdatePodMonitor creates podMonitor object or an error
+func CreateOrUpdatePodMonitor(podMonitorDefinition *monitoringv1.PodMonitor) (*monitoringv1.PodMonitor, error) {
+	ctx := context.TODO()
+	name := podMonitorDefinition.GetName()
+	namespace := podMonitorDefinition.GetNamespace()
+	logger.Debugf("creating podmonitor %s", name)
+	client, err := getMonitoringClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monitoring client. %v", err)
+	}
+	oldPm, err := client.MonitoringV1().PodMonitors(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			pm, err := client.MonitoringV1().PodMonitors(namespace).Create(ctx, podMonitorDefinition, metav1.CreateOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create podmonitor. %v", err)
+			}
+			return pm, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve podmonitor. %v", err)
+	}
+	oldPm.Spec = podMonitorDefinition.Spec
+	pm, err := client.MonitoringV1().PodMonitors(namespace).Update(ctx, oldPm, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update podmonitor. %v", err)
+	}
+	return pm, nil
+}
+
 // GetPrometheusRule returns provided prometheus rules or an error
 func GetPrometheusRule(ruleFilePath string) (*monitoringv1.PrometheusRule, error) {
 	ruleFile, err := ioutil.ReadFile(filepath.Clean(ruleFilePath))
@@ -10,20 +10,17 @@ import (
 
 func CreateOrUpdateRawDevice(ctx context.Context, clientset rawclient.Interface, device *v1.RawDevice) (*v1.RawDevice, error) {
 
-	_, err := CreateRawDevice(ctx, clientset, device)
+	dev, err := CreateRawDevice(ctx, clientset, device)
 	if k8serrors.IsAlreadyExists(err) {
 		newDev, err := clientset.RawdeviceV1().RawDevices().Get(ctx, device.Name, metav1.GetOptions{})
 		if err != nil {
 			return nil, err
 		}
 		newDev.Spec = device.Spec
-		dev, err := clientset.RawdeviceV1().RawDevices().Update(ctx, newDev, metav1.UpdateOptions{})
-		if err != nil {
-			return dev, err
-		}
+		return clientset.RawdeviceV1().RawDevices().Update(ctx, newDev, metav1.UpdateOptions{})
 	}
 
-	return nil, err
+	return dev, err
 }
 
 func CreateRawDevice(ctx context.Context, clientset rawclient.Interface, device *v1.RawDevice) (*v1.RawDevice, error) {
@@ -0,0 +1,117 @@
+package csi
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	k8sYAML "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const RawDevicePrometheusRule = `
+apiVersion: monitoring.coreos.com/v1
+kind: PrometheusRule
+metadata:
+  labels:
+    prometheus: kube-prometheus
+  name: raw-device-alert
+  namespace: operators
+spec:
+  groups:
+    - name: raw-device
+      rules:
+        - alert: raw.device.pool.near.empty
+          annotations:
+            alert_current_value: '{{ $value }}'
+            alert_notifications: '[]'
+            description: Less than 10% of the raw devices on node {{ $labels.node }} are free. Add devices to the node.
+            summary: raw device pool near empty
+          expr: sum by (node) (rawdevice_pool_devices{state="free"}) / sum by (node) (rawdevice_pool_devices) < 0.1
+          for: 5m
+          labels:
+            alert_description: Less than 10% of the raw devices on node {{ $labels.node }} are free. Add devices to the node.
+            severity: Medium
+        - alert: raw.device.pool.empty
+          annotations:
+            alert_current_value: '{{ $value }}'
+            alert_notifications: '[]'
+            description: No raw device is free on node {{ $labels.node }}. New volumes can not be provisioned there.
+            summary: raw device pool empty
+          expr: sum by (node) (rawdevice_pool_devices) unless sum by (node) (rawdevice_pool_devices{state="free"})
+          for: 5m
+          labels:
+            alert_description: No raw device is free on node {{ $labels.node }}. New volumes can not be provisioned there.
+            severity: High
+        - alert: raw.device.lost
+          annotations:
+            alert_current_value: '{{ $value }}'
+            alert_notifications: '[]'
+            description: Claimed raw devices on node {{ $labels.node }} are no longer found on the node.
+            summary: raw device lost
+          expr: sum by (node) (rawdevice_pool_devices{state="lost"}) > 0
+          for: 60s
+          labels:
+            alert_description: Claimed raw devices on node {{ $labels.node }} are no longer found on the node.
+            severity: Critical
+`
+
+// RawDevicePodMonitors scrape the metrics of the raw device plugins and
+// provisioners, the alert rules are built on them
+var RawDevicePodMonitors = []string{`
+apiVersion: monitoring.coreos.com/v1
+kind: PodMonitor
+metadata:
+  labels:
+    prometheus: kube-prometheus
+  name: raw-device-plugin
+  namespace: operators
+spec:
+  podMetricsEndpoints:
+    - path: /metrics
+      port: metrics
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: raw-device-plugin
+`, `
+apiVersion: monitoring.coreos.com/v1
+kind: PodMonitor
+metadata:
+  labels:
+    prometheus: kube-prometheus
+  name: raw-device-provisioner
+  namespace: operators
+spec:
+  podMetricsEndpoints:
+    - path: /metrics
+      port: metrics
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: raw-device-provisioner
+`}
+
+func createOrUpdatePodMonitors(namespace string) error {
+	for _, definition := range RawDevicePodMonitors {
+		var monitor monitoringv1.PodMonitor
+		err := k8sYAML.NewYAMLOrJSONDecoder(bytes.NewBufferString(definition), 1000).Decode(&monitor)
+		if err != nil {
+			return fmt.Errorf("podMonitor could not be decoded. %v", err)
+		}
+		monitor.Namespace = namespace
+		if _, err := k8sutil.CreateOrUpdatePodMonitor(&monitor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createOrUpdatePrometheusRule(namespace string) error {
+	var rule monitoringv1.PrometheusRule
+	err := k8sYAML.NewYAMLOrJSONDecoder(bytes.NewBufferString(RawDevicePrometheusRule), 1000).Decode(&rule)
+	if err != nil {
+		return fmt.Errorf("prometheusRules could not be decoded. %v", err)
+	}
+	rule.Namespace = namespace
+	_, err = k8sutil.CreateOrUpdatePrometheusRule(&rule)
+	return err
+}
@@ -175,6 +175,15 @@ func (r *CSIRawDeviceController) startDrivers(ver *version.Info, ownerInfo *k8su
 		}
 	}
 
+	// the pod monitors and alert rules are optional, the prometheus operator
+	// may not be installed
+	if err = createOrUpdatePodMonitors(r.opConfig.OperatorNamespace); err != nil {
+		logger.Warningf("failed to create raw device pod monitors. %v", err)
+	}
+	if err = createOrUpdatePrometheusRule(r.opConfig.OperatorNamespace); err != nil {
+		logger.Warningf("failed to create raw device prometheus rule. %v", err)
+	}
+
 	return nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
 	"github.com/alauda/nativestor/pkg/cluster"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/volumegroup"
 	"github.com/alauda/nativestor/pkg/util/exec"
 	"github.com/alauda/nativestor/pkg/util/sys"
 	"github.com/coreos/pkg/capnslog"
@@ -34,6 +35,19 @@ func (c *CleanUp) Start() error {
 		return err
 	}
 	vgs, pvs := getVgInfo(topolvmCluster, c.nodeName)
+	if len(topolvmCluster.Spec.Storage.NodeClasses) > 0 {
+		node, err := c.context.Clientset.CoreV1().Nodes().Get(context.TODO(), c.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		classVgs, err := volumegroup.NodeClassVolumeGroups(topolvmCluster.Spec.Storage.NodeClasses, node.Labels)
+		if err != nil {
+			return err
+		}
+		for _, vg := range classVgs {
+			vgs[vg] = vg
+		}
+	}
 	return cleanVgs(c.context.Executor, vgs, pvs)
 }
 
@@ -49,6 +63,10 @@ func getVgInfo(topolvmCluster *topolvmv2.TopolvmCluster, node string) (map[strin
 		for _, ele := range topolvmCluster.Spec.Storage.DeviceClasses {
 			if ele.NodeName == node {
 				for _, v := range ele.DeviceClasses {
+					// adopted vgs belong to the user
+					if v.Adopt {
+						continue
+					}
 					vgs[v.VgName] = v.VgName
 					for _, d := range v.Device {
 						pvs[d.Name] = d.Name
@@ -58,6 +76,29 @@ func getVgInfo(topolvmCluster *topolvmv2.TopolvmCluster, node string) (map[strin
 		}
 	}
 
+	// a node being decommissioned is no longer in the spec
+	for _, state := range topolvmCluster.Status.NodeStorageStatus {
+		if state.Node != node {
+			continue
+		}
+		for _, class := range state.FailClasses {
+			if !class.Adopted && class.VgName != "" {
+				vgs[class.VgName] = class.VgName
+			}
+		}
+		for _, class := range state.SuccessClasses {
+			if class.Adopted || class.VgName == "" {
+				continue
+			}
+			vgs[class.VgName] = class.VgName
+			for _, d := range class.DeviceStates {
+				if d.State == topolvmv2.DeviceOnline {
+					pvs[d.Name] = d.Name
+				}
+			}
+		}
+	}
+
 	return vgs, pvs
 }
 
@@ -1,14 +1,13 @@
 package controller
 
 import (
-	"context"
 	"fmt"
-	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
 	"github.com/alauda/nativestor/pkg/cluster/topolvm"
 	"github.com/alauda/nativestor/pkg/operator/k8sutil"
 	batch "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"os"
 	"time"
@@ -21,9 +20,11 @@ const (
 	indexJobMinorNumber = "22"
 )
 
-func (r *TopolvmController) startCleanUpJobs(cluster *topolvmv2.TopolvmCluster) {
+// startCleanUpJobs cleans the nodes of the cluster and waits for the jobs to
+// complete.
+func (r *clusterController) startCleanUpJobs(nodes []string) {
 
-	ownerRef, err := k8sutil.GetDeploymentOwnerReference(r.opManagerContext, r.context.Clientset, os.Getenv(k8sutil.PodNameEnvVar), r.opConfig.OperatorNamespace)
+	ownerRef, err := k8sutil.GetDeploymentOwnerReference(r.ctx, r.context.Clientset, os.Getenv(k8sutil.PodNameEnvVar), r.opConfig.OperatorNamespace)
 	if err != nil {
 		logger.Warningf("could not find deployment owner reference to assign to csi drivers. %v", err)
 	}
@@ -35,39 +36,18 @@ func (r *TopolvmController) startCleanUpJobs(cluster *topolvmv2.TopolvmCluster)
 	ownerInfo := k8sutil.NewOwnerInfoWithOwnerRef(ownerRef, r.opConfig.OperatorNamespace)
 
 	var cleanjobs []*batch.Job
-	if cluster.Spec.UseAllNodes {
-		nodes, err := r.context.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	for _, node := range nodes {
+		job, err := makeJob(r.context.Clientset, r.namespacedName, node, r.opConfig.Image)
 		if err != nil {
-			logger.Errorf("list node failed err %v", err)
+			logger.Errorf("make job failed err %v", err)
+			continue
 		}
-		for _, ele := range nodes.Items {
-			job, err := makeJob(r.context.Clientset, ele.Name, r.opConfig.Image)
-			if err != nil {
-				logger.Errorf("make job failed err %v", err)
-			}
-			ownerInfo.SetOwnerReference(job)
-			err = k8sutil.RunReplaceableJob(r.context.Clientset, job, true)
-			if err != nil {
-				logger.Errorf("run replaceable job failed err %v", err)
-			} else {
-				cleanjobs = append(cleanjobs, job)
-			}
-		}
-	}
-
-	if cluster.Spec.Storage.DeviceClasses != nil {
-		for _, ele := range cluster.Spec.Storage.DeviceClasses {
-			job, err := makeJob(r.context.Clientset, ele.NodeName, r.opConfig.Image)
-			if err != nil {
-				logger.Errorf("make job failed err %v", err)
-			}
-			ownerInfo.SetOwnerReference(job)
-			err = k8sutil.RunReplaceableJob(r.context.Clientset, job, true)
-			if err != nil {
-				logger.Errorf("run replaceable job failed err %v", err)
-			} else {
-				cleanjobs = append(cleanjobs, job)
-			}
+		ownerInfo.SetOwnerReference(job)
+		err = k8sutil.RunReplaceableJob(r.context.Clientset, job, true)
+		if err != nil {
+			logger.Errorf("run replaceable job failed err %v", err)
+		} else {
+			cleanjobs = append(cleanjobs, job)
 		}
 	}
 
@@ -80,9 +60,9 @@ func (r *TopolvmController) startCleanUpJobs(cluster *topolvmv2.TopolvmCluster)
 
 }
 
-func makeJob(clientset kubernetes.Interface, nodeName string, image string) (*batch.Job, error) {
+func makeJob(clientset kubernetes.Interface, cluster types.NamespacedName, nodeName string, image string) (*batch.Job, error) {
 
-	podSpec, err := provisionPodTemplateSpec(nodeName, image, v1.RestartPolicyNever)
+	podSpec, err := provisionPodTemplateSpec(cluster, nodeName, image, v1.RestartPolicyNever)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +77,7 @@ func makeJob(clientset kubernetes.Interface, nodeName string, image string) (*ba
 			// job validation introduced in v1.22 uses DNS regex which accepts only
 			// domain name but not subdomains, so truncating the name to always pass hash
 			Name:      truncateNodeNameForIndexJob(topolvm.CleanDeviceJobFmt, nodeName),
-			Namespace: topolvm.NameSpace,
+			Namespace: cluster.Namespace,
 		},
 	}
 
@@ -121,7 +101,7 @@ func makeJob(clientset kubernetes.Interface, nodeName string, image string) (*ba
 	return job, nil
 }
 
-func provisionPodTemplateSpec(nodeName string, image string, restart v1.RestartPolicy) (*v1.PodTemplateSpec, error) {
+func provisionPodTemplateSpec(cluster types.NamespacedName, nodeName string, image string, restart v1.RestartPolicy) (*v1.PodTemplateSpec, error) {
 
 	var volumes []v1.Volume
 	devVolume := v1.Volume{Name: "devices", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/dev"}}}
@@ -153,9 +133,9 @@ func provisionPodTemplateSpec(nodeName string, image string, restart v1.RestartP
 				VolumeMounts: volumeMount,
 				Env: []v1.EnvVar{
 					{Name: topolvm.NodeNameEnv, Value: nodeName},
-					{Name: topolvm.PodNameSpaceEnv, Value: topolvm.NameSpace},
+					{Name: topolvm.PodNameSpaceEnv, Value: cluster.Namespace},
 					{Name: topolvm.LogLevelEnv, Value: topolvm.CleanUpJobLogLevel},
-					{Name: topolvm.ClusterNameEnv, Value: topolvm.ClusterName},
+					{Name: topolvm.ClusterNameEnv, Value: cluster.Name},
 				},
 			},
 		},
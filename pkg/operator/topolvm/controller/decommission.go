@@ -0,0 +1,315 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
+	topolvmv1 "github.com/topolvm/topolvm/api/v1"
+	batch "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+var decommissionLogger = capnslog.NewPackageLogger("topolvm/operator", "node-decommission")
+
+// maxBlockingVolumes is the number of logical volumes named in the blocker
+// of a draining node
+const maxBlockingVolumes = 5
+
+// decommission tears down the nodes which have a lvmd configmap of the
+// cluster but are no longer owned by it. New volumes stop being provisioned
+// on such a node, and once its logical volumes are gone its node plugin is
+// removed, its volume groups are wiped if the policy says so and its lvmd
+// configmap is removed. It tells whether nodes are still being torn down.
+func (r *rolloutController) decommission() (bool, error) {
+
+	c := r.clusterController
+	topolvmCluster := c.getCluster()
+	if topolvmCluster == nil || c.getRef() == nil {
+		return false, nil
+	}
+	ctx := c.ctx
+	namespace := c.namespacedName.Namespace
+	cms, err := c.context.Clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: lvmdConfigMapSelector(c.namespacedName.Name)})
+	if err != nil {
+		return true, errors.Wrap(err, "failed to list lvmd configmap")
+	}
+	owned, _, err := c.claimedNodes()
+	if err != nil {
+		return true, errors.Wrap(err, "get nodes of cluster failed")
+	}
+	owns := make(map[string]bool)
+	for _, node := range owned {
+		owns[node] = true
+	}
+
+	var leaving []*v1.ConfigMap
+	for index := range cms.Items {
+		cm := &cms.Items[index]
+		node := getNodeName(cm)
+		if node == "" {
+			continue
+		}
+		_, marked := cm.Annotations[topolvm.DecommissionAnnotation]
+		if !owns[node] {
+			leaving = append(leaving, cm)
+			continue
+		}
+		if marked {
+			// the node is claimed again before it was torn down
+			decommissionLogger.Infof("node %s is back in cluster %s, stop decommissioning it", node, c.namespacedName.Name)
+			if err := labelDecommissioning(ctx, c.context.Clientset, node, ""); err != nil {
+				return true, err
+			}
+			delete(cm.Annotations, topolvm.DecommissionAnnotation)
+			if _, err := c.context.Clientset.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+				return true, errors.Wrapf(err, "failed to update lvmd configmap %s", cm.Name)
+			}
+		}
+	}
+	if len(leaving) == 0 {
+		c.updateDecommissioning(nil)
+		return false, r.updateDecommissionStatus(nil, nil)
+	}
+
+	lvs := &topolvmv1.LogicalVolumeList{}
+	if err := c.context.Client.List(ctx, lvs); err != nil {
+		return true, errors.Wrap(err, "failed to list logical volumes")
+	}
+	var states []topolvmv2.NodeDecommission
+	var removed, decommissioning []string
+	for _, cm := range leaving {
+		state, err := r.decommissionNode(topolvmCluster.Spec.Decommission, cm, lvs.Items)
+		if err != nil {
+			return true, err
+		}
+		if state == nil {
+			removed = append(removed, getNodeName(cm))
+			continue
+		}
+		states = append(states, *state)
+		decommissioning = append(decommissioning, state.Node)
+	}
+	c.updateDecommissioning(decommissioning)
+	if err := r.updateDecommissionStatus(states, removed); err != nil {
+		return true, err
+	}
+	return len(states) > 0, nil
+}
+
+// decommissionNode makes the next step of tearing a node down, it returns
+// the state of the node or nil once it is torn down.
+func (r *rolloutController) decommissionNode(policy *topolvmv2.DecommissionPolicy, cm *v1.ConfigMap, lvs []topolvmv1.LogicalVolume) (*topolvmv2.NodeDecommission, error) {
+
+	c := r.clusterController
+	ctx := c.ctx
+	namespace := c.namespacedName.Namespace
+	node := getNodeName(cm)
+	state := &topolvmv2.NodeDecommission{Node: node, Phase: topolvmv2.DecommissionDraining}
+
+	if _, ok := cm.Annotations[topolvm.DecommissionAnnotation]; !ok {
+		decommissionLogger.Infof("node %s left cluster %s, decommission it", node, c.namespacedName.Name)
+		if cm.Annotations == nil {
+			cm.Annotations = make(map[string]string)
+		}
+		cm.Annotations[topolvm.DecommissionAnnotation] = time.Now().Format(time.RFC3339)
+		if _, err := c.context.Clientset.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			return nil, errors.Wrapf(err, "failed to update lvmd configmap %s", cm.Name)
+		}
+	}
+
+	// the node plugin puts the capacity back whenever it starts or its
+	// logical volumes change, the webhook drops it from labeled nodes
+	if err := labelDecommissioning(ctx, c.context.Clientset, node, c.namespacedName.Name); err != nil {
+		return nil, err
+	}
+	if err := RemoveNodeCapacityAnnotations(c.context.Clientset, node); err != nil {
+		return nil, errors.Wrapf(err, "failed to remove capacity annotations of node %s", node)
+	}
+	var names []string
+	for _, lv := range lvs {
+		if lv.Spec.NodeName == node {
+			names = append(names, lv.Name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		state.LogicalVolumes = int32(len(names))
+		blocker := fmt.Sprintf("logical volumes %s are left", strings.Join(names, ","))
+		if len(names) > maxBlockingVolumes {
+			blocker = fmt.Sprintf("logical volumes %s and %d more are left", strings.Join(names[:maxBlockingVolumes], ","), len(names)-maxBlockingVolumes)
+		}
+		state.Blockers = append(state.Blockers, blocker)
+		return state, nil
+	}
+
+	// lvmd must be gone before its volume groups are
+	state.Phase = topolvmv2.DecommissionRemovingNodePlugin
+	deployment := k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, node)
+	if err := k8sutil.DeleteDeployment(ctx, c.context.Clientset, namespace, deployment); err != nil && !kerrors.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "failed to delete deployment %s", deployment)
+	}
+
+	jobName := truncateNodeNameForIndexJob(topolvm.CleanDeviceJobFmt, node)
+	if policy != nil && policy.WipeDevices {
+		state.Phase = topolvmv2.DecommissionWipingDevices
+		done, blocker, err := r.wipeDevices(node, jobName)
+		if err != nil {
+			return nil, err
+		}
+		if blocker != "" {
+			state.Blockers = append(state.Blockers, blocker)
+		}
+		if !done {
+			return state, nil
+		}
+	}
+
+	// the node plugin is gone, the label goes before the configmap so it is
+	// not left behind for the next owner of the node
+	if err := labelDecommissioning(ctx, c.context.Clientset, node, ""); err != nil {
+		return nil, err
+	}
+	if err := c.context.Clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, cm.Name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "failed to delete lvmd configmap %s", cm.Name)
+	}
+	if err := RemoveNodeCapacityAnnotations(c.context.Clientset, node); err != nil {
+		return nil, errors.Wrapf(err, "failed to remove capacity annotations of node %s", node)
+	}
+	if err := k8sutil.DeleteBatchJob(c.context.Clientset, namespace, jobName, false); err != nil {
+		return nil, err
+	}
+	decommissionLogger.Infof("node %s is decommissioned", node)
+	return nil, nil
+}
+
+// labelDecommissioning labels the node with the cluster decommissioning it,
+// an empty cluster removes the label. A node which is gone is left alone.
+func labelDecommissioning(ctx context.Context, clientset kubernetes.Interface, node, cluster string) error {
+	var value interface{}
+	if cluster != "" {
+		value = cluster
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{topolvm.DecommissionLabel: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := clientset.CoreV1().Nodes().Patch(ctx, node, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil && !kerrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to label node %s", node)
+	}
+	return nil
+}
+
+// unlabelDecommissioning removes the label of the nodes the cluster was
+// decommissioning, e.g. when it is deleted.
+func unlabelDecommissioning(ctx context.Context, clientset kubernetes.Interface, cluster string) error {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", topolvm.DecommissionLabel, cluster)})
+	if err != nil {
+		return errors.Wrap(err, "failed to list decommissioning nodes")
+	}
+	for _, node := range nodes.Items {
+		if err := labelDecommissioning(ctx, clientset, node.Name, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipeDevices runs the clean job of the node, it tells whether the devices
+// are wiped and why not if the job failed. A node which is gone has nothing
+// to wipe.
+func (r *rolloutController) wipeDevices(node, jobName string) (bool, string, error) {
+
+	c := r.clusterController
+	ctx := c.ctx
+	namespace := c.namespacedName.Namespace
+	if _, err := c.context.Clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{}); err != nil {
+		if kerrors.IsNotFound(err) {
+			decommissionLogger.Infof("node %s is gone, skip wiping its devices", node)
+			return true, "", nil
+		}
+		return false, "", errors.Wrapf(err, "failed to get node %s", node)
+	}
+
+	job, err := c.context.Clientset.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		job, err = makeJob(c.context.Clientset, c.namespacedName, node, c.opConfig.Image)
+		if err != nil {
+			return false, "", errors.Wrapf(err, "failed to make clean job of node %s", node)
+		}
+		job.OwnerReferences = []metav1.OwnerReference{*c.getRef()}
+		decommissionLogger.Infof("wipe devices of node %s", node)
+		if _, err := c.context.Clientset.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+			return false, "", errors.Wrapf(err, "failed to create job %s", jobName)
+		}
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", errors.Wrapf(err, "failed to get job %s", jobName)
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != v1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batch.JobComplete:
+			return true, "", nil
+		case batch.JobFailed:
+			return false, fmt.Sprintf("job %s failed: %s, delete it to retry", jobName, condition.Message), nil
+		}
+	}
+	return false, "", nil
+}
+
+// updateDecommissionStatus reports the nodes being torn down, and drops the
+// nodes removed from the status of the cluster.
+func (r *rolloutController) updateDecommissionStatus(states []topolvmv2.NodeDecommission, removed []string) error {
+	c := r.clusterController
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+	topolvmCluster := &topolvmv2.TopolvmCluster{}
+	err := c.context.Client.Get(c.ctx, c.namespacedName, topolvmCluster)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to retrieve topolvm cluster %q to update decommission status", c.namespacedName.Name)
+	}
+	status := topolvmCluster.Status.DeepCopy()
+	status.DecommissioningNodes = states
+	if len(removed) > 0 {
+		gone := make(map[string]bool)
+		for _, node := range removed {
+			gone[node] = true
+		}
+		nodes := make([]topolvmv2.NodeStorageState, 0, len(status.NodeStorageStatus))
+		for _, n := range status.NodeStorageStatus {
+			if !gone[n.Node] {
+				nodes = append(nodes, n)
+			}
+		}
+		status.NodeStorageStatus = nodes
+	}
+	if reflect.DeepEqual(topolvmCluster.Status, *status) {
+		return nil
+	}
+	topolvmCluster.Status = *status
+	return k8sutil.UpdateStatus(c.context.Client, topolvmCluster)
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/stretchr/testify/assert"
+	topolvmv1 "github.com/topolvm/topolvm/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// claimingCluster is a cluster named name created at the time, claiming the
+// nodes.
+func claimingCluster(name string, created time.Time, nodes ...string) *topolvmv2.TopolvmCluster {
+	topolvmCluster := &topolvmv2.TopolvmCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, CreationTimestamp: metav1.NewTime(created)},
+	}
+	for _, node := range nodes {
+		topolvmCluster.Spec.DeviceClasses = append(topolvmCluster.Spec.DeviceClasses, topolvmv2.NodeDevices{NodeName: node})
+		topolvmCluster.Status.NodeStorageStatus = append(topolvmCluster.Status.NodeStorageStatus, topolvmv2.NodeStorageState{Node: node})
+	}
+	return topolvmCluster
+}
+
+// clusterConfigMap is the lvmd configmap of the node in the cluster, marked
+// for decommission when decommissioning is set.
+func clusterConfigMap(cluster, node string, decommissioning bool) *corev1.ConfigMap {
+	cm := lvmdConfigMap(node)
+	cm.Labels[topolvm.ClusterAttr] = cluster
+	if decommissioning {
+		cm.Annotations[topolvm.DecommissionAnnotation] = time.Now().Format(time.RFC3339)
+	}
+	return cm
+}
+
+func testNode(name string, decommissionedBy string) *corev1.Node {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Annotations: map[string]string{topolvm.CapacityKeyPrefix + "hdd": "1073741824"},
+	}}
+	if decommissionedBy != "" {
+		node.Labels = map[string]string{topolvm.DecommissionLabel: decommissionedBy}
+	}
+	return node
+}
+
+func testLogicalVolume(name, node string) *topolvmv1.LogicalVolume {
+	return &topolvmv1.LogicalVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       topolvmv1.LogicalVolumeSpec{Name: name, NodeName: node},
+	}
+}
+
+func cleanJob(node string, condition batch.JobConditionType) *batch.Job {
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: truncateNodeNameForIndexJob(topolvm.CleanDeviceJobFmt, node), Namespace: testNamespace}}
+	if condition != "" {
+		job.Status.Conditions = []batch.JobCondition{{Type: condition, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+	}
+	return job
+}
+
+func nodePluginOf(node string) *appsv1.Deployment {
+	return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, node), Namespace: testNamespace}}
+}
+
+func TestDecommission(t *testing.T) {
+	wipe := &topolvmv2.DecommissionPolicy{WipeDevices: true}
+
+	tests := []struct {
+		name    string
+		claimed []string
+		policy  *topolvmv2.DecommissionPolicy
+		objects []runtime.Object
+		pending bool
+		// state is the decommission state of node2 after the step, nil
+		// once it is not decommissioned
+		state *topolvmv2.NodeDecommission
+		// label is the decommission label of node2 after the step
+		label      string
+		marked     bool
+		configMap  bool
+		nodePlugin bool
+		job        bool
+	}{
+		{
+			name:    "a node leaving is cordoned and drains",
+			claimed: []string{"node1"},
+			objects: []runtime.Object{testNode("node2", ""), clusterConfigMap("cluster", "node2", false), nodePluginOf("node2"),
+				testLogicalVolume("pvc-1", "node2"), testLogicalVolume("pvc-2", "node1")},
+			pending: true,
+			state: &topolvmv2.NodeDecommission{Node: "node2", Phase: topolvmv2.DecommissionDraining, LogicalVolumes: 1,
+				Blockers: []string{"logical volumes pvc-1 are left"}},
+			label:      "cluster",
+			marked:     true,
+			configMap:  true,
+			nodePlugin: true,
+		},
+		{
+			name:      "a drained node loses its node plugin before its devices are wiped",
+			claimed:   []string{"node1"},
+			policy:    wipe,
+			objects:   []runtime.Object{testNode("node2", "cluster"), clusterConfigMap("cluster", "node2", true), nodePluginOf("node2")},
+			pending:   true,
+			state:     &topolvmv2.NodeDecommission{Node: "node2", Phase: topolvmv2.DecommissionWipingDevices},
+			label:     "cluster",
+			marked:    true,
+			configMap: true,
+			job:       true,
+		},
+		{
+			name:    "a failed wipe job blocks the node",
+			claimed: []string{"node1"},
+			policy:  wipe,
+			objects: []runtime.Object{testNode("node2", "cluster"), clusterConfigMap("cluster", "node2", true), cleanJob("node2", batch.JobFailed)},
+			pending: true,
+			state: &topolvmv2.NodeDecommission{Node: "node2", Phase: topolvmv2.DecommissionWipingDevices,
+				Blockers: []string{"job " + truncateNodeNameForIndexJob(topolvm.CleanDeviceJobFmt, "node2") + " failed: BackoffLimitExceeded, delete it to retry"}},
+			label:     "cluster",
+			marked:    true,
+			configMap: true,
+			job:       true,
+		},
+		{
+			name:    "a wiped node is removed",
+			claimed: []string{"node1"},
+			policy:  wipe,
+			objects: []runtime.Object{testNode("node2", "cluster"), clusterConfigMap("cluster", "node2", true), cleanJob("node2", batch.JobComplete)},
+		},
+		{
+			name:    "a node which is gone is not wiped",
+			claimed: []string{"node1"},
+			policy:  wipe,
+			objects: []runtime.Object{clusterConfigMap("cluster", "node2", true), nodePluginOf("node2")},
+		},
+		{
+			name:    "without wiping the configmap goes along with the node plugin",
+			claimed: []string{"node1"},
+			objects: []runtime.Object{testNode("node2", "cluster"), clusterConfigMap("cluster", "node2", true), nodePluginOf("node2")},
+		},
+		{
+			name:    "a node claimed again is kept",
+			claimed: []string{"node1", "node2"},
+			objects: []runtime.Object{testNode("node2", "cluster"), clusterConfigMap("cluster", "node2", true), nodePluginOf("node2"),
+				testLogicalVolume("pvc-1", "node2")},
+			configMap:  true,
+			nodePlugin: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topolvmCluster := claimingCluster("cluster", time.Now(), "node1", "node2")
+			topolvmCluster.Spec.DeviceClasses = nil
+			for _, node := range tt.claimed {
+				topolvmCluster.Spec.DeviceClasses = append(topolvmCluster.Spec.DeviceClasses, topolvmv2.NodeDevices{NodeName: node})
+			}
+			topolvmCluster.Spec.Decommission = tt.policy
+			objects := append([]runtime.Object{testNode("node1", ""), clusterConfigMap("cluster", "node1", false)}, tt.objects...)
+			c := newTestClusterController(t, topolvmCluster, objects...)
+			r := newRolloutController(c)
+			ctx := context.TODO()
+
+			pending, err := r.decommission()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.pending, pending)
+
+			current := &topolvmv2.TopolvmCluster{}
+			assert.NoError(t, c.context.Client.Get(ctx, c.namespacedName, current))
+			if tt.state == nil {
+				assert.Empty(t, current.Status.DecommissioningNodes)
+			} else {
+				assert.Equal(t, []topolvmv2.NodeDecommission{*tt.state}, current.Status.DecommissioningNodes)
+			}
+			var nodes []string
+			for _, n := range current.Status.NodeStorageStatus {
+				nodes = append(nodes, n.Node)
+			}
+			if tt.configMap {
+				assert.Equal(t, []string{"node1", "node2"}, nodes)
+			} else {
+				assert.Equal(t, []string{"node1"}, nodes)
+			}
+
+			cm, err := c.context.Clientset.CoreV1().ConfigMaps(testNamespace).Get(ctx, k8sutil.TruncateNodeName(topolvm.LvmdConfigMapFmt, "node2"), metav1.GetOptions{})
+			assert.Equal(t, tt.configMap, err == nil)
+			if err == nil {
+				_, marked := cm.Annotations[topolvm.DecommissionAnnotation]
+				assert.Equal(t, tt.marked, marked)
+			}
+			_, err = c.context.Clientset.AppsV1().Deployments(testNamespace).Get(ctx, k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, "node2"), metav1.GetOptions{})
+			assert.Equal(t, tt.nodePlugin, err == nil)
+			_, err = c.context.Clientset.BatchV1().Jobs(testNamespace).Get(ctx, truncateNodeNameForIndexJob(topolvm.CleanDeviceJobFmt, "node2"), metav1.GetOptions{})
+			assert.Equal(t, tt.job, err == nil)
+
+			node, err := c.context.Clientset.CoreV1().Nodes().Get(ctx, "node2", metav1.GetOptions{})
+			if kerrors.IsNotFound(err) {
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.label, node.Labels[topolvm.DecommissionLabel])
+			if tt.label != "" || !tt.configMap {
+				assert.NotContains(t, node.Annotations, topolvm.CapacityKeyPrefix+"hdd")
+			}
+		})
+	}
+}
+
+// TestDecommissionOrder follows a node through its decommission: it drains
+// first, then its node plugin is removed, its devices are wiped and at last
+// its lvmd configmap is removed.
+func TestDecommissionOrder(t *testing.T) {
+	topolvmCluster := claimingCluster("cluster", time.Now(), "node1", "node2")
+	topolvmCluster.Spec.DeviceClasses = topolvmCluster.Spec.DeviceClasses[:1]
+	topolvmCluster.Spec.Decommission = &topolvmv2.DecommissionPolicy{WipeDevices: true}
+	c := newTestClusterController(t, topolvmCluster, testNode("node1", ""), testNode("node2", ""),
+		clusterConfigMap("cluster", "node1", false), clusterConfigMap("cluster", "node2", false), nodePluginOf("node2"),
+		testLogicalVolume("pvc-1", "node2"))
+	r := newRolloutController(c)
+	ctx := context.TODO()
+	jobName := truncateNodeNameForIndexJob(topolvm.CleanDeviceJobFmt, "node2")
+	cmName := k8sutil.TruncateNodeName(topolvm.LvmdConfigMapFmt, "node2")
+	phase := func() topolvmv2.DecommissionPhase {
+		current := &topolvmv2.TopolvmCluster{}
+		assert.NoError(t, c.context.Client.Get(ctx, c.namespacedName, current))
+		if len(current.Status.DecommissioningNodes) == 0 {
+			return ""
+		}
+		return current.Status.DecommissioningNodes[0].Phase
+	}
+
+	// the node plugin stays while volumes are left
+	for i := 0; i < 2; i++ {
+		pending, err := r.decommission()
+		assert.NoError(t, err)
+		assert.True(t, pending)
+		assert.Equal(t, topolvmv2.DecommissionDraining, phase())
+		_, err = c.context.Clientset.AppsV1().Deployments(testNamespace).Get(ctx, k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, "node2"), metav1.GetOptions{})
+		assert.NoError(t, err)
+	}
+	assert.True(t, c.isDecommissioning("node2"))
+
+	assert.NoError(t, c.context.Client.Delete(ctx, testLogicalVolume("pvc-1", "node2")))
+	pending, err := r.decommission()
+	assert.NoError(t, err)
+	assert.True(t, pending)
+	assert.Equal(t, topolvmv2.DecommissionWipingDevices, phase())
+	_, err = c.context.Clientset.AppsV1().Deployments(testNamespace).Get(ctx, k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, "node2"), metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	_, err = c.context.Clientset.CoreV1().ConfigMaps(testNamespace).Get(ctx, cmName, metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	job, err := c.context.Clientset.BatchV1().Jobs(testNamespace).Get(ctx, jobName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	job.Status.Conditions = []batch.JobCondition{{Type: batch.JobComplete, Status: corev1.ConditionTrue}}
+	_, err = c.context.Clientset.BatchV1().Jobs(testNamespace).UpdateStatus(ctx, job, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	pending, err = r.decommission()
+	assert.NoError(t, err)
+	assert.False(t, pending)
+	assert.Equal(t, topolvmv2.DecommissionPhase(""), phase())
+	_, err = c.context.Clientset.CoreV1().ConfigMaps(testNamespace).Get(ctx, cmName, metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	_, err = c.context.Clientset.BatchV1().Jobs(testNamespace).Get(ctx, jobName, metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	node, err := c.context.Clientset.CoreV1().Nodes().Get(ctx, "node2", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, node.Labels, topolvm.DecommissionLabel)
+	assert.False(t, c.isDecommissioning("node2"))
+}
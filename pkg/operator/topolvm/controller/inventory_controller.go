@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+
+	rawv1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+var inventoryLogger = capnslog.NewPackageLogger("topolvm/operator", "device-inventory")
+
+type inventoryController struct {
+	clusterController   *clusterController
+	inventoryController cache.Controller
+}
+
+func newInventoryController(clusterController *clusterController) *inventoryController {
+
+	inventory := &inventoryController{
+		clusterController: clusterController,
+	}
+
+	_, inventory.inventoryController = cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return clusterController.context.RawDeviceClientset.RawdeviceV1().NodeDeviceInventories().List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return clusterController.context.RawDeviceClientset.RawdeviceV1().NodeDeviceInventories().Watch(context.TODO(), options)
+			},
+		}, &rawv1.NodeDeviceInventory{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: inventory.onUpdate,
+		},
+	)
+
+	return inventory
+}
+
+func (i *inventoryController) start() {
+	go i.inventoryController.Run(i.clusterController.ctx.Done())
+}
+
+func (i *inventoryController) onUpdate(oldObj, newObj interface{}) {
+
+	oldInventory, err := getInventoryObject(oldObj)
+	if err != nil {
+		inventoryLogger.Errorf("failed to get old inventory object. %v", err)
+		return
+	}
+
+	newInventory, err := getInventoryObject(newObj)
+	if err != nil {
+		inventoryLogger.Errorf("failed to get new inventory object. %v", err)
+		return
+	}
+
+	// the first status written by discovery is picked up by the job started
+	// with the cluster, only later changes need a new job. Node classes wait
+	// for the first status instead, it comes in after a node joined
+	if oldInventory.Status.LastUpdateTime == nil {
+		if newInventory.Status.LastUpdateTime == nil || !i.clusterController.usesNodeClasses() {
+			return
+		}
+	} else if !devicesChanged(oldInventory.Status.Devices, newInventory.Status.Devices) {
+		return
+	}
+	if !i.clusterController.selectsDevicesOf(newInventory.Spec.NodeName) {
+		return
+	}
+
+	nodeName := newInventory.Spec.NodeName
+	inventoryLogger.Infof("devices of node %s changed, restart volume group job", nodeName)
+	if err := i.clusterController.RestartJob(nodeName, i.clusterController.getRef()); err != nil {
+		inventoryLogger.Errorf("restart job of node %s failed %v", nodeName, err)
+	}
+}
+
+// devicesChanged compares the devices leaving their health and multipath path
+// states out, they change without changing the volume groups.
+func devicesChanged(oldDevices, newDevices []rawv1.InventoryDevice) bool {
+	if len(oldDevices) != len(newDevices) {
+		return true
+	}
+	for i := range oldDevices {
+		o, n := oldDevices[i], newDevices[i]
+		o.Health, n.Health = nil, nil
+		o.Multipath, n.Multipath = nil, nil
+		if !reflect.DeepEqual(o, n) {
+			return true
+		}
+	}
+	return false
+}
+
+func getInventoryObject(obj interface{}) (*rawv1.NodeDeviceInventory, error) {
+
+	inventory, ok := obj.(*rawv1.NodeDeviceInventory)
+	if ok {
+		return inventory.DeepCopy(), nil
+	}
+	return nil, errors.Errorf("not a known device inventory: %+v", obj)
+}
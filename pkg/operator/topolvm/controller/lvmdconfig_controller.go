@@ -1,11 +1,12 @@
 package controller
 
 import (
-	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
+	"path/filepath"
+
 	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
-	"github.com/alauda/nativestor/pkg/cluster"
 	"github.com/alauda/nativestor/pkg/cluster/topolvm"
 	"github.com/alauda/nativestor/pkg/operator"
 	"github.com/alauda/nativestor/pkg/operator/csi"
@@ -13,6 +14,7 @@ import (
 	csitopo "github.com/alauda/nativestor/pkg/operator/topolvm/csi"
 	"github.com/coreos/pkg/capnslog"
 	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -23,22 +25,22 @@ import (
 var lvmdLogger = capnslog.NewPackageLogger("topolvm/operator", "lvmd-config")
 
 type lvmdConfigController struct {
-	topolvmController *TopolvmController
+	clusterController *clusterController
 	lvmdController    cache.Controller
 }
 
-func newLvmdController(topolvmController *TopolvmController) *lvmdConfigController {
+func newLvmdController(clusterController *clusterController) *lvmdConfigController {
 
 	lvmd := &lvmdConfigController{
-		topolvmController: topolvmController,
+		clusterController: clusterController,
 	}
 
 	_, lvmd.lvmdController = cache.NewInformer(
-		cache.NewFilteredListWatchFromClient(topolvmController.context.Clientset.CoreV1().RESTClient(),
+		cache.NewFilteredListWatchFromClient(clusterController.context.Clientset.CoreV1().RESTClient(),
 			string(v1.ResourceConfigMaps),
-			topolvmController.opConfig.OperatorNamespace,
+			clusterController.namespacedName.Namespace,
 			func(options *metav1.ListOptions) {
-				options.LabelSelector = fmt.Sprintf("%s=%s", topolvm.LvmdConfigMapLabelKey, topolvm.LvmdConfigMapLabelValue)
+				options.LabelSelector = lvmdConfigMapSelector(clusterController.namespacedName.Name)
 			}), &v1.ConfigMap{},
 		0,
 		cache.ResourceEventHandlerFuncs{
@@ -52,7 +54,7 @@ func newLvmdController(topolvmController *TopolvmController) *lvmdConfigControll
 }
 
 func (l *lvmdConfigController) start() {
-	go l.lvmdController.Run(l.topolvmController.opManagerContext.Done())
+	go l.lvmdController.Run(l.clusterController.ctx.Done())
 }
 
 func (l *lvmdConfigController) onAdd(obj interface{}) {
@@ -66,36 +68,8 @@ func (l *lvmdConfigController) onAdd(obj interface{}) {
 
 	l.updateClusterStatus(cm)
 
-	err = l.startTopolvmNodePlugin(cm)
-	if err != nil {
-		lvmdLogger.Errorf("failed to start topolvm node plugin %v", err)
-	}
-
-}
-
-func (l *lvmdConfigController) startTopolvmNodePlugin(configMap *v1.ConfigMap) error {
-
-	if _, ok := configMap.Data[topolvm.LvmdConfigMapKey]; !ok {
-		return nil
-	}
-
-	nodeName := getNodeName(configMap)
-	if nodeName == "" {
-		lvmdLogger.Error("can not get node name")
-		return nil
-	}
-
-	if l.checkingTopolvmNodePluginDeploymentExisting(nodeName) {
-		return nil
-	}
-
-	err := l.createTopolvmPluginNodeDeployment(nodeName)
-	if err != nil {
-
-		return err
-	}
-
-	return nil
+	// the node plugin is created by the rollout
+	l.clusterController.rolloutController.enqueue()
 }
 
 func (l *lvmdConfigController) onUpdate(oldObj, newobj interface{}) {
@@ -123,60 +97,22 @@ func (l *lvmdConfigController) onUpdate(oldObj, newobj interface{}) {
 		return
 	}
 
-	if _, ok := oldCm.Data[topolvm.LocalDiskCMData]; ok {
-		if oldCm.Data[topolvm.LocalDiskCMData] != newCm.Data[topolvm.LocalDiskCMData] && l.topolvmController.UseAllNodeAndDevices() {
-			l.topolvmController.RestartJob(nodeName, l.topolvmController.getRef())
-		}
-	}
-
 	if _, ok := newCm.Data[topolvm.LvmdConfigMapKey]; !ok {
 		lvmdLogger.Errorf("node %s all volume groups are not available", nodeName)
 		return
 	}
 
-	if l.checkingTopolvmNodePluginDeploymentExisting(nodeName) {
-		if oldCm.Data[topolvm.LvmdConfigMapKey] == newCm.Data[topolvm.LvmdConfigMapKey] {
-			lvmdLogger.Infof("cm%s  update but data not change no need to update node deployment", oldCm.ObjectMeta.Name)
-			return
-		}
-		replaceNodePod(l.topolvmController.context, nodeName)
-
-	} else {
-
-		err = l.createTopolvmPluginNodeDeployment(nodeName)
-		if err != nil {
-			lvmdLogger.Errorf("create topolvm plugin failed %v", err)
-		}
+	// the lvcache sidecar comes and goes with the cached classes
+	if oldCm.Data[topolvm.LvmdConfigMapKey] == newCm.Data[topolvm.LvmdConfigMapKey] && hasCacheClasses(oldCm) == hasCacheClasses(newCm) {
+		lvmdLogger.Debugf("cm %s update but data not change no need to update node deployment", oldCm.ObjectMeta.Name)
+		return
 	}
+	l.clusterController.rolloutController.enqueue()
 }
 
 func (l *lvmdConfigController) onDelete(obj interface{}) {
 	// nothing
 }
-func (l *lvmdConfigController) checkingTopolvmNodePluginDeploymentExisting(nodeName string) bool {
-
-	deploymentName := k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, nodeName)
-	existing, err := k8sutil.CheckDeploymentIsExisting(l.topolvmController.opManagerContext, l.topolvmController.context.Clientset, deploymentName, l.topolvmController.namespacedName.Namespace)
-	if err != nil {
-		return false
-	}
-	return existing
-}
-
-func replaceNodePod(contextd *cluster.Context, nodeName string) {
-
-	deploymentName := k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, nodeName)
-	ctx := context.TODO()
-	pods, err := contextd.Clientset.CoreV1().Pods(topolvm.NameSpace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", topolvm.AppAttr, deploymentName)})
-	if err != nil {
-		lvmdLogger.Errorf("list pod with label %s failed %v", deploymentName, err)
-	}
-	for _, val := range pods.Items {
-		if err := contextd.Clientset.CoreV1().Pods(topolvm.NameSpace).Delete(ctx, val.ObjectMeta.Name, metav1.DeleteOptions{}); err != nil {
-			lvmdLogger.Errorf("delete pod %s failed err %v", val.ObjectMeta.Name, err)
-		}
-	}
-}
 
 func getNodeName(cm *v1.ConfigMap) string {
 
@@ -211,7 +147,7 @@ func (l *lvmdConfigController) updateClusterStatus(cm *v1.ConfigMap) {
 		lvmdLogger.Errorf("unmarshal node status failed err %v", err)
 		return
 	}
-	if err := l.topolvmController.UpdateStatus(nodeStatus); err != nil {
+	if err := l.clusterController.UpdateStatus(nodeStatus); err != nil {
 		lvmdLogger.Errorf("update status failed err %v", err)
 	}
 }
@@ -229,7 +165,7 @@ func (l *lvmdConfigController) checkUpdateClusterStatus(old, new *v1.ConfigMap)
 			lvmdLogger.Errorf("unmarshal node status failed err %v", err)
 			return err
 		}
-		if err := l.topolvmController.UpdateStatus(nodeStatus); err != nil {
+		if err := l.clusterController.UpdateStatus(nodeStatus); err != nil {
 			return errors.Wrapf(err, "update node %s status failed", nodeStatus.Node)
 		}
 	}
@@ -237,58 +173,133 @@ func (l *lvmdConfigController) checkUpdateClusterStatus(old, new *v1.ConfigMap)
 
 }
 
-func (l *lvmdConfigController) createTopolvmPluginNodeDeployment(node string) error {
+func hasCacheClasses(cm *v1.ConfigMap) bool {
+	_, ok := cm.Data[topolvm.LvmdCacheConfigMapKey]
+	return ok
+}
+
+// lvcacheContainer caches the volumes of the cached classes, it runs lvm on
+// the host like the volume group job.
+func lvcacheContainer(image string) v1.Container {
+	privileged := true
+	runAsUser := int64(0)
+	return v1.Container{
+		Name:    topolvm.LvcacheContainerName,
+		Image:   image,
+		Command: []string{"/topolvm", "lvcache", "--config=" + topolvm.LvcacheConfigPath},
+		Ports: []v1.ContainerPort{
+			{Name: "lvcache-metrics", ContainerPort: topolvm.LvcacheMetricsPort, Protocol: v1.ProtocolTCP},
+		},
+		SecurityContext: &v1.SecurityContext{
+			Privileged: &privileged,
+			RunAsUser:  &runAsUser,
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{Name: "lvmd-config-dir", MountPath: filepath.Dir(topolvm.LvcacheConfigPath), ReadOnly: true},
+		},
+	}
+}
 
-	opNamespaceName := types.NamespacedName{Name: operator.OperatorSettingConfigMapName, Namespace: l.topolvmController.opConfig.OperatorNamespace}
+// loadOperatorSetting reads the operator setting into the operator config.
+func (c *clusterController) loadOperatorSetting() error {
+	opNamespaceName := types.NamespacedName{Name: operator.OperatorSettingConfigMapName, Namespace: c.opConfig.OperatorNamespace}
 	opConfig := &v1.ConfigMap{}
-	err := l.topolvmController.client.Get(l.topolvmController.opManagerContext, opNamespaceName, opConfig)
+	err := c.client.Get(c.ctx, opNamespaceName, opConfig)
 	if err != nil {
 		if kerrors.IsNotFound(err) {
 			logger.Debug("operator's configmap resource not found. will use default value or env var.")
-			l.topolvmController.opConfig.Parameters = make(map[string]string)
+			c.opConfig.Parameters = make(map[string]string)
 		} else {
 			// Error reading the object - requeue the request.
 			return errors.Wrap(err, "failed to get operator's configmap")
 		}
 	} else {
 		// Populate the operator's config
-		l.topolvmController.opConfig.Parameters = opConfig.Data
+		c.opConfig.Parameters = opConfig.Data
+	}
+	return nil
+}
+
+// nodeTopolvmImage is the topolvm image the node plugins of the cluster run,
+// clusters may run their nodes on a topolvm version of their own.
+func (c *clusterController) nodeTopolvmImage(topolvmCluster *topolvmv2.TopolvmCluster) string {
+	if topolvmCluster != nil && topolvmCluster.Spec.TopolvmVersion != "" {
+		return topolvmCluster.Spec.TopolvmVersion
+	}
+	return k8sutil.GetValue(c.opConfig.Parameters, "TOPOLVM_IMAGE", csitopo.DefaultTopolvmImage)
+}
+
+// nodePluginTemplate is the node plugin deployment of the cluster before it
+// is made for a node.
+func (c *clusterController) nodePluginTemplate() (*appsv1.Deployment, error) {
+
+	if err := c.loadOperatorSetting(); err != nil {
+		return nil, err
 	}
 
 	param := csi.Param{}
-	param.TopolvmImage = k8sutil.GetValue(l.topolvmController.opConfig.Parameters, "TOPOLVM_IMAGE", csitopo.DefaultTopolvmImage)
-	param.RegistrarImage = k8sutil.GetValue(l.topolvmController.opConfig.Parameters, "CSI_REGISTRAR_IMAGE", csi.DefaultRegistrarImage)
-	param.LivenessImage = k8sutil.GetValue(l.topolvmController.opConfig.Parameters, "CSI_LIVENESS_IMAGE", csi.DefaultLivenessImage)
-	param.KubeletDirPath = k8sutil.GetValue(l.topolvmController.opConfig.Parameters, "KUBELET_ROOT_DIR", csi.DefaultKubeletDir)
+	param.TopolvmImage = c.nodeTopolvmImage(c.getCluster())
+	param.RegistrarImage = k8sutil.GetValue(c.opConfig.Parameters, "CSI_REGISTRAR_IMAGE", csi.DefaultRegistrarImage)
+	param.LivenessImage = k8sutil.GetValue(c.opConfig.Parameters, "CSI_LIVENESS_IMAGE", csi.DefaultLivenessImage)
+	param.KubeletDirPath = k8sutil.GetValue(c.opConfig.Parameters, "KUBELET_ROOT_DIR", csi.DefaultKubeletDir)
 
 	tp := csi.TemplateParam{
 		Param:     param,
-		Namespace: l.topolvmController.opConfig.OperatorNamespace,
+		Namespace: c.opConfig.OperatorNamespace,
 	}
 
 	topolvmPlugin, err := csi.TemplateToDeployment("topolvm-plugin", csitopo.CSITopolvmPluginTemplatePath, tp)
 	if err != nil {
-		return errors.Wrap(err, "failed to load topolvm provisioner deployment template")
+		return nil, errors.Wrap(err, "failed to load topolvm provisioner deployment template")
 	}
 
-	topolvmPluginTolerations := csi.GetToleration(l.topolvmController.opConfig.Parameters, csitopo.TopolvmPluginTolerationsEnv, []v1.Toleration{})
-	topolvmPluginNodeAffinity := csi.GetNodeAffinity(l.topolvmController.opConfig.Parameters, csitopo.TopolvmPluginNodeAffinityEnv, &v1.NodeAffinity{})
+	topolvmPluginTolerations := csi.GetToleration(c.opConfig.Parameters, csitopo.TopolvmPluginTolerationsEnv, []v1.Toleration{})
+	topolvmPluginNodeAffinity := csi.GetNodeAffinity(c.opConfig.Parameters, csitopo.TopolvmPluginNodeAffinityEnv, &v1.NodeAffinity{})
 	csi.ApplyToPodSpec(&topolvmPlugin.Spec.Template.Spec, topolvmPluginNodeAffinity, topolvmPluginTolerations)
-	csi.ApplyResourcesToContainers(l.topolvmController.opConfig.Parameters, csitopo.TopolvmPluginResource, &topolvmPlugin.Spec.Template.Spec)
+	csi.ApplyResourcesToContainers(c.opConfig.Parameters, csitopo.TopolvmPluginResource, &topolvmPlugin.Spec.Template.Spec)
+	return topolvmPlugin, nil
+}
+
+// nodePluginDeployment makes the node plugin deployment of a node from the
+// template. Its pods restart when the lvmd config changes, and its revision
+// annotation tells whether an existing deployment is up to date.
+func (c *clusterController) nodePluginDeployment(template *appsv1.Deployment, node string, configMap *v1.ConfigMap) (*appsv1.Deployment, error) {
 
+	topolvmPlugin := template.DeepCopy()
 	topolvmPlugin.Name = k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, node)
 	nodeSelector := map[string]string{
 		v1.LabelHostname: node,
 	}
 	topolvmPlugin.Spec.Template.Spec.NodeSelector = nodeSelector
-	topolvmPlugin.OwnerReferences = []metav1.OwnerReference{*l.topolvmController.getRef()}
+	// the pods of a node share the lvmd socket, the old one goes first
+	topolvmPlugin.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	topolvmPlugin.OwnerReferences = []metav1.OwnerReference{*c.getRef()}
 	lvmdName := k8sutil.TruncateNodeName(topolvm.LvmdConfigMapFmt, node)
 	v := v1.Volume{Name: "lvmd-config-dir", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: lvmdName}}}}
 	topolvmPlugin.Spec.Template.Spec.Volumes = append(topolvmPlugin.Spec.Template.Spec.Volumes, v)
-	_, err = k8sutil.CreateOrUpdateDeployment(l.topolvmController.opManagerContext, l.topolvmController.context.Clientset, topolvmPlugin)
+	if hasCacheClasses(configMap) {
+		topolvmPlugin.Spec.Template.Spec.Containers = append(topolvmPlugin.Spec.Template.Spec.Containers, lvcacheContainer(c.opConfig.Image))
+	}
+
+	if topolvmPlugin.Spec.Template.Annotations == nil {
+		topolvmPlugin.Spec.Template.Annotations = make(map[string]string)
+	}
+	topolvmPlugin.Spec.Template.Annotations[topolvm.LvmdConfigHashAnnotation] = hashOf(configMap.Data[topolvm.LvmdConfigMapKey])
+	podTemplate, err := json.Marshal(topolvmPlugin.Spec.Template)
 	if err != nil {
-		return errors.Wrapf(err, "failed to update topolvm provisioner deployment %q", topolvmPlugin.Name)
+		return nil, errors.Wrapf(err, "failed to marshal pod template of %s", topolvmPlugin.Name)
+	}
+	if topolvmPlugin.Annotations == nil {
+		topolvmPlugin.Annotations = make(map[string]string)
 	}
+	revision := hashOf(string(podTemplate))
+	topolvmPlugin.Annotations[topolvm.NodePluginRevisionAnnotation] = revision
+	// a new revision always replaces the pods, the rollout waits for new ones
+	topolvmPlugin.Spec.Template.Annotations[topolvm.NodePluginRevisionAnnotation] = revision
+	return topolvmPlugin, nil
+}
 
-	return nil
+func hashOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])[:16]
 }
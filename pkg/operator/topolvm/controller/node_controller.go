@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/volumegroup"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+var nodeLogger = capnslog.NewPackageLogger("topolvm/operator", "node-classes")
+
+type nodeController struct {
+	clusterController *clusterController
+	nodeController    cache.Controller
+}
+
+func newNodeController(clusterController *clusterController) *nodeController {
+
+	node := &nodeController{
+		clusterController: clusterController,
+	}
+
+	_, node.nodeController = cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return clusterController.context.Clientset.CoreV1().Nodes().List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return clusterController.context.Clientset.CoreV1().Nodes().Watch(context.TODO(), options)
+			},
+		}, &v1.Node{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: node.onUpdate,
+		},
+	)
+
+	return node
+}
+
+func (n *nodeController) start() {
+	go n.nodeController.Run(n.clusterController.ctx.Done())
+}
+
+// onUpdate restarts the volume group job of a node whose labels changed the
+// node classes selecting it, a node no longer selected is decommissioned.
+// Nodes joining are handled once discovery reported their devices.
+func (n *nodeController) onUpdate(oldObj, newObj interface{}) {
+
+	oldNode, err := getNodeObject(oldObj)
+	if err != nil {
+		nodeLogger.Errorf("failed to get old node object. %v", err)
+		return
+	}
+
+	newNode, err := getNodeObject(newObj)
+	if err != nil {
+		nodeLogger.Errorf("failed to get new node object. %v", err)
+		return
+	}
+
+	// the node plugin of a node being decommissioned may put its capacity
+	// back
+	if n.clusterController.isDecommissioning(newNode.Name) && hasCapacity(newNode) {
+		n.clusterController.rolloutController.enqueue()
+	}
+
+	if reflect.DeepEqual(oldNode.Labels, newNode.Labels) {
+		return
+	}
+	topolvmCluster := n.clusterController.getCluster()
+	if topolvmCluster == nil || len(topolvmCluster.Spec.NodeClasses) == 0 {
+		return
+	}
+
+	oldSelecting, err := volumegroup.SelectingNodeClasses(topolvmCluster.Spec.NodeClasses, oldNode.Labels)
+	if err != nil {
+		nodeLogger.Errorf("select node classes of node %s failed %v", oldNode.Name, err)
+		return
+	}
+	newSelecting, err := volumegroup.SelectingNodeClasses(topolvmCluster.Spec.NodeClasses, newNode.Labels)
+	if err != nil {
+		nodeLogger.Errorf("select node classes of node %s failed %v", newNode.Name, err)
+		return
+	}
+	if reflect.DeepEqual(oldSelecting, newSelecting) {
+		return
+	}
+	if len(newSelecting) == 0 {
+		nodeLogger.Infof("node %s is no longer selected by any node class, decommission it unless the cluster still claims it", newNode.Name)
+		n.clusterController.rolloutController.enqueue()
+		return
+	}
+
+	if !n.clusterController.ownsNode(newNode.Name) {
+		nodeLogger.Infof("node %s is owned by another cluster, skip it", newNode.Name)
+		return
+	}
+	nodeLogger.Infof("node classes of node %s changed, restart volume group job", newNode.Name)
+	if err := n.clusterController.RestartJob(newNode.Name, n.clusterController.getRef()); err != nil {
+		nodeLogger.Errorf("restart job of node %s failed %v", newNode.Name, err)
+	}
+}
+
+func hasCapacity(node *v1.Node) bool {
+	for key := range node.Annotations {
+		if strings.HasPrefix(key, topolvm.CapacityKeyPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func getNodeObject(obj interface{}) (*v1.Node, error) {
+
+	node, ok := obj.(*v1.Node)
+	if ok {
+		return node.DeepCopy(), nil
+	}
+	return nil, errors.Errorf("not a known node: %+v", obj)
+}
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/volumegroup"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// claimedNodes returns the nodes the cluster claims and owns, and the nodes it
+// claims but other clusters own along with their owner. A node is owned by
+// the cluster whose lvmd configmap it has, or else by the oldest cluster
+// claiming it. A node whose cluster no longer claims it is decommissioned by
+// that cluster, the others wait for its lvmd configmap to be removed.
+func (r *clusterController) claimedNodes() ([]string, map[string]string, error) {
+
+	ctx := context.TODO()
+	clusters := &topolvmv2.TopolvmClusterList{}
+	if err := r.context.Client.List(ctx, clusters, client.InNamespace(r.namespacedName.Namespace)); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list topolvm cluster")
+	}
+	nodes, err := r.context.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list node")
+	}
+	cms, err := r.context.Clientset.CoreV1().ConfigMaps(r.namespacedName.Namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", topolvm.LvmdConfigMapLabelKey, topolvm.LvmdConfigMapLabelValue)})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list lvmd configmap")
+	}
+	current := make(map[string]string)
+	for _, cm := range cms.Items {
+		if owner := cm.Labels[topolvm.ClusterAttr]; owner != "" {
+			current[cm.GetAnnotations()[topolvm.LvmdAnnotationsNodeKey]] = owner
+		}
+	}
+
+	exists := make(map[string]bool)
+	for _, c := range clusters.Items {
+		exists[c.Name] = true
+	}
+
+	var owned []string
+	conflicts := make(map[string]string)
+	for node, claiming := range volumegroup.NodeOwners(clusters.Items, nodes.Items, current) {
+		if leaving := current[node]; exists[leaving] && leaving != claiming[0] {
+			for _, name := range claiming {
+				if name == r.namespacedName.Name {
+					conflicts[node] = leaving
+				}
+			}
+			continue
+		}
+		if claiming[0] == r.namespacedName.Name {
+			owned = append(owned, node)
+			continue
+		}
+		for _, name := range claiming[1:] {
+			if name == r.namespacedName.Name {
+				conflicts[node] = claiming[0]
+			}
+		}
+	}
+	sort.Strings(owned)
+	return owned, conflicts, nil
+}
+
+// lastCluster tells whether no other cluster is left in the namespace.
+func (r *clusterController) lastCluster() (bool, error) {
+	clusters := &topolvmv2.TopolvmClusterList{}
+	if err := r.context.Client.List(context.TODO(), clusters, client.InNamespace(r.namespacedName.Namespace)); err != nil {
+		return false, errors.Wrap(err, "failed to list topolvm cluster")
+	}
+	for _, c := range clusters.Items {
+		if c.Name != r.namespacedName.Name {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// nodeConflictCondition is the NodeConflict condition of a cluster, conflicts
+// maps the nodes other clusters own to their owner.
+func nodeConflictCondition(conflicts map[string]string, generation int64) metav1.Condition {
+
+	condition := metav1.Condition{
+		Type:               topolvmv2.ClusterNodeConflict,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NodesOwned",
+		Message:            "no node is owned by another cluster",
+		ObservedGeneration: generation,
+	}
+	if len(conflicts) == 0 {
+		return condition
+	}
+	nodes := make([]string, 0, len(conflicts))
+	for node := range conflicts {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	owned := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		owned = append(owned, fmt.Sprintf("%s (cluster %s)", node, conflicts[node]))
+	}
+	condition.Status = metav1.ConditionTrue
+	condition.Reason = "NodesOwnedElsewhere"
+	condition.Message = fmt.Sprintf("nodes %s are owned by other clusters", strings.Join(owned, ", "))
+	return condition
+}
+
+func lvmdConfigMapSelector(clusterName string) string {
+	return fmt.Sprintf("%s=%s,%s=%s", topolvm.LvmdConfigMapLabelKey, topolvm.LvmdConfigMapLabelValue, topolvm.ClusterAttr, clusterName)
+}
+
+// enqueueClusters reconciles the cluster of an event. The clusters waiting
+// for its nodes are reconciled as well when it is deleted, its spec changes
+// or it is done decommissioning nodes, it may have released them.
+func enqueueClusters(reader client.Reader) handler.EventHandler {
+
+	enqueue := func(obj client.Object, q workqueue.RateLimitingInterface) {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}})
+	}
+	enqueueWaiting := func(obj client.Object, q workqueue.RateLimitingInterface) {
+		clusters := &topolvmv2.TopolvmClusterList{}
+		if err := reader.List(context.TODO(), clusters, client.InNamespace(obj.GetNamespace())); err != nil {
+			logger.Errorf("failed to list topolvm cluster %v", err)
+			return
+		}
+		for index, c := range clusters.Items {
+			if c.Name != obj.GetName() && meta.IsStatusConditionTrue(c.Status.Conditions, topolvmv2.ClusterNodeConflict) {
+				enqueue(&clusters.Items[index], q)
+			}
+		}
+	}
+
+	return handler.Funcs{
+		CreateFunc: func(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(e.Object, q)
+		},
+		UpdateFunc: func(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(e.ObjectNew, q)
+			if e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() || releasedNodes(e.ObjectOld, e.ObjectNew) {
+				enqueueWaiting(e.ObjectNew, q)
+			}
+		},
+		DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			enqueue(e.Object, q)
+			enqueueWaiting(e.Object, q)
+		},
+	}
+}
+
+// releasedNodes tells whether nodes left the status of a cluster, which is
+// when it is done decommissioning them.
+func releasedNodes(oldObj, newObj client.Object) bool {
+	oldCluster, ok := oldObj.(*topolvmv2.TopolvmCluster)
+	if !ok {
+		return false
+	}
+	newCluster, ok := newObj.(*topolvmv2.TopolvmCluster)
+	if !ok {
+		return false
+	}
+	nodes := make(map[string]bool)
+	for _, n := range newCluster.Status.NodeStorageStatus {
+		nodes[n.Node] = true
+	}
+	for _, n := range newCluster.Status.DecommissioningNodes {
+		nodes[n.Node] = true
+	}
+	for _, n := range oldCluster.Status.NodeStorageStatus {
+		if !nodes[n.Node] {
+			return true
+		}
+	}
+	for _, n := range oldCluster.Status.DecommissioningNodes {
+		if !nodes[n.Node] {
+			return true
+		}
+	}
+	return false
+}
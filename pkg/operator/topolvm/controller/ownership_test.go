@@ -0,0 +1,189 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestClaimedNodes(t *testing.T) {
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+
+	tests := []struct {
+		name      string
+		objects   []runtime.Object
+		owned     []string
+		conflicts map[string]string
+	}{
+		{
+			name:      "a node claimed by several clusters goes to the oldest",
+			objects:   []runtime.Object{claimingCluster("a", older, "node1")},
+			conflicts: map[string]string{"node1": "a"},
+		},
+		{
+			name:    "a node stays with the cluster which prepared it",
+			objects: []runtime.Object{claimingCluster("a", older, "node1"), clusterConfigMap("b", "node1", false)},
+			owned:   []string{"node1"},
+		},
+		{
+			name:      "a node waits for the cluster decommissioning it",
+			objects:   []runtime.Object{claimingCluster("a", older), clusterConfigMap("a", "node1", true)},
+			conflicts: map[string]string{"node1": "a"},
+		},
+		{
+			name:    "a decommissioned node is handed off to the cluster waiting for it",
+			objects: []runtime.Object{claimingCluster("a", older)},
+			owned:   []string{"node1"},
+		},
+		{
+			name:    "a node of a deleted cluster is taken over",
+			objects: []runtime.Object{clusterConfigMap("a", "node1", false)},
+			owned:   []string{"node1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append([]runtime.Object{testNode("node1", "")}, tt.objects...)
+			c := newTestClusterController(t, claimingCluster("b", newer, "node1"), objects...)
+
+			owned, conflicts, err := c.claimedNodes()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.owned, owned)
+			if len(tt.conflicts) == 0 {
+				assert.Empty(t, conflicts)
+			} else {
+				assert.Equal(t, tt.conflicts, conflicts)
+			}
+		})
+	}
+}
+
+// TestDecommissionHandoff moves a node from the cluster releasing it to the
+// cluster waiting for it.
+func TestDecommissionHandoff(t *testing.T) {
+	leaving := claimingCluster("a", time.Now().Add(-time.Hour))
+	leaving.Status.NodeStorageStatus = []topolvmv2.NodeStorageState{{Node: "node1"}}
+	waiting := claimingCluster("b", time.Now(), "node1")
+	a := newTestClusterController(t, leaving, waiting, testNode("node1", ""), clusterConfigMap("a", "node1", false), testLogicalVolume("pvc-1", "node1"))
+	// both clusters share the clients
+	b := newTestClusterController(t, waiting)
+	b.client = a.client
+	b.context = a.context
+
+	_, conflicts, err := b.claimedNodes()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"node1": "a"}, conflicts)
+
+	r := newRolloutController(a)
+	ctx := context.TODO()
+	pending, err := r.decommission()
+	assert.NoError(t, err)
+	assert.True(t, pending)
+	before := &topolvmv2.TopolvmCluster{}
+	assert.NoError(t, a.context.Client.Get(ctx, a.namespacedName, before))
+	node, err := a.context.Clientset.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "a", node.Labels[topolvm.DecommissionLabel])
+	_, conflicts, err = b.claimedNodes()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"node1": "a"}, conflicts)
+
+	assert.NoError(t, a.context.Client.Delete(ctx, testLogicalVolume("pvc-1", "node1")))
+	pending, err = r.decommission()
+	assert.NoError(t, err)
+	assert.False(t, pending)
+	after := &topolvmv2.TopolvmCluster{}
+	assert.NoError(t, a.context.Client.Get(ctx, a.namespacedName, after))
+	// the waiting cluster is reconciled once the node left the status
+	assert.True(t, releasedNodes(before, after))
+
+	owned, conflicts, err := b.claimedNodes()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"node1"}, owned)
+	assert.Empty(t, conflicts)
+	_, err = a.context.Clientset.CoreV1().ConfigMaps(testNamespace).Get(ctx, k8sutil.TruncateNodeName(topolvm.LvmdConfigMapFmt, "node1"), metav1.GetOptions{})
+	assert.Error(t, err)
+	node, err = a.context.Clientset.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, node.Labels, topolvm.DecommissionLabel)
+}
+
+func TestReleasedNodes(t *testing.T) {
+	withNodes := func(stored []string, decommissioning []string) *topolvmv2.TopolvmCluster {
+		c := &topolvmv2.TopolvmCluster{}
+		for _, node := range stored {
+			c.Status.NodeStorageStatus = append(c.Status.NodeStorageStatus, topolvmv2.NodeStorageState{Node: node})
+		}
+		for _, node := range decommissioning {
+			c.Status.DecommissioningNodes = append(c.Status.DecommissioningNodes, topolvmv2.NodeDecommission{Node: node})
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		old      *topolvmv2.TopolvmCluster
+		new      *topolvmv2.TopolvmCluster
+		released bool
+	}{
+		{
+			name: "unchanged",
+			old:  withNodes([]string{"node1", "node2"}, nil),
+			new:  withNodes([]string{"node1", "node2"}, nil),
+		},
+		{
+			name: "a node starts being decommissioned",
+			old:  withNodes([]string{"node1", "node2"}, nil),
+			new:  withNodes([]string{"node1", "node2"}, []string{"node2"}),
+		},
+		{
+			name:     "a decommissioned node leaves the status",
+			old:      withNodes([]string{"node1", "node2"}, []string{"node2"}),
+			new:      withNodes([]string{"node1"}, nil),
+			released: true,
+		},
+		{
+			name:     "a node leaves without being decommissioned",
+			old:      withNodes([]string{"node1", "node2"}, nil),
+			new:      withNodes([]string{"node1"}, nil),
+			released: true,
+		},
+		{
+			name: "a node joins",
+			old:  withNodes([]string{"node1"}, nil),
+			new:  withNodes([]string{"node1", "node2"}, nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.released, releasedNodes(tt.old, tt.new))
+		})
+	}
+
+	assert.False(t, releasedNodes(&corev1.ConfigMap{}, withNodes(nil, nil)))
+}
@@ -0,0 +1,317 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/rollout"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var rolloutLogger = capnslog.NewPackageLogger("topolvm/operator", "node-plugin-rollout")
+
+// rolloutController rolls the node plugins of the cluster out. Node plugins
+// missing are created at once, the outdated ones are updated in batches of
+// the upgrade strategy once the topolvm controller is rolled out.
+type rolloutController struct {
+	clusterController *clusterController
+	trigger           chan struct{}
+}
+
+func newRolloutController(clusterController *clusterController) *rolloutController {
+	return &rolloutController{
+		clusterController: clusterController,
+		trigger:           make(chan struct{}, 1),
+	}
+}
+
+func (r *rolloutController) start() {
+	go r.run()
+}
+
+// enqueue asks for a rollout step, the steps asked for while one runs are
+// merged into the next one.
+func (r *rolloutController) enqueue() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// run steps the rollout when asked to, and every interval until it is
+// complete to follow the health of the updated nodes. The nodes leaving the
+// cluster are torn down first, their node plugins are not rolled out.
+func (r *rolloutController) run() {
+	for {
+		var tick <-chan time.Time
+		decommissioning, decommissionErr := r.decommission()
+		if decommissionErr != nil {
+			decommissionLogger.Errorf("decommission nodes failed %v", decommissionErr)
+		}
+		pending, err := r.step()
+		if err != nil {
+			rolloutLogger.Errorf("roll out node plugins failed %v", err)
+		}
+		if pending || decommissioning || err != nil || decommissionErr != nil {
+			tick = time.After(topolvm.RolloutInterval)
+		}
+		select {
+		case <-r.clusterController.ctx.Done():
+			return
+		case <-r.trigger:
+		case <-tick:
+		}
+	}
+}
+
+// step makes the next step of the rollout and reports its progress, it tells
+// whether the rollout is not complete yet.
+func (r *rolloutController) step() (bool, error) {
+
+	c := r.clusterController
+	topolvmCluster := c.getCluster()
+	if topolvmCluster == nil || c.getRef() == nil {
+		return false, nil
+	}
+	ctx := c.ctx
+	namespace := c.namespacedName.Namespace
+	cms, err := c.context.Clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: lvmdConfigMapSelector(c.namespacedName.Name)})
+	if err != nil {
+		return true, errors.Wrap(err, "failed to list lvmd configmap")
+	}
+	template, err := c.nodePluginTemplate()
+	if err != nil {
+		return true, err
+	}
+
+	now := time.Now()
+	var nodes []rollout.Node
+	desired := make(map[string]*appsv1.Deployment)
+	for index := range cms.Items {
+		cm := &cms.Items[index]
+		if _, ok := cm.Data[topolvm.LvmdConfigMapKey]; !ok {
+			continue
+		}
+		if _, ok := cm.Annotations[topolvm.DecommissionAnnotation]; ok {
+			continue
+		}
+		node := getNodeName(cm)
+		if node == "" {
+			continue
+		}
+		deployment, err := c.nodePluginDeployment(template, node, cm)
+		if err != nil {
+			return true, err
+		}
+		desired[node] = deployment
+
+		existing, err := c.context.Clientset.AppsV1().Deployments(namespace).Get(ctx, deployment.Name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			// a node without node plugin has nothing to keep available
+			if err := r.apply(deployment, now); err != nil {
+				return true, err
+			}
+			nodes = append(nodes, rollout.Node{Name: node, Updated: true, UpdatedAt: now})
+			continue
+		}
+		if err != nil {
+			return true, errors.Wrapf(err, "failed to get deployment %s", deployment.Name)
+		}
+		state := rollout.Node{
+			Name:      node,
+			Updated:   existing.Annotations[topolvm.NodePluginRevisionAnnotation] == deployment.Annotations[topolvm.NodePluginRevisionAnnotation],
+			UpdatedAt: existing.CreationTimestamp.Time,
+		}
+		if updated, err := time.Parse(time.RFC3339, existing.Annotations[topolvm.NodePluginUpdatedAnnotation]); err == nil {
+			state.UpdatedAt = updated
+		}
+		state.Healthy, err = r.healthy(node, existing, state.UpdatedAt)
+		if err != nil {
+			return true, err
+		}
+		nodes = append(nodes, state)
+	}
+
+	var maxUnavailableValue *intstr.IntOrString
+	timeout := topolvm.DefaultNodeTimeout
+	if strategy := topolvmCluster.Spec.UpgradeStrategy; strategy != nil {
+		maxUnavailableValue = strategy.MaxUnavailable
+		if strategy.NodeTimeoutSeconds > 0 {
+			timeout = time.Duration(strategy.NodeTimeoutSeconds) * time.Second
+		}
+	}
+	maxUnavailable, err := rollout.MaxUnavailable(maxUnavailableValue, len(nodes))
+	if err != nil {
+		return true, err
+	}
+	plan := rollout.Next(nodes, maxUnavailable, timeout, now)
+
+	status := &topolvmv2.NodePluginRollout{
+		Image:         lvmdImage(template),
+		UpdatedNodes:  int32(plan.Updated),
+		TotalNodes:    int32(len(nodes)),
+		UpdatingNodes: plan.Updating,
+		FailedNodes:   plan.Failed,
+	}
+	switch {
+	case plan.Paused():
+		status.Phase = topolvmv2.RolloutPaused
+		status.Message = fmt.Sprintf("node plugins of nodes %s are not healthy after %s", strings.Join(plan.Failed, ","), timeout)
+	case plan.Complete(len(nodes)):
+		status.Phase = topolvmv2.RolloutComplete
+		status.Message = fmt.Sprintf("node plugins of %d nodes are updated", len(nodes))
+	default:
+		status.Phase = topolvmv2.RolloutProgressing
+		status.Message = fmt.Sprintf("%d of %d node plugins are updated", plan.Updated, len(nodes))
+		if len(plan.Update) == 0 {
+			break
+		}
+		rolledOut, err := r.controllerRolledOut()
+		if err != nil {
+			return true, err
+		}
+		if !rolledOut {
+			status.Message = fmt.Sprintf("waiting for %s to roll out", topolvm.TopolvmControllerDeploymentName)
+			break
+		}
+		for _, node := range plan.Update {
+			rolloutLogger.Infof("update node plugin of node %s", node)
+			if err := r.apply(desired[node], now); err != nil {
+				return true, err
+			}
+		}
+		status.UpdatingNodes = append(status.UpdatingNodes, plan.Update...)
+		sort.Strings(status.UpdatingNodes)
+	}
+
+	if err := r.updateStatus(status); err != nil {
+		return true, err
+	}
+	return status.Phase != topolvmv2.RolloutComplete, nil
+}
+
+// apply creates or updates the node plugin deployment of a node.
+func (r *rolloutController) apply(deployment *appsv1.Deployment, now time.Time) error {
+	deployment.Annotations[topolvm.NodePluginUpdatedAnnotation] = now.Format(time.RFC3339)
+	if _, err := k8sutil.CreateOrUpdateDeployment(r.clusterController.ctx, r.clusterController.context.Clientset, deployment); err != nil {
+		return errors.Wrapf(err, "failed to update topolvm node plugin deployment %q", deployment.Name)
+	}
+	return nil
+}
+
+// healthy tells whether the node plugin of the node is rolled out and a pod
+// created since it was updated has lvmd and the plugin ready and registered
+// in the CSINode of the node. The CSINode keeps no time of registration, the
+// registration of the old pod is removed by its preStop hook, so the one
+// found once the registrar of the new pod runs is taken as its own.
+func (r *rolloutController) healthy(node string, deployment *appsv1.Deployment, updatedAt time.Time) (bool, error) {
+
+	if !k8sutil.DeploymentRolledOut(deployment) {
+		return false, nil
+	}
+
+	c := r.clusterController
+	pods, err := c.context.Clientset.CoreV1().Pods(c.namespacedName.Namespace).List(c.ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", topolvm.TopolvmComposeAttr, topolvm.TopolvmComposeNode),
+		FieldSelector: "spec.nodeName=" + node,
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list node plugin pods of node %s", node)
+	}
+	ready := false
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.CreationTimestamp.Time.Before(updatedAt) {
+			continue
+		}
+		if podReady(&pod) {
+			ready = true
+			break
+		}
+	}
+	if !ready {
+		return false, nil
+	}
+
+	csiNode, err := c.context.Clientset.StorageV1().CSINodes().Get(c.ctx, node, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get csinode %s", node)
+	}
+	for _, driver := range csiNode.Spec.Drivers {
+		if driver.Name == topolvm.TopolvmCSIDriverName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// podReady tells whether lvmd and the plugin of a node plugin pod are ready
+// and its registrar runs.
+func podReady(pod *corev1.Pod) bool {
+	ready := map[string]bool{}
+	for _, s := range pod.Status.ContainerStatuses {
+		switch s.Name {
+		case topolvm.LvmdContainerName, topolvm.NodePluginContainerName:
+			ready[s.Name] = s.Ready
+		case topolvm.RegistrarContainerName:
+			ready[s.Name] = s.State.Running != nil
+		}
+	}
+	return ready[topolvm.LvmdContainerName] && ready[topolvm.NodePluginContainerName] && ready[topolvm.RegistrarContainerName]
+}
+
+// controllerRolledOut tells whether the topolvm controller is rolled out, the
+// node plugins are only updated after it.
+func (r *rolloutController) controllerRolledOut() (bool, error) {
+	c := r.clusterController
+	deployment, err := c.context.Clientset.AppsV1().Deployments(c.opConfig.OperatorNamespace).Get(c.ctx, topolvm.TopolvmControllerDeploymentName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get deployment %s", topolvm.TopolvmControllerDeploymentName)
+	}
+	return k8sutil.DeploymentRolledOut(deployment), nil
+}
+
+func (r *rolloutController) updateStatus(rollout *topolvmv2.NodePluginRollout) error {
+	c := r.clusterController
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+	topolvmCluster := &topolvmv2.TopolvmCluster{}
+	err := c.context.Client.Get(c.ctx, c.namespacedName, topolvmCluster)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to retrieve topolvm cluster %q to update rollout status", c.namespacedName.Name)
+	}
+	if reflect.DeepEqual(topolvmCluster.Status.NodePluginRollout, rollout) {
+		return nil
+	}
+	topolvmCluster.Status.NodePluginRollout = rollout
+	return k8sutil.UpdateStatus(c.context.Client, topolvmCluster)
+}
+
+func lvmdImage(deployment *appsv1.Deployment) string {
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name == topolvm.LvmdContainerName {
+			return container.Image
+		}
+	}
+	return ""
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/stretchr/testify/assert"
+	topolvmv1 "github.com/topolvm/topolvm/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testNamespace = "nativestor-system"
+
+// newTestClusterController makes a controller of the cluster whose clients
+// serve the objects, the clusters and logical volumes are served by the
+// controller-runtime client and the others by the clientset.
+func newTestClusterController(t *testing.T, topolvmCluster *topolvmv2.TopolvmCluster, objects ...runtime.Object) *clusterController {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, topolvmv2.AddToScheme(scheme))
+	assert.NoError(t, topolvmv1.AddToScheme(scheme))
+	clientObjects := []client.Object{topolvmCluster}
+	var clientsetObjects []runtime.Object
+	for _, object := range objects {
+		switch o := object.(type) {
+		case *topolvmv2.TopolvmCluster:
+			clientObjects = append(clientObjects, o)
+		case *topolvmv1.LogicalVolume:
+			clientObjects = append(clientObjects, o)
+		default:
+			clientsetObjects = append(clientsetObjects, object)
+		}
+	}
+	client := crfake.NewClientBuilder().WithScheme(scheme).WithObjects(clientObjects...).Build()
+	ctx, cancel := context.WithCancel(context.TODO())
+	t.Cleanup(cancel)
+	return &clusterController{
+		client:         client,
+		context:        &cluster.Context{Clientset: fake.NewSimpleClientset(clientsetObjects...), Client: client},
+		opConfig:       operator.OperatorConfig{OperatorNamespace: testNamespace, Image: "nativestor"},
+		ctx:            ctx,
+		cancel:         cancel,
+		namespacedName: types.NamespacedName{Namespace: testNamespace, Name: topolvmCluster.Name},
+		cluster:        topolvmCluster,
+		ref:            &metav1.OwnerReference{APIVersion: topolvmv2.GroupVersion.String(), Kind: "TopolvmCluster", Name: topolvmCluster.Name},
+	}
+}
+
+func rolloutCluster() *topolvmv2.TopolvmCluster {
+	return &topolvmv2.TopolvmCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: testNamespace},
+		Spec:       topolvmv2.TopolvmClusterSpec{TopolvmVersion: "topolvm:new"},
+	}
+}
+
+func lvmdConfigMap(node string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        k8sutil.TruncateNodeName(topolvm.LvmdConfigMapFmt, node),
+			Namespace:   testNamespace,
+			Labels:      map[string]string{topolvm.LvmdConfigMapLabelKey: topolvm.LvmdConfigMapLabelValue, topolvm.ClusterAttr: "cluster"},
+			Annotations: map[string]string{topolvm.LvmdAnnotationsNodeKey: node},
+		},
+		Data: map[string]string{topolvm.LvmdConfigMapKey: "device-classes: []\n"},
+	}
+}
+
+// nodePlugin is the rolled out node plugin deployment of the node, of the
+// topolvm version when it is not the one of the cluster.
+func nodePlugin(t *testing.T, node, version string, updatedAt time.Time) *appsv1.Deployment {
+	topolvmCluster := rolloutCluster()
+	if version != "" {
+		topolvmCluster.Spec.TopolvmVersion = version
+	}
+	c := newTestClusterController(t, topolvmCluster)
+	template, err := c.nodePluginTemplate()
+	assert.NoError(t, err)
+	deployment, err := c.nodePluginDeployment(template, node, lvmdConfigMap(node))
+	assert.NoError(t, err)
+	deployment.Annotations[topolvm.NodePluginUpdatedAnnotation] = updatedAt.Format(time.RFC3339)
+	deployment.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	return deployment
+}
+
+func nodePluginPod(node string, createdAt time.Time, ready bool) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "topolvm-node-" + node,
+			Namespace:         testNamespace,
+			Labels:            map[string]string{topolvm.TopolvmComposeAttr: topolvm.TopolvmComposeNode},
+			CreationTimestamp: metav1.NewTime(createdAt),
+		},
+		Spec: corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: topolvm.LvmdContainerName, Ready: ready},
+			{Name: topolvm.NodePluginContainerName, Ready: ready},
+			{Name: topolvm.RegistrarContainerName, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}},
+	}
+}
+
+func csiNode(node string) *storagev1.CSINode {
+	return &storagev1.CSINode{
+		ObjectMeta: metav1.ObjectMeta{Name: node},
+		Spec:       storagev1.CSINodeSpec{Drivers: []storagev1.CSINodeDriver{{Name: topolvm.TopolvmCSIDriverName, NodeID: node}}},
+	}
+}
+
+func topolvmController() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: topolvm.TopolvmControllerDeploymentName, Namespace: testNamespace},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+}
+
+func TestRolloutStep(t *testing.T) {
+	now := time.Now()
+	updatedAt := now.Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		pending bool
+		phase   topolvmv2.RolloutPhase
+		message string
+		// images are the lvmd images of the node plugins after the step
+		images map[string]string
+	}{
+		{
+			name:    "missing node plugins are created at once",
+			objects: []runtime.Object{lvmdConfigMap("node1")},
+			pending: true,
+			phase:   topolvmv2.RolloutProgressing,
+			message: "0 of 1 node plugins are updated",
+			images:  map[string]string{"node1": "topolvm:new"},
+		},
+		{
+			name: "a new pod ready and registered is healthy",
+			objects: []runtime.Object{lvmdConfigMap("node1"), nodePlugin(t, "node1", "", updatedAt),
+				nodePluginPod("node1", updatedAt.Add(time.Second), true), csiNode("node1")},
+			phase:   topolvmv2.RolloutComplete,
+			message: "node plugins of 1 nodes are updated",
+		},
+		{
+			name: "a pod older than the update is not healthy",
+			objects: []runtime.Object{lvmdConfigMap("node1"), nodePlugin(t, "node1", "", updatedAt),
+				nodePluginPod("node1", updatedAt.Add(-time.Hour), true), csiNode("node1")},
+			pending: true,
+			phase:   topolvmv2.RolloutProgressing,
+			message: "0 of 1 node plugins are updated",
+		},
+		{
+			name: "a pod whose lvmd is not ready is not healthy",
+			objects: []runtime.Object{lvmdConfigMap("node1"), nodePlugin(t, "node1", "", updatedAt),
+				nodePluginPod("node1", updatedAt.Add(time.Second), false), csiNode("node1")},
+			pending: true,
+			phase:   topolvmv2.RolloutProgressing,
+			message: "0 of 1 node plugins are updated",
+		},
+		{
+			name: "a pod not registered in the csinode is not healthy",
+			objects: []runtime.Object{lvmdConfigMap("node1"), nodePlugin(t, "node1", "", updatedAt),
+				nodePluginPod("node1", updatedAt.Add(time.Second), true)},
+			pending: true,
+			phase:   topolvmv2.RolloutProgressing,
+			message: "0 of 1 node plugins are updated",
+		},
+		{
+			name: "a node not healthy past the timeout pauses the rollout",
+			objects: []runtime.Object{lvmdConfigMap("node1"), nodePlugin(t, "node1", "", now.Add(-time.Hour)),
+				nodePluginPod("node1", now.Add(-time.Hour), false), csiNode("node1")},
+			pending: true,
+			phase:   topolvmv2.RolloutPaused,
+			message: "node plugins of nodes node1 are not healthy after 10m0s",
+		},
+		{
+			name: "outdated nodes wait for the topolvm controller",
+			objects: []runtime.Object{lvmdConfigMap("node1"), nodePlugin(t, "node1", "topolvm:old", updatedAt),
+				nodePluginPod("node1", updatedAt.Add(time.Second), true), csiNode("node1")},
+			pending: true,
+			phase:   topolvmv2.RolloutProgressing,
+			message: "waiting for topolvm-controller to roll out",
+			images:  map[string]string{"node1": "topolvm:old"},
+		},
+		{
+			name: "outdated nodes are updated in batches of max unavailable",
+			objects: []runtime.Object{topolvmController(),
+				lvmdConfigMap("node1"), nodePlugin(t, "node1", "topolvm:old", updatedAt), nodePluginPod("node1", updatedAt.Add(time.Second), true), csiNode("node1"),
+				lvmdConfigMap("node2"), nodePlugin(t, "node2", "topolvm:old", updatedAt), nodePluginPod("node2", updatedAt.Add(time.Second), true), csiNode("node2")},
+			pending: true,
+			phase:   topolvmv2.RolloutProgressing,
+			message: "0 of 2 node plugins are updated",
+			images:  map[string]string{"node1": "topolvm:new", "node2": "topolvm:old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClusterController(t, rolloutCluster(), tt.objects...)
+			r := newRolloutController(c)
+
+			pending, err := r.step()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.pending, pending)
+
+			topolvmCluster := &topolvmv2.TopolvmCluster{}
+			assert.NoError(t, c.context.Client.Get(context.TODO(), c.namespacedName, topolvmCluster))
+			if assert.NotNil(t, topolvmCluster.Status.NodePluginRollout) {
+				assert.Equal(t, tt.phase, topolvmCluster.Status.NodePluginRollout.Phase)
+				assert.Equal(t, tt.message, topolvmCluster.Status.NodePluginRollout.Message)
+			}
+			for node, image := range tt.images {
+				name := k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, node)
+				deployment, err := c.context.Clientset.AppsV1().Deployments(testNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+				if assert.NoError(t, err) {
+					assert.Equal(t, image, lvmdImage(deployment))
+				}
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -27,7 +28,6 @@ import (
 	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
-	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
@@ -57,16 +57,37 @@ func Add(mgr manager.Manager, context *cluster.Context, opManagerContext context
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager, context *cluster.Context, opManagerContext context.Context, opConfig operator.OperatorConfig, metric chan *topolvm.Metrics) reconcile.Reconciler {
 
-	topolvmCtr := &TopolvmController{
+	return &TopolvmController{
 		scheme:           mgr.GetScheme(),
 		client:           mgr.GetClient(),
 		context:          context,
 		opConfig:         opConfig,
 		opManagerContext: opManagerContext,
 		metric:           metric,
+		clusters:         make(map[types.NamespacedName]*clusterController),
 	}
-	topolvmCtr.lvmdController = newLvmdController(topolvmCtr)
-	return topolvmCtr
+}
+
+// newClusterController makes the controller of a cluster, its informers and
+// status checker stop once the cluster is removed.
+func newClusterController(r *TopolvmController, name types.NamespacedName) *clusterController {
+
+	ctx, cancel := context.WithCancel(r.opManagerContext)
+	c := &clusterController{
+		scheme:         r.scheme,
+		client:         r.client,
+		context:        r.context,
+		ctx:            ctx,
+		cancel:         cancel,
+		opConfig:       r.opConfig,
+		metric:         r.metric,
+		namespacedName: name,
+	}
+	c.lvmdController = newLvmdController(c)
+	c.inventoryController = newInventoryController(c)
+	c.nodeController = newNodeController(c)
+	c.rolloutController = newRolloutController(c)
+	return c
 }
 
 func (r *TopolvmController) Reconcile(context context.Context, request reconcile.Request) (reconcile.Result, error) {
@@ -92,64 +113,138 @@ func (r *TopolvmController) reconcile(request reconcile.Request) (reconcile.Resu
 	if err != nil {
 		if kerrors.IsNotFound(err) {
 			logger.Debug("topolvm cluster resource not found. Ignoring since object must be deleted.")
+			r.removeClusterController(request.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, errors.Wrap(err, "failed to get topolvm cluster")
 	}
 
-	topolvm.ClusterName = request.NamespacedName.Name
-	topolvm.TopolvmImage = topolvmCluster.Spec.TopolvmVersion
-	topolvm.CertsSecret = topolvmCluster.Spec.CertsSecret
-
 	// Set a finalizer so we can do cleanup before the object goes away
 	err = ctr.AddFinalizerIfNotPresent(r.context.Client, topolvmCluster)
 	if err != nil {
 		return reconcile.Result{}, errors.Wrap(err, "failed to add finalizer")
 	}
 
-	r.namespacedName = request.NamespacedName
+	c := r.clusterController(request.NamespacedName)
 	// DELETE: the CR was deleted
 	if !topolvmCluster.GetDeletionTimestamp().IsZero() {
 
-		err = r.reconcileDelete(topolvmCluster)
+		err = c.reconcileDelete(topolvmCluster)
 		if err != nil {
 			return ctr.ImmediateRetryResultNoBackoff, errors.Wrap(err, "failed to clean cluster")
 		}
+		r.removeClusterController(request.NamespacedName)
 		return reconcile.Result{}, nil
 	}
 
+	err = c.reconcileCluster(topolvmCluster)
+	if statusErr := c.updateReconcileStatus(topolvmCluster.Generation, err); statusErr != nil {
+		logger.Errorf("update reconcile status failed %v", statusErr)
+	}
+	return reconcile.Result{}, err
+}
+
+// clusterController returns the controller of the cluster, it is made on the
+// first reconcile of the cluster.
+func (r *TopolvmController) clusterController(name types.NamespacedName) *clusterController {
+	r.clustersLock.Lock()
+	defer r.clustersLock.Unlock()
+	c, ok := r.clusters[name]
+	if !ok {
+		c = newClusterController(r, name)
+		r.clusters[name] = c
+	}
+	return c
+}
+
+// removeClusterController stops the controller of a cluster which is gone.
+func (r *TopolvmController) removeClusterController(name types.NamespacedName) {
+	r.clustersLock.Lock()
+	defer r.clustersLock.Unlock()
+	if c, ok := r.clusters[name]; ok {
+		c.stopCheckClusterStatus()
+		c.cancel()
+		delete(r.clusters, name)
+	}
+}
+
+func (r *clusterController) reconcileCluster(topolvmCluster *topolvmv2.TopolvmCluster) error {
+
 	// Create the controller owner ref
 	ref, err := ctr.GetControllerObjectOwnerReference(topolvmCluster, r.scheme)
 	if err != nil || ref == nil {
-		return reconcile.Result{}, errors.Wrapf(err, "failed to get controller %q owner reference", topolvmCluster.Name)
+		return errors.Wrapf(err, "failed to get controller %q owner reference", topolvmCluster.Name)
 	}
 	r.updateRef(ref)
 	if err := r.checkStorageConfig(topolvmCluster); err != nil {
-		return reconcile.Result{}, errors.Wrap(err, "check storage config failed")
+		return errors.Wrap(err, "check storage config failed")
 	}
 	if err := r.onAdd(topolvmCluster, ref); err != nil {
-		return reconcile.Result{}, errors.Wrapf(err, "failed to reconcile cluster %q", topolvmCluster.Name)
+		return errors.Wrapf(err, "failed to reconcile cluster %q", topolvmCluster.Name)
 	}
+	return nil
+}
 
-	return reconcile.Result{}, nil
+// updateReconcileStatus records the result of reconciling the generation in
+// the ReconcileFailed condition and observedGeneration, and the nodes other
+// clusters own in the NodeConflict condition.
+func (r *clusterController) updateReconcileStatus(generation int64, reconcileErr error) error {
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+	topolvmCluster := &topolvmv2.TopolvmCluster{}
+	err := r.context.Client.Get(context.TODO(), r.namespacedName, topolvmCluster)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to retrieve topolvm cluster %q to update reconcile status", r.namespacedName.Name)
+	}
+
+	status := topolvmCluster.Status.DeepCopy()
+	condition := metav1.Condition{
+		Type:               topolvmv2.ClusterReconcileFailed,
+		Status:             metav1.ConditionFalse,
+		Reason:             "ReconcileSucceeded",
+		Message:            fmt.Sprintf("generation %d is reconciled", generation),
+		ObservedGeneration: generation,
+	}
+	if reconcileErr != nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "ReconcileError"
+		condition.Message = reconcileErr.Error()
+	} else {
+		status.ObservedGeneration = generation
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	meta.SetStatusCondition(&status.Conditions, nodeConflictCondition(r.getConflicts(), generation))
+	if reflect.DeepEqual(topolvmCluster.Status, *status) {
+		return nil
+	}
+	topolvmCluster.Status = *status
+	return k8sutil.UpdateStatus(r.context.Client, topolvmCluster)
 }
 
-func (r *TopolvmController) reconcileDelete(topolvmCluster *topolvmv2.TopolvmCluster) error {
+func (r *clusterController) reconcileDelete(topolvmCluster *topolvmv2.TopolvmCluster) error {
 
 	nsName := r.namespacedName
 	logger.Infof("deleting topolvm cluster %q", topolvmCluster.Name)
 	r.stopCheckClusterStatus()
 	// Remove finalizer
 
+	// only the nodes of the cluster are cleaned, other clusters keep theirs
+	owned, _, err := r.claimedNodes()
+	if err != nil {
+		return errors.Wrap(err, "get nodes of cluster failed")
+	}
 	r.updateCluster(nil)
-	err := r.cleanCluster()
+	err = r.cleanCluster(owned)
 	if err != nil {
 		return errors.Wrap(err, "clean cluster failed")
 	}
 	if topolvmCluster.Spec.CleanUp {
 		logger.Infof("start clean job")
-		r.startCleanUpJobs(topolvmCluster)
+		r.startCleanUpJobs(owned)
 	}
 
 	err = removeFinalizer(r.context.Client, nsName)
@@ -160,9 +255,9 @@ func (r *TopolvmController) reconcileDelete(topolvmCluster *topolvmv2.TopolvmClu
 
 }
 
-func (r *TopolvmController) startClusterMonitor() error {
+func (r *clusterController) startClusterMonitor() error {
 	if r.health == nil {
-		internalCtx, internalCancel := context.WithCancel(r.opManagerContext)
+		internalCtx, internalCancel := context.WithCancel(r.ctx)
 		r.health = &clusterHealth{
 			internalCtx:    internalCtx,
 			internalCancel: internalCancel,
@@ -175,7 +270,7 @@ func (r *TopolvmController) startClusterMonitor() error {
 	return nil
 }
 
-func (r *TopolvmController) stopCheckClusterStatus() {
+func (r *clusterController) stopCheckClusterStatus() {
 	if r.health != nil {
 		if r.health.internalCtx.Err() == nil {
 			r.health.internalCancel()
@@ -184,56 +279,59 @@ func (r *TopolvmController) stopCheckClusterStatus() {
 	}
 }
 
+// verifyTopolvmCluster checks the cluster is in the operator namespace, where
+// the service accounts of its jobs and node plugins are.
 func (r *TopolvmController) verifyTopolvmCluster(request reconcile.Request) error {
-	// Pass object name and namespace
 	if request.Namespace != r.opConfig.OperatorNamespace {
-		return fmt.Errorf("namespace %s of topovlm cluster:%s is not equal to operator namespace:%s", request.Namespace, request.NamespacedName.Name, topolvm.NameSpace)
-
-	}
-	topolvmClusters := &topolvmv2.TopolvmClusterList{}
-	err := r.context.Client.List(context.TODO(), topolvmClusters, &client.ListOptions{})
-	if err != nil {
-		return errors.Wrap(err, "failed to list topolvm cluster")
-	}
-
-	if topolvmClusters == nil || topolvmClusters.Items == nil {
-		return errors.New("no topolvm cluster instance existing")
-	}
-
-	oldest := topolvmv2.TopolvmCluster{}
-	if len(topolvmClusters.Items) > 1 {
-		for index, c := range topolvmClusters.Items {
-			if index == 0 {
-				oldest = c
-			}
-			if c.CreationTimestamp.Before(&oldest.CreationTimestamp) {
-				oldest = c
-			}
-		}
-
-		if request.NamespacedName.Name != oldest.Name {
-			return errors.New("only support one cluster instance")
-		}
+		return fmt.Errorf("namespace %s of topovlm cluster:%s is not equal to operator namespace:%s", request.Namespace, request.NamespacedName.Name, r.opConfig.OperatorNamespace)
 	}
 	return nil
-
 }
 
+// TopolvmController reconciles the TopolvmClusters, each of them is run by a
+// clusterController of its own.
 type TopolvmController struct {
 	scheme           *runtime.Scheme
 	client           client.Client
 	context          *cluster.Context
 	opManagerContext context.Context
 	opConfig         operator.OperatorConfig
-	statusChecker    *monitor.ClusterStatusChecker
-	namespacedName   types.NamespacedName
-	cluster          *topolvmv2.TopolvmCluster
-	health           *clusterHealth
-	statusLock       sync.Mutex
-	refLock          sync.Mutex
-	ref              *metav1.OwnerReference
 	metric           chan *topolvm.Metrics
-	lvmdController   *lvmdConfigController
+	clustersLock     sync.Mutex
+	clusters         map[types.NamespacedName]*clusterController
+}
+
+type clusterController struct {
+	scheme   *runtime.Scheme
+	client   client.Client
+	context  *cluster.Context
+	opConfig operator.OperatorConfig
+	// ctx is cancelled once the cluster is gone
+	ctx            context.Context
+	cancel         context.CancelFunc
+	statusChecker  *monitor.ClusterStatusChecker
+	namespacedName types.NamespacedName
+	cluster        *topolvmv2.TopolvmCluster
+	health         *clusterHealth
+	statusLock     sync.Mutex
+	refLock        sync.Mutex
+	ref            *metav1.OwnerReference
+	// ownedNodes are the nodes the cluster prepares, conflicts maps the
+	// nodes it claims but another cluster owns to that cluster
+	ownedNodes []string
+	conflicts  map[string]string
+	// decommissioning are the nodes leaving the cluster being torn down
+	decommissioning map[string]bool
+	metric          chan *topolvm.Metrics
+	lvmdController  *lvmdConfigController
+	// inventoryController restarts the volume group jobs when the
+	// discovered devices of a node change
+	inventoryController *inventoryController
+	// nodeController restarts the volume group job of a node whose labels
+	// change the node classes selecting it
+	nodeController *nodeController
+	// rolloutController creates and updates the node plugins
+	rolloutController *rolloutController
 }
 
 type clusterHealth struct {
@@ -250,7 +348,7 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	logger.Infof("%s successfully started", controllerName)
 
 	err = c.Watch(&source.Kind{
-		Type: &topolvmv2.TopolvmCluster{TypeMeta: metav1.TypeMeta{Kind: "TopolvmCluster", APIVersion: topolvmv2.SchemeGroupVersion.String()}}}, &handler.EnqueueRequestForObject{}, predicateController())
+		Type: &topolvmv2.TopolvmCluster{TypeMeta: metav1.TypeMeta{Kind: "TopolvmCluster", APIVersion: topolvmv2.SchemeGroupVersion.String()}}}, enqueueClusters(mgr.GetClient()), predicateController())
 	if err != nil {
 		return err
 	}
@@ -258,20 +356,34 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	return nil
 }
 
-func (r *TopolvmController) cleanCluster() error {
+func (r *clusterController) cleanCluster(nodes []string) error {
 
-	err := cleanLvmdConfigmap(r.context.Clientset, r.namespacedName.Namespace)
+	err := cleanLvmdConfigmap(r.context.Clientset, r.namespacedName)
 	if err != nil {
 		logger.Errorf("failed to clean lvmd configmap err %v", err)
 		return err
 	}
 
-	err = RemoveNodeCapacityAnnotations(r.context.Clientset)
-	if err != nil {
-		logger.Errorf("failed to remove node capacity annotations err %v", err)
-		return errors.Wrap(err, "failed to remove node capacity annotations")
+	if err := unlabelDecommissioning(context.TODO(), r.context.Clientset, r.namespacedName.Name); err != nil {
+		return err
+	}
+
+	if len(nodes) > 0 {
+		err = RemoveNodeCapacityAnnotations(r.context.Clientset, nodes...)
+		if err != nil {
+			logger.Errorf("failed to remove node capacity annotations err %v", err)
+			return errors.Wrap(err, "failed to remove node capacity annotations")
+		}
 	}
 
+	// the csi driver is shared by the clusters
+	last, err := r.lastCluster()
+	if err != nil {
+		return err
+	}
+	if !last {
+		return nil
+	}
 	if err = csidriver.DeleteTopolvmCsiDriver(r.context.Clientset); err != nil {
 		logger.Errorf("clean csi driver failed err:%s", err.Error())
 		return err
@@ -279,30 +391,19 @@ func (r *TopolvmController) cleanCluster() error {
 	return nil
 }
 
-func (r *TopolvmController) checkStorageConfig(topolvmCluster *topolvmv2.TopolvmCluster) error {
-
-	if topolvmCluster.Spec.Storage.UseAllNodes && topolvmCluster.Spec.Storage.DeviceClasses != nil {
-		return errors.New("should not both config use all node and deviceclasses ")
-	}
-
-	if !topolvmCluster.Spec.Storage.UseAllNodes && topolvmCluster.Spec.UseAllDevices {
-		return errors.New("should not config useAllNodes false but useAllDevice true")
-	}
-
-	if topolvmCluster.Spec.UseAllDevices && topolvmCluster.Spec.Storage.Devices != nil {
-		return errors.New("should not config useAllNodes true but config storage.devices")
+// checkStorageConfig checks the rules the webhook enforces on admission, for
+// clusters admitted while the webhook was not serving.
+func (r *clusterController) checkStorageConfig(topolvmCluster *topolvmv2.TopolvmCluster) error {
+	if err := volumegroup.ValidateStorage(&topolvmCluster.Spec.Storage); err != nil {
+		return err
 	}
-
-	if topolvmCluster.Spec.Storage.UseAllNodes {
-		if topolvmCluster.Spec.Storage.VolumeGroupName == "" || topolvmCluster.Spec.Storage.ClassName == "" {
-			return errors.New("if use all nodes ,volumeGroupName and className must be define")
-		}
+	if err := r.loadOperatorSetting(); err != nil {
+		return err
 	}
-
-	return nil
+	return volumegroup.ValidateTopolvmVersion(&topolvmCluster.Spec.Storage, r.nodeTopolvmImage(topolvmCluster))
 }
 
-func (r *TopolvmController) UpdateStatus(state *topolvmv2.NodeStorageState) error {
+func (r *clusterController) UpdateStatus(state *topolvmv2.NodeStorageState) error {
 	r.statusLock.Lock()
 	defer r.statusLock.Unlock()
 	topolvmCluster := &topolvmv2.TopolvmCluster{}
@@ -322,6 +423,11 @@ func (r *TopolvmController) UpdateStatus(state *topolvmv2.NodeStorageState) erro
 	for i := 0; i < length; i++ {
 		if topolvmCluster.Status.NodeStorageStatus[i].Node == state.Node {
 			nodeFound = true
+			// the conditions are kept up by the status checker
+			if state.Conditions == nil {
+				state.Conditions = topolvmCluster.Status.NodeStorageStatus[i].Conditions
+				state.LastError = topolvmCluster.Status.NodeStorageStatus[i].LastError
+			}
 			topolvmCluster.Status.NodeStorageStatus[i] = *state
 			break
 		}
@@ -340,15 +446,42 @@ func (r *TopolvmController) UpdateStatus(state *topolvmv2.NodeStorageState) erro
 
 }
 
-func (r *TopolvmController) UseAllNodeAndDevices() bool {
-	if r.getCluster() != nil {
-		return r.getCluster().Spec.UseAllNodes
-	} else {
+// selectsDevicesOf tells whether the volume groups of the node are built from
+// the devices discovered on it.
+func (r *clusterController) selectsDevicesOf(nodeName string) bool {
+	topolvmCluster := r.getCluster()
+	if topolvmCluster == nil {
+		return false
+	}
+	if !topolvmCluster.Spec.UseAllNodes && len(topolvmCluster.Spec.NodeClasses) == 0 {
 		return false
 	}
+	return r.ownsNode(nodeName)
+}
+
+// ownsNode tells whether the cluster claims the node and no other cluster
+// owns it.
+func (r *clusterController) ownsNode(nodeName string) bool {
+	owned, _, err := r.claimedNodes()
+	if err != nil {
+		logger.Errorf("get nodes of cluster %s failed err %v", r.namespacedName.Name, err)
+		return false
+	}
+	for _, node := range owned {
+		if node == nodeName {
+			return true
+		}
+	}
+	return false
+}
+
+// usesNodeClasses tells whether the device classes are given by node classes.
+func (r *clusterController) usesNodeClasses() bool {
+	topolvmCluster := r.getCluster()
+	return topolvmCluster != nil && len(topolvmCluster.Spec.NodeClasses) > 0
 }
 
-func (r *TopolvmController) onAdd(topolvmCluster *topolvmv2.TopolvmCluster, ref *metav1.OwnerReference) error {
+func (r *clusterController) onAdd(topolvmCluster *topolvmv2.TopolvmCluster, ref *metav1.OwnerReference) error {
 	if topolvm.IsOperatorHub {
 
 		err := csidriver.CheckTopolvmCsiDriverExisting(r.context.Clientset, ref)
@@ -362,14 +495,25 @@ func (r *TopolvmController) onAdd(topolvmCluster *topolvmv2.TopolvmCluster, ref
 			return err
 		}
 	}
+	owned, conflicts, err := r.claimedNodes()
+	if err != nil {
+		return errors.Wrap(err, "get nodes of cluster failed")
+	}
+	for node, owner := range conflicts {
+		logger.Warningf("node %s claimed by cluster %s is owned by cluster %s, skip it", node, topolvmCluster.Name, owner)
+	}
 	// Start the main topolvm cluster orchestration
-	if err := r.startPrepareVolumeGroupJob(topolvmCluster, ref); err != nil {
+	if err := r.startPrepareVolumeGroupJob(topolvmCluster, ref, owned); err != nil {
 		return errors.Wrap(err, "start prepare volume group failed")
 	}
+	r.updateNodes(owned, conflicts)
 
 	if r.getCluster() == nil {
 		r.updateCluster(topolvmCluster.DeepCopy())
 		r.lvmdController.start()
+		r.inventoryController.start()
+		r.nodeController.start()
+		r.rolloutController.start()
 		err := r.startClusterMonitor()
 		if err != nil {
 			return errors.Wrap(err, "start cluster monitor failed")
@@ -377,21 +521,23 @@ func (r *TopolvmController) onAdd(topolvmCluster *topolvmv2.TopolvmCluster, ref
 	}
 
 	r.updateCluster(topolvmCluster.DeepCopy())
+	// the topolvm version or upgrade strategy may have changed
+	r.rolloutController.enqueue()
 
 	return nil
 
 }
 
-func (r *TopolvmController) startPrepareVolumeGroupJob(topolvmCluster *topolvmv2.TopolvmCluster, ref *metav1.OwnerReference) error {
+func (r *clusterController) startPrepareVolumeGroupJob(topolvmCluster *topolvmv2.TopolvmCluster, ref *metav1.OwnerReference, owned []string) error {
 
 	storage := topolvmCluster.Spec.Storage
 	// if device class not change then check if has fail class that should be recreate
-	if r.getCluster() != nil && reflect.DeepEqual(r.getCluster().DeepCopy().Spec.Storage, storage) {
+	if r.getCluster() != nil && reflect.DeepEqual(r.getCluster().DeepCopy().Spec.Storage, storage) && reflect.DeepEqual(r.getOwnedNodes(), owned) {
 		go func() {
 			for _, ele := range topolvmCluster.Status.NodeStorageStatus {
 				if len(ele.FailClasses) > 0 || len(ele.SuccessClasses) == 0 {
 					logger.Infof("node%s has fail classes recreate job again", ele.Node)
-					if err := volumegroup.MakeAndRunJob(r.context.Clientset, ele.Node, r.opConfig.Image, ref); err != nil {
+					if err := volumegroup.MakeAndRunJob(r.context.Clientset, r.namespacedName, ele.Node, r.opConfig.Image, ref); err != nil {
 						logger.Errorf("create job for node failed %s", ele.Node)
 					}
 				} else {
@@ -405,23 +551,9 @@ func (r *TopolvmController) startPrepareVolumeGroupJob(topolvmCluster *topolvmv2
 	// first should create job anyway
 	logger.Info("start make prepare volume group job")
 	go func() {
-		if topolvmCluster.Spec.UseAllNodes {
-			nodes, err := r.context.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
-			if err != nil {
-				logger.Errorf("list node failed err %v", err)
-			}
-			for _, ele := range nodes.Items {
-				if err := volumegroup.MakeAndRunJob(r.context.Clientset, ele.Name, r.opConfig.Image, ref); err != nil {
-					logger.Errorf("create job for node failed %s", ele.Name)
-				}
-			}
-		}
-
-		if storage.DeviceClasses != nil {
-			for _, ele := range storage.DeviceClasses {
-				if err := volumegroup.MakeAndRunJob(r.context.Clientset, ele.NodeName, r.opConfig.Image, ref); err != nil {
-					logger.Errorf("create job for node failed %s", ele.NodeName)
-				}
+		for _, node := range owned {
+			if err := volumegroup.MakeAndRunJob(r.context.Clientset, r.namespacedName, node, r.opConfig.Image, ref); err != nil {
+				logger.Errorf("create job for node failed %s", node)
 			}
 		}
 	}()
@@ -429,35 +561,69 @@ func (r *TopolvmController) startPrepareVolumeGroupJob(topolvmCluster *topolvmv2
 	return nil
 }
 
-func (r *TopolvmController) RestartJob(node string, ref *metav1.OwnerReference) error {
+func (r *clusterController) RestartJob(node string, ref *metav1.OwnerReference) error {
 
-	return volumegroup.MakeAndRunJob(r.context.Clientset, node, r.opConfig.Image, ref)
+	return volumegroup.MakeAndRunJob(r.context.Clientset, r.namespacedName, node, r.opConfig.Image, ref)
 }
 
-func (r *TopolvmController) updateCluster(c *topolvmv2.TopolvmCluster) {
+func (r *clusterController) updateCluster(c *topolvmv2.TopolvmCluster) {
 	r.refLock.Lock()
 	defer r.refLock.Unlock()
 	r.cluster = c
 }
 
-func (r *TopolvmController) getCluster() *topolvmv2.TopolvmCluster {
+func (r *clusterController) getCluster() *topolvmv2.TopolvmCluster {
 	r.refLock.Lock()
 	defer r.refLock.Unlock()
 	return r.cluster.DeepCopy()
 }
 
-func (r *TopolvmController) updateRef(ref *metav1.OwnerReference) {
+func (r *clusterController) updateRef(ref *metav1.OwnerReference) {
 	r.refLock.Lock()
 	defer r.refLock.Unlock()
 	r.ref = ref
 }
 
-func (r *TopolvmController) getRef() *metav1.OwnerReference {
+func (r *clusterController) getRef() *metav1.OwnerReference {
 	r.refLock.Lock()
 	defer r.refLock.Unlock()
 	return r.ref
 }
 
+func (r *clusterController) updateNodes(owned []string, conflicts map[string]string) {
+	r.refLock.Lock()
+	defer r.refLock.Unlock()
+	r.ownedNodes = owned
+	r.conflicts = conflicts
+}
+
+func (r *clusterController) getOwnedNodes() []string {
+	r.refLock.Lock()
+	defer r.refLock.Unlock()
+	return r.ownedNodes
+}
+
+func (r *clusterController) getConflicts() map[string]string {
+	r.refLock.Lock()
+	defer r.refLock.Unlock()
+	return r.conflicts
+}
+
+func (r *clusterController) updateDecommissioning(nodes []string) {
+	r.refLock.Lock()
+	defer r.refLock.Unlock()
+	r.decommissioning = make(map[string]bool)
+	for _, node := range nodes {
+		r.decommissioning[node] = true
+	}
+}
+
+func (r *clusterController) isDecommissioning(node string) bool {
+	r.refLock.Lock()
+	defer r.refLock.Unlock()
+	return r.decommissioning[node]
+}
+
 // removeFinalizer removes a finalizer
 func removeFinalizer(client client.Client, name types.NamespacedName) error {
 	topolvmCluster := &topolvmv2.TopolvmCluster{}
@@ -477,15 +643,24 @@ func removeFinalizer(client client.Client, name types.NamespacedName) error {
 	return nil
 }
 
-func RemoveNodeCapacityAnnotations(clientset kubernetes.Interface) error {
+// RemoveNodeCapacityAnnotations removes the capacity annotations of the
+// nodes, or of all nodes when none is given.
+func RemoveNodeCapacityAnnotations(clientset kubernetes.Interface, names ...string) error {
 
 	ctx := context.TODO()
 	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		errors.Wrapf(err, "failed list node")
+		return errors.Wrapf(err, "failed list node")
+	}
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[name] = true
 	}
 	nodes := nodeList.DeepCopy()
 	for index, n := range nodes.Items {
+		if len(selected) > 0 && !selected[n.Name] {
+			continue
+		}
 		for key := range n.Annotations {
 			if strings.HasPrefix(key, topolvm.CapacityKeyPrefix) {
 
@@ -512,10 +687,11 @@ func RemoveNodeCapacityAnnotations(clientset kubernetes.Interface) error {
 	return err
 }
 
-func cleanLvmdConfigmap(clientset kubernetes.Interface, namespace string) error {
+func cleanLvmdConfigmap(clientset kubernetes.Interface, cluster types.NamespacedName) error {
 
 	ctx := context.TODO()
-	cms, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", topolvm.LvmdConfigMapLabelKey, topolvm.LvmdConfigMapLabelValue)})
+	namespace := cluster.Namespace
+	cms, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: lvmdConfigMapSelector(cluster.Name)})
 	if err != nil {
 		return err
 	}
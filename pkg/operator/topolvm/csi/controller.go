@@ -2,6 +2,7 @@ package csi
 
 import (
 	"context"
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
 	"github.com/alauda/nativestor/pkg/cluster"
 	"github.com/alauda/nativestor/pkg/operator"
 	controllerutil "github.com/alauda/nativestor/pkg/operator/controller"
@@ -166,6 +167,13 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for TopolvmClusters, the topolvm-controller runs their topolvmVersion and certsSecret
+	err = c.Watch(&source.Kind{
+		Type: &topolvmv2.TopolvmCluster{TypeMeta: metav1.TypeMeta{Kind: "TopolvmCluster", APIVersion: topolvmv2.GroupVersion.String()}}}, &handler.EnqueueRequestForObject{}, predicateCluster())
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -17,6 +17,7 @@ limitations under the License.
 package csi
 
 import (
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
 	"github.com/alauda/nativestor/pkg/operator"
 	"regexp"
 
@@ -65,6 +66,34 @@ func predicateController() predicate.Funcs {
 	}
 }
 
+// predicateCluster is the predicate function to trigger reconcile on the clusters settings of the topolvm-controller change
+func predicateCluster() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			_, ok := e.Object.(*topolvmv2.TopolvmCluster)
+			return ok
+		},
+
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if old, ok := e.ObjectOld.(*topolvmv2.TopolvmCluster); ok {
+				if new, ok := e.ObjectNew.(*topolvmv2.TopolvmCluster); ok {
+					return old.Spec.TopolvmVersion != new.Spec.TopolvmVersion || old.Spec.CertsSecret != new.Spec.CertsSecret
+				}
+			}
+			return false
+		},
+
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			_, ok := e.Object.(*topolvmv2.TopolvmCluster)
+			return ok
+		},
+
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
 func findCSIChange(str string) bool {
 	var re = regexp.MustCompile(`\"TOPOLVM_|\"CSI_|\"KUBELET_.`)
 	found := re.FindAllString(str, -1)
@@ -3,6 +3,7 @@ package csi
 import (
 	"context"
 	_ "embed"
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
 	"github.com/alauda/nativestor/pkg/cluster/topolvm"
 	"github.com/alauda/nativestor/pkg/operator/csi"
 	"github.com/alauda/nativestor/pkg/operator/k8sutil"
@@ -13,6 +14,7 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"strconv"
 )
 
@@ -36,7 +38,7 @@ const (
 	// default provisioner replicas
 	defaultProvisionerReplicas int32 = 2
 
-	csiTopolvmProvisioner = "topolvm-controller"
+	csiTopolvmProvisioner = topolvm.TopolvmControllerDeploymentName
 )
 
 var (
@@ -69,6 +71,13 @@ func (r *CSITopolvmController) startDrivers(ver *version.Info, ownerInfo *k8suti
 		Param:     CSIParam,
 		Namespace: r.opConfig.OperatorNamespace,
 	}
+	cluster, err := r.sharedCluster()
+	if err != nil {
+		return err
+	}
+	if tp.TopolvmImage, err = r.controllerImage(); err != nil {
+		return err
+	}
 
 	// default value `system-node-critical` is the highest available priority
 	tp.PluginPriorityClassName = k8sutil.GetValue(r.opConfig.Parameters, "CSI_PLUGIN_PRIORITY_CLASSNAME", "")
@@ -144,7 +153,7 @@ func (r *CSITopolvmController) startDrivers(ver *version.Info, ownerInfo *k8suti
 		topolvmProvisioner.Spec.Strategy = apps.DeploymentStrategy{
 			Type: apps.RecreateDeploymentStrategyType,
 		}
-		err = r.addCertForProvisioner(topolvmProvisioner)
+		err = r.addCertForProvisioner(topolvmProvisioner, cluster)
 		if err != nil {
 			return errors.Wrapf(err, "failed to add init continer that generats cert")
 		}
@@ -237,16 +246,77 @@ func (r *CSITopolvmController) deleteCSIDriverResources(ver *version.Info, deplo
 	return succeeded
 }
 
-func (r *CSITopolvmController) addCertForProvisioner(provisioner *apps.Deployment) error {
+// sharedCluster returns the oldest cluster, the topolvm-controller is shared
+// by all clusters and the webhook makes them agree on its certsSecret.
+func (r *CSITopolvmController) sharedCluster() (*topolvmv2.TopolvmCluster, error) {
+	clusters := &topolvmv2.TopolvmClusterList{}
+	if err := r.client.List(context.TODO(), clusters, client.InNamespace(r.opConfig.OperatorNamespace)); err != nil {
+		return nil, errors.Wrap(err, "failed to list topolvm cluster")
+	}
+	var oldest *topolvmv2.TopolvmCluster
+	for index, c := range clusters.Items {
+		if oldest == nil || c.CreationTimestamp.Before(&oldest.CreationTimestamp) {
+			oldest = &clusters.Items[index]
+		}
+	}
+	return oldest, nil
+}
+
+// controllerImage returns the topolvm image of the topolvm-controller, the
+// newest one the node plugins of the clusters run. The controller is thus
+// upgraded along with the first cluster, before its nodes are rolled out.
+// Clusters without topolvmVersion run TOPOLVM_IMAGE, images not tagged with
+// a version are only taken when no cluster runs one which is.
+func (r *CSITopolvmController) controllerImage() (string, error) {
+	clusters := &topolvmv2.TopolvmClusterList{}
+	if err := r.client.List(context.TODO(), clusters, client.InNamespace(r.opConfig.OperatorNamespace)); err != nil {
+		return "", errors.Wrap(err, "failed to list topolvm cluster")
+	}
+	images := []string{}
+	for _, c := range clusters.Items {
+		if c.Spec.TopolvmVersion != "" {
+			images = append(images, c.Spec.TopolvmVersion)
+		} else {
+			images = append(images, CSIParam.TopolvmImage)
+		}
+	}
+	return newestImage(images, CSIParam.TopolvmImage), nil
+}
+
+// newestImage returns the image tagged with the highest version, the first
+// image if none is tagged with a version, or def if there is none.
+func newestImage(images []string, def string) string {
+	if len(images) == 0 {
+		return def
+	}
+	image := images[0]
+	newest, _ := k8sutil.ImageVersion(image)
+	for _, candidate := range images[1:] {
+		v, err := k8sutil.ImageVersion(candidate)
+		if err != nil {
+			continue
+		}
+		if newest == nil || newest.LessThan(v) {
+			image, newest = candidate, v
+		}
+	}
+	return image
+}
+
+func (r *CSITopolvmController) addCertForProvisioner(provisioner *apps.Deployment, cluster *topolvmv2.TopolvmCluster) error {
 	iContainers := []corev1.Container{}
 
 	certsFound := false
-	if topolvm.CertsSecret != "" {
-		if _, err := r.context.Clientset.CoreV1().Secrets(topolvm.NameSpace).Get(context.TODO(), topolvm.CertsSecret, metav1.GetOptions{}); err != nil {
+	certsSecret := ""
+	if cluster != nil {
+		certsSecret = cluster.Spec.CertsSecret
+	}
+	if certsSecret != "" {
+		if _, err := r.context.Clientset.CoreV1().Secrets(r.opConfig.OperatorNamespace).Get(context.TODO(), certsSecret, metav1.GetOptions{}); err != nil {
 			if k8serrors.IsNotFound(err) {
-				logger.Errorf("Secret %s provided in cluster CRD not found", topolvm.CertsSecret)
+				logger.Errorf("Secret %s provided in cluster CRD not found", certsSecret)
 			} else {
-				logger.Errorf("Unexpected error trying to locate secret %q: %v", topolvm.CertsSecret, err)
+				logger.Errorf("Unexpected error trying to locate secret %q: %v", certsSecret, err)
 			}
 			return err
 		} else {
@@ -255,8 +325,8 @@ func (r *CSITopolvmController) addCertForProvisioner(provisioner *apps.Deploymen
 	}
 
 	if certsFound {
-		provisioner.Spec.Template.Spec.Volumes = append(provisioner.Spec.Template.Spec.Volumes, corev1.Volume{Name: "certs", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: topolvm.CertsSecret}}})
-		logger.Infof("topolvm-controller will be deployed using the certificate provided in secret %s", topolvm.CertsSecret)
+		provisioner.Spec.Template.Spec.Volumes = append(provisioner.Spec.Template.Spec.Volumes, corev1.Volume{Name: "certs", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: certsSecret}}})
+		logger.Infof("topolvm-controller will be deployed using the certificate provided in secret %s", certsSecret)
 	} else {
 		provisioner.Spec.Template.Spec.Volumes = append(provisioner.Spec.Template.Spec.Volumes, corev1.Volume{Name: "certs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
 		iContainers = append(iContainers, *getInitContainer())
@@ -0,0 +1,15 @@
+package csi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewestImage(t *testing.T) {
+	assert.Equal(t, "topolvm:0.10", newestImage(nil, "topolvm:0.10"))
+	assert.Equal(t, "topolvm:0.11.0", newestImage([]string{"topolvm:0.10.2", "topolvm:0.11.0", "topolvm:0.10.6"}, "topolvm:0.10"))
+	// images not tagged with a version lose to those which are
+	assert.Equal(t, "topolvm:0.10.2", newestImage([]string{"topolvm:latest", "topolvm:0.10.2"}, "topolvm:0.10"))
+	assert.Equal(t, "topolvm:latest", newestImage([]string{"topolvm:latest", "topolvm:dev"}, "topolvm:0.10"))
+}
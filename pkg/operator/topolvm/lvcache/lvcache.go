@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvcache
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/util/exec"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
+)
+
+var logger = capnslog.NewPackageLogger("topolvm/operator", "lvcache")
+
+const (
+	// cacheVolumeSuffix names the cache volume of a logical volume until it
+	// is attached, lvm renames it then
+	cacheVolumeSuffix = "_cache"
+
+	segTypeCache      = "cache"
+	segTypeWritecache = "writecache"
+)
+
+// Cacher attaches a cache on the cache devices to every volume of the cached
+// classes of the node, and exports the cache statistics.
+type Cacher struct {
+	executor   exec.Executor
+	configPath string
+	interval   time.Duration
+	// lock keeps lvmd off the volume group while the cache devices are
+	// allocatable
+	lock func(vgname string) (func(), error)
+}
+
+func NewCacher(executor exec.Executor, configPath string, interval time.Duration) *Cacher {
+	return &Cacher{executor: executor, configPath: configPath, interval: interval, lock: sys.LockVolumeGroup}
+}
+
+// Run caches the volumes every interval until the context is done.
+func (c *Cacher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.cacheVolumes(); err != nil {
+			logger.Errorf("cache volumes failed %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// loadClasses reads the cached classes the volume group job wrote, the file
+// is missing while the node has none.
+func (c *Cacher) loadClasses() ([]topolvm.CacheClass, error) {
+	data, err := ioutil.ReadFile(c.configPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var classes []topolvm.CacheClass
+	if err := json.Unmarshal(data, &classes); err != nil {
+		return nil, errors.Wrapf(err, "parse %s failed", c.configPath)
+	}
+	return classes, nil
+}
+
+func (c *Cacher) cacheVolumes() error {
+	classes, err := c.loadClasses()
+	if err != nil {
+		return err
+	}
+	resetMetrics()
+	for i := range classes {
+		if err := c.cacheClass(&classes[i]); err != nil {
+			cacheErrors.WithLabelValues(classes[i].Name).Inc()
+			logger.Errorf("cache volumes of class %s failed %v", classes[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Cacher) cacheClass(class *topolvm.CacheClass) error {
+	volumes, err := sys.GetLogicalVolumes(c.executor, class.VolumeGroup)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, lv := range volumes {
+		names[lv.Name] = true
+	}
+
+	for _, lv := range volumes {
+		switch {
+		case lv.SegType == segTypeCache || lv.SegType == segTypeWritecache:
+			exportVolume(class, &lv)
+			continue
+		case strings.HasSuffix(lv.Name, cacheVolumeSuffix):
+			continue
+		case lv.SegType != "linear" && lv.SegType != "striped":
+			// thin pools, raid and the like are left alone
+			continue
+		}
+		if err := c.attachCache(class, &lv, names[lv.Name+cacheVolumeSuffix]); err != nil {
+			return errors.Wrapf(err, "cache lv %s/%s failed", class.VolumeGroup, lv.Name)
+		}
+		logger.Infof("cache of lv %s/%s attached", class.VolumeGroup, lv.Name)
+	}
+	return nil
+}
+
+// attachCache creates the cache volume of the logical volume on the cache
+// devices, unless a failed attempt left it, and attaches it.
+func (c *Cacher) attachCache(class *topolvm.CacheClass, lv *sys.LogicalVolume, created bool) error {
+	cacheVolume := lv.Name + cacheVolumeSuffix
+	if !created {
+		size := lv.Size * uint64(class.SizePercent) / 100
+		if err := sys.CreateCacheVolume(c.executor, c.lock, class.VolumeGroup, cacheVolume, size, class.Devices); err != nil {
+			return err
+		}
+	}
+	return sys.AttachCacheVolume(c.executor, class.VolumeGroup, lv.Name, cacheVolume, class.Type, class.Mode)
+}
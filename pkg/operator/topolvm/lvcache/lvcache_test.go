@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvcache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	exectest "github.com/alauda/nativestor/pkg/util/exec/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheClass(t *testing.T) {
+	lvs := "  LVM2_LV_NAME='pvc-1' LVM2_LV_SIZE='10737418240' LVM2_SEGTYPE='linear' LVM2_CACHE_READ_HITS='' LVM2_CACHE_READ_MISSES='' LVM2_CACHE_WRITE_HITS='' LVM2_CACHE_WRITE_MISSES=''\n" +
+		"  LVM2_LV_NAME='pvc-2' LVM2_LV_SIZE='10737418240' LVM2_SEGTYPE='cache' LVM2_CACHE_READ_HITS='30' LVM2_CACHE_READ_MISSES='10' LVM2_CACHE_WRITE_HITS='50' LVM2_CACHE_WRITE_MISSES='10'\n" +
+		"  LVM2_LV_NAME='pvc-3' LVM2_LV_SIZE='10737418240' LVM2_SEGTYPE='linear' LVM2_CACHE_READ_HITS='' LVM2_CACHE_READ_MISSES='' LVM2_CACHE_WRITE_HITS='' LVM2_CACHE_WRITE_MISSES=''\n" +
+		"  LVM2_LV_NAME='pvc-3_cache' LVM2_LV_SIZE='1073741824' LVM2_SEGTYPE='linear' LVM2_CACHE_READ_HITS='' LVM2_CACHE_READ_MISSES='' LVM2_CACHE_WRITE_HITS='' LVM2_CACHE_WRITE_MISSES=''\n"
+	var commands []string
+	executor := &exectest.MockExecutor{
+		MockExecuteCommandWithOutput: func(command string, arg ...string) (string, error) {
+			return lvs, nil
+		},
+		MockExecuteCommand: func(command string, arg ...string) error {
+			commands = append(commands, strings.Join(arg[7:], " "))
+			return nil
+		},
+	}
+	c := NewCacher(executor, "", 0)
+	c.lock = func(vgname string) (func(), error) {
+		commands = append(commands, "lock "+vgname)
+		return func() { commands = append(commands, "unlock "+vgname) }, nil
+	}
+	class := &topolvm.CacheClass{Name: "hdd", VolumeGroup: "hdd-vg", Type: "cache", Mode: "writeback", SizePercent: 10, Devices: []string{"/dev/nvme0n1"}}
+
+	resetMetrics()
+	assert.NoError(t, c.cacheClass(class))
+	assert.Equal(t, []string{
+		// lvmd waits while the cache devices are allocatable
+		"lock hdd-vg",
+		"lvm pvchange --nolocking -x y /dev/nvme0n1",
+		"lvm lvcreate --nolocking -y -n pvc-1_cache -L 1073741824b hdd-vg /dev/nvme0n1",
+		"lvm pvchange --nolocking -x n /dev/nvme0n1",
+		"unlock hdd-vg",
+		"lvm lvconvert -y --type cache --cachevol pvc-1_cache --cachemode writeback hdd-vg/pvc-1",
+		// the cache volume left by a failed attempt is attached as is
+		"lvm lvconvert -y --type cache --cachevol pvc-3_cache --cachemode writeback hdd-vg/pvc-3",
+	}, commands)
+
+	assert.Equal(t, 0.8, testutil.ToFloat64(hitRatio.WithLabelValues("hdd", "hdd-vg", "pvc-2")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(cachedVolumes.WithLabelValues("hdd", "cache")))
+}
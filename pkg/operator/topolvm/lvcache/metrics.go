@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvcache
+
+import (
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "nativestor"
+	metricsSubsystem = "lvcache"
+)
+
+var (
+	volumeLabels = []string{"device_class", "volume_group", "logical_volume"}
+
+	readHits      = newVolumeGauge("read_hits", "Reads of the volume served by dm-cache since activation.")
+	readMisses    = newVolumeGauge("read_misses", "Reads of the volume dm-cache missed since activation.")
+	writeHits     = newVolumeGauge("write_hits", "Writes of the volume served by dm-cache since activation.")
+	writeMisses   = newVolumeGauge("write_misses", "Writes of the volume dm-cache missed since activation.")
+	hitRatio      = newVolumeGauge("hit_ratio", "Share of the reads and writes of the volume served by dm-cache.")
+	cachedVolumes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cached_volumes",
+		Help:      "Number of cached volumes of the device class by cache type.",
+	}, []string{"device_class", "type"})
+	cacheErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "errors_total",
+		Help:      "Number of failed passes caching the volumes of the device class.",
+	}, []string{"device_class"})
+
+	volumeGauges = []*prometheus.GaugeVec{readHits, readMisses, writeHits, writeMisses, hitRatio, cachedVolumes}
+)
+
+func newVolumeGauge(name, help string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      name,
+		Help:      help,
+	}, volumeLabels)
+}
+
+// RegisterMetrics registers the cache metrics into registry.
+func RegisterMetrics(registry prometheus.Registerer) error {
+	for _, g := range volumeGauges {
+		if err := registry.Register(g); err != nil {
+			return err
+		}
+	}
+	return registry.Register(cacheErrors)
+}
+
+// resetMetrics drops the volumes of the last pass, they may be gone.
+func resetMetrics() {
+	for _, g := range volumeGauges {
+		g.Reset()
+	}
+}
+
+// exportVolume exports the statistics of a cached volume, lvm only reports
+// them for dm-cache.
+func exportVolume(class *topolvm.CacheClass, lv *sys.LogicalVolume) {
+	cachedVolumes.WithLabelValues(class.Name, lv.SegType).Inc()
+	if lv.SegType != segTypeCache {
+		return
+	}
+	labels := []string{class.Name, class.VolumeGroup, lv.Name}
+	readHits.WithLabelValues(labels...).Set(float64(lv.CacheReadHits))
+	readMisses.WithLabelValues(labels...).Set(float64(lv.CacheReadMisses))
+	writeHits.WithLabelValues(labels...).Set(float64(lv.CacheWriteHits))
+	writeMisses.WithLabelValues(labels...).Set(float64(lv.CacheWriteMisses))
+	hits := lv.CacheReadHits + lv.CacheWriteHits
+	if total := hits + lv.CacheReadMisses + lv.CacheWriteMisses; total > 0 {
+		hitRatio.WithLabelValues(labels...).Set(float64(hits) / float64(total))
+	}
+}
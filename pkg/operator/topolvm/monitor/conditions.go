@@ -0,0 +1,226 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/volumegroup"
+	appsv1 "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	reasonJobRunning        = "JobRunning"
+	reasonJobFailed         = "JobFailed"
+	reasonClassesFailed     = "ClassesFailed"
+	reasonPrepared          = "Prepared"
+	reasonPending           = "Pending"
+	reasonNotCreated        = "NotCreated"
+	reasonRollingOut        = "RollingOut"
+	reasonRolledOut         = "RolledOut"
+	reasonNodesReady        = "NodesReady"
+	reasonNoNodeReady       = "NoNodeReady"
+	reasonNodesNotReady     = "NodesNotReady"
+	reasonAsExpected        = "AsExpected"
+	reasonReconciling       = "Reconciling"
+	reasonPreparingVGs      = "PreparingVolumeGroups"
+	reasonRollingOutPlugins = "RollingOutNodePlugins"
+	reasonReconciled        = "Reconciled"
+	reasonRolloutPaused     = "RolloutPaused"
+	reasonDecommissioning   = "DecommissioningNodes"
+)
+
+// updateNodeConditions sets the conditions of a node from its volume group job
+// and node plugin deployment.
+func (c *ClusterStatusChecker) updateNodeConditions(ctx context.Context, state *topolvmv2.NodeStorageState, generation int64) {
+
+	var job *batch.Job
+	j, err := c.context.Clientset.BatchV1().Jobs(c.nameSpace.Namespace).Get(ctx, volumegroup.JobName(state.Node), metav1.GetOptions{})
+	if err == nil {
+		job = j
+	} else if !kerrors.IsNotFound(err) {
+		logger.Errorf("get volume group job of node %s failed %v", state.Node, err)
+	}
+	vgCondition := volumeGroupsCondition(job, state)
+	vgCondition.ObservedGeneration = generation
+	meta.SetStatusCondition(&state.Conditions, vgCondition)
+
+	var deployment *appsv1.Deployment
+	name := k8sutil.TruncateNodeName(topolvm.TopolvmNodeDeploymentFmt, state.Node)
+	d, err := c.context.Clientset.AppsV1().Deployments(c.nameSpace.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		deployment = d
+	} else if !kerrors.IsNotFound(err) {
+		logger.Errorf("get node plugin deployment of node %s failed %v", state.Node, err)
+	}
+	pluginCondition := nodePluginCondition(deployment)
+	pluginCondition.ObservedGeneration = generation
+	meta.SetStatusCondition(&state.Conditions, pluginCondition)
+
+	if vgCondition.Reason == reasonJobFailed || vgCondition.Reason == reasonClassesFailed {
+		state.LastError = vgCondition.Message
+	} else if vgCondition.Status == metav1.ConditionTrue && pluginCondition.Status == metav1.ConditionTrue {
+		state.LastError = ""
+	}
+}
+
+// volumeGroupsCondition is the VolumeGroupsReady condition of a node, job is
+// nil when the node has no volume group job.
+func volumeGroupsCondition(job *batch.Job, state *topolvmv2.NodeStorageState) metav1.Condition {
+
+	condition := metav1.Condition{Type: topolvmv2.NodeVolumeGroupsReady, Status: metav1.ConditionFalse}
+	switch {
+	case job != nil && job.Status.Active > 0:
+		condition.Reason = reasonJobRunning
+		condition.Message = fmt.Sprintf("job %s is preparing the volume groups", job.Name)
+	case job != nil && job.Status.Failed > 0 && job.Status.Succeeded == 0:
+		condition.Reason = reasonJobFailed
+		condition.Message = fmt.Sprintf("job %s failed", job.Name)
+		for _, c := range job.Status.Conditions {
+			if c.Type == batch.JobFailed && c.Message != "" {
+				condition.Message = fmt.Sprintf("job %s failed: %s", job.Name, c.Message)
+			}
+		}
+	case len(state.FailClasses) > 0:
+		condition.Reason = reasonClassesFailed
+		messages := make([]string, 0, len(state.FailClasses))
+		for _, class := range state.FailClasses {
+			messages = append(messages, fmt.Sprintf("class %s: %s", class.Name, class.Message))
+		}
+		condition.Message = strings.Join(messages, "; ")
+	case len(state.SuccessClasses) > 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasonPrepared
+		condition.Message = fmt.Sprintf("%d classes are ready", len(state.SuccessClasses))
+	default:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = reasonPending
+		condition.Message = "volume groups are not prepared yet"
+	}
+	return condition
+}
+
+// nodePluginCondition is the NodePluginReady condition of a node, deployment
+// is nil when the node plugin is not created.
+func nodePluginCondition(deployment *appsv1.Deployment) metav1.Condition {
+
+	condition := metav1.Condition{Type: topolvmv2.NodePluginReady, Status: metav1.ConditionFalse}
+	if deployment == nil {
+		condition.Reason = reasonNotCreated
+		condition.Message = "node plugin is not created"
+		return condition
+	}
+
+	if !k8sutil.DeploymentRolledOut(deployment) {
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		status := deployment.Status
+		condition.Reason = reasonRollingOut
+		condition.Message = fmt.Sprintf("%d of %d pods of %s are updated and available", status.AvailableReplicas, replicas, deployment.Name)
+		return condition
+	}
+	condition.Status = metav1.ConditionTrue
+	condition.Reason = reasonRolledOut
+	condition.Message = fmt.Sprintf("%s is rolled out", deployment.Name)
+	return condition
+}
+
+// updateClusterConditions sets the Available, Progressing and Degraded
+// conditions of the cluster from the states of its nodes, the nodes being
+// decommissioned only count as progressing.
+func updateClusterConditions(status *topolvmv2.TopolvmClusterStatus, generation int64) {
+
+	// the nodes being decommissioned lose their node plugin on purpose
+	var decommissioning []string
+	leaving := make(map[string]bool)
+	for _, n := range status.DecommissioningNodes {
+		decommissioning = append(decommissioning, n.Node)
+		leaving[n.Node] = true
+	}
+
+	var ready, notReady, preparing, rollingOut []string
+	for _, n := range status.NodeStorageStatus {
+		if leaving[n.Node] {
+			continue
+		}
+		if n.Phase == topolvmv2.ConditionReady {
+			ready = append(ready, n.Node)
+		}
+		if n.Phase != topolvmv2.ConditionReady || len(n.FailClasses) > 0 {
+			notReady = append(notReady, n.Node)
+		}
+		if c := meta.FindStatusCondition(n.Conditions, topolvmv2.NodeVolumeGroupsReady); c != nil && c.Reason == reasonJobRunning {
+			preparing = append(preparing, n.Node)
+		}
+		if c := meta.FindStatusCondition(n.Conditions, topolvmv2.NodePluginReady); c != nil && c.Reason == reasonRollingOut {
+			rollingOut = append(rollingOut, n.Node)
+		}
+	}
+	sort.Strings(ready)
+	sort.Strings(notReady)
+	sort.Strings(preparing)
+	sort.Strings(rollingOut)
+
+	available := metav1.Condition{Type: topolvmv2.ClusterAvailable, ObservedGeneration: generation}
+	if status.Phase == topolvmv2.ConditionReady {
+		available.Status = metav1.ConditionTrue
+		available.Reason = reasonNodesReady
+		available.Message = fmt.Sprintf("nodes %s serve volumes", strings.Join(ready, ","))
+	} else {
+		available.Status = metav1.ConditionFalse
+		available.Reason = reasonNoNodeReady
+		available.Message = "no node serves volumes"
+	}
+	meta.SetStatusCondition(&status.Conditions, available)
+
+	rollout := status.NodePluginRollout
+	degraded := metav1.Condition{Type: topolvmv2.ClusterDegraded, ObservedGeneration: generation}
+	if rollout != nil && rollout.Phase == topolvmv2.RolloutPaused {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = reasonRolloutPaused
+		degraded.Message = rollout.Message
+	} else if len(notReady) > 0 {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = reasonNodesNotReady
+		degraded.Message = fmt.Sprintf("nodes %s are not ready or have failed classes", strings.Join(notReady, ","))
+	} else {
+		degraded.Status = metav1.ConditionFalse
+		degraded.Reason = reasonAsExpected
+		degraded.Message = "all nodes are ready"
+	}
+	meta.SetStatusCondition(&status.Conditions, degraded)
+
+	progressing := metav1.Condition{Type: topolvmv2.ClusterProgressing, Status: metav1.ConditionTrue, ObservedGeneration: generation}
+	switch {
+	case status.ObservedGeneration != generation:
+		progressing.Reason = reasonReconciling
+		progressing.Message = fmt.Sprintf("generation %d is not reconciled yet", generation)
+	case len(preparing) > 0:
+		progressing.Reason = reasonPreparingVGs
+		progressing.Message = fmt.Sprintf("volume groups of nodes %s are being prepared", strings.Join(preparing, ","))
+	case rollout != nil && rollout.Phase == topolvmv2.RolloutProgressing:
+		progressing.Reason = reasonRollingOutPlugins
+		progressing.Message = rollout.Message
+	case len(rollingOut) > 0:
+		progressing.Reason = reasonRollingOutPlugins
+		progressing.Message = fmt.Sprintf("node plugins of nodes %s are rolling out", strings.Join(rollingOut, ","))
+	case len(decommissioning) > 0:
+		progressing.Reason = reasonDecommissioning
+		progressing.Message = fmt.Sprintf("nodes %s are being decommissioned", strings.Join(decommissioning, ","))
+	default:
+		progressing.Status = metav1.ConditionFalse
+		progressing.Reason = reasonReconciled
+		progressing.Message = "cluster is reconciled"
+	}
+	meta.SetStatusCondition(&status.Conditions, progressing)
+}
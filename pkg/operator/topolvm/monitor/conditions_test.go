@@ -0,0 +1,124 @@
+package monitor
+
+import (
+	"testing"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVolumeGroupsCondition(t *testing.T) {
+	state := &topolvmv2.NodeStorageState{Node: "node1"}
+	c := volumeGroupsCondition(nil, state)
+	assert.Equal(t, metav1.ConditionUnknown, c.Status)
+	assert.Equal(t, reasonPending, c.Reason)
+
+	running := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job1"}, Status: batch.JobStatus{Active: 1}}
+	c = volumeGroupsCondition(running, state)
+	assert.Equal(t, metav1.ConditionFalse, c.Status)
+	assert.Equal(t, reasonJobRunning, c.Reason)
+
+	failed := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job1"}, Status: batch.JobStatus{
+		Failed:     1,
+		Conditions: []batch.JobCondition{{Type: batch.JobFailed, Message: "backoff limit exceeded"}},
+	}}
+	c = volumeGroupsCondition(failed, state)
+	assert.Equal(t, reasonJobFailed, c.Reason)
+	assert.Equal(t, "job job1 failed: backoff limit exceeded", c.Message)
+
+	done := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "job1"}, Status: batch.JobStatus{Succeeded: 1}}
+	state.FailClasses = []topolvmv2.ClassState{{Name: "hdd", Message: "no available devices"}}
+	c = volumeGroupsCondition(done, state)
+	assert.Equal(t, reasonClassesFailed, c.Reason)
+	assert.Equal(t, "class hdd: no available devices", c.Message)
+
+	state.FailClasses = nil
+	state.SuccessClasses = []topolvmv2.ClassState{{Name: "ssd", State: topolvmv2.ClassReady}}
+	c = volumeGroupsCondition(done, state)
+	assert.Equal(t, metav1.ConditionTrue, c.Status)
+	assert.Equal(t, reasonPrepared, c.Reason)
+}
+
+func TestNodePluginCondition(t *testing.T) {
+	c := nodePluginCondition(nil)
+	assert.Equal(t, metav1.ConditionFalse, c.Status)
+	assert.Equal(t, reasonNotCreated, c.Reason)
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "topolvm-node-node1", Generation: 2},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+	c = nodePluginCondition(deployment)
+	assert.Equal(t, reasonRollingOut, c.Reason)
+
+	deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 1}
+	c = nodePluginCondition(deployment)
+	assert.Equal(t, reasonRollingOut, c.Reason)
+
+	deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	c = nodePluginCondition(deployment)
+	assert.Equal(t, metav1.ConditionTrue, c.Status)
+	assert.Equal(t, reasonRolledOut, c.Reason)
+}
+
+func TestUpdateClusterConditions(t *testing.T) {
+	status := &topolvmv2.TopolvmClusterStatus{
+		Phase:              topolvmv2.ConditionReady,
+		ObservedGeneration: 3,
+		NodeStorageStatus: []topolvmv2.NodeStorageState{
+			{
+				Node:       "node1",
+				Phase:      topolvmv2.ConditionReady,
+				Conditions: []metav1.Condition{{Type: topolvmv2.NodePluginReady, Status: metav1.ConditionTrue, Reason: reasonRolledOut}},
+			},
+			{
+				Node:       "node2",
+				Phase:      topolvmv2.ConditionUnknown,
+				Conditions: []metav1.Condition{{Type: topolvmv2.NodeVolumeGroupsReady, Status: metav1.ConditionFalse, Reason: reasonJobRunning}},
+			},
+		},
+	}
+	updateClusterConditions(status, 3)
+	assert.True(t, meta.IsStatusConditionTrue(status.Conditions, topolvmv2.ClusterAvailable))
+	assert.True(t, meta.IsStatusConditionTrue(status.Conditions, topolvmv2.ClusterDegraded))
+	progressing := meta.FindStatusCondition(status.Conditions, topolvmv2.ClusterProgressing)
+	assert.Equal(t, metav1.ConditionTrue, progressing.Status)
+	assert.Equal(t, reasonPreparingVGs, progressing.Reason)
+
+	updateClusterConditions(status, 4)
+	progressing = meta.FindStatusCondition(status.Conditions, topolvmv2.ClusterProgressing)
+	assert.Equal(t, reasonReconciling, progressing.Reason)
+
+	status.NodeStorageStatus = status.NodeStorageStatus[:1]
+	updateClusterConditions(status, 3)
+	assert.True(t, meta.IsStatusConditionFalse(status.Conditions, topolvmv2.ClusterDegraded))
+	assert.True(t, meta.IsStatusConditionFalse(status.Conditions, topolvmv2.ClusterProgressing))
+
+	status.NodePluginRollout = &topolvmv2.NodePluginRollout{Phase: topolvmv2.RolloutProgressing, Message: "1 of 2 node plugins are updated"}
+	updateClusterConditions(status, 3)
+	progressing = meta.FindStatusCondition(status.Conditions, topolvmv2.ClusterProgressing)
+	assert.Equal(t, reasonRollingOutPlugins, progressing.Reason)
+	assert.Equal(t, "1 of 2 node plugins are updated", progressing.Message)
+
+	status.NodePluginRollout.Phase = topolvmv2.RolloutPaused
+	updateClusterConditions(status, 3)
+	degraded := meta.FindStatusCondition(status.Conditions, topolvmv2.ClusterDegraded)
+	assert.Equal(t, metav1.ConditionTrue, degraded.Status)
+	assert.Equal(t, reasonRolloutPaused, degraded.Reason)
+
+	// a node being decommissioned is not degraded
+	status.NodePluginRollout = nil
+	status.NodeStorageStatus = append(status.NodeStorageStatus, topolvmv2.NodeStorageState{Node: "node3", Phase: topolvmv2.ConditionUnknown})
+	status.DecommissioningNodes = []topolvmv2.NodeDecommission{{Node: "node3", Phase: topolvmv2.DecommissionDraining, LogicalVolumes: 1}}
+	updateClusterConditions(status, 3)
+	assert.True(t, meta.IsStatusConditionFalse(status.Conditions, topolvmv2.ClusterDegraded))
+	progressing = meta.FindStatusCondition(status.Conditions, topolvmv2.ClusterProgressing)
+	assert.Equal(t, reasonDecommissioning, progressing.Reason)
+	assert.Equal(t, "nodes node3 are being decommissioned", progressing.Message)
+}
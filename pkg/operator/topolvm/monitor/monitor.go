@@ -3,7 +3,6 @@ package monitor
 import (
 	"bytes"
 	"fmt"
-	"github.com/alauda/nativestor/pkg/cluster/topolvm"
 
 	"github.com/alauda/nativestor/pkg/operator/k8sutil"
 	"github.com/pkg/errors"
@@ -19,11 +18,11 @@ const (
 	port               = "metrics"
 )
 
-func EnableServiceMonitor() error {
+func EnableServiceMonitor(namespace string) error {
 	serviceMonitor := monitoringv1.ServiceMonitor{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      serviceMonitorName,
-			Namespace: topolvm.NameSpace,
+			Namespace: namespace,
 			Labels: map[string]string{
 				"prometheus": "kube-prometheus",
 			},
@@ -33,7 +32,7 @@ func EnableServiceMonitor() error {
 				{Interval: interval, Path: path, Port: port},
 			},
 			NamespaceSelector: monitoringv1.NamespaceSelector{
-				MatchNames: []string{topolvm.NameSpace},
+				MatchNames: []string{namespace},
 			},
 			Selector: metav1.LabelSelector{
 				MatchLabels: map[string]string{
@@ -48,13 +47,13 @@ func EnableServiceMonitor() error {
 	return nil
 }
 
-func CreateOrUpdatePrometheusRule() error {
+func CreateOrUpdatePrometheusRule(namespace string) error {
 	var rule monitoringv1.PrometheusRule
 	err := k8sYAML.NewYAMLOrJSONDecoder(bytes.NewBufferString(PrometheusRule), 1000).Decode(&rule)
 	if err != nil {
 		return fmt.Errorf("prometheusRules could not be decoded. %v", err)
 	}
-	rule.Namespace = topolvm.NameSpace
+	rule.Namespace = namespace
 	_, err = k8sutil.CreateOrUpdatePrometheusRule(&rule)
 	return err
 }
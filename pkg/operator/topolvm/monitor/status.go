@@ -54,11 +54,11 @@ func (c *ClusterStatusChecker) CheckClusterStatus() {
 
 		case <-time.After(c.interval):
 			c.checkStatus()
-			if err := EnableServiceMonitor(); err != nil {
+			if err := EnableServiceMonitor(c.nameSpace.Namespace); err != nil {
 				logger.Errorf("monitor failed err %s", err.Error())
 			}
 
-			if err := CreateOrUpdatePrometheusRule(); err != nil {
+			if err := CreateOrUpdatePrometheusRule(c.nameSpace.Namespace); err != nil {
 				logger.Errorf("create rule failed err %s", err.Error())
 			}
 		}
@@ -69,7 +69,7 @@ func (c *ClusterStatusChecker) checkStatus() {
 
 	logger.Info("check and update topolvm cluster status")
 	ctx := context.TODO()
-	pods, err := c.context.Clientset.CoreV1().Pods(topolvm.NameSpace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", topolvm.TopolvmComposeAttr, topolvm.TopolvmComposeNode)})
+	pods, err := c.context.Clientset.CoreV1().Pods(c.nameSpace.Namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", topolvm.TopolvmComposeAttr, topolvm.TopolvmComposeNode)})
 	if err != nil && !kerrors.IsNotFound(err) {
 		logger.Errorf("list topolvm node pod  failed %v", err)
 	}
@@ -91,9 +91,10 @@ func (c *ClusterStatusChecker) checkStatus() {
 	nodesStatus := make(map[string]*topolvmv2.NodeStorageState)
 	nodes := make([]string, 0)
 
-	if topolvmCluster.Spec.UseAllNodes {
+	// nodes selected by labels are the ones a volume group job ran on
+	if topolvmCluster.Spec.UseAllNodes || len(topolvmCluster.Spec.NodeClasses) > 0 {
 
-		cms, err := c.context.Clientset.CoreV1().ConfigMaps(topolvm.NameSpace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", topolvm.LvmdConfigMapLabelKey, topolvm.LvmdConfigMapLabelValue)})
+		cms, err := c.context.Clientset.CoreV1().ConfigMaps(c.nameSpace.Namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s,%s=%s", topolvm.LvmdConfigMapLabelKey, topolvm.LvmdConfigMapLabelValue, topolvm.ClusterAttr, c.nameSpace.Name)})
 		if err != nil && !kerrors.IsNotFound(err) {
 			logger.Errorf("list lvmd configmap failed %v", err)
 		}
@@ -243,6 +244,11 @@ func (c *ClusterStatusChecker) checkStatus() {
 		clusterStatus.Phase = topolvmv2.ConditionFailure
 	}
 
+	for index := range clusterStatus.NodeStorageStatus {
+		c.updateNodeConditions(ctx, &clusterStatus.NodeStorageStatus[index], topolvmCluster.Generation)
+	}
+	updateClusterConditions(clusterStatus, topolvmCluster.Generation)
+
 	if reflect.DeepEqual(topolvmCluster.Status, *clusterStatus) {
 		logger.Debugf("no need to update cluster status")
 		return
@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rollout
+
+import (
+	"sort"
+	"time"
+
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Node is the state of the node plugin of a node
+type Node struct {
+	Name string
+	// Updated is set when the node plugin has the desired revision
+	Updated bool
+	// Healthy is set when the node plugin is rolled out and a pod created
+	// since its update is ready and registered in the CSINode of the node
+	Healthy bool
+	// UpdatedAt is when the node plugin was updated to its revision
+	UpdatedAt time.Time
+}
+
+// Plan is the next step of a rollout
+type Plan struct {
+	// Update are the nodes to update now
+	Update []string
+	// Updating are the updated nodes which are not healthy yet
+	Updating []string
+	// Failed are the updated nodes not healthy past the node timeout, they
+	// pause the rollout
+	Failed []string
+	// Updated is the number of updated nodes which are healthy
+	Updated int
+}
+
+// Paused tells whether the rollout waits for failed nodes.
+func (p *Plan) Paused() bool {
+	return len(p.Failed) > 0
+}
+
+// Complete tells whether every node is updated and healthy.
+func (p *Plan) Complete(total int) bool {
+	return p.Updated == total
+}
+
+// MaxUnavailable is the number of nodes which may be unavailable out of
+// total, a percentage is rounded down but allows at least one node.
+func MaxUnavailable(value *intstr.IntOrString, total int) (int, error) {
+	if value == nil {
+		return topolvm.DefaultMaxUnavailable, nil
+	}
+	n, err := intstr.GetScaledValueFromIntOrPercent(value, total, false)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid max unavailable %s", value.String())
+	}
+	if n < 0 {
+		return 0, errors.Errorf("invalid max unavailable %s: must not be negative", value.String())
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n, nil
+}
+
+// Next plans the next step of a rollout. Outdated nodes whose node plugin is
+// unhealthy anyway are updated at once, healthy ones in the order of their
+// names while fewer than maxUnavailable nodes are unavailable. Nothing is
+// updated while the rollout is paused.
+func Next(nodes []Node, maxUnavailable int, timeout time.Duration, now time.Time) Plan {
+
+	sorted := append([]Node(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	plan := Plan{}
+	var unhealthy, outdated []string
+	for _, n := range sorted {
+		switch {
+		case n.Updated && n.Healthy:
+			plan.Updated++
+		case n.Updated && now.Sub(n.UpdatedAt) > timeout:
+			plan.Failed = append(plan.Failed, n.Name)
+		case n.Updated:
+			plan.Updating = append(plan.Updating, n.Name)
+		case !n.Healthy:
+			unhealthy = append(unhealthy, n.Name)
+		default:
+			outdated = append(outdated, n.Name)
+		}
+	}
+	if plan.Paused() {
+		return plan
+	}
+
+	plan.Update = unhealthy
+	budget := maxUnavailable - len(plan.Updating) - len(unhealthy)
+	for i := 0; i < budget && i < len(outdated); i++ {
+		plan.Update = append(plan.Update, outdated[i])
+	}
+	return plan
+}
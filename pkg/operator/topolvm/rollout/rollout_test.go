@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rollout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestMaxUnavailable(t *testing.T) {
+	n, err := MaxUnavailable(nil, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	value := intstr.FromInt(3)
+	n, err = MaxUnavailable(&value, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	value = intstr.FromString("25%")
+	n, err = MaxUnavailable(&value, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// a rollout always makes progress
+	n, err = MaxUnavailable(&value, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	value = intstr.FromString("many")
+	_, err = MaxUnavailable(&value, 10)
+	assert.Error(t, err)
+
+	value = intstr.FromInt(-1)
+	_, err = MaxUnavailable(&value, 10)
+	assert.Error(t, err)
+}
+
+func TestNext(t *testing.T) {
+	now := time.Now()
+	timeout := 10 * time.Minute
+
+	// healthy outdated nodes go in batches, in the order of their names
+	plan := Next([]Node{
+		{Name: "node3", Healthy: true},
+		{Name: "node1", Healthy: true},
+		{Name: "node2", Healthy: true},
+	}, 2, timeout, now)
+	assert.Equal(t, []string{"node1", "node2"}, plan.Update)
+	assert.False(t, plan.Paused())
+
+	// nodes being updated take from the batch
+	plan = Next([]Node{
+		{Name: "node1", Updated: true, UpdatedAt: now.Add(-time.Minute)},
+		{Name: "node2", Updated: true, Healthy: true},
+		{Name: "node3", Healthy: true},
+		{Name: "node4", Healthy: true},
+	}, 2, timeout, now)
+	assert.Equal(t, []string{"node3"}, plan.Update)
+	assert.Equal(t, []string{"node1"}, plan.Updating)
+	assert.Equal(t, 1, plan.Updated)
+
+	// unhealthy outdated nodes are updated at once
+	plan = Next([]Node{
+		{Name: "node1"},
+		{Name: "node2"},
+		{Name: "node3", Healthy: true},
+	}, 1, timeout, now)
+	assert.Equal(t, []string{"node1", "node2"}, plan.Update)
+
+	// a node not healthy in time pauses the rollout
+	plan = Next([]Node{
+		{Name: "node1", Updated: true, UpdatedAt: now.Add(-time.Hour)},
+		{Name: "node2", Healthy: true},
+	}, 1, timeout, now)
+	assert.True(t, plan.Paused())
+	assert.Equal(t, []string{"node1"}, plan.Failed)
+	assert.Empty(t, plan.Update)
+
+	plan = Next([]Node{
+		{Name: "node1", Updated: true, Healthy: true},
+		{Name: "node2", Updated: true, Healthy: true},
+	}, 1, timeout, now)
+	assert.True(t, plan.Complete(2))
+	assert.Empty(t, plan.Update)
+}
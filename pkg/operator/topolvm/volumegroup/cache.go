@@ -0,0 +1,183 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"encoding/json"
+	"fmt"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	DefaultCacheSizePercent = 10
+
+	ClassCacheFail    = "cache failed"
+	ClassCacheWarning = "cache warning"
+)
+
+// validateCache checks the cache of a class along with the class settings it
+// can not be combined with.
+func validateCache(class *topolvmv2.DeviceClass) error {
+	cache := class.Cache
+	if class.Adopt || class.ThinPool != nil || class.Raid != nil {
+		return errors.New("cache can not be combined with adopt, thinPool or raid")
+	}
+	if len(cache.Devices) == 0 {
+		return errors.New("cache devices must be defined")
+	}
+	if cache.Type == topolvmv2.CacheTypeWritecache && cache.Mode != "" {
+		return errors.New("mode is not supported by writecache")
+	}
+	if cache.SizePercent > 100 {
+		return fmt.Errorf("sizePercent %d is greater than 100", cache.SizePercent)
+	}
+	for _, d := range cache.Devices {
+		if d.Type == Loop {
+			return fmt.Errorf("cache device %s is a loop device", d.Name)
+		}
+		for _, dev := range class.Device {
+			if dev.Name == d.Name {
+				return fmt.Errorf("device %s is both a device and a cache device", d.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func cacheType(cache *topolvmv2.CacheConfig) topolvmv2.CacheType {
+	if cache.Type == "" {
+		return topolvmv2.CacheTypeCache
+	}
+	return cache.Type
+}
+
+func cacheMode(cache *topolvmv2.CacheConfig) topolvmv2.CacheMode {
+	if cacheType(cache) == topolvmv2.CacheTypeWritecache {
+		return ""
+	}
+	if cache.Mode == "" {
+		return topolvmv2.CacheWritethrough
+	}
+	return cache.Mode
+}
+
+func cacheSizePercent(cache *topolvmv2.CacheConfig) uint {
+	if cache.SizePercent == 0 {
+		return DefaultCacheSizePercent
+	}
+	return cache.SizePercent
+}
+
+// classDevices returns the devices of the class along with its cache devices,
+// all of them are physical volumes of its volume group.
+func classDevices(class *topolvmv2.DeviceClass) []topolvmv2.Disk {
+	if class.Cache == nil {
+		return class.Device
+	}
+	devices := append([]topolvmv2.Disk{}, class.Device...)
+	return append(devices, class.Cache.Devices...)
+}
+
+// ensureCacheDevices adds the cache devices of the class missing from its
+// volume group and keeps lvmd from allocating volumes on them, only the
+// lvcache sidecar places caches there.
+func (c *PrePareVg) ensureCacheDevices(class *topolvmv2.DeviceClass, classState *topolvmv2.ClassState) error {
+
+	pvs, err := sys.GetPhysicalVolume(c.context.Executor, class.VgName)
+	if err != nil {
+		return errors.Wrapf(err, "list pv for vg %s failed", class.VgName)
+	}
+
+	var names []string
+	for _, d := range class.Cache.Devices {
+		names = append(names, d.Name)
+		if _, ok := pvs[d.Name]; ok {
+			continue
+		}
+		if _, ok := c.availableDisks[d.Name]; !ok {
+			return errors.Errorf("cache device %s is not available", d.Name)
+		}
+		if err := c.wipeStaleSignature(d.Name); err != nil {
+			return err
+		}
+		if err := sys.CreatePhysicalVolume(c.context.Executor, d.Name); err != nil {
+			return errors.Wrapf(err, "create pv %s failed", d.Name)
+		}
+		if err := sys.ExpandVolumeGroup(c.context.Executor, class.VgName, []string{d.Name}); err != nil {
+			return errors.Wrapf(err, "add cache device %s to vg %s failed", d.Name, class.VgName)
+		}
+		vgLogger.Infof("cache device %s added to vg %s", d.Name, class.VgName)
+		classState.DeviceStates = append(classState.DeviceStates, topolvmv2.DeviceState{Name: d.Name, State: topolvmv2.DeviceOnline})
+	}
+
+	return sys.SetPhysicalVolumesAllocatable(c.context.Executor, names, false)
+}
+
+// checkCacheClass adds the cache devices new to a created class.
+func (c *PrePareVg) checkCacheClass(class *topolvmv2.DeviceClass, sucClass map[string]*topolvmv2.ClassState) {
+
+	classState, ok := sucClass[class.VgName]
+	if !ok || class.Cache == nil {
+		return
+	}
+	if err := c.ensureCacheDevices(class, classState); err != nil {
+		vgLogger.Errorf("check cache devices of vg %s failed err %v", class.VgName, err)
+		classState.Message = ClassCacheWarning
+	}
+}
+
+// updateCacheConf writes the cached classes ready on the node for the lvcache
+// sidecar of the node plugin.
+func updateCacheConf(cm *v1.ConfigMap, classes []topolvmv2.DeviceClass, sucClass map[string]*topolvmv2.ClassState) error {
+
+	var cacheClasses []topolvm.CacheClass
+	for _, class := range classes {
+		if class.Cache == nil {
+			continue
+		}
+		if _, ok := sucClass[class.VgName]; !ok {
+			continue
+		}
+		cacheClass := topolvm.CacheClass{
+			Name:        class.ClassName,
+			VolumeGroup: class.VgName,
+			Type:        string(cacheType(class.Cache)),
+			Mode:        string(cacheMode(class.Cache)),
+			SizePercent: cacheSizePercent(class.Cache),
+		}
+		for _, d := range class.Cache.Devices {
+			cacheClass.Devices = append(cacheClass.Devices, d.Name)
+		}
+		cacheClasses = append(cacheClasses, cacheClass)
+	}
+
+	if len(cacheClasses) == 0 {
+		delete(cm.Data, topolvm.LvmdCacheConfigMapKey)
+		return nil
+	}
+	value, err := json.Marshal(cacheClasses)
+	if err != nil {
+		return err
+	}
+	cm.Data[topolvm.LvmdCacheConfigMapKey] = string(value)
+	return nil
+}
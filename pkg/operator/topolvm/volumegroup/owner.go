@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"sort"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ClaimsNode tells whether the storage builds volume groups on the node.
+func ClaimsNode(storage *topolvmv2.Storage, node *v1.Node) bool {
+	if storage.UseAllNodes {
+		return true
+	}
+	for _, ele := range storage.DeviceClasses {
+		if ele.NodeName == node.Name {
+			return true
+		}
+	}
+	return len(storage.NodeClasses) > 0 && SelectsNode(storage.NodeClasses, node.Labels)
+}
+
+// NodeOwners returns the clusters claiming each node, the cluster owning the
+// node comes first. A node stays with the cluster current says owns it, as
+// long as that cluster still claims it, other nodes go to the oldest cluster
+// claiming them. Clusters being deleted keep their nodes until they are gone.
+func NodeOwners(clusters []topolvmv2.TopolvmCluster, nodes []v1.Node, current map[string]string) map[string][]string {
+
+	sorted := make([]topolvmv2.TopolvmCluster, len(clusters))
+	copy(sorted, clusters)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].CreationTimestamp.Equal(&sorted[j].CreationTimestamp) {
+			return sorted[i].CreationTimestamp.Before(&sorted[j].CreationTimestamp)
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	// nodes named in device classes may join the cluster later
+	known := make(map[string]bool)
+	for _, node := range nodes {
+		known[node.Name] = true
+	}
+	nodes = append([]v1.Node{}, nodes...)
+	for _, c := range sorted {
+		for _, ele := range c.Spec.DeviceClasses {
+			if !known[ele.NodeName] {
+				known[ele.NodeName] = true
+				node := v1.Node{}
+				node.Name = ele.NodeName
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	owners := make(map[string][]string)
+	for index := range nodes {
+		node := &nodes[index]
+		var claiming []string
+		for _, c := range sorted {
+			if ClaimsNode(&c.Spec.Storage, node) {
+				claiming = append(claiming, c.Name)
+			}
+		}
+		if len(claiming) == 0 {
+			continue
+		}
+		for i, name := range claiming {
+			if name == current[node.Name] {
+				claiming = append([]string{name}, append(claiming[:i:i], claiming[i+1:]...)...)
+				break
+			}
+		}
+		owners[node.Name] = claiming
+	}
+	return owners
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"testing"
+	"time"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeOwners(t *testing.T) {
+	created := time.Now()
+	older := topolvmv2.TopolvmCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool-a", CreationTimestamp: metav1.NewTime(created)},
+		Spec: topolvmv2.TopolvmClusterSpec{Storage: topolvmv2.Storage{
+			NodeClasses: []topolvmv2.NodeClassSelector{{
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "a"}},
+				Classes:      []topolvmv2.SelectedDeviceClass{{ClassName: "hdd", VgName: "hdd"}},
+			}},
+		}},
+	}
+	younger := topolvmv2.TopolvmCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool-b", CreationTimestamp: metav1.NewTime(created.Add(time.Minute))},
+		Spec: topolvmv2.TopolvmClusterSpec{Storage: topolvmv2.Storage{
+			DeviceClasses: []topolvmv2.NodeDevices{{NodeName: "node1"}, {NodeName: "node2"}, {NodeName: "node4"}},
+		}},
+	}
+	nodes := []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"pool": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"pool": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{"pool": "b"}}},
+	}
+
+	owners := NodeOwners([]topolvmv2.TopolvmCluster{younger, older}, nodes, map[string]string{"node2": "pool-b", "node3": "pool-a"})
+	assert.Equal(t, map[string][]string{
+		// both claim node1, the older cluster gets it
+		"node1": {"pool-a", "pool-b"},
+		// node2 was prepared by the younger cluster already
+		"node2": {"pool-b", "pool-a"},
+		// node4 did not join yet
+		"node4": {"pool-b"},
+	}, owners)
+}
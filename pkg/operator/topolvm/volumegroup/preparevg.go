@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"strconv"
 	"strings"
 
 	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
@@ -43,6 +44,9 @@ const (
 	ClassDeleteError      = "delete error"
 	ClassCreateSuccessful = "create successful"
 	ClassCreateFail       = "create failed"
+	ClassAdoptSuccessful  = "adopt successful"
+	ClassAdoptFail        = "adopt failed"
+	PvMissing             = "physical volume missing"
 	DeviceStateError      = "error"
 	LoopCreateFailed      = "failed"
 	Loop                  = "loop"
@@ -58,15 +62,18 @@ type PrePareVg struct {
 	nodeDevices        topolvmv2.NodeDevices
 	loopsState         []topolvmv2.LoopState
 	loopMap            map[string]topolvmv2.LoopState
+	rules              *sys.DeviceRules
+	availableDisks     map[string]*sys.LocalDisk
 }
 
-func NewPrepareVg(nodeName string, nameSpace string, topolvmClusterName string, context *cluster.Context) *PrePareVg {
+func NewPrepareVg(nodeName string, nameSpace string, topolvmClusterName string, context *cluster.Context, rules *sys.DeviceRules) *PrePareVg {
 	return &PrePareVg{
 		nodeName:           nodeName,
 		namespace:          nameSpace,
 		topolvmClusterName: topolvmClusterName,
 		context:            context,
 		loopMap:            make(map[string]topolvmv2.LoopState),
+		rules:              rules,
 	}
 }
 
@@ -99,7 +106,7 @@ func (c *PrePareVg) provision(topolvmCluster *topolvmv2.TopolvmCluster) error {
 		}
 	}
 
-	disks, err := sys.GetAvailableDevices(c.context)
+	disks, err := c.getAvailableDevices()
 	if err != nil {
 		vgLogger.Errorf("can not list disk err:%s", err)
 		return err
@@ -114,7 +121,7 @@ func (c *PrePareVg) provision(topolvmCluster *topolvmv2.TopolvmCluster) error {
 
 		if topolvmCluster.Spec.Storage.UseLoop {
 			checkLoopDevice(c.context.Executor, c.nodeDevices.DeviceClasses[0].Device, &c.loopsState, c.loopMap)
-			disks, err = sys.GetAvailableDevices(c.context)
+			disks, err = c.getAvailableDevices()
 			if err != nil {
 				vgLogger.Errorf("can not list disk err:%s", err)
 				return err
@@ -125,7 +132,7 @@ func (c *PrePareVg) provision(topolvmCluster *topolvmv2.TopolvmCluster) error {
 
 		if topolvmCluster.Spec.Storage.UseLoop {
 			checkLoopDevice(c.context.Executor, topolvmCluster.Spec.Devices, &c.loopsState, c.loopMap)
-			disks, err = sys.GetAvailableDevices(c.context)
+			disks, err = c.getAvailableDevices()
 			if err != nil {
 				vgLogger.Errorf("can not list disk err:%s", err)
 				return err
@@ -135,6 +142,14 @@ func (c *PrePareVg) provision(topolvmCluster *topolvmv2.TopolvmCluster) error {
 		deviceClasses := []topolvmv2.DeviceClass{deviceClass}
 		c.nodeDevices = topolvmv2.NodeDevices{NodeName: c.nodeName, DeviceClasses: deviceClasses}
 
+	} else if len(topolvmCluster.Spec.NodeClasses) > 0 {
+		deviceClasses, err := c.selectDeviceClasses(topolvmCluster.Spec.NodeClasses)
+		if err != nil {
+			vgLogger.Errorf("select device classes failed err:%v", err)
+			return err
+		}
+		c.nodeDevices = topolvmv2.NodeDevices{NodeName: c.nodeName, DeviceClasses: deviceClasses}
+
 	} else if topolvmCluster.Spec.DeviceClasses != nil {
 		for _, dev := range topolvmCluster.Spec.DeviceClasses {
 			if dev.NodeName == c.nodeName {
@@ -146,7 +161,7 @@ func (c *PrePareVg) provision(topolvmCluster *topolvmv2.TopolvmCluster) error {
 			for _, ele := range c.nodeDevices.DeviceClasses {
 				checkLoopDevice(c.context.Executor, ele.Device, &c.loopsState, c.loopMap)
 			}
-			disks, err = sys.GetAvailableDevices(c.context)
+			disks, err = c.getAvailableDevices()
 			if err != nil {
 				vgLogger.Errorf("can not list disk err:%s", err)
 				return err
@@ -188,6 +203,49 @@ func (c *PrePareVg) provision(topolvmCluster *topolvmv2.TopolvmCluster) error {
 	return c.provisionFirst(disks, nil)
 }
 
+// labelConfigMap marks the lvmd configmap as the one of the cluster, the
+// operator watches the configmaps of each cluster apart.
+func (c *PrePareVg) labelConfigMap(cm *v1.ConfigMap) {
+	if cm.Labels == nil {
+		cm.Labels = make(map[string]string)
+	}
+	cm.Labels[topolvm.ClusterAttr] = c.topolvmClusterName
+}
+
+func (c *PrePareVg) getAvailableDevices() (map[string]*sys.LocalDisk, error) {
+	disks, err := sys.GetAvailableDevicesWith(sys.NewLsblkEnumerator(c.context.Executor), c.rules)
+	if err != nil {
+		return nil, err
+	}
+	c.availableDisks = disks
+	return disks, nil
+}
+
+// wipeStaleSignature erases the signature left on a device the rules allowed
+// despite it, pvcreate refuses such devices otherwise.
+func (c *PrePareVg) wipeStaleSignature(name string) error {
+	disk, ok := c.availableDisks[name]
+	if !ok || (disk.Filesystem == "" && disk.PartitionTable == "") {
+		return nil
+	}
+	vgLogger.Warningf("wiping stale signatures of device %s, filesystem %q partition table %q", name, disk.Filesystem, disk.PartitionTable)
+	if err := sys.WipeSignatures(c.context.Executor, name); err != nil {
+		return errors.Wrapf(err, "wipe signature of device %s failed", name)
+	}
+	disk.Filesystem = ""
+	disk.PartitionTable = ""
+	return nil
+}
+
+func (c *PrePareVg) wipeStaleSignatures(disks []topolvmv2.Disk) error {
+	for _, d := range disks {
+		if err := c.wipeStaleSignature(d.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func getVgNameMap(classes []topolvmv2.ClassState) map[string]*topolvmv2.ClassState {
 
 	vgMap := make(map[string]*topolvmv2.ClassState)
@@ -217,7 +275,11 @@ func (c *PrePareVg) provisionFirst(disks map[string]*sys.LocalDisk, cm *v1.Confi
 
 	for _, dev := range c.nodeDevices.DeviceClasses {
 
-		if _, ok := vgs[dev.VgName]; ok {
+		if dev.Adopt {
+			if c.adoptVg(&dev, sucClassMap, failClassMap) {
+				sucVgs = append(sucVgs, dev)
+			}
+		} else if _, ok := vgs[dev.VgName]; ok {
 
 			failClassMap[dev.VgName] = &topolvmv2.ClassState{Name: dev.ClassName, State: topolvmv2.ClassUnReady, Message: ClassCreateFail + " vg existing"}
 
@@ -241,6 +303,7 @@ func (c *PrePareVg) provisionFirst(disks map[string]*sys.LocalDisk, cm *v1.Confi
 				Namespace: c.namespace,
 				Labels: map[string]string{
 					topolvm.LvmdConfigMapLabelKey: topolvm.LvmdConfigMapLabelValue,
+					topolvm.ClusterAttr:           c.topolvmClusterName,
 				},
 				Annotations: annotations,
 			},
@@ -249,6 +312,7 @@ func (c *PrePareVg) provisionFirst(disks map[string]*sys.LocalDisk, cm *v1.Confi
 	} else {
 
 		cmNew = cm.DeepCopy()
+		c.labelConfigMap(cmNew)
 	}
 
 	// create cm for node to notify operator to create or update node deployment and update TopolvmCluster status
@@ -263,6 +327,11 @@ func (c *PrePareVg) provisionFirst(disks map[string]*sys.LocalDisk, cm *v1.Confi
 		return errors.Wrap(err, "create lvmd conf failed")
 	}
 
+	err = updateCacheConf(cmNew, c.nodeDevices.DeviceClasses, sucClassMap)
+	if err != nil {
+		return errors.Wrap(err, "create cache conf failed")
+	}
+
 	if cm == nil {
 		if err := k8sutil.CreateOrPatchConfigmap(c.context.Clientset, cmNew); err != nil {
 			vgLogger.Errorf("create configmap failed err:+%v", err)
@@ -288,22 +357,40 @@ func (c *PrePareVg) provisionWithNodeStatus(cm *v1.ConfigMap, vgStatus string, d
 	}
 
 	newCm := cm.DeepCopy()
+	c.labelConfigMap(newCm)
 
 	sucClassMap := getVgNameMap(nodeStatus.SuccessClasses)
 	failClassMap := getVgNameMap(nodeStatus.FailClasses)
 
 	for _, dev := range c.nodeDevices.DeviceClasses {
 
+		if dev.Adopt {
+			_, adopted := sucClassMap[dev.VgName]
+			// refresh the state of an adopted vg, it is never expanded or shrunk
+			if c.adoptVg(&dev, sucClassMap, failClassMap) && !adopted {
+				sucVgs = append(sucVgs, dev)
+			}
+			continue
+		}
+
+		if state, ok := sucClassMap[dev.VgName]; ok && state.Adopted {
+			vgLogger.Warningf("vg %s was adopted, it is never expanded or shrunk", dev.VgName)
+			continue
+		}
+
 		if _, ok := sucClassMap[dev.VgName]; ok {
 			// check if need expand
 			err := c.checkVgIfExpand(&dev, sucClassMap)
 			if err != nil {
 				vgLogger.Errorf("checkVgIfExpand vg:%s failed err %v", dev.VgName, err)
 			}
+			c.checkRaidClass(&dev, sucClassMap)
+			c.checkCacheClass(&dev, sucClassMap)
 			err = c.checkVgIfShrink(&dev, sucClassMap)
 			if err != nil {
 				vgLogger.Errorf("checkVgIfShrink vg:%s failed err %v", dev.VgName, err)
 			}
+			c.checkThinPool(&dev, sucClassMap)
 			continue
 		}
 
@@ -330,6 +417,11 @@ func (c *PrePareVg) provisionWithNodeStatus(cm *v1.ConfigMap, vgStatus string, d
 		return errors.Wrap(err, "update lvmd conf failed")
 	}
 
+	err = updateCacheConf(newCm, c.nodeDevices.DeviceClasses, sucClassMap)
+	if err != nil {
+		return errors.Wrap(err, "update cache conf failed")
+	}
+
 	err = updateVgStatus(newCm, &nodeStatus, sucClassMap, failClassMap, c.loopsState)
 	if err != nil {
 		return errors.Wrap(err, "update vg status failed")
@@ -352,7 +444,10 @@ func (c *PrePareVg) checkVgIfDelete(sucClass map[string]*topolvmv2.ClassState, f
 				break
 			}
 		}
-		if !found {
+		if !found && sucClass[key].Adopted {
+			vgLogger.Infof("class of adopted vg %s is removed, keep the vg", key)
+			delete(sucClass, key)
+		} else if !found {
 			if err := sys.RemoveVolumeGroup(c.context.Executor, key); err != nil {
 				vgLogger.Errorf("remove vg %s failed err %s", key, err.Error())
 				sucClass[key].Message = ClassDeleteError
@@ -399,7 +494,11 @@ func (c *PrePareVg) checkVgIfExpand(class *topolvmv2.DeviceClass, sucClass map[s
 		}
 		if _, ok := pv[d.Name]; !ok {
 
-			if err = sys.CreatePhysicalVolume(c.context.Executor, d.Name); err != nil {
+			err = c.wipeStaleSignature(d.Name)
+			if err == nil {
+				err = sys.CreatePhysicalVolume(c.context.Executor, d.Name)
+			}
+			if err != nil {
 				sucClass[class.VgName].Message = ClassExpandWaring
 				deviceStatus := topolvmv2.DeviceState{Name: d.Name, State: DeviceStateError, Message: err.Error()}
 				sucClass[class.VgName].DeviceStates = append(sucClass[class.VgName].DeviceStates, deviceStatus)
@@ -440,7 +539,7 @@ func (c *PrePareVg) checkVgIfShrink(class *topolvmv2.DeviceClass, sucClass map[s
 
 	for pv := range pvs {
 		found := false
-		for _, d := range class.Device {
+		for _, d := range classDevices(class) {
 			if pv == d.Name {
 				found = true
 				break
@@ -461,6 +560,11 @@ func (c *PrePareVg) checkVgIfShrink(class *topolvmv2.DeviceClass, sucClass map[s
 		return nil
 	}
 
+	if len(deletePvs) > 0 && class.Raid != nil && len(pvs)-len(deletePvs) < RaidMinDevices(class.Raid) {
+		sucClass[class.VgName].Message = ClassShrinkError
+		return errors.Errorf("%s vg %s can not shrink below %d pvs", class.Raid.Type, class.VgName, RaidMinDevices(class.Raid))
+	}
+
 	if len(deletePvs) > 0 {
 		err := sys.ShrinkVolumeGroup(c.context.Executor, class.VgName, deletePvs)
 		if err != nil {
@@ -482,6 +586,42 @@ func (c *PrePareVg) checkVgIfShrink(class *topolvmv2.DeviceClass, sucClass map[s
 
 func (c *PrePareVg) createVgRetry(availaDisks map[string]*sys.LocalDisk, class *topolvmv2.DeviceClass, sucClass map[string]*topolvmv2.ClassState, failClass map[string]*topolvmv2.ClassState) bool {
 
+	// the vg was created before, only its thin pool is missing
+	if class.ThinPool != nil && strings.HasPrefix(failClass[class.VgName].Message, ClassThinPoolFail) {
+		classState := &topolvmv2.ClassState{Name: class.ClassName, VgName: class.VgName, DeviceStates: failClass[class.VgName].DeviceStates}
+		if err := c.ensureThinPool(class, classState, true); err != nil {
+			vgLogger.Errorf("create thin pool of vg %s retry failed err:%v", class.VgName, err)
+			failClass[class.VgName].Message = ClassThinPoolFail + " " + err.Error()
+			return false
+		}
+		classState.State = topolvmv2.ClassReady
+		classState.Message = ClassCreateSuccessful
+		sucClass[class.VgName] = classState
+		delete(failClass, class.VgName)
+		return true
+	}
+
+	// the vg was created before, only its cache devices are missing
+	if class.Cache != nil && strings.HasPrefix(failClass[class.VgName].Message, ClassCacheFail) {
+		classState := &topolvmv2.ClassState{Name: class.ClassName, VgName: class.VgName, DeviceStates: failClass[class.VgName].DeviceStates}
+		if err := c.ensureCacheDevices(class, classState); err != nil {
+			vgLogger.Errorf("add cache devices to vg %s retry failed err:%v", class.VgName, err)
+			failClass[class.VgName].Message = ClassCacheFail + " " + err.Error()
+			return false
+		}
+		classState.State = topolvmv2.ClassReady
+		classState.Message = ClassCreateSuccessful
+		sucClass[class.VgName] = classState
+		delete(failClass, class.VgName)
+		return true
+	}
+
+	if err := checkRaidDevices(class); err != nil {
+		vgLogger.Errorf("create vg %s retry failed err:%v", class.VgName, err)
+		failClass[class.VgName].Message = ClassRaidFail + " " + err.Error()
+		return false
+	}
+
 	available := true
 
 	for index, disk := range class.Device {
@@ -508,12 +648,33 @@ func (c *PrePareVg) createVgRetry(availaDisks map[string]*sys.LocalDisk, class *
 		}
 	}
 	if available {
+		if err := c.wipeStaleSignatures(class.Device); err != nil {
+			vgLogger.Errorf("create vg %s retry failed err:%v", class.VgName, err)
+			return false
+		}
 		if err := sys.CreateVolumeGroup(c.context.Executor, class.Device, class.VgName); err != nil {
 			vgLogger.Errorf("create vg %s retry failed err:%v", class.VgName, err)
 			return false
 		}
 		vgLogger.Infof("create vg %s retry successful", class.VgName)
-		sucClass[class.VgName] = &topolvmv2.ClassState{Name: class.ClassName, VgName: class.VgName, State: topolvmv2.ClassReady, Message: ClassCreateSuccessful}
+		classState := &topolvmv2.ClassState{Name: class.ClassName, VgName: class.VgName, State: topolvmv2.ClassReady, Message: ClassCreateSuccessful}
+		if class.ThinPool != nil {
+			if err := c.ensureThinPool(class, classState, true); err != nil {
+				vgLogger.Errorf("create thin pool of vg %s failed err:%v", class.VgName, err)
+				failClass[class.VgName].State = topolvmv2.ClassUnReady
+				failClass[class.VgName].Message = ClassThinPoolFail + " " + err.Error()
+				return false
+			}
+		}
+		if class.Cache != nil {
+			if err := c.ensureCacheDevices(class, classState); err != nil {
+				vgLogger.Errorf("add cache devices to vg %s failed err:%v", class.VgName, err)
+				failClass[class.VgName].State = topolvmv2.ClassUnReady
+				failClass[class.VgName].Message = ClassCacheFail + " " + err.Error()
+				return false
+			}
+		}
+		sucClass[class.VgName] = classState
 		delete(failClass, class.VgName)
 		return true
 	}
@@ -535,6 +696,14 @@ func (c *PrePareVg) createVg(availaDisks map[string]*sys.LocalDisk, class *topol
 
 	classState := &topolvmv2.ClassState{Name: class.ClassName, VgName: class.VgName}
 
+	if err := checkRaidDevices(class); err != nil {
+		vgLogger.Errorf("create vg %s failed err:%v", class.VgName, err)
+		classState.Message = ClassRaidFail + " " + err.Error()
+		classState.State = topolvmv2.ClassUnReady
+		failClass[class.VgName] = classState
+		return false
+	}
+
 	available := true
 
 	for index, disk := range class.Device {
@@ -557,13 +726,31 @@ func (c *PrePareVg) createVg(availaDisks map[string]*sys.LocalDisk, class *topol
 		}
 	}
 	if available {
-		if err := sys.CreateVolumeGroup(c.context.Executor, class.Device, class.VgName); err != nil {
+		err := c.wipeStaleSignatures(class.Device)
+		if err == nil {
+			err = sys.CreateVolumeGroup(c.context.Executor, class.Device, class.VgName)
+		}
+		if err != nil {
 			classState.Message = ClassCreateFail
 			classState.State = topolvmv2.ClassUnReady
 			failClass[class.VgName] = classState
 			vgLogger.Errorf("create vg %s retry failed err:%v", class.VgName, err)
 			return false
 
+		} else if class.ThinPool != nil && c.ensureThinPool(class, classState, true) != nil {
+			classState.Message = ClassThinPoolFail
+			classState.State = topolvmv2.ClassUnReady
+			failClass[class.VgName] = classState
+			vgLogger.Errorf("create thin pool of vg %s failed", class.VgName)
+			return false
+
+		} else if class.Cache != nil && c.ensureCacheDevices(class, classState) != nil {
+			classState.Message = ClassCacheFail
+			classState.State = topolvmv2.ClassUnReady
+			failClass[class.VgName] = classState
+			vgLogger.Errorf("add cache devices to vg %s failed", class.VgName)
+			return false
+
 		} else {
 			classState.State = topolvmv2.ClassReady
 			classState.Message = ClassCreateSuccessful
@@ -582,6 +769,80 @@ func (c *PrePareVg) createVg(availaDisks map[string]*sys.LocalDisk, class *topol
 
 }
 
+// adoptVg takes over a volume group created outside the operator after
+// checking it has the devices of the class, if any are given. Its physical
+// volumes are reported but never changed.
+func (c *PrePareVg) adoptVg(class *topolvmv2.DeviceClass, sucClass map[string]*topolvmv2.ClassState, failClass map[string]*topolvmv2.ClassState) bool {
+
+	classState := &topolvmv2.ClassState{Name: class.ClassName, VgName: class.VgName, Adopted: true}
+	if err := c.checkAdoptedVg(class, classState); err != nil {
+		vgLogger.Errorf("adopt vg %s failed err:%v", class.VgName, err)
+		classState.State = topolvmv2.ClassUnReady
+		classState.Message = ClassAdoptFail + " " + err.Error()
+		failClass[class.VgName] = classState
+		delete(sucClass, class.VgName)
+		return false
+	}
+	classState.State = topolvmv2.ClassReady
+	classState.Message = ClassAdoptSuccessful
+	sucClass[class.VgName] = classState
+	delete(failClass, class.VgName)
+	return true
+}
+
+func (c *PrePareVg) checkAdoptedVg(class *topolvmv2.DeviceClass, classState *topolvmv2.ClassState) error {
+
+	vgs, err := sys.GetVolumeGroups(c.context.Executor)
+	if err != nil {
+		return errors.Wrap(err, "list volume groups failed")
+	}
+	if _, ok := vgs[class.VgName]; !ok {
+		return errors.Errorf("vg %s not found", class.VgName)
+	}
+
+	pvs, err := sys.GetPhysicalVolumeStates(c.context.Executor, class.VgName)
+	if err != nil {
+		return err
+	}
+	for _, d := range class.Device {
+		found := false
+		for _, pv := range pvs {
+			if pv.Name == d.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("device %s is not a pv of vg %s", d.Name, class.VgName)
+		}
+	}
+
+	for _, pv := range pvs {
+		devStatus := topolvmv2.DeviceState{Name: pv.Name, State: topolvmv2.DeviceOnline}
+		if pv.Missing {
+			devStatus = topolvmv2.DeviceState{Name: pv.UUID, State: topolvmv2.DeviceOffline, Message: PvMissing}
+		}
+		classState.DeviceStates = append(classState.DeviceStates, devStatus)
+	}
+
+	size, err := sys.GetVolumeGroupSize(c.context.Executor, class.VgName)
+	if err != nil {
+		return errors.Wrapf(err, "get size of vg %s failed", class.VgName)
+	}
+	classState.Size = size
+
+	if class.ThinPool != nil {
+		return c.ensureThinPool(class, classState, false)
+	}
+	if class.Raid != nil {
+		if min := RaidMinDevices(class.Raid); len(pvs) < min {
+			return errors.Errorf("%s needs at least %d pvs, vg %s has %d", class.Raid.Type, min, class.VgName, len(pvs))
+		}
+		return c.checkRaid(class, classState, "")
+	}
+	return nil
+}
+
 func (c *PrePareVg) updateLvmdConf(cm *v1.ConfigMap, newVgs []topolvmv2.DeviceClass, sucClass map[string]*topolvmv2.ClassState) error {
 
 	lvmdConf := topolvm.LmvdConf{}
@@ -600,6 +861,18 @@ func (c *PrePareVg) updateLvmdConf(cm *v1.ConfigMap, newVgs []topolvmv2.DeviceCl
 			if (ele.Name == dev.ClassName) && (ele.Default != dev.Default) {
 				lvmdConf.DeviceClasses[index].Default = dev.Default
 			}
+			// the pool of a thin class stays, its overprovision ratio may change
+			if ele.Name == dev.ClassName && ele.ThinPoolConfig != nil && dev.ThinPool != nil {
+				ratio, err := strconv.ParseFloat(dev.ThinPool.OverprovisionRatio, 64)
+				if err != nil {
+					return errors.Wrapf(err, "bad overprovision ratio of class %s", dev.ClassName)
+				}
+				lvmdConf.DeviceClasses[index].ThinPoolConfig.OverprovisionRatio = ratio
+			}
+			// new volumes get the raid layout the class has now
+			if ele.Name == dev.ClassName && dev.Raid != nil && checkRaidDevices(&dev) == nil {
+				lvmdConf.DeviceClasses[index].LVCreateOptions = raidOptions(dev.Raid)
+			}
 		}
 	}
 
@@ -710,6 +983,18 @@ func convertConfig(dev *topolvmv2.DeviceClass) (*topolvm.DeviceClass, error) {
 		return nil, err
 	}
 
+	if dev.ThinPool != nil {
+		ratio, err := strconv.ParseFloat(dev.ThinPool.OverprovisionRatio, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad overprovision ratio of class %s", dev.ClassName)
+		}
+		devClass.Type = topolvm.LvmdDeviceClassTypeThin
+		devClass.ThinPoolConfig = &topolvm.ThinPoolConfig{Name: dev.ThinPool.Name, OverprovisionRatio: ratio}
+	}
+	if dev.Raid != nil {
+		devClass.LVCreateOptions = raidOptions(dev.Raid)
+	}
+
 	return devClass, nil
 
 }
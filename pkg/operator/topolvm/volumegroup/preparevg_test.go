@@ -0,0 +1,227 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"fmt"
+	"testing"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	exectest "github.com/alauda/nativestor/pkg/util/exec/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/topolvm/topolvm/lvmd"
+	v1 "k8s.io/api/core/v1"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// lvmExecutor answers lvm reports by their fields and fails on any command
+// changing a volume group.
+func lvmExecutor(t *testing.T, reports map[string]string) *exectest.MockExecutor {
+	return &exectest.MockExecutor{
+		MockExecuteCommandWithOutput: func(command string, arg ...string) (string, error) {
+			for i := range arg {
+				if arg[i] == "-o" && i+1 < len(arg) {
+					if out, ok := reports[arg[i+1]]; ok {
+						return out, nil
+					}
+				}
+			}
+			return "", fmt.Errorf("unexpected command %s %v", command, arg)
+		},
+		MockExecuteCommand: func(command string, arg ...string) error {
+			t.Errorf("unexpected command %s %v", command, arg)
+			return nil
+		},
+	}
+}
+
+func TestAdoptVg(t *testing.T) {
+	executor := lvmExecutor(t, map[string]string{
+		"vg_name": "  LVM2_VG_NAME='curated'\n  LVM2_VG_NAME='system'\n",
+		"pv_name,pv_uuid,pv_missing": "  LVM2_PV_NAME='/dev/sdb' LVM2_PV_UUID='uuid-b' LVM2_PV_MISSING=''\n" +
+			"  LVM2_PV_NAME='[unknown]' LVM2_PV_UUID='uuid-c' LVM2_PV_MISSING='missing'\n",
+		"vg_size": "  LVM2_VG_SIZE='1073741824'\n",
+	})
+	c := &PrePareVg{nodeName: "node1", context: &cluster.Context{Executor: executor}}
+
+	sucClass := make(map[string]*topolvmv2.ClassState)
+	failClass := make(map[string]*topolvmv2.ClassState)
+	class := &topolvmv2.DeviceClass{ClassName: "hdd", VgName: "curated", Adopt: true, Device: []topolvmv2.Disk{{Name: "/dev/sdb"}}}
+	assert.True(t, c.adoptVg(class, sucClass, failClass))
+
+	state := sucClass["curated"]
+	assert.True(t, state.Adopted)
+	assert.Equal(t, topolvmv2.ClassReady, state.State)
+	assert.Equal(t, uint64(1<<30), state.Size)
+	assert.Equal(t, []topolvmv2.DeviceState{
+		{Name: "/dev/sdb", State: topolvmv2.DeviceOnline},
+		{Name: "uuid-c", State: topolvmv2.DeviceOffline, Message: PvMissing},
+	}, state.DeviceStates)
+
+	// a device of the class outside the vg fails the class
+	class.Device = append(class.Device, topolvmv2.Disk{Name: "/dev/sdd"})
+	assert.False(t, c.adoptVg(class, sucClass, failClass))
+	assert.NotContains(t, sucClass, "curated")
+	assert.Equal(t, topolvmv2.ClassUnReady, failClass["curated"].State)
+
+	// so does a vg that does not exist
+	missing := &topolvmv2.DeviceClass{ClassName: "ssd", VgName: "ssd", Adopt: true}
+	assert.False(t, c.adoptVg(missing, sucClass, failClass))
+	assert.Contains(t, failClass, "ssd")
+}
+
+func TestCheckVgIfDeleteKeepsAdoptedVg(t *testing.T) {
+	c := &PrePareVg{nodeName: "node1", context: &cluster.Context{Executor: lvmExecutor(t, nil)}}
+
+	sucClass := map[string]*topolvmv2.ClassState{
+		"curated": {Name: "hdd", VgName: "curated", State: topolvmv2.ClassReady, Adopted: true},
+	}
+	c.checkVgIfDelete(sucClass, map[string]*topolvmv2.ClassState{})
+	assert.Empty(t, sucClass)
+}
+
+func TestEnsureThinPool(t *testing.T) {
+	lvs := ""
+	var commands [][]string
+	executor := &exectest.MockExecutor{
+		MockExecuteCommandWithOutput: func(command string, arg ...string) (string, error) {
+			return lvs, nil
+		},
+		MockExecuteCommand: func(command string, arg ...string) error {
+			commands = append(commands, arg[7:])
+			lvs = "  LVM2_LV_NAME='pool0' LVM2_LV_ATTR='twi-a-tz--' LVM2_LV_SIZE='966367641' LVM2_DATA_PERCENT='0.00' LVM2_METADATA_PERCENT='10.45'\n"
+			return nil
+		},
+	}
+	c := &PrePareVg{nodeName: "node1", context: &cluster.Context{Executor: executor}}
+
+	class := &topolvmv2.DeviceClass{ClassName: "thin", VgName: "thin-vg", ThinPool: &topolvmv2.ThinPoolConfig{Name: "pool0", OverprovisionRatio: "5"}}
+	state := &topolvmv2.ClassState{Name: "thin", VgName: "thin-vg"}
+
+	// an adopted vg must bring its pool
+	assert.Error(t, c.ensureThinPool(class, state, false))
+	assert.Empty(t, commands)
+
+	assert.NoError(t, c.ensureThinPool(class, state, true))
+	assert.Equal(t, [][]string{{"lvm", "lvcreate", "--type", "thin-pool", "-l", "90%VG", "-n", "pool0", "thin-vg"}}, commands)
+	assert.Equal(t, &topolvmv2.ThinPoolState{Name: "pool0", Size: 966367641, DataPercent: "0.00", MetadataPercent: "10.45"}, state.ThinPool)
+}
+
+func TestConvertThinConfig(t *testing.T) {
+	class := &topolvmv2.DeviceClass{ClassName: "thin", VgName: "thin-vg", SpareGb: 10, ThinPool: &topolvmv2.ThinPoolConfig{Name: "pool0", OverprovisionRatio: "2.5"}}
+	devClass, err := convertConfig(class)
+	assert.NoError(t, err)
+	assert.Equal(t, "thin", devClass.Type)
+	assert.Equal(t, "thin-vg", devClass.VolumeGroup)
+	assert.Equal(t, &topolvm.ThinPoolConfig{Name: "pool0", OverprovisionRatio: 2.5}, devClass.ThinPoolConfig)
+
+	class.ThinPool.OverprovisionRatio = "many"
+	_, err = convertConfig(class)
+	assert.Error(t, err)
+}
+
+func TestUpdateCacheConf(t *testing.T) {
+	cache := &topolvmv2.CacheConfig{Devices: []topolvmv2.Disk{{Name: "/dev/nvme0n1", Type: "disk"}}}
+	classes := []topolvmv2.DeviceClass{
+		{ClassName: "hdd", VgName: "hdd-vg", Device: []topolvmv2.Disk{{Name: "/dev/sdb", Type: "disk"}}, Cache: cache},
+		{ClassName: "ssd", VgName: "ssd-vg", Device: []topolvmv2.Disk{{Name: "/dev/nvme1n1", Type: "disk"}}},
+	}
+	assert.NoError(t, validateCache(&classes[0]))
+	assert.Equal(t, []topolvmv2.Disk{{Name: "/dev/sdb", Type: "disk"}, {Name: "/dev/nvme0n1", Type: "disk"}}, classDevices(&classes[0]))
+
+	cm := &v1.ConfigMap{Data: map[string]string{}}
+	sucClass := map[string]*topolvmv2.ClassState{"hdd-vg": {Name: "hdd", VgName: "hdd-vg"}, "ssd-vg": {Name: "ssd", VgName: "ssd-vg"}}
+	assert.NoError(t, updateCacheConf(cm, classes, sucClass))
+	assert.JSONEq(t, `[{"name":"hdd","volumeGroup":"hdd-vg","type":"cache","mode":"writethrough","sizePercent":10,"devices":["/dev/nvme0n1"]}]`, cm.Data[topolvm.LvmdCacheConfigMapKey])
+
+	// the key goes away with the last cached class
+	delete(sucClass, "hdd-vg")
+	assert.NoError(t, updateCacheConf(cm, classes, sucClass))
+	assert.NotContains(t, cm.Data, topolvm.LvmdCacheConfigMapKey)
+
+	cache.Type = topolvmv2.CacheTypeWritecache
+	cache.Mode = topolvmv2.CacheWriteback
+	assert.Error(t, validateCache(&classes[0]))
+	cache.Mode = ""
+	cache.Devices = classes[0].Device
+	assert.Error(t, validateCache(&classes[0]))
+}
+
+// lvmdConfig is the lvmd config of TopoLVM 0.11.0, the first one reading thin
+// classes and one reading the lvcreate-options of raid classes, copied from pkg/lvmd/cmd and lvmd of github.com/topolvm/topolvm
+// v0.11.0 since the module is pinned to v0.10.2.
+type lvmdConfig struct {
+	SocketName    string             `json:"socket-name"`
+	DeviceClasses []*lvmdDeviceClass `json:"device-classes"`
+}
+
+type lvmdDeviceClass struct {
+	Name            string              `json:"name"`
+	VolumeGroup     string              `json:"volume-group"`
+	Default         bool                `json:"default"`
+	SpareGB         *uint64             `json:"spare-gb"`
+	Stripe          *uint               `json:"stripe"`
+	StripeSize      string              `json:"stripe-size"`
+	LVCreateOptions []string            `json:"lvcreate-options"`
+	Type            string              `json:"type"`
+	ThinPoolConfig  *lvmdThinPoolConfig `json:"thin-pool"`
+}
+
+type lvmdThinPoolConfig struct {
+	Name               string  `json:"name"`
+	OverprovisionRatio float64 `json:"overprovision-ratio"`
+}
+
+// pinnedLvmdConfig is the lvmd config of the pinned TopoLVM v0.10.2.
+type pinnedLvmdConfig struct {
+	SocketName    string              `json:"socket-name"`
+	DeviceClasses []*lvmd.DeviceClass `json:"device-classes"`
+}
+
+func TestLvmdConfReadByLvmd(t *testing.T) {
+	plain := topolvmv2.DeviceClass{ClassName: "hdd", VgName: "hdd", Default: true, SpareGb: 10, Stripe: 2, StripeSize: "64"}
+	thin := topolvmv2.DeviceClass{ClassName: "thin", VgName: "thin", ThinPool: &topolvmv2.ThinPoolConfig{Name: "pool", OverprovisionRatio: "5.0"}}
+	raid := topolvmv2.DeviceClass{ClassName: "raid", VgName: "raid", Raid: &topolvmv2.RaidConfig{Type: topolvmv2.Raid10}}
+
+	cm := &v1.ConfigMap{Data: map[string]string{}}
+	assert.NoError(t, createLvmdConf(cm, []topolvmv2.DeviceClass{plain, thin, raid}))
+	conf := &lvmdConfig{}
+	assert.NoError(t, sigsyaml.UnmarshalStrict([]byte(cm.Data[topolvm.LvmdConfigMapKey]), conf))
+	assert.Equal(t, topolvm.LvmdSocketPath, conf.SocketName)
+	assert.Len(t, conf.DeviceClasses, 3)
+	assert.Equal(t, "hdd", conf.DeviceClasses[0].VolumeGroup)
+	assert.True(t, conf.DeviceClasses[0].Default)
+	assert.Equal(t, uint64(10), *conf.DeviceClasses[0].SpareGB)
+	assert.Equal(t, uint(2), *conf.DeviceClasses[0].Stripe)
+	assert.Equal(t, "64", conf.DeviceClasses[0].StripeSize)
+	assert.Equal(t, "thin", conf.DeviceClasses[1].Type)
+	assert.Equal(t, &lvmdThinPoolConfig{Name: "pool", OverprovisionRatio: 5}, conf.DeviceClasses[1].ThinPoolConfig)
+	assert.Equal(t, []string{"--type=raid10", "--mirrors=1", "--stripes=2"}, conf.DeviceClasses[2].LVCreateOptions)
+
+	// the pinned lvmd reads plain classes but would drop the thin pool and
+	// the raid layout
+	for _, class := range []topolvmv2.DeviceClass{thin, raid} {
+		cm = &v1.ConfigMap{Data: map[string]string{}}
+		assert.NoError(t, createLvmdConf(cm, []topolvmv2.DeviceClass{plain, class}))
+		assert.Error(t, sigsyaml.UnmarshalStrict([]byte(cm.Data[topolvm.LvmdConfigMapKey]), &pinnedLvmdConfig{}))
+	}
+	cm = &v1.ConfigMap{Data: map[string]string{}}
+	assert.NoError(t, createLvmdConf(cm, []topolvmv2.DeviceClass{plain}))
+	assert.NoError(t, sigsyaml.UnmarshalStrict([]byte(cm.Data[topolvm.LvmdConfigMapKey]), &pinnedLvmdConfig{}))
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"fmt"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultRaidMirrors = 1
+	DefaultRaidStripes = 2
+
+	ClassRaidFail    = "raid failed"
+	ClassRaidWarning = "raid warning"
+
+	// lvm health of a raid volume missing a device
+	raidPartial = "partial"
+	raidInSync  = "100.00"
+)
+
+// validateRaid checks the raid layout of a class along with the class
+// settings lvmd can not combine with it.
+func validateRaid(raid *topolvmv2.RaidConfig, stripe uint, stripeSize string, thinPool *topolvmv2.ThinPoolConfig) error {
+	if stripe != 0 || stripeSize != "" {
+		return errors.New("stripe and stripeSize are not supported by raid classes, set raid.stripes instead")
+	}
+	if thinPool != nil {
+		return errors.New("raid classes can not be thin provisioned")
+	}
+	switch raid.Type {
+	case topolvmv2.Raid1:
+		if raid.Stripes != 0 {
+			return errors.New("stripes is not supported by raid1")
+		}
+	case topolvmv2.Raid10:
+	case topolvmv2.Raid5:
+		if raid.Mirrors != 0 {
+			return errors.New("mirrors is not supported by raid5")
+		}
+	default:
+		return fmt.Errorf("unknown raid type %q", raid.Type)
+	}
+	if raid.Stripes == 1 {
+		return errors.New("stripes must be at least 2")
+	}
+	return nil
+}
+
+func raidMirrors(raid *topolvmv2.RaidConfig) uint {
+	if raid.Mirrors == 0 {
+		return DefaultRaidMirrors
+	}
+	return raid.Mirrors
+}
+
+func raidStripes(raid *topolvmv2.RaidConfig) uint {
+	if raid.Stripes == 0 {
+		return DefaultRaidStripes
+	}
+	return raid.Stripes
+}
+
+// RaidMinDevices returns the number of physical volumes a volume of the raid
+// layout is spread over.
+func RaidMinDevices(raid *topolvmv2.RaidConfig) int {
+	switch raid.Type {
+	case topolvmv2.Raid1:
+		return int(raidMirrors(raid) + 1)
+	case topolvmv2.Raid10:
+		return int((raidMirrors(raid) + 1) * raidStripes(raid))
+	default:
+		return int(raidStripes(raid) + 1)
+	}
+}
+
+// raidOptions returns the lvcreate options lvmd creates the volumes of the
+// raid layout with.
+func raidOptions(raid *topolvmv2.RaidConfig) []string {
+	options := []string{"--type=" + string(raid.Type)}
+	if raid.Type != topolvmv2.Raid5 {
+		options = append(options, fmt.Sprintf("--mirrors=%d", raidMirrors(raid)))
+	}
+	if raid.Type != topolvmv2.Raid1 {
+		options = append(options, fmt.Sprintf("--stripes=%d", raidStripes(raid)))
+	}
+	return options
+}
+
+// checkRaidDevices fails a raid class having too few devices for its layout.
+func checkRaidDevices(class *topolvmv2.DeviceClass) error {
+	if class.Raid == nil {
+		return nil
+	}
+	if min := RaidMinDevices(class.Raid); len(class.Device) < min {
+		return fmt.Errorf("%s needs at least %d devices, class %s has %d", class.Raid.Type, min, class.ClassName, len(class.Device))
+	}
+	return nil
+}
+
+// checkRaid reports the degraded raid volumes of the class. With a repair
+// device, the volumes missing a device are first rebuilt onto it.
+func (c *PrePareVg) checkRaid(class *topolvmv2.DeviceClass, classState *topolvmv2.ClassState, repairDevice string) error {
+
+	volumes, err := sys.GetRaidVolumes(c.context.Executor, class.VgName)
+	if err != nil {
+		return err
+	}
+
+	var repairErr error
+	if repairDevice != "" {
+		var repaired bool
+		repaired, repairErr = c.repairRaid(class.VgName, volumes, repairDevice)
+		if repaired {
+			if volumes, err = sys.GetRaidVolumes(c.context.Executor, class.VgName); err != nil {
+				return err
+			}
+		}
+	}
+
+	classState.DegradedVolumes = degradedVolumes(volumes)
+	return repairErr
+}
+
+// repairRaid rebuilds the raid volumes missing a device onto the repair
+// device, the missing physical volumes are dropped from the volume group
+// once every volume is rebuilt.
+func (c *PrePareVg) repairRaid(vgName string, volumes []sys.RaidVolume, repairDevice string) (bool, error) {
+
+	repaired := false
+	for _, v := range volumes {
+		if v.Health != raidPartial {
+			continue
+		}
+		vgLogger.Infof("repair raid volume %s/%s onto %s", vgName, v.Name, repairDevice)
+		if err := sys.RepairRaidVolume(c.context.Executor, vgName, v.Name, repairDevice); err != nil {
+			return repaired, errors.Wrapf(err, "repair raid volume %s/%s onto %s failed", vgName, v.Name, repairDevice)
+		}
+		repaired = true
+	}
+	if !repaired {
+		return false, nil
+	}
+	if err := sys.RemoveMissingPhysicalVolumes(c.context.Executor, vgName); err != nil {
+		return true, errors.Wrapf(err, "remove missing pv of vg %s failed", vgName)
+	}
+	return true, nil
+}
+
+// validateRepairDevice checks the repair device is one of the devices of the
+// raid class.
+func validateRepairDevice(class *topolvmv2.DeviceClass) error {
+	if class.Raid == nil {
+		return errors.New("only raid classes are repaired")
+	}
+	if class.Adopt {
+		return errors.New("adopted classes are never repaired")
+	}
+	for _, d := range class.Device {
+		if d.Name == class.RepairDevice {
+			return nil
+		}
+	}
+	return fmt.Errorf("device %s is not a device of the class", class.RepairDevice)
+}
+
+// repairDevice is the physical volume the raid volumes of the class are
+// rebuilt on, empty if the class has no repair device.
+func (c *PrePareVg) repairDevice(class *topolvmv2.DeviceClass) string {
+	for _, d := range class.Device {
+		if d.Name != class.RepairDevice {
+			continue
+		}
+		if d.Type == Loop && d.Auto {
+			return c.getDeviceName(d.Name)
+		}
+		return d.Name
+	}
+	return ""
+}
+
+func degradedVolumes(volumes []sys.RaidVolume) []topolvmv2.RaidVolumeState {
+	var degraded []topolvmv2.RaidVolumeState
+	for _, v := range volumes {
+		if v.Health == "" && v.SyncPercent == raidInSync {
+			continue
+		}
+		degraded = append(degraded, topolvmv2.RaidVolumeState{Name: v.Name, Health: v.Health, SyncPercent: v.SyncPercent})
+	}
+	return degraded
+}
+
+// checkRaidClass reports the raid volumes of a created class, and repairs
+// them when the class names a repair device.
+func (c *PrePareVg) checkRaidClass(class *topolvmv2.DeviceClass, sucClass map[string]*topolvmv2.ClassState) {
+
+	classState, ok := sucClass[class.VgName]
+	if !ok || class.Raid == nil {
+		return
+	}
+	if err := c.checkRaid(class, classState, c.repairDevice(class)); err != nil {
+		vgLogger.Errorf("check raid of vg %s failed err %v", class.VgName, err)
+		classState.Message = ClassRaidWarning
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"strings"
+	"testing"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster"
+	exectest "github.com/alauda/nativestor/pkg/util/exec/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRaidLayout(t *testing.T) {
+	raid1 := &topolvmv2.RaidConfig{Type: topolvmv2.Raid1}
+	assert.Equal(t, 2, RaidMinDevices(raid1))
+	assert.Equal(t, []string{"--type=raid1", "--mirrors=1"}, raidOptions(raid1))
+
+	raid10 := &topolvmv2.RaidConfig{Type: topolvmv2.Raid10, Stripes: 3}
+	assert.Equal(t, 6, RaidMinDevices(raid10))
+	assert.Equal(t, []string{"--type=raid10", "--mirrors=1", "--stripes=3"}, raidOptions(raid10))
+
+	raid5 := &topolvmv2.RaidConfig{Type: topolvmv2.Raid5}
+	assert.Equal(t, 3, RaidMinDevices(raid5))
+	assert.Equal(t, []string{"--type=raid5", "--stripes=2"}, raidOptions(raid5))
+
+	assert.NoError(t, validateRaid(raid10, 0, "", nil))
+	assert.Error(t, validateRaid(raid1, 2, "", nil))
+	assert.Error(t, validateRaid(raid5, 0, "", &topolvmv2.ThinPoolConfig{Name: "pool0"}))
+	assert.Error(t, validateRaid(&topolvmv2.RaidConfig{Type: topolvmv2.Raid5, Mirrors: 2}, 0, "", nil))
+
+	class := &topolvmv2.DeviceClass{ClassName: "mirror", VgName: "mirror-vg", Raid: raid1, Device: []topolvmv2.Disk{{Name: "/dev/sdb"}}}
+	assert.Error(t, checkRaidDevices(class))
+	class.Device = append(class.Device, topolvmv2.Disk{Name: "/dev/sdc"})
+	assert.NoError(t, checkRaidDevices(class))
+
+	class.RepairDevice = "/dev/sdd"
+	assert.EqualError(t, validateRepairDevice(class), "device /dev/sdd is not a device of the class")
+	class.RepairDevice = "/dev/sdc"
+	assert.NoError(t, validateRepairDevice(class))
+	class.Raid = nil
+	assert.EqualError(t, validateRepairDevice(class), "only raid classes are repaired")
+}
+
+func TestCheckRaidRepair(t *testing.T) {
+	lvs := "  LVM2_LV_NAME='pvc-1' LVM2_SEGTYPE='raid1' LVM2_LV_HEALTH_STATUS='partial' LVM2_SYNC_PERCENT='100.00'\n" +
+		"  LVM2_LV_NAME='pvc-2' LVM2_SEGTYPE='raid1' LVM2_LV_HEALTH_STATUS='' LVM2_SYNC_PERCENT='100.00'\n" +
+		"  LVM2_LV_NAME='scratch' LVM2_SEGTYPE='linear' LVM2_LV_HEALTH_STATUS='' LVM2_SYNC_PERCENT=''\n"
+	var commands []string
+	executor := &exectest.MockExecutor{
+		MockExecuteCommandWithOutput: func(command string, arg ...string) (string, error) {
+			return lvs, nil
+		},
+		MockExecuteCommand: func(command string, arg ...string) error {
+			commands = append(commands, strings.Join(arg[7:], " "))
+			// the repaired volume resyncs onto the replacement
+			lvs = "  LVM2_LV_NAME='pvc-1' LVM2_SEGTYPE='raid1' LVM2_LV_HEALTH_STATUS='' LVM2_SYNC_PERCENT='12.50'\n" +
+				"  LVM2_LV_NAME='pvc-2' LVM2_SEGTYPE='raid1' LVM2_LV_HEALTH_STATUS='' LVM2_SYNC_PERCENT='100.00'\n"
+			return nil
+		},
+	}
+	c := &PrePareVg{nodeName: "node1", context: &cluster.Context{Executor: executor}}
+	class := &topolvmv2.DeviceClass{ClassName: "mirror", VgName: "mirror-vg", Raid: &topolvmv2.RaidConfig{Type: topolvmv2.Raid1}}
+	state := &topolvmv2.ClassState{Name: "mirror", VgName: "mirror-vg"}
+
+	// classes without a repair device are only reported
+	sucClass := map[string]*topolvmv2.ClassState{"mirror-vg": state}
+	c.checkRaidClass(class, sucClass)
+	assert.Empty(t, commands)
+	assert.Equal(t, []topolvmv2.RaidVolumeState{{Name: "pvc-1", Health: "partial", SyncPercent: "100.00"}}, state.DegradedVolumes)
+
+	class.Device = []topolvmv2.Disk{{Name: "/dev/sdb"}, {Name: "/dev/sdd"}}
+	class.RepairDevice = "/dev/sdd"
+	c.checkRaidClass(class, sucClass)
+	assert.Equal(t, []string{"lvm lvconvert --repair -y mirror-vg/pvc-1 /dev/sdd", "lvm vgreduce --removemissing mirror-vg"}, commands)
+	assert.Equal(t, []topolvmv2.RaidVolumeState{{Name: "pvc-1", SyncPercent: "12.50"}}, state.DegradedVolumes)
+}
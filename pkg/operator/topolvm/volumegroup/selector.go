@@ -0,0 +1,325 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	rawv1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const devDir = "/dev"
+
+// ValidateNodeClasses checks the node selectors and device selectors of the
+// node classes.
+func ValidateNodeClasses(selectors []topolvmv2.NodeClassSelector) error {
+	for i, s := range selectors {
+		if s.NodeSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(s.NodeSelector); err != nil {
+				return fmt.Errorf("invalid node selector of node class %d: %v", i, err)
+			}
+		}
+		if len(s.Classes) == 0 {
+			return fmt.Errorf("node class %d has no classes", i)
+		}
+		classNames := make(map[string]bool)
+		vgNames := make(map[string]bool)
+		defaults := 0
+		for _, class := range s.Classes {
+			if class.ClassName == "" || class.VgName == "" {
+				return fmt.Errorf("className and volumeGroup of node class %d must be defined", i)
+			}
+			if classNames[class.ClassName] || vgNames[class.VgName] {
+				return fmt.Errorf("node class %d defines class %s or volume group %s twice", i, class.ClassName, class.VgName)
+			}
+			classNames[class.ClassName] = true
+			vgNames[class.VgName] = true
+			if class.Default {
+				defaults++
+			}
+			if err := validateDeviceSelector(&class.DeviceSelector); err != nil {
+				return fmt.Errorf("invalid device selector of class %s: %v", class.ClassName, err)
+			}
+			if class.ThinPool != nil {
+				if err := validateThinPool(class.ThinPool); err != nil {
+					return fmt.Errorf("invalid thin pool of class %s: %v", class.ClassName, err)
+				}
+			}
+			if class.Raid != nil {
+				if err := validateRaid(class.Raid, class.Stripe, class.StripeSize, class.ThinPool); err != nil {
+					return fmt.Errorf("invalid raid of class %s: %v", class.ClassName, err)
+				}
+			}
+			if class.Cache != nil {
+				if err := validateCache(&topolvmv2.DeviceClass{Adopt: class.Adopt, ThinPool: class.ThinPool, Raid: class.Raid, Cache: class.Cache}); err != nil {
+					return fmt.Errorf("invalid cache of class %s: %v", class.ClassName, err)
+				}
+			}
+		}
+		if defaults > 1 {
+			return fmt.Errorf("node class %d has more than one default class", i)
+		}
+	}
+	return nil
+}
+
+func validateDeviceSelector(s *topolvmv2.DeviceSelector) error {
+	for _, patterns := range [][]string{s.Paths, s.ByIDs, s.Models} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("bad pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	if s.MinSize != nil && s.MaxSize != nil && s.MinSize.Cmp(*s.MaxSize) > 0 {
+		return fmt.Errorf("minSize %s is greater than maxSize %s", s.MinSize.String(), s.MaxSize.String())
+	}
+	return nil
+}
+
+// SelectingNodeClasses returns the indexes of the node classes selecting a
+// node with the labels.
+func SelectingNodeClasses(selectors []topolvmv2.NodeClassSelector, nodeLabels map[string]string) ([]int, error) {
+	var selecting []int
+	for i, s := range selectors {
+		if s.NodeSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(s.NodeSelector)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid node selector of node class %d", i)
+			}
+			if !selector.Matches(labels.Set(nodeLabels)) {
+				continue
+			}
+		}
+		selecting = append(selecting, i)
+	}
+	return selecting, nil
+}
+
+// SelectsNode tells whether any node class selects a node with the labels.
+func SelectsNode(selectors []topolvmv2.NodeClassSelector, nodeLabels map[string]string) bool {
+	selecting, err := SelectingNodeClasses(selectors, nodeLabels)
+	if err != nil {
+		logger.Errorf("select node classes failed err %v", err)
+		return false
+	}
+	return len(selecting) > 0
+}
+
+// NodeClassVolumeGroups returns the volume groups of the classes a node with
+// the labels gets, adopted ones are left out.
+func NodeClassVolumeGroups(selectors []topolvmv2.NodeClassSelector, nodeLabels map[string]string) ([]string, error) {
+	selecting, err := SelectingNodeClasses(selectors, nodeLabels)
+	if err != nil {
+		return nil, err
+	}
+	var vgs []string
+	for _, i := range selecting {
+		for _, class := range selectors[i].Classes {
+			if class.Adopt {
+				continue
+			}
+			vgs = append(vgs, class.VgName)
+		}
+	}
+	return vgs, nil
+}
+
+// selectDeviceClasses resolves the device classes of the node from its
+// labels and its discovered devices.
+func (c *PrePareVg) selectDeviceClasses(selectors []topolvmv2.NodeClassSelector) ([]topolvmv2.DeviceClass, error) {
+	ctx := context.TODO()
+	node, err := c.context.Clientset.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get node %s failed", c.nodeName)
+	}
+	selecting, err := SelectingNodeClasses(selectors, node.Labels)
+	if err != nil {
+		return nil, err
+	}
+	// a node leaving the selectors keeps its volume groups, they may hold
+	// volumes still in use
+	if len(selecting) == 0 {
+		return nil, errors.Errorf("node %s is not selected by any node class", c.nodeName)
+	}
+
+	inventory, err := c.context.RawDeviceClientset.RawdeviceV1().NodeDeviceInventories().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get device inventory of node %s failed", c.nodeName)
+	}
+	// without devices every class would be dropped, a new job is started
+	// once discovery reports them
+	if inventory.Status.LastUpdateTime == nil {
+		return nil, errors.Errorf("devices of node %s are not discovered yet", c.nodeName)
+	}
+
+	vgs, err := sys.GetVolumeGroups(c.context.Executor)
+	if err != nil {
+		return nil, errors.Wrap(err, "list volume groups failed")
+	}
+	pvs := make(map[string][]string)
+	for _, i := range selecting {
+		for _, class := range selectors[i].Classes {
+			if _, ok := vgs[class.VgName]; !ok {
+				continue
+			}
+			vgPvs, err := sys.GetPhysicalVolume(c.context.Executor, class.VgName)
+			if err != nil {
+				return nil, errors.Wrapf(err, "list pv for vg %s failed", class.VgName)
+			}
+			for pv := range vgPvs {
+				pvs[class.VgName] = append(pvs[class.VgName], pv)
+			}
+			sort.Strings(pvs[class.VgName])
+		}
+	}
+
+	var selected []topolvmv2.NodeClassSelector
+	for _, i := range selecting {
+		selected = append(selected, selectors[i])
+	}
+	return nodeDeviceClasses(selected, inventory.Status.Devices, pvs), nil
+}
+
+// nodeDeviceClasses builds the device classes of the selected node classes.
+// A class keeps the physical volumes its volume group has and gets the
+// available devices matching its device selector, a device goes to the first
+// class matching it. Cache devices are never picked, they are kept apart
+// from the devices of their class. Classes without any device are left out,
+// and a class name given by an earlier node class wins. pvs are the physical
+// volumes of the existing volume groups.
+func nodeDeviceClasses(selectors []topolvmv2.NodeClassSelector, devices []rawv1.InventoryDevice, pvs map[string][]string) []topolvmv2.DeviceClass {
+	claimed := make(map[string]bool)
+	for _, vgPvs := range pvs {
+		for _, pv := range vgPvs {
+			claimed[pv] = true
+		}
+	}
+	cacheDevices := make(map[string]bool)
+	for _, s := range selectors {
+		for _, class := range s.Classes {
+			if class.Cache == nil {
+				continue
+			}
+			for _, d := range class.Cache.Devices {
+				claimed[d.Name] = true
+				cacheDevices[d.Name] = true
+			}
+		}
+	}
+
+	var classes []topolvmv2.DeviceClass
+	seenClasses := make(map[string]bool)
+	seenVgs := make(map[string]bool)
+	for _, s := range selectors {
+		for _, class := range s.Classes {
+			if seenClasses[class.ClassName] || seenVgs[class.VgName] {
+				vgLogger.Warningf("class %s or volume group %s is given by an earlier node class, skip it", class.ClassName, class.VgName)
+				continue
+			}
+			deviceClass := topolvmv2.DeviceClass{
+				ClassName:  class.ClassName,
+				VgName:     class.VgName,
+				Default:    class.Default,
+				SpareGb:    class.SpareGb,
+				Stripe:     class.Stripe,
+				StripeSize: class.StripeSize,
+				Adopt:      class.Adopt,
+				ThinPool:   class.ThinPool,
+				Raid:       class.Raid,
+				Cache:      class.Cache,
+			}
+			// an adopted vg keeps the devices it has
+			if class.Adopt {
+				if _, ok := pvs[class.VgName]; !ok {
+					vgLogger.Infof("vg %s of class %s to adopt not found, skip it", class.VgName, class.ClassName)
+					continue
+				}
+				seenClasses[class.ClassName] = true
+				seenVgs[class.VgName] = true
+				classes = append(classes, deviceClass)
+				continue
+			}
+			for _, pv := range pvs[class.VgName] {
+				if cacheDevices[pv] {
+					continue
+				}
+				deviceClass.Device = append(deviceClass.Device, topolvmv2.Disk{Name: pv})
+			}
+			for i := range devices {
+				d := &devices[i]
+				name := filepath.Join(devDir, d.Name)
+				if !d.Available || claimed[name] || !matchDevice(&class.DeviceSelector, d) {
+					continue
+				}
+				claimed[name] = true
+				deviceClass.Device = append(deviceClass.Device, topolvmv2.Disk{Name: name, Type: d.Type})
+			}
+			if len(deviceClass.Device) == 0 {
+				vgLogger.Infof("no device matches class %s, skip it", class.ClassName)
+				continue
+			}
+			seenClasses[class.ClassName] = true
+			seenVgs[class.VgName] = true
+			classes = append(classes, deviceClass)
+		}
+	}
+	return classes
+}
+
+// matchDevice reports whether the device matches every field of the selector.
+func matchDevice(s *topolvmv2.DeviceSelector, d *rawv1.InventoryDevice) bool {
+	if len(s.Paths) > 0 && !matchAny(s.Paths, d.RealPath, filepath.Join(devDir, d.Name)) {
+		return false
+	}
+	if len(s.ByIDs) > 0 && !matchAny(s.ByIDs, d.DevLinks...) {
+		return false
+	}
+	if s.MinSize != nil && d.Size < s.MinSize.Value() {
+		return false
+	}
+	if s.MaxSize != nil && d.Size > s.MaxSize.Value() {
+		return false
+	}
+	if s.Rotational != nil && *s.Rotational != d.Rotational {
+		return false
+	}
+	if len(s.Models) > 0 && !matchAny(s.Models, strings.TrimSpace(d.Model)) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if ok, _ := filepath.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"testing"
+
+	rawv1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testNodeClasses() []topolvmv2.NodeClassSelector {
+	rotational := false
+	minSize := resource.MustParse("100Gi")
+	return []topolvmv2.NodeClassSelector{
+		{
+			NodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "storage", Operator: metav1.LabelSelectorOpIn, Values: []string{"fast", "mixed"}},
+				},
+			},
+			Classes: []topolvmv2.SelectedDeviceClass{
+				{ClassName: "ssd", VgName: "ssd-vg", Default: true, DeviceSelector: topolvmv2.DeviceSelector{Rotational: &rotational, Paths: []string{"/dev/nvme*"}}},
+				{ClassName: "hdd", VgName: "hdd-vg", DeviceSelector: topolvmv2.DeviceSelector{MinSize: &minSize, Models: []string{"ST*"}}},
+			},
+		},
+		{
+			Classes: []topolvmv2.SelectedDeviceClass{
+				{ClassName: "any", VgName: "any-vg"},
+			},
+		},
+	}
+}
+
+func TestSelectingNodeClasses(t *testing.T) {
+	selectors := testNodeClasses()
+
+	selecting, err := SelectingNodeClasses(selectors, map[string]string{"storage": "fast"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, selecting)
+
+	selecting, err = SelectingNodeClasses(selectors, map[string]string{"storage": "none"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, selecting)
+
+	assert.NoError(t, ValidateNodeClasses(selectors))
+	selectors[0].Classes[1].VgName = "ssd-vg"
+	assert.Error(t, ValidateNodeClasses(selectors))
+	selectors[0].Classes[1].VgName = "hdd-vg"
+	selectors[0].Classes[1].DeviceSelector.Models = []string{"["}
+	assert.Error(t, ValidateNodeClasses(selectors))
+}
+
+func TestNodeDeviceClasses(t *testing.T) {
+	devices := []rawv1.InventoryDevice{
+		{Name: "nvme0n1", RealPath: "/dev/nvme0n1", Type: "disk", Size: 500 << 30, Available: true},
+		{Name: "nvme1n1", RealPath: "/dev/nvme1n1", Type: "disk", Size: 500 << 30, Available: false},
+		{Name: "sdb", RealPath: "/dev/sdb", Type: "disk", Size: 4 << 40, Rotational: true, Model: "ST4000NM ", Available: true},
+		{Name: "sdc", RealPath: "/dev/sdc", Type: "disk", Size: 50 << 30, Rotational: true, Model: "ST500", Available: true},
+		{Name: "sdd", RealPath: "/dev/sdd", Type: "disk", Size: 4 << 40, Rotational: true, Model: "ST4000NM", Available: true},
+	}
+	// sdd joined hdd-vg before
+	pvs := map[string][]string{"hdd-vg": {"/dev/sdd"}}
+
+	classes := nodeDeviceClasses(testNodeClasses(), devices, pvs)
+	assert.Len(t, classes, 3)
+
+	assert.Equal(t, "ssd", classes[0].ClassName)
+	assert.True(t, classes[0].Default)
+	assert.Equal(t, []topolvmv2.Disk{{Name: "/dev/nvme0n1", Type: "disk"}}, classes[0].Device)
+
+	assert.Equal(t, "hdd-vg", classes[1].VgName)
+	assert.Equal(t, []topolvmv2.Disk{{Name: "/dev/sdd"}, {Name: "/dev/sdb", Type: "disk"}}, classes[1].Device)
+
+	// the catch all class only gets what the earlier classes left
+	assert.Equal(t, "any", classes[2].ClassName)
+	assert.Equal(t, []topolvmv2.Disk{{Name: "/dev/sdc", Type: "disk"}}, classes[2].Device)
+
+	// classes without devices are left out
+	classes = nodeDeviceClasses(testNodeClasses()[:1], devices[1:2], nil)
+	assert.Empty(t, classes)
+
+	// a class to adopt never picks devices and needs its vg
+	adopt := []topolvmv2.NodeClassSelector{{Classes: []topolvmv2.SelectedDeviceClass{{ClassName: "curated", VgName: "curated", Adopt: true}}}}
+	assert.Empty(t, nodeDeviceClasses(adopt, devices, nil))
+	classes = nodeDeviceClasses(adopt, devices, map[string][]string{"curated": {"/dev/sde"}})
+	assert.Len(t, classes, 1)
+	assert.True(t, classes[0].Adopt)
+	assert.Empty(t, classes[0].Device)
+}
+
+func TestNodeDeviceClassesWithCache(t *testing.T) {
+	devices := []rawv1.InventoryDevice{
+		{Name: "nvme0n1", RealPath: "/dev/nvme0n1", Type: "disk", Size: 500 << 30, Available: true},
+		{Name: "sdb", RealPath: "/dev/sdb", Type: "disk", Size: 4 << 40, Rotational: true, Available: true},
+		{Name: "sdc", RealPath: "/dev/sdc", Type: "disk", Size: 4 << 40, Rotational: true, Available: true},
+	}
+	cache := &topolvmv2.CacheConfig{Devices: []topolvmv2.Disk{{Name: "/dev/nvme0n1", Type: "disk"}}}
+	selectors := []topolvmv2.NodeClassSelector{{Classes: []topolvmv2.SelectedDeviceClass{{ClassName: "hdd", VgName: "hdd-vg", Cache: cache}}}}
+	assert.NoError(t, ValidateNodeClasses(selectors))
+
+	// the cache device joined the vg along with sdb before
+	pvs := map[string][]string{"hdd-vg": {"/dev/nvme0n1", "/dev/sdb"}}
+	classes := nodeDeviceClasses(selectors, devices, pvs)
+	assert.Len(t, classes, 1)
+	assert.Equal(t, []topolvmv2.Disk{{Name: "/dev/sdb"}, {Name: "/dev/sdc", Type: "disk"}}, classes[0].Device)
+	assert.Equal(t, cache, classes[0].Cache)
+
+	// a cache does not go along with a thin pool
+	selectors[0].Classes[0].ThinPool = &topolvmv2.ThinPoolConfig{Name: "pool"}
+	assert.Error(t, ValidateNodeClasses(selectors))
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"fmt"
+	"strconv"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/util/sys"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultThinPoolSizePercent is the share of the volume group a thin pool
+	// takes when none is set, the rest is left for its metadata
+	DefaultThinPoolSizePercent = 90
+
+	ClassThinPoolFail    = "thin pool failed"
+	ClassThinPoolWarning = "thin pool warning"
+)
+
+// ValidateDeviceClasses checks the classes given per node.
+func ValidateDeviceClasses(nodes []topolvmv2.NodeDevices) error {
+	nodeNames := make(map[string]bool)
+	for _, node := range nodes {
+		if nodeNames[node.NodeName] {
+			return fmt.Errorf("node %s is listed twice", node.NodeName)
+		}
+		nodeNames[node.NodeName] = true
+		for _, class := range node.DeviceClasses {
+			if class.ThinPool != nil {
+				if err := validateThinPool(class.ThinPool); err != nil {
+					return fmt.Errorf("invalid thin pool of class %s on node %s: %v", class.ClassName, node.NodeName, err)
+				}
+			}
+			if class.Raid != nil {
+				if err := validateRaid(class.Raid, class.Stripe, class.StripeSize, class.ThinPool); err != nil {
+					return fmt.Errorf("invalid raid of class %s on node %s: %v", class.ClassName, node.NodeName, err)
+				}
+			}
+			if class.RepairDevice != "" {
+				if err := validateRepairDevice(&class); err != nil {
+					return fmt.Errorf("invalid repairDevice of class %s on node %s: %v", class.ClassName, node.NodeName, err)
+				}
+			}
+			if class.Cache != nil {
+				if err := validateCache(&class); err != nil {
+					return fmt.Errorf("invalid cache of class %s on node %s: %v", class.ClassName, node.NodeName, err)
+				}
+			}
+		}
+		if err := validateNodeDevices(&node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateThinPool(pool *topolvmv2.ThinPoolConfig) error {
+	if pool.Name == "" {
+		return errors.New("name must be defined")
+	}
+	if pool.SizePercent > 100 {
+		return fmt.Errorf("sizePercent %d is greater than 100", pool.SizePercent)
+	}
+	ratio, err := strconv.ParseFloat(pool.OverprovisionRatio, 64)
+	if err != nil {
+		return fmt.Errorf("bad overprovisionRatio %q: %v", pool.OverprovisionRatio, err)
+	}
+	if ratio < 1 {
+		return fmt.Errorf("overprovisionRatio %s is less than 1", pool.OverprovisionRatio)
+	}
+	return nil
+}
+
+func thinPoolSizePercent(pool *topolvmv2.ThinPoolConfig) uint {
+	if pool.SizePercent == 0 {
+		return DefaultThinPoolSizePercent
+	}
+	return pool.SizePercent
+}
+
+// ensureThinPool creates the thin pool of the class, or grows it along with
+// the volume group, and reports its usage. The pool of an adopted volume
+// group must exist and is never changed.
+func (c *PrePareVg) ensureThinPool(class *topolvmv2.DeviceClass, classState *topolvmv2.ClassState, manage bool) error {
+
+	pool := class.ThinPool
+	sizePercent := thinPoolSizePercent(pool)
+	thinPool, err := sys.GetThinPool(c.context.Executor, class.VgName, pool.Name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case thinPool == nil && !manage:
+		return errors.Errorf("thin pool %s not found in vg %s", pool.Name, class.VgName)
+	case thinPool == nil:
+		vgLogger.Infof("create thin pool %s in vg %s", pool.Name, class.VgName)
+		if err := sys.CreateThinPool(c.context.Executor, class.VgName, pool.Name, sizePercent); err != nil {
+			return errors.Wrapf(err, "create thin pool %s in vg %s failed", pool.Name, class.VgName)
+		}
+	case manage:
+		vgSize, err := sys.GetVolumeGroupSize(c.context.Executor, class.VgName)
+		if err != nil {
+			return err
+		}
+		extentSize, err := sys.GetVolumeGroupExtentSize(c.context.Executor, class.VgName)
+		if err != nil {
+			return err
+		}
+		// lvm rounds the pool down to whole extents
+		if vgSize*uint64(sizePercent)/100 >= thinPool.Size+extentSize {
+			vgLogger.Infof("extend thin pool %s to %d%% of vg %s", pool.Name, sizePercent, class.VgName)
+			if err := sys.ExtendThinPool(c.context.Executor, class.VgName, pool.Name, sizePercent); err != nil {
+				return errors.Wrapf(err, "extend thin pool %s in vg %s failed", pool.Name, class.VgName)
+			}
+		}
+	}
+
+	thinPool, err = sys.GetThinPool(c.context.Executor, class.VgName, pool.Name)
+	if err != nil {
+		return err
+	}
+	if thinPool == nil {
+		return errors.Errorf("thin pool %s not found in vg %s", pool.Name, class.VgName)
+	}
+	classState.ThinPool = &topolvmv2.ThinPoolState{
+		Name:            thinPool.Name,
+		Size:            thinPool.Size,
+		DataPercent:     thinPool.DataPercent,
+		MetadataPercent: thinPool.MetadataPercent,
+	}
+	return nil
+}
+
+// checkThinPool grows the thin pool of a created class along with its volume
+// group and refreshes its usage. A class only gets a thin pool when it is
+// created, its volume group may already hold thick volumes.
+func (c *PrePareVg) checkThinPool(class *topolvmv2.DeviceClass, sucClass map[string]*topolvmv2.ClassState) {
+
+	classState, ok := sucClass[class.VgName]
+	if !ok || class.ThinPool == nil {
+		return
+	}
+	if classState.ThinPool == nil {
+		vgLogger.Warningf("class %s was created without thin pool, a thin pool is only set up with a new class", class.ClassName)
+		return
+	}
+	if err := c.ensureThinPool(class, classState, true); err != nil {
+		vgLogger.Errorf("check thin pool of vg %s failed err %v", class.VgName, err)
+		classState.Message = ClassThinPoolWarning
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"fmt"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// DefaultSpareGb is the space lvmd keeps free in a volume group when the
+// class sets none
+const DefaultSpareGb = 10
+
+// DefaultStorage fills the class names, volume group names and spare sizes
+// left out, a class missing one of its names takes the other.
+func DefaultStorage(storage *topolvmv2.Storage) {
+	if storage.UseAllNodes {
+		if storage.ClassName == "" {
+			storage.ClassName = storage.VolumeGroupName
+		}
+		if storage.VolumeGroupName == "" {
+			storage.VolumeGroupName = storage.ClassName
+		}
+	}
+	for i := range storage.DeviceClasses {
+		classes := storage.DeviceClasses[i].DeviceClasses
+		for j := range classes {
+			defaultNames(&classes[j].ClassName, &classes[j].VgName)
+			if classes[j].SpareGb == 0 {
+				classes[j].SpareGb = DefaultSpareGb
+			}
+		}
+	}
+	for i := range storage.NodeClasses {
+		classes := storage.NodeClasses[i].Classes
+		for j := range classes {
+			defaultNames(&classes[j].ClassName, &classes[j].VgName)
+			if classes[j].SpareGb == 0 {
+				classes[j].SpareGb = DefaultSpareGb
+			}
+		}
+	}
+}
+
+func defaultNames(className, vgName *string) {
+	if *className == "" {
+		*className = *vgName
+	}
+	if *vgName == "" {
+		*vgName = *className
+	}
+}
+
+// ValidateStorage checks the storage of a cluster as a whole.
+func ValidateStorage(storage *topolvmv2.Storage) error {
+
+	if storage.UseAllNodes && storage.DeviceClasses != nil {
+		return errors.New("should not both config use all node and deviceclasses ")
+	}
+
+	if !storage.UseAllNodes && storage.UseAllDevices {
+		return errors.New("should not config useAllNodes false but useAllDevice true")
+	}
+
+	if storage.UseAllDevices && storage.Devices != nil {
+		return errors.New("should not config useAllNodes true but config storage.devices")
+	}
+
+	if storage.UseAllDevices && storage.DeviceClasses != nil {
+		return errors.New("should not config useAllDevices together with deviceClasses")
+	}
+
+	if storage.UseAllNodes {
+		if storage.VolumeGroupName == "" || storage.ClassName == "" {
+			return errors.New("if use all nodes ,volumeGroupName and className must be define")
+		}
+	}
+
+	if err := validateDisks(storage.Devices); err != nil {
+		return errors.Wrap(err, "invalid devices")
+	}
+
+	if len(storage.NodeClasses) > 0 {
+		if storage.UseAllNodes || storage.Devices != nil || storage.DeviceClasses != nil || storage.UseLoop {
+			return errors.New("should not config nodeClasses together with useAllNodes, devices, deviceClasses or useLoop")
+		}
+		if err := ValidateNodeClasses(storage.NodeClasses); err != nil {
+			return errors.Wrap(err, "invalid node classes")
+		}
+	}
+
+	if err := ValidateDeviceClasses(storage.DeviceClasses); err != nil {
+		return errors.Wrap(err, "invalid device classes")
+	}
+	return nil
+}
+
+const (
+	// MinThinPoolTopolvmVersion is the first TopoLVM whose lvmd reads the thin
+	// pool of a device class, older ones ignore it and create thick volumes.
+	MinThinPoolTopolvmVersion = "0.11.0"
+	// MinRaidTopolvmVersion is the first TopoLVM whose lvmd reads the
+	// lvcreate-options of a device class, which carry the raid layout. Older
+	// ones ignore them and create linear volumes.
+	MinRaidTopolvmVersion = "0.10.6"
+)
+
+// ValidateTopolvmVersion checks the topolvm image the node plugins of the
+// cluster run reads every class of the storage. lvmd ignores the fields of
+// lvmd.yaml it does not know, a class an older lvmd can not express would
+// silently be served as a plain class.
+func ValidateTopolvmVersion(storage *topolvmv2.Storage, image string) error {
+	for _, node := range storage.DeviceClasses {
+		for _, class := range node.DeviceClasses {
+			if err := validateClassVersion(class.ClassName, class.ThinPool, class.Raid, image); err != nil {
+				return err
+			}
+		}
+	}
+	for _, selector := range storage.NodeClasses {
+		for _, class := range selector.Classes {
+			if err := validateClassVersion(class.ClassName, class.ThinPool, class.Raid, image); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateClassVersion(className string, pool *topolvmv2.ThinPoolConfig, raid *topolvmv2.RaidConfig, image string) error {
+	if pool != nil {
+		if err := requireTopolvmVersion(image, MinThinPoolTopolvmVersion); err != nil {
+			return errors.Wrapf(err, "thin pool of class %s", className)
+		}
+	}
+	if raid != nil {
+		if err := requireTopolvmVersion(image, MinRaidTopolvmVersion); err != nil {
+			return errors.Wrapf(err, "raid of class %s", className)
+		}
+	}
+	return nil
+}
+
+// requireTopolvmVersion fails unless the tag of the topolvm image is the
+// given version or a newer one.
+func requireTopolvmVersion(image, min string) error {
+	current, err := k8sutil.ImageVersion(image)
+	if err != nil {
+		return fmt.Errorf("topolvm image %s must be tagged with its version, topolvm %s or newer is needed", image, min)
+	}
+	if current.LessThan(version.MustParseGeneric(min)) {
+		return fmt.Errorf("topolvm image %s is older than %s", image, min)
+	}
+	return nil
+}
+
+// validateNodeDevices checks the names, default and devices of the classes of
+// a node, a device may only be used by one class.
+func validateNodeDevices(node *topolvmv2.NodeDevices) error {
+	if node.NodeName == "" {
+		return errors.New("nodeName must be defined")
+	}
+	classNames := make(map[string]bool)
+	vgNames := make(map[string]bool)
+	devices := make(map[string]string)
+	defaults := 0
+	for _, class := range node.DeviceClasses {
+		if class.ClassName == "" || class.VgName == "" {
+			return fmt.Errorf("className and volumeGroup of classes on node %s must be defined", node.NodeName)
+		}
+		if classNames[class.ClassName] {
+			return fmt.Errorf("node %s defines class %s twice", node.NodeName, class.ClassName)
+		}
+		if vgNames[class.VgName] {
+			return fmt.Errorf("node %s defines volume group %s twice", node.NodeName, class.VgName)
+		}
+		classNames[class.ClassName] = true
+		vgNames[class.VgName] = true
+		if class.Default {
+			defaults++
+		}
+		if err := validateDisks(class.Device); err != nil {
+			return fmt.Errorf("invalid devices of class %s on node %s: %v", class.ClassName, node.NodeName, err)
+		}
+		for _, d := range classDevices(&class) {
+			if other, ok := devices[d.Name]; ok {
+				return fmt.Errorf("device %s on node %s is used by both class %s and class %s", d.Name, node.NodeName, other, class.ClassName)
+			}
+			devices[d.Name] = class.ClassName
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("node %s has more than one default class", node.NodeName)
+	}
+	return nil
+}
+
+func validateDisks(disks []topolvmv2.Disk) error {
+	names := make(map[string]bool)
+	for _, d := range disks {
+		if d.Name == "" {
+			return errors.New("device name must be defined")
+		}
+		if names[d.Name] {
+			return fmt.Errorf("device %s is listed twice", d.Name)
+		}
+		names[d.Name] = true
+		if d.Type == Loop && d.Auto {
+			if d.Size == 0 {
+				return fmt.Errorf("loop device %s should define size", d.Name)
+			}
+			if d.Path == "" {
+				return fmt.Errorf("loop device %s should define path", d.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// ClassChange is a change of a class that takes space away from the
+// volumes in it.
+type ClassChange struct {
+	// Node is the node of the class, empty for classes of node classes
+	// which may be on any node
+	Node  string
+	Class string
+	// Default is set for default classes, which hold the volumes created
+	// without a class
+	Default bool
+	Message string
+}
+
+// ShrinkingChanges lists the classes the update removes, renames the volume
+// group of, or removes devices from. The classes of a node removed from the
+// cluster are not, the node is drained by its decommission.
+func ShrinkingChanges(old, new *topolvmv2.Storage) []ClassChange {
+	var changes []ClassChange
+
+	newNodes := make(map[string]map[string]*topolvmv2.DeviceClass)
+	for i := range new.DeviceClasses {
+		classes := make(map[string]*topolvmv2.DeviceClass)
+		for j := range new.DeviceClasses[i].DeviceClasses {
+			class := &new.DeviceClasses[i].DeviceClasses[j]
+			classes[class.ClassName] = class
+		}
+		newNodes[new.DeviceClasses[i].NodeName] = classes
+	}
+	for _, node := range old.DeviceClasses {
+		if _, ok := newNodes[node.NodeName]; !ok && !new.UseAllNodes && len(new.NodeClasses) == 0 {
+			continue
+		}
+		for _, oldClass := range node.DeviceClasses {
+			newClass, ok := newNodes[node.NodeName][oldClass.ClassName]
+			if !ok {
+				changes = append(changes, ClassChange{Node: node.NodeName, Class: oldClass.ClassName, Default: oldClass.Default, Message: "class is removed"})
+				continue
+			}
+			if newClass.VgName != oldClass.VgName {
+				changes = append(changes, ClassChange{Node: node.NodeName, Class: oldClass.ClassName, Default: oldClass.Default, Message: fmt.Sprintf("volume group is changed from %s to %s", oldClass.VgName, newClass.VgName)})
+				continue
+			}
+			kept := make(map[string]bool)
+			for _, d := range classDevices(newClass) {
+				kept[d.Name] = true
+			}
+			for _, d := range classDevices(&oldClass) {
+				if !kept[d.Name] {
+					changes = append(changes, ClassChange{Node: node.NodeName, Class: oldClass.ClassName, Default: oldClass.Default, Message: fmt.Sprintf("device %s is removed", d.Name)})
+				}
+			}
+		}
+	}
+
+	newSelected := make(map[string]string)
+	for _, s := range new.NodeClasses {
+		for _, class := range s.Classes {
+			newSelected[class.ClassName] = class.VgName
+		}
+	}
+	for _, s := range old.NodeClasses {
+		for _, class := range s.Classes {
+			vgName, ok := newSelected[class.ClassName]
+			if !ok {
+				changes = append(changes, ClassChange{Class: class.ClassName, Default: class.Default, Message: "class is removed"})
+			} else if vgName != class.VgName {
+				changes = append(changes, ClassChange{Class: class.ClassName, Default: class.Default, Message: fmt.Sprintf("volume group is changed from %s to %s", class.VgName, vgName)})
+			}
+		}
+	}
+
+	kept := make(map[string]bool)
+	for _, d := range new.Devices {
+		kept[d.Name] = true
+	}
+	for _, d := range old.Devices {
+		if !kept[d.Name] {
+			changes = append(changes, ClassChange{Class: old.ClassName, Default: true, Message: fmt.Sprintf("device %s is removed", d.Name)})
+		}
+	}
+
+	if old.UseAllNodes && (!new.UseAllNodes || new.ClassName != old.ClassName || new.VolumeGroupName != old.VolumeGroupName) {
+		changes = append(changes, ClassChange{Class: old.ClassName, Default: true, Message: "class of all nodes is removed or renamed"})
+	}
+	return changes
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumegroup
+
+import (
+	"testing"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStorage() *topolvmv2.Storage {
+	return &topolvmv2.Storage{
+		DeviceClasses: []topolvmv2.NodeDevices{
+			{
+				NodeName: "node1",
+				DeviceClasses: []topolvmv2.DeviceClass{
+					{ClassName: "hdd", VgName: "hdd", Default: true, Device: []topolvmv2.Disk{{Name: "/dev/sdb", Type: "disk"}, {Name: "/dev/sdc", Type: "disk"}}},
+					{ClassName: "ssd", VgName: "ssd", Device: []topolvmv2.Disk{{Name: "/dev/nvme0n1", Type: "disk"}}},
+				},
+			},
+		},
+	}
+}
+
+func TestDefaultStorage(t *testing.T) {
+	storage := &topolvmv2.Storage{
+		DeviceClasses: []topolvmv2.NodeDevices{
+			{NodeName: "node1", DeviceClasses: []topolvmv2.DeviceClass{{ClassName: "hdd"}, {VgName: "ssd-vg", SpareGb: 5}}},
+		},
+	}
+	DefaultStorage(storage)
+	classes := storage.DeviceClasses[0].DeviceClasses
+	assert.Equal(t, "hdd", classes[0].VgName)
+	assert.Equal(t, uint64(DefaultSpareGb), classes[0].SpareGb)
+	assert.Equal(t, "ssd-vg", classes[1].ClassName)
+	assert.Equal(t, uint64(5), classes[1].SpareGb)
+
+	storage = &topolvmv2.Storage{UseAllNodes: true, VolumeGroupName: "vg"}
+	DefaultStorage(storage)
+	assert.Equal(t, "vg", storage.ClassName)
+}
+
+func TestValidateStorage(t *testing.T) {
+	assert.NoError(t, ValidateStorage(testStorage()))
+
+	storage := testStorage()
+	storage.DeviceClasses[0].DeviceClasses[1].Default = true
+	assert.EqualError(t, ValidateStorage(storage), "invalid device classes: node node1 has more than one default class")
+
+	storage = testStorage()
+	storage.DeviceClasses[0].DeviceClasses[1].VgName = "hdd"
+	assert.EqualError(t, ValidateStorage(storage), "invalid device classes: node node1 defines volume group hdd twice")
+
+	storage = testStorage()
+	storage.DeviceClasses[0].DeviceClasses[1].Device = append(storage.DeviceClasses[0].DeviceClasses[1].Device, topolvmv2.Disk{Name: "/dev/sdb", Type: "disk"})
+	assert.EqualError(t, ValidateStorage(storage), "invalid device classes: device /dev/sdb on node node1 is used by both class hdd and class ssd")
+
+	storage = testStorage()
+	storage.DeviceClasses = append(storage.DeviceClasses, storage.DeviceClasses[0])
+	assert.EqualError(t, ValidateStorage(storage), "invalid device classes: node node1 is listed twice")
+
+	storage = testStorage()
+	storage.UseAllDevices = true
+	assert.Error(t, ValidateStorage(storage))
+
+	storage = testStorage()
+	storage.DeviceClasses[0].DeviceClasses[1].Device = []topolvmv2.Disk{{Name: "loop0", Type: Loop, Auto: true, Path: "/data"}}
+	assert.EqualError(t, ValidateStorage(storage), "invalid device classes: invalid devices of class ssd on node node1: loop device loop0 should define size")
+}
+
+func TestValidateTopolvmVersion(t *testing.T) {
+	storage := testStorage()
+	assert.NoError(t, ValidateTopolvmVersion(storage, "quay.io/topolvm/topolvm-with-sidecar:0.10"))
+
+	storage.DeviceClasses[0].DeviceClasses[1].ThinPool = &topolvmv2.ThinPoolConfig{Name: "pool", OverprovisionRatio: "2"}
+	assert.NoError(t, ValidateTopolvmVersion(storage, "quay.io/topolvm/topolvm-with-sidecar:0.11.0"))
+	assert.NoError(t, ValidateTopolvmVersion(storage, "registry:5000/topolvm:v0.12.1@sha256:0123"))
+	assert.EqualError(t, ValidateTopolvmVersion(storage, "quay.io/topolvm/topolvm-with-sidecar:0.10.2"), "thin pool of class ssd: topolvm image quay.io/topolvm/topolvm-with-sidecar:0.10.2 is older than 0.11.0")
+	assert.EqualError(t, ValidateTopolvmVersion(storage, "registry:5000/topolvm"), "thin pool of class ssd: topolvm image registry:5000/topolvm must be tagged with its version, topolvm 0.11.0 or newer is needed")
+
+	storage = testStorage()
+	storage.DeviceClasses[0].DeviceClasses[0].Raid = &topolvmv2.RaidConfig{Type: "raid1"}
+	assert.NoError(t, ValidateTopolvmVersion(storage, "quay.io/topolvm/topolvm-with-sidecar:0.10.6"))
+	assert.EqualError(t, ValidateTopolvmVersion(storage, "quay.io/topolvm/topolvm-with-sidecar:0.10.2"), "raid of class hdd: topolvm image quay.io/topolvm/topolvm-with-sidecar:0.10.2 is older than 0.10.6")
+
+	storage = &topolvmv2.Storage{NodeClasses: []topolvmv2.NodeClassSelector{{Classes: []topolvmv2.SelectedDeviceClass{{ClassName: "thin", ThinPool: &topolvmv2.ThinPoolConfig{Name: "pool"}}}}}}
+	assert.Error(t, ValidateTopolvmVersion(storage, "topolvm:latest"))
+}
+
+func TestShrinkingChanges(t *testing.T) {
+	old := testStorage()
+	assert.Empty(t, ShrinkingChanges(old, testStorage()))
+
+	grown := testStorage()
+	grown.DeviceClasses[0].DeviceClasses[1].Device = append(grown.DeviceClasses[0].DeviceClasses[1].Device, topolvmv2.Disk{Name: "/dev/nvme1n1", Type: "disk"})
+	assert.Empty(t, ShrinkingChanges(old, grown))
+
+	shrunk := testStorage()
+	shrunk.DeviceClasses[0].DeviceClasses[0].Device = shrunk.DeviceClasses[0].DeviceClasses[0].Device[:1]
+	shrunk.DeviceClasses[0].DeviceClasses = shrunk.DeviceClasses[0].DeviceClasses[:1]
+	assert.Equal(t, []ClassChange{
+		{Node: "node1", Class: "hdd", Default: true, Message: "device /dev/sdc is removed"},
+		{Node: "node1", Class: "ssd", Message: "class is removed"},
+	}, ShrinkingChanges(old, shrunk))
+
+	// a node removed from the cluster is decommissioned
+	removed := testStorage()
+	removed.DeviceClasses = nil
+	assert.Empty(t, ShrinkingChanges(old, removed))
+}
@@ -20,12 +20,15 @@ import (
 	"fmt"
 	"github.com/alauda/nativestor/pkg/cluster/topolvm"
 
+	"github.com/alauda/nativestor/pkg/operator"
 	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/alauda/nativestor/pkg/util/sys"
 	"github.com/coreos/pkg/capnslog"
 	batch "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -40,13 +43,23 @@ const (
 	indexJobMinorNumber = "22"
 )
 
-func makeJob(clientset kubernetes.Interface, nodeName string, image string, reference *metav1.OwnerReference) (*batch.Job, error) {
+func makeJob(clientset kubernetes.Interface, cluster types.NamespacedName, nodeName string, image string, reference *metav1.OwnerReference) (*batch.Job, error) {
 
-	podSpec, err := provisionPodTemplateSpec(nodeName, image, v1.RestartPolicyNever)
+	podSpec, err := provisionPodTemplateSpec(cluster, nodeName, image, v1.RestartPolicyNever)
 	if err != nil {
 		return nil, err
 	}
 
+	// the job picks devices with the same rules the discover daemon publishes them with
+	rules, err := k8sutil.GetOperatorSetting(clientset, cluster.Namespace, operator.OperatorSettingConfigMapName, sys.DeviceRulesEnv, "")
+	if err != nil {
+		return nil, err
+	}
+	if rules != "" {
+		container := &podSpec.Spec.Containers[0]
+		container.Env = append(container.Env, v1.EnvVar{Name: sys.DeviceRulesEnv, Value: rules})
+	}
+
 	version, err := clientset.Discovery().ServerVersion()
 	if err != nil {
 		return nil, err
@@ -57,10 +70,10 @@ func makeJob(clientset kubernetes.Interface, nodeName string, image string, refe
 			// job validation introduced in v1.22 uses DNS regex which accepts only
 			// domain name but not subdomains, so truncating the name to always pass hash
 			Name:      truncateNodeNameForIndexJob(topolvm.PrepareVgJobFmt, nodeName),
-			Namespace: topolvm.NameSpace,
+			Namespace: cluster.Namespace,
 			Labels: map[string]string{
 				topolvm.AppAttr:     topolvm.PrePareVgAppName,
-				topolvm.ClusterAttr: topolvm.NameSpace,
+				topolvm.ClusterAttr: cluster.Name,
 			},
 			OwnerReferences: []metav1.OwnerReference{*reference},
 		},
@@ -92,11 +105,17 @@ func truncateNodeNameForIndexJob(format, nodeName string) string {
 	return fmt.Sprintf(format, hashed)
 }
 
-func MakeAndRunJob(clientset kubernetes.Interface, nodeName string, image string, reference *metav1.OwnerReference) error {
+// JobName is the name of the volume group job of the node.
+func JobName(nodeName string) string {
+	return fmt.Sprintf(topolvm.PrepareVgJobFmt, k8sutil.Hash(nodeName))
+}
+
+// MakeAndRunJob runs the volume group job of the cluster on the node.
+func MakeAndRunJob(clientset kubernetes.Interface, cluster types.NamespacedName, nodeName string, image string, reference *metav1.OwnerReference) error {
 	// update the orchestration status of this node to the starting state
 
 	logger.Debugf("start make prepare vg job")
-	job, err := makeJob(clientset, nodeName, image, reference)
+	job, err := makeJob(clientset, cluster, nodeName, image, reference)
 	if err != nil {
 		logger.Errorf("make job for node:%s failed", nodeName)
 		return err
@@ -117,7 +136,7 @@ func runJob(clientset kubernetes.Interface, job *batch.Job, nodeName string) err
 	return nil
 }
 
-func provisionPodTemplateSpec(nodeName string, image string, restart v1.RestartPolicy) (*v1.PodTemplateSpec, error) {
+func provisionPodTemplateSpec(cluster types.NamespacedName, nodeName string, image string, restart v1.RestartPolicy) (*v1.PodTemplateSpec, error) {
 
 	var volumes []v1.Volume
 	devVolume := v1.Volume{Name: "devices", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/dev"}}}
@@ -161,9 +180,9 @@ func provisionPodTemplateSpec(nodeName string, image string, restart v1.RestartP
 				VolumeMounts: volumeMount,
 				Env: []v1.EnvVar{
 					{Name: topolvm.NodeNameEnv, Value: nodeName},
-					{Name: topolvm.PodNameSpaceEnv, Value: topolvm.NameSpace},
+					{Name: topolvm.PodNameSpaceEnv, Value: cluster.Namespace},
 					{Name: topolvm.LogLevelEnv, Value: topolvm.PrePareVgJobLogLevel},
-					{Name: topolvm.ClusterNameEnv, Value: topolvm.ClusterName},
+					{Name: topolvm.ClusterNameEnv, Value: cluster.Name},
 				},
 			},
 		},
@@ -179,7 +198,7 @@ func provisionPodTemplateSpec(nodeName string, image string, restart v1.RestartP
 		Name: topolvm.PrePareVgAppName,
 		Labels: map[string]string{
 			topolvm.AppAttr:     topolvm.PrePareVgAppName,
-			topolvm.ClusterAttr: topolvm.NameSpace,
+			topolvm.ClusterAttr: cluster.Name,
 		},
 		Annotations: map[string]string{},
 	}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	certValidFor = 10 * 365 * 24 * time.Hour
+	// certs are renewed once they expire within this time
+	certRenewBefore = 30 * 24 * time.Hour
+	caCertKey       = "ca.crt"
+)
+
+type certificates struct {
+	ca   []byte
+	cert []byte
+	key  []byte
+}
+
+// ensureCertSecret returns the certificates of the webhook service from its
+// secret, they are generated when the secret is missing or expires soon.
+func ensureCertSecret(ctx context.Context, clientset kubernetes.Interface, namespace string) (*certificates, error) {
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, topolvm.WebhookCertSecret, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "get secret %s failed", topolvm.WebhookCertSecret)
+	}
+	found := err == nil
+	if found {
+		certs := &certificates{ca: secret.Data[caCertKey], cert: secret.Data[v1.TLSCertKey], key: secret.Data[v1.TLSPrivateKeyKey]}
+		if certValid(certs.cert, time.Now().Add(certRenewBefore)) {
+			return certs, nil
+		}
+		logger.Infof("webhook certificate in secret %s is invalid or expires soon, generate a new one", topolvm.WebhookCertSecret)
+	}
+
+	certs, err := generateCerts(fmt.Sprintf("%s.%s.svc", topolvm.WebhookServiceName, namespace))
+	if err != nil {
+		return nil, err
+	}
+	data := map[string][]byte{caCertKey: certs.ca, v1.TLSCertKey: certs.cert, v1.TLSPrivateKeyKey: certs.key}
+	if !found {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: topolvm.WebhookCertSecret, Namespace: namespace},
+			Type:       v1.SecretTypeTLS,
+			Data:       data,
+		}
+		_, err = clientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		secret.Data = data
+		_, err = clientset.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "save secret %s failed", topolvm.WebhookCertSecret)
+	}
+	return certs, nil
+}
+
+// certValid tells whether the PEM certificate is still valid at the time.
+func certValid(certPEM []byte, at time.Time) bool {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return at.Before(cert.NotAfter)
+}
+
+// generateCerts makes a self signed certificate for the host, it is its own
+// CA.
+func generateCerts(host string) (*certificates, error) {
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate key failed")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "generate serial number failed")
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(certValidFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              dnsAliases(host),
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return nil, errors.Wrap(err, "create certificate failed")
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal private key failed")
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	return &certificates{
+		ca:   certPEM,
+		cert: certPEM,
+		key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}),
+	}, nil
+}
+
+// dnsAliases returns the service host along with its shorter names, e.g.
+// svc.ns.svc, svc.ns and svc.
+func dnsAliases(host string) []string {
+	aliases := []string{host}
+	for i := len(host) - 1; i > 0; i-- {
+		if host[i] == '.' {
+			aliases = append(aliases, host[:i])
+		}
+	}
+	return aliases
+}
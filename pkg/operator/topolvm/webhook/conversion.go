@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/pkg/errors"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const migrateInterval = 10 * time.Second
+
+// ensureConversion points the conversion of the TopolvmCluster CRD at the
+// operator.
+func ensureConversion(ctx context.Context, crds apiextensionsclient.Interface, namespace string, ca []byte) error {
+
+	crdClient := crds.ApiextensionsV1().CustomResourceDefinitions()
+	crd, err := crdClient.Get(ctx, topolvm.ClusterCRDName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get crd %s", topolvm.ClusterCRDName)
+	}
+
+	path := topolvm.ConvertClusterPath
+	port := int32(443)
+	conversion := &apiextensionsv1.CustomResourceConversion{
+		Strategy: apiextensionsv1.WebhookConverter,
+		Webhook: &apiextensionsv1.WebhookConversion{
+			ClientConfig: &apiextensionsv1.WebhookClientConfig{
+				Service: &apiextensionsv1.ServiceReference{
+					Namespace: namespace,
+					Name:      topolvm.WebhookServiceName,
+					Path:      &path,
+					Port:      &port,
+				},
+				CABundle: ca,
+			},
+			ConversionReviewVersions: []string{"v1"},
+		},
+	}
+	if reflect.DeepEqual(crd.Spec.Conversion, conversion) {
+		return nil
+	}
+	crd.Spec.Conversion = conversion
+	if _, err := crdClient.Update(ctx, crd, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to update crd %s", topolvm.ClusterCRDName)
+	}
+	return nil
+}
+
+// storageMigrator rewrites the stored clusters in the storage version of the
+// CRD, then drops the other versions from its stored versions, so they can
+// be removed from the CRD in a later release.
+type storageMigrator struct {
+	client client.Client
+	reader client.Reader
+	crds   apiextensionsclient.Interface
+}
+
+// Start retries the migration until it succeeds, it needs the conversion
+// webhook served.
+func (m *storageMigrator) Start(ctx context.Context) error {
+	// the poll only ends without success when the operator stops
+	_ = wait.PollImmediateUntil(migrateInterval, func() (bool, error) {
+		if err := m.migrate(ctx); err != nil {
+			logger.Warningf("migrate stored topolvm clusters failed, retry later %v", err)
+			return false, nil
+		}
+		return true, nil
+	}, ctx.Done())
+	return nil
+}
+
+func (m *storageMigrator) migrate(ctx context.Context) error {
+
+	crdClient := m.crds.ApiextensionsV1().CustomResourceDefinitions()
+	crd, err := crdClient.Get(ctx, topolvm.ClusterCRDName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get crd %s", topolvm.ClusterCRDName)
+	}
+	storageVersion := ""
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			storageVersion = version.Name
+		}
+	}
+	if storageVersion == "" {
+		return errors.Errorf("crd %s has no storage version", topolvm.ClusterCRDName)
+	}
+	if reflect.DeepEqual(crd.Status.StoredVersions, []string{storageVersion}) {
+		return nil
+	}
+
+	// the clusters are read and written in the storage version, whichever
+	// it is, so the rewrite converts nothing but what is stored
+	clusters := &unstructured.UnstructuredList{}
+	clusters.SetGroupVersionKind(schema.GroupVersionKind{Group: crd.Spec.Group, Version: storageVersion, Kind: crd.Spec.Names.ListKind})
+	if err := m.reader.List(ctx, clusters); err != nil {
+		return errors.Wrap(err, "failed to list topolvm cluster")
+	}
+	for index := range clusters.Items {
+		// an update without change stores the cluster in the storage
+		// version, a conflict means someone else stored it meanwhile
+		err := m.client.Update(ctx, &clusters.Items[index])
+		if err != nil && !kerrors.IsNotFound(err) && !kerrors.IsConflict(err) {
+			return errors.Wrapf(err, "failed to migrate topolvm cluster %s", clusters.Items[index].GetName())
+		}
+	}
+
+	crd.Status.StoredVersions = []string{storageVersion}
+	if _, err := crdClient.UpdateStatus(ctx, crd, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to update stored versions of crd %s", topolvm.ClusterCRDName)
+	}
+	logger.Infof("topolvm clusters are stored as %s", storageVersion)
+	return nil
+}
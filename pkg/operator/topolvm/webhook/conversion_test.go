@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/stretchr/testify/assert"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	crdfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func clusterCRD(storageVersion string, storedVersions ...string) *apiextensionsv1.CustomResourceDefinition {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: topolvm.ClusterCRDName},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: topolvmv2.GroupVersion.Group,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: "TopolvmCluster", ListKind: "TopolvmClusterList"},
+		},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{StoredVersions: storedVersions},
+	}
+	for _, version := range []string{"v2", "v3"} {
+		crd.Spec.Versions = append(crd.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{Name: version, Served: true, Storage: version == storageVersion})
+	}
+	return crd
+}
+
+func TestStorageMigrator(t *testing.T) {
+	scheme := testScheme(t)
+	// the fake client does not convert, the cluster is already stored in the
+	// storage version
+	for storageVersion, cluster := range map[string]client.Object{"v2": testCluster("/dev/sdb"), "v3": testClusterV3("/dev/sdb")} {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster).Build()
+		crds := crdfake.NewSimpleClientset(clusterCRD(storageVersion, "v2", "v3"))
+		m := &storageMigrator{client: c, reader: c, crds: crds}
+		stored := cluster.DeepCopyObject().(client.Object)
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(cluster), stored))
+
+		assert.NoError(t, m.migrate(context.TODO()))
+		crd, err := crds.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), topolvm.ClusterCRDName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{storageVersion}, crd.Status.StoredVersions)
+		// the cluster was rewritten without change
+		migrated := cluster.DeepCopyObject().(client.Object)
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(cluster), migrated))
+		migratedVersion := migrated.GetResourceVersion()
+		assert.NotEqual(t, stored.GetResourceVersion(), migratedVersion, storageVersion)
+		migrated.SetResourceVersion(stored.GetResourceVersion())
+		assert.Equal(t, stored, migrated, storageVersion)
+
+		// a migrated crd is left as it is
+		assert.NoError(t, m.migrate(context.TODO()))
+		again := cluster.DeepCopyObject().(client.Object)
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(cluster), again))
+		assert.Equal(t, migratedVersion, again.GetResourceVersion(), storageVersion)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	topolvmv3 "github.com/alauda/nativestor/apis/topolvm/v3"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator"
+	"github.com/alauda/nativestor/pkg/operator/k8sutil"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/csi"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/rollout"
+	"github.com/alauda/nativestor/pkg/operator/topolvm/volumegroup"
+	topolvmv1 "github.com/topolvm/topolvm/api/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// clusterDefaulter fills the names and spare sizes left out of a
+// TopolvmCluster.
+type clusterDefaulter struct {
+	decoder *admission.Decoder
+}
+
+func (d *clusterDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Kind.Version == topolvmv3.GroupVersion.Version {
+		return d.handleV3(req)
+	}
+
+	topolvmCluster := &topolvmv2.TopolvmCluster{}
+	if err := d.decoder.Decode(req, topolvmCluster); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	volumegroup.DefaultStorage(&topolvmCluster.Spec.Storage)
+	marshaled, err := json.Marshal(topolvmCluster)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// handleV3 fills the volume group names left out of a v3 TopolvmCluster.
+// Spare sizes are left to lvmd, a v2 spare size set on the classes of
+// templates or of node groups of every node would not convert back.
+func (d *clusterDefaulter) handleV3(req admission.Request) admission.Response {
+	topolvmCluster := &topolvmv3.TopolvmCluster{}
+	if err := d.decoder.Decode(req, topolvmCluster); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	for i := range topolvmCluster.Spec.NodeGroups {
+		classes := topolvmCluster.Spec.NodeGroups[i].Classes
+		for j := range classes {
+			if classes[j].VolumeGroup == "" {
+				classes[j].VolumeGroup = classes[j].Name
+			}
+		}
+	}
+	marshaled, err := json.Marshal(topolvmCluster)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// clusterValidator rejects invalid TopolvmClusters and updates taking space
+// away from classes which still have volumes. v3 clusters are validated as
+// v2, those v2 can not express are rejected.
+type clusterValidator struct {
+	decoder *admission.Decoder
+	reader  client.Reader
+	// namespace is the namespace of the operator and its setting
+	namespace string
+}
+
+func (v *clusterValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	hub := &topolvmv3.TopolvmCluster{}
+	topolvmCluster := &topolvmv2.TopolvmCluster{}
+	if req.Kind.Version == topolvmv3.GroupVersion.Version {
+		if err := v.decoder.Decode(req, hub); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		topolvmCluster.ObjectMeta = hub.ObjectMeta
+	} else if err := v.decoder.Decode(req, topolvmCluster); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	// the finalizer is removed from clusters being deleted
+	if !topolvmCluster.GetDeletionTimestamp().IsZero() {
+		return admission.Allowed("")
+	}
+	// the operator only changes the metadata, e.g. adds the finalizer or
+	// rewrites clusters in the storage version, which is never denied
+	if req.Operation == admissionv1.Update && specUnchanged(req) {
+		return admission.Allowed("")
+	}
+	if req.Kind.Version == topolvmv3.GroupVersion.Version {
+		if err := topolvmCluster.ConvertFrom(hub); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+
+	if err := volumegroup.ValidateStorage(&topolvmCluster.Spec.Storage); err != nil {
+		return admission.Denied(err.Error())
+	}
+	image, err := v.nodeTopolvmImage(ctx, topolvmCluster)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if err := volumegroup.ValidateTopolvmVersion(&topolvmCluster.Spec.Storage, image); err != nil {
+		return admission.Denied(err.Error())
+	}
+	if strategy := topolvmCluster.Spec.UpgradeStrategy; strategy != nil {
+		if _, err := rollout.MaxUnavailable(strategy.MaxUnavailable, 1); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+	if reason, err := v.sharedSettingsDiffer(ctx, topolvmCluster); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	} else if reason != "" {
+		return admission.Denied(reason)
+	}
+
+	if req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	old := &topolvmv2.TopolvmCluster{}
+	if req.Kind.Version == topolvmv3.GroupVersion.Version {
+		oldHub := &topolvmv3.TopolvmCluster{}
+		if err := v.decoder.DecodeRaw(req.OldObject, oldHub); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if err := old.ConvertFrom(oldHub); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	} else if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	changes := volumegroup.ShrinkingChanges(&old.Spec.Storage, &topolvmCluster.Spec.Storage)
+	if len(changes) == 0 {
+		return admission.Allowed("")
+	}
+
+	lvs := &topolvmv1.LogicalVolumeList{}
+	if err := v.reader.List(ctx, lvs); err != nil {
+		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("list logical volumes failed: %v", err))
+	}
+	if reasons := unsafeChanges(changes, lvs.Items); len(reasons) > 0 {
+		return admission.Denied(strings.Join(reasons, "; "))
+	}
+	return admission.Allowed("")
+}
+
+// nodeTopolvmImage is the topolvm image the node plugins of the cluster run,
+// TOPOLVM_IMAGE of the operator setting when the cluster sets none.
+func (v *clusterValidator) nodeTopolvmImage(ctx context.Context, cluster *topolvmv2.TopolvmCluster) (string, error) {
+	if cluster.Spec.TopolvmVersion != "" {
+		return cluster.Spec.TopolvmVersion, nil
+	}
+	setting := &corev1.ConfigMap{}
+	err := v.reader.Get(ctx, types.NamespacedName{Name: operator.OperatorSettingConfigMapName, Namespace: v.namespace}, setting)
+	if kerrors.IsNotFound(err) {
+		return csi.DefaultTopolvmImage, nil
+	} else if err != nil {
+		return "", fmt.Errorf("get operator setting failed: %v", err)
+	}
+	return k8sutil.GetValue(setting.Data, "TOPOLVM_IMAGE", csi.DefaultTopolvmImage), nil
+}
+
+// sharedSettingsDiffer tells why the cluster can not live next to the other
+// clusters of its namespace. The topolvm-controller is shared by all of them,
+// so they must agree on its certs secret. Their topolvm versions may differ,
+// the controller runs the newest one.
+func (v *clusterValidator) sharedSettingsDiffer(ctx context.Context, cluster *topolvmv2.TopolvmCluster) (string, error) {
+	clusters := &topolvmv2.TopolvmClusterList{}
+	if err := v.reader.List(ctx, clusters, client.InNamespace(cluster.Namespace)); err != nil {
+		return "", fmt.Errorf("list topolvm clusters failed: %v", err)
+	}
+	for _, other := range clusters.Items {
+		if other.Name == cluster.Name || !other.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		if other.Spec.CertsSecret != cluster.Spec.CertsSecret {
+			return fmt.Sprintf("certsSecret must be the same as that of cluster %s, the topolvm-controller is shared by all clusters", other.Name), nil
+		}
+	}
+	return "", nil
+}
+
+// unsafeChanges returns why the changes are unsafe for classes which still
+// have logical volumes.
+func unsafeChanges(changes []volumegroup.ClassChange, lvs []topolvmv1.LogicalVolume) []string {
+	var reasons []string
+	for _, change := range changes {
+		count := 0
+		for _, lv := range lvs {
+			if lv.Spec.DeviceClass != change.Class && !(lv.Spec.DeviceClass == "" && change.Default) {
+				continue
+			}
+			if change.Node != "" && lv.Spec.NodeName != change.Node {
+				continue
+			}
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		if change.Node != "" {
+			reasons = append(reasons, fmt.Sprintf("class %s on node %s still has %d logical volumes: %s", change.Class, change.Node, count, change.Message))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("class %s still has %d logical volumes: %s", change.Class, count, change.Message))
+		}
+	}
+	return reasons
+}
+
+// capacityStripper drops the capacity annotations from the nodes being
+// decommissioned, so their node plugins can not put them back while they
+// drain.
+type capacityStripper struct{}
+
+func (s *capacityStripper) Handle(ctx context.Context, req admission.Request) admission.Response {
+	node := map[string]interface{}{}
+	if err := json.Unmarshal(req.Object.Raw, &node); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	metadata, _ := node["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	stripped := false
+	for key := range annotations {
+		if strings.HasPrefix(key, topolvm.CapacityKeyPrefix) {
+			delete(annotations, key)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return admission.Allowed("")
+	}
+	marshaled, err := json.Marshal(node)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// specUnchanged tells whether an update leaves the spec of the object as it
+// was.
+func specUnchanged(req admission.Request) bool {
+	var object, old struct {
+		Spec interface{} `json:"spec"`
+	}
+	if err := json.Unmarshal(req.Object.Raw, &object); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(object.Spec, old.Spec)
+}
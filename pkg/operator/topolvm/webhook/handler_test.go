@@ -0,0 +1,272 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	topolvmv3 "github.com/alauda/nativestor/apis/topolvm/v3"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/stretchr/testify/assert"
+	topolvmv1 "github.com/topolvm/topolvm/api/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, topolvmv2.AddToScheme(scheme))
+	assert.NoError(t, topolvmv3.AddToScheme(scheme))
+	assert.NoError(t, topolvmv1.AddToScheme(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	return scheme
+}
+
+func testCluster(devices ...string) *topolvmv2.TopolvmCluster {
+	class := topolvmv2.DeviceClass{ClassName: "hdd", VgName: "hdd", Default: true}
+	for _, d := range devices {
+		class.Device = append(class.Device, topolvmv2.Disk{Name: d, Type: "disk"})
+	}
+	return &topolvmv2.TopolvmCluster{
+		TypeMeta:   metav1.TypeMeta{Kind: "TopolvmCluster", APIVersion: topolvmv2.GroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "nativestor-system"},
+		Spec: topolvmv2.TopolvmClusterSpec{
+			Storage: topolvmv2.Storage{
+				DeviceClasses: []topolvmv2.NodeDevices{{NodeName: "node1", DeviceClasses: []topolvmv2.DeviceClass{class}}},
+			},
+		},
+	}
+}
+
+func request(t *testing.T, op admissionv1.Operation, obj, old runtime.Object) admission.Request {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: op,
+		Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+	}}
+	raw, err := json.Marshal(obj)
+	assert.NoError(t, err)
+	req.Object = runtime.RawExtension{Raw: raw}
+	if old != nil {
+		raw, err = json.Marshal(old)
+		assert.NoError(t, err)
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+	return req
+}
+
+func TestClusterDefaulter(t *testing.T) {
+	decoder, err := admission.NewDecoder(testScheme(t))
+	assert.NoError(t, err)
+	d := &clusterDefaulter{decoder: decoder}
+
+	cluster := testCluster("/dev/sdb")
+	cluster.Spec.DeviceClasses[0].DeviceClasses[0].VgName = ""
+	resp := d.Handle(context.TODO(), request(t, admissionv1.Create, cluster, nil))
+	assert.True(t, resp.Allowed)
+	paths := make(map[string]interface{})
+	for _, p := range resp.Patches {
+		paths[p.Path] = p.Value
+	}
+	assert.Equal(t, "hdd", paths["/spec/storage/deviceClasses/0/classes/0/volumeGroup"])
+	assert.Equal(t, float64(10), paths["/spec/storage/deviceClasses/0/classes/0/spareGb"])
+}
+
+func TestClusterValidator(t *testing.T) {
+	scheme := testScheme(t)
+	decoder, err := admission.NewDecoder(scheme)
+	assert.NoError(t, err)
+	lv := &topolvmv1.LogicalVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec:       topolvmv1.LogicalVolumeSpec{Name: "pvc-1", NodeName: "node1"},
+	}
+	v := &clusterValidator{decoder: decoder, reader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(lv).Build()}
+
+	invalid := testCluster("/dev/sdb", "/dev/sdb")
+	resp := v.Handle(context.TODO(), request(t, admissionv1.Create, invalid, nil))
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "invalid device classes: invalid devices of class hdd on node node1: device /dev/sdb is listed twice", string(resp.Result.Reason))
+
+	invalid = testCluster("/dev/sdb")
+	maxUnavailable := intstr.FromString("half")
+	invalid.Spec.UpgradeStrategy = &topolvmv2.UpgradeStrategy{MaxUnavailable: &maxUnavailable}
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Create, invalid, nil))
+	assert.False(t, resp.Allowed)
+	assert.Contains(t, string(resp.Result.Reason), "invalid max unavailable half")
+
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Update, testCluster("/dev/sdb", "/dev/sdc", "/dev/sdd"), testCluster("/dev/sdb", "/dev/sdc")))
+	assert.True(t, resp.Allowed)
+
+	// the volume created without a class is in the default class hdd
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Update, testCluster("/dev/sdb"), testCluster("/dev/sdb", "/dev/sdc")))
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "class hdd on node node1 still has 1 logical volumes: device /dev/sdc is removed", string(resp.Result.Reason))
+
+	// adding the finalizer to a cluster admitted while the webhook was not
+	// registered is not denied
+	withFinalizer := invalid.DeepCopy()
+	withFinalizer.Finalizers = []string{"topolvmcluster.topolvm.cybozu.com"}
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Update, withFinalizer, invalid))
+	assert.True(t, resp.Allowed)
+}
+
+func testClusterV3(devices ...string) *topolvmv3.TopolvmCluster {
+	class := topolvmv3.DeviceClass{Name: "hdd", VolumeGroup: "hdd", ClassSettings: topolvmv3.ClassSettings{Default: true}}
+	for _, d := range devices {
+		class.Devices = append(class.Devices, topolvmv3.Device{Name: d})
+	}
+	return &topolvmv3.TopolvmCluster{
+		TypeMeta:   metav1.TypeMeta{Kind: "TopolvmCluster", APIVersion: topolvmv3.GroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "nativestor-system"},
+		Spec: topolvmv3.TopolvmClusterSpec{
+			NodeGroups: []topolvmv3.NodeGroup{{Name: "node1", NodeName: "node1", Classes: []topolvmv3.DeviceClass{class}}},
+		},
+	}
+}
+
+func TestClusterWebhooksV3(t *testing.T) {
+	scheme := testScheme(t)
+	decoder, err := admission.NewDecoder(scheme)
+	assert.NoError(t, err)
+
+	d := &clusterDefaulter{decoder: decoder}
+	cluster := testClusterV3("/dev/sdb")
+	cluster.Spec.NodeGroups[0].Classes[0].VolumeGroup = ""
+	resp := d.Handle(context.TODO(), request(t, admissionv1.Create, cluster, nil))
+	assert.True(t, resp.Allowed)
+	assert.Len(t, resp.Patches, 1)
+	assert.Equal(t, "/spec/nodeGroups/0/classes/0/volumeGroup", resp.Patches[0].Path)
+	assert.Equal(t, "hdd", resp.Patches[0].Value)
+
+	lv := &topolvmv1.LogicalVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec:       topolvmv1.LogicalVolumeSpec{Name: "pvc-1", NodeName: "node1"},
+	}
+	v := &clusterValidator{decoder: decoder, reader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(lv).Build()}
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Create, testClusterV3("/dev/sdb"), nil))
+	assert.True(t, resp.Allowed)
+
+	// v2 has no node group of selected nodes listing devices
+	cluster = testClusterV3("/dev/sdb")
+	cluster.Spec.NodeGroups[0].NodeName = ""
+	cluster.Spec.NodeGroups[0].NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "a"}}
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Create, cluster, nil))
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "cluster cluster can not be converted to topolvm.cybozu.com/v2: classes of node group node1 selecting nodes by label should pick their devices by selector", string(resp.Result.Reason))
+
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Update, testClusterV3("/dev/sdb"), testClusterV3("/dev/sdb", "/dev/sdc")))
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "class hdd on node node1 still has 1 logical volumes: device /dev/sdc is removed", string(resp.Result.Reason))
+}
+
+func TestClusterValidatorSharedSettings(t *testing.T) {
+	scheme := testScheme(t)
+	decoder, err := admission.NewDecoder(scheme)
+	assert.NoError(t, err)
+	other := testCluster("/dev/sdc")
+	other.Name = "other"
+	other.Spec.CertsSecret = "certs"
+	v := &clusterValidator{decoder: decoder, reader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(other).Build()}
+
+	resp := v.Handle(context.TODO(), request(t, admissionv1.Create, testCluster("/dev/sdb"), nil))
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "certsSecret must be the same as that of cluster other, the topolvm-controller is shared by all clusters", string(resp.Result.Reason))
+
+	cluster := testCluster("/dev/sdb")
+	cluster.Spec.CertsSecret = "certs"
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Create, cluster, nil))
+	assert.True(t, resp.Allowed)
+
+	// node pools may run topolvm versions of their own
+	upgraded := cluster.DeepCopy()
+	upgraded.Spec.TopolvmVersion = "quay.io/topolvm/topolvm-with-sidecar:0.11"
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Update, upgraded, cluster))
+	assert.True(t, resp.Allowed)
+
+	// only clusters of the same namespace are compared
+	cluster.Namespace = "other-system"
+	cluster.Spec.CertsSecret = ""
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Create, cluster, nil))
+	assert.True(t, resp.Allowed)
+}
+
+func TestClusterValidatorTopolvmVersion(t *testing.T) {
+	scheme := testScheme(t)
+	decoder, err := admission.NewDecoder(scheme)
+	assert.NoError(t, err)
+	v := &clusterValidator{decoder: decoder, reader: fake.NewClientBuilder().WithScheme(scheme).Build(), namespace: "nativestor-system"}
+
+	cluster := testCluster("/dev/sdb")
+	cluster.Spec.Storage.DeviceClasses[0].DeviceClasses[0].ThinPool = &topolvmv2.ThinPoolConfig{Name: "pool", OverprovisionRatio: "2"}
+	resp := v.Handle(context.TODO(), request(t, admissionv1.Create, cluster, nil))
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "thin pool of class hdd: topolvm image quay.io/topolvm/topolvm-with-sidecar:0.10 is older than 0.11.0", string(resp.Result.Reason))
+
+	cluster.Spec.TopolvmVersion = "quay.io/topolvm/topolvm-with-sidecar:0.11.0"
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Create, cluster, nil))
+	assert.True(t, resp.Allowed)
+
+	raid := testCluster("/dev/sdb", "/dev/sdc")
+	raid.Spec.Storage.DeviceClasses[0].DeviceClasses[0].Raid = &topolvmv2.RaidConfig{Type: topolvmv2.Raid1}
+	raid.Spec.TopolvmVersion = "quay.io/topolvm/topolvm-with-sidecar:0.10.2"
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Create, raid, nil))
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "raid of class hdd: topolvm image quay.io/topolvm/topolvm-with-sidecar:0.10.2 is older than 0.10.6", string(resp.Result.Reason))
+
+	// clusters setting no version run TOPOLVM_IMAGE of the operator setting
+	setting := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "nativestor-setting", Namespace: "nativestor-system"},
+		Data:       map[string]string{"TOPOLVM_IMAGE": "quay.io/topolvm/topolvm-with-sidecar:0.12.0"},
+	}
+	v.reader = fake.NewClientBuilder().WithScheme(scheme).WithObjects(setting).Build()
+	cluster.Spec.TopolvmVersion = ""
+	resp = v.Handle(context.TODO(), request(t, admissionv1.Create, cluster, nil))
+	assert.True(t, resp.Allowed)
+}
+
+func TestCapacityStripper(t *testing.T) {
+	s := &capacityStripper{}
+	node := &corev1.Node{
+		TypeMeta: metav1.TypeMeta{Kind: "Node", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				topolvm.CapacityKeyPrefix + "hdd": "1073741824",
+				"node.alpha.kubernetes.io/ttl":    "0",
+			},
+		},
+	}
+	resp := s.Handle(context.TODO(), request(t, admissionv1.Update, node, nil))
+	assert.True(t, resp.Allowed)
+	if assert.Len(t, resp.Patches, 1) {
+		assert.Equal(t, "remove", resp.Patches[0].Operation)
+		assert.Equal(t, "/metadata/annotations/capacity.topolvm.cybozu.com~1hdd", resp.Patches[0].Path)
+	}
+
+	delete(node.Annotations, topolvm.CapacityKeyPrefix+"hdd")
+	resp = s.Handle(context.TODO(), request(t, admissionv1.Update, node, nil))
+	assert.True(t, resp.Allowed)
+	assert.Empty(t, resp.Patches)
+}
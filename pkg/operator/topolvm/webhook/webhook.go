@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	topolvmv2 "github.com/alauda/nativestor/apis/topolvm/v2"
+	topolvmv3 "github.com/alauda/nativestor/apis/topolvm/v3"
+	"github.com/alauda/nativestor/pkg/cluster"
+	"github.com/alauda/nativestor/pkg/cluster/topolvm"
+	"github.com/alauda/nativestor/pkg/operator"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+)
+
+var logger = capnslog.NewPackageLogger("topolvm/operator", "webhook")
+
+// Add serves the defaulting, validating and conversion webhooks of
+// TopolvmCluster from the operator, with a self signed certificate kept in a
+// secret.
+func Add(mgr manager.Manager, context *cluster.Context, opManagerContext context.Context, opConfig operator.OperatorConfig) error {
+
+	certs, err := ensureCertSecret(opManagerContext, context.Clientset, opConfig.OperatorNamespace)
+	if err != nil {
+		return errors.Wrap(err, "ensure webhook certificate failed")
+	}
+	if err := writeCerts(topolvm.WebhookCertDir, certs); err != nil {
+		return errors.Wrap(err, "write webhook certificate failed")
+	}
+
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+	server := mgr.GetWebhookServer()
+	server.Port = topolvm.WebhookPort
+	server.CertDir = topolvm.WebhookCertDir
+	server.Register(topolvm.MutateClusterPath, &ctrlwebhook.Admission{Handler: &clusterDefaulter{decoder: decoder}})
+	server.Register(topolvm.ValidateClusterPath, &ctrlwebhook.Admission{Handler: &clusterValidator{decoder: decoder, reader: mgr.GetAPIReader(), namespace: opConfig.OperatorNamespace}})
+	server.Register(topolvm.MutateNodePath, &ctrlwebhook.Admission{Handler: &capacityStripper{}})
+	converter := &conversion.Webhook{}
+	if err := converter.InjectScheme(mgr.GetScheme()); err != nil {
+		return err
+	}
+	server.Register(topolvm.ConvertClusterPath, converter)
+
+	if err := ensureWebhookConfigurations(opManagerContext, context.Clientset, opConfig.OperatorNamespace, certs.ca); err != nil {
+		return errors.Wrap(err, "ensure webhook configurations failed")
+	}
+	if err := ensureConversion(opManagerContext, context.APIExtensionClientset, opConfig.OperatorNamespace, certs.ca); err != nil {
+		return errors.Wrap(err, "ensure topolvm cluster conversion failed")
+	}
+	if err := mgr.Add(&storageMigrator{client: mgr.GetClient(), reader: mgr.GetAPIReader(), crds: context.APIExtensionClientset}); err != nil {
+		return err
+	}
+	logger.Infof("topolvm cluster webhooks registered")
+	return nil
+}
+
+func writeCerts(dir string, certs *certificates) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.crt"), certs.cert, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "tls.key"), certs.key, 0600)
+}
+
+func clusterRules() []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{topolvmv2.GroupVersion.Group},
+				APIVersions: []string{topolvmv2.GroupVersion.Version, topolvmv3.GroupVersion.Version},
+				Resources:   []string{"topolvmclusters"},
+			},
+		},
+	}
+}
+
+func nodeRules() []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{
+		{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"nodes"},
+			},
+		},
+	}
+}
+
+func clientConfig(namespace, path string, ca []byte) admissionregistrationv1.WebhookClientConfig {
+	port := int32(443)
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: namespace,
+			Name:      topolvm.WebhookServiceName,
+			Path:      &path,
+			Port:      &port,
+		},
+		CABundle: ca,
+	}
+}
+
+// ensureWebhookConfigurations creates or updates the webhook configurations
+// pointing at the operator. Failures of the mutating webhook are ignored, the
+// operator fills in the defaults as well, and removes the capacity
+// annotations of the nodes being decommissioned at every step. Updates the
+// validating webhook can not check are rejected, so no unsafe update gets
+// through while the operator is gone, clusters labeled with
+// SkipValidationLabel are left out of it. Only nodes labeled with
+// DecommissionLabel go through the webhook of nodes.
+func ensureWebhookConfigurations(ctx context.Context, clientset kubernetes.Interface, namespace string, ca []byte) error {
+
+	failurePolicy := admissionregistrationv1.Ignore
+	validatingFailurePolicy := admissionregistrationv1.Fail
+	validated := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: topolvm.SkipValidationLabel, Operator: metav1.LabelSelectorOpDoesNotExist},
+		},
+	}
+	decommissioning := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: topolvm.DecommissionLabel, Operator: metav1.LabelSelectorOpExists},
+		},
+	}
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: topolvm.WebhookConfigName},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				Name:                    "mtopolvmcluster.topolvm.cybozu.com",
+				ClientConfig:            clientConfig(namespace, topolvm.MutateClusterPath, ca),
+				Rules:                   clusterRules(),
+				FailurePolicy:           &failurePolicy,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+			{
+				Name:                    "mnode.topolvm.cybozu.com",
+				ClientConfig:            clientConfig(namespace, topolvm.MutateNodePath, ca),
+				Rules:                   nodeRules(),
+				FailurePolicy:           &failurePolicy,
+				ObjectSelector:          decommissioning,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: topolvm.WebhookConfigName},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				Name:                    "vtopolvmcluster.topolvm.cybozu.com",
+				ClientConfig:            clientConfig(namespace, topolvm.ValidateClusterPath, ca),
+				Rules:                   clusterRules(),
+				FailurePolicy:           &validatingFailurePolicy,
+				ObjectSelector:          validated,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+
+	mutatingClient := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	existingMutating, err := mutatingClient.Get(ctx, mutating.Name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = mutatingClient.Create(ctx, mutating, metav1.CreateOptions{})
+	} else if err == nil {
+		mutating.ResourceVersion = existingMutating.ResourceVersion
+		_, err = mutatingClient.Update(ctx, mutating, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "create or update mutating webhook configuration %s failed", mutating.Name)
+	}
+
+	validatingClient := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	existingValidating, err := validatingClient.Get(ctx, validating.Name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = validatingClient.Create(ctx, validating, metav1.CreateOptions{})
+	} else if err == nil {
+		validating.ResourceVersion = existingValidating.ResourceVersion
+		_, err = validatingClient.Update(ctx, validating, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "create or update validating webhook configuration %s failed", validating.Name)
+	}
+	return nil
+}
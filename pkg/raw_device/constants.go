@@ -6,4 +6,19 @@ const PluginName = "nativestor.alauda.io"
 // TopologyNodeKey is the key of topology that represents node name.
 const TopologyNodeKey = "topology.nativestor.alauda.io/node"
 
+// DeviceTypeKey is the StorageClass parameter restricting volumes to raw devices of one type.
+const DeviceTypeKey = "nativestor.alauda.io/device-type"
+
+// NUMANodeKey is the StorageClass parameter restricting volumes to raw devices attached to one NUMA node.
+const NUMANodeKey = "nativestor.alauda.io/numa-node"
+
+// LogicalBlockSizeKey is the StorageClass parameter restricting volumes to raw devices of one logical block size, e.g. 4096 for 4Kn devices.
+const LogicalBlockSizeKey = "nativestor.alauda.io/logical-block-size"
+
+// DiscardKey is the StorageClass parameter restricting volumes to raw devices supporting discard when "true".
+const DiscardKey = "nativestor.alauda.io/discard"
+
 const DefaultCSISocket = "/run/raw-device/csi-rawdevice.sock"
+
+// DefaultVolumeCachePath is where the node plugin keeps the volumes it has staged or published.
+const DefaultVolumeCachePath = "/run/raw-device/published-volumes.json"
@@ -8,14 +8,19 @@ import (
 	"github.com/alauda/nativestor/generated/nativestore/rawdevice/clientset/versioned"
 	"github.com/alauda/nativestor/generated/nativestore/rawdevice/informers/externalversions"
 	"github.com/alauda/nativestor/pkg/cluster"
+	rawdevice "github.com/alauda/nativestor/pkg/raw_device"
 	"github.com/alauda/nativestor/pkg/raw_device/runner"
 	"github.com/kubernetes-csi/csi-lib-utils/leaderelection"
 	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"time"
 	//+kubebuilder:scaffold:imports
 )
@@ -51,11 +56,29 @@ func subMain() error {
 	ctx.RawDeviceClientset = clientset
 
 	factory := externalversions.NewSharedInformerFactory(ctx.RawDeviceClientset, ResyncPeriodOfCsiInformer)
-	rawDeviceLister := factory.Rawdevice().V1().RawDevices().Lister()
+	rawDeviceInformer := factory.Rawdevice().V1().RawDevices()
+	if err := rawDeviceInformer.Informer().AddIndexers(raw_device.RawDeviceIndexers()); err != nil {
+		setupLog.Error(err, "add raw device indexers failed")
+		return err
+	}
+
+	if err := raw_device.RegisterRPCMetrics(metrics.Registry); err != nil {
+		setupLog.Error(err, "register rpc metrics failed")
+		return err
+	}
+	if err := metrics.Registry.Register(raw_device.NewPoolCollector(rawDeviceInformer.Lister())); err != nil {
+		setupLog.Error(err, "register raw device pool metrics failed")
+		return err
+	}
+	runner.StartMetricsServer(config.metricsAddr, metrics.Registry, setupLog)
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: ctx.Clientset.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme, corev1.EventSource{Component: rawdevice.PluginName})
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(raw_device.MetricsInterceptor))
 	csi.RegisterIdentityServer(grpcServer, raw_device.NewIdentityService())
-	csi.RegisterControllerServer(grpcServer, raw_device.NewControllerService(ctx, rawDeviceLister))
+	csi.RegisterControllerServer(grpcServer, raw_device.NewControllerService(ctx, rawDeviceInformer, recorder))
 	controllerServer := runner.NewGRPCRunner(grpcServer, config.csiSocket, config.enableLeaderElection)
 
 	run := func(ctx context.Context) {
@@ -15,6 +15,8 @@ import (
 
 var config struct {
 	csiSocket                   string
+	volumeCachePath             string
+	volumeCacheResync           time.Duration
 	metricsAddr                 string
 	probeAddr                   string
 	webhookAddr                 string
@@ -60,6 +62,8 @@ func init() {
 	fs.StringVar(&config.certDir, "cert-dir", "", "certificate directory")
 	fs.StringVar(&config.leaderElectionNamespace, "leader-election-namespace", "", "Namespace where the leader election resource lives. Defaults to the pod namespace if not set.")
 	fs.StringVar(&config.csiSocket, "csi-socket", raw_device2.DefaultCSISocket, "UNIX domain socket filename for CSI")
+	fs.StringVar(&config.volumeCachePath, "volume-cache-path", raw_device2.DefaultVolumeCachePath, "File where staged and published volumes are cached so they can be staged and published again while the API server is unreachable")
+	fs.DurationVar(&config.volumeCacheResync, "volume-cache-resync", 5*time.Minute, "Interval to reconcile the volume cache with the API server")
 	fs.StringVar(&config.leaderElectionID, "leader-election-id", "raw-device", "ID for leader election by controller-runtime")
 	fs.DurationVar(&config.leaderElectionLeaseDuration, "leader-election-lease-duration", 15*time.Second, "Duration, in seconds, that non-leader candidates will wait to force acquire leadership. Defaults to 15 seconds.")
 	fs.DurationVar(&config.leaderElectionRenewDeadline, "leader-election-renew-deadline", 10*time.Second, "Duration, in seconds, that the acting leader will retry refreshing leadership before giving up. Defaults to 10 seconds.")
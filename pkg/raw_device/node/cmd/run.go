@@ -18,6 +18,7 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"time"
 	//+kubebuilder:scaffold:imports
 )
@@ -61,16 +62,30 @@ func subMain() error {
 	factory := externalversions.NewSharedInformerFactory(ctx.RawDeviceClientset, ResyncPeriodOfCsiInformer)
 	rawDeviceLister := factory.Rawdevice().V1().RawDevices().Lister()
 
+	setupLog.Info("load volume cache", "path", config.volumeCachePath)
+	volumeCache, err := raw_device.NewVolumeCache(config.volumeCachePath)
+	if err != nil {
+		setupLog.Error(err, "load volume cache failed")
+		return err
+	}
+
+	if err := raw_device.RegisterRPCMetrics(metrics.Registry); err != nil {
+		setupLog.Error(err, "register rpc metrics failed")
+		return err
+	}
+	runner.StartMetricsServer(config.metricsAddr, metrics.Registry, setupLog)
+
 	setupLog.Info("register csi node server")
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(ErrorLoggingInterceptor))
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(raw_device.MetricsInterceptor, ErrorLoggingInterceptor))
 	csi.RegisterIdentityServer(grpcServer, raw_device.NewIdentityService())
-	csi.RegisterNodeServer(grpcServer, raw_device.NewNodeService(ctx, rawDeviceLister, nodename))
+	csi.RegisterNodeServer(grpcServer, raw_device.NewNodeService(ctx, rawDeviceLister, volumeCache, nodename))
 	controllerServer := runner.NewGRPCRunner(grpcServer, config.csiSocket, config.enableLeaderElection)
 
-	run := func(ctx context.Context) {
-		factory.Start(ctx.Done())
+	run := func(runCtx context.Context) {
+		factory.Start(runCtx.Done())
+		go volumeCache.Run(runCtx, ctx.RawDeviceClientset, nodename, config.volumeCacheResync)
 		setupLog.Info("controller server start")
-		err = controllerServer.Start(ctx)
+		err = controllerServer.Start(runCtx)
 		if err != nil {
 			setupLog.Error(err, "start controller server  failed")
 		}
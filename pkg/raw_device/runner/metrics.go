@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+type metricsServerRunner struct {
+	addr     string
+	gatherer prometheus.Gatherer
+	handlers []PathHandler
+}
+
+// PathHandler is a handler served next to the metrics, e.g. a health probe.
+type PathHandler struct {
+	Path    string
+	Handler http.Handler
+}
+
+var _ manager.LeaderElectionRunnable = metricsServerRunner{}
+
+// NewMetricsRunner creates controller-runtime's manager.Runnable serving
+// the metrics of gatherer at addr under /metrics along with handlers.
+func NewMetricsRunner(addr string, gatherer prometheus.Gatherer, handlers ...PathHandler) manager.Runnable {
+	return metricsServerRunner{addr, gatherer, handlers}
+}
+
+// StartMetricsServer serves the metrics of gatherer at addr in the
+// background, regardless of leader election so every replica can be
+// scraped. An empty addr or "0" disables it.
+func StartMetricsServer(addr string, gatherer prometheus.Gatherer, log logr.Logger) {
+	if addr == "" || addr == "0" {
+		return
+	}
+	go func() {
+		if err := NewMetricsRunner(addr, gatherer).Start(context.Background()); err != nil {
+			log.Error(err, "metrics server stopped", "addr", addr)
+		}
+	}()
+}
+
+// Start implements controller-runtime's manager.Runnable.
+func (r metricsServerRunner) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(r.gatherer, promhttp.HandlerOpts{}))
+	for _, h := range r.handlers {
+		mux.Handle(h.Path, h.Handler)
+	}
+	srv := &http.Server{Addr: r.addr, Handler: mux}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r metricsServerRunner) NeedLeaderElection() bool {
+	return false
+}
@@ -60,6 +60,11 @@ type LocalDiskAppendInfo struct {
 	LocalDisk
 	Available bool   `json:"available"`
 	Message   string `json:"message"`
+	// Reasons are the machine readable reasons why the device is not available
+	Reasons []UsageReason `json:"reasons,omitempty"`
+	// StaleSignatures are the signatures the rules accepted, the device is
+	// only available for volume groups then
+	StaleSignatures []UsageReason `json:"staleSignatures,omitempty"`
 }
 
 // LocalDisk contains information about an unformatted block device
@@ -108,6 +113,32 @@ type LocalDisk struct {
 	Encrypted bool   `json:"encrypted,omitempty"`
 	Major     uint32 `json:"major,omitempty"`
 	Minor     uint32 `json:"minor,omitempty"`
+	// Holders are the kernel names of the devices built on top of the device
+	Holders []string `json:"holders,omitempty"`
+	// PartitionTable is the type of the partition table on the device, e.g. gpt or dos
+	PartitionTable string `json:"partitionTable,omitempty"`
+	// PartitionName is the name of the partition in the partition table
+	PartitionName string `json:"partitionName,omitempty"`
+	// MultipathMember is whether the device is a path of a multipath device
+	MultipathMember bool `json:"multipathMember,omitempty"`
+	// MultipathPaths are the paths of a multipath device
+	MultipathPaths []MultipathPath `json:"multipathPaths,omitempty"`
+	// NVMeNamespaceID is the namespace id of a NVMe namespace
+	NVMeNamespaceID uint32 `json:"nvmeNamespaceId,omitempty"`
+	// NVMeController is the kernel name of the controller of a NVMe namespace, e.g. nvme0
+	NVMeController string `json:"nvmeController,omitempty"`
+	// PCIAddress is the address of the PCI function the device is attached to
+	PCIAddress string `json:"pciAddress,omitempty"`
+	// NUMANode is the NUMA node of the PCI function, nil without NUMA
+	NUMANode *int `json:"numaNode,omitempty"`
+	// LogicalBlockSize is the smallest unit the device addresses in bytes
+	LogicalBlockSize uint64 `json:"logicalBlockSize,omitempty"`
+	// PhysicalBlockSize is the smallest unit the device writes without a read-modify-write in bytes
+	PhysicalBlockSize uint64 `json:"physicalBlockSize,omitempty"`
+	// Discard is whether the device supports discard
+	Discard bool `json:"discard,omitempty"`
+	// QueueDepth is the depth of the device queue
+	QueueDepth uint64 `json:"queueDepth,omitempty"`
 }
 
 // ListDevices list all devices available on a machine
@@ -356,6 +387,11 @@ func parseUdevInfo(output string) map[string]string {
 	return result
 }
 
+// WipeSignatures erases the filesystem, raid and partition table signatures of the device
+func WipeSignatures(executor exec.Executor, device string) error {
+	return executor.ExecuteCommand("wipefs", "--all", device)
+}
+
 // ListDevicesChild list all child available on a device
 func ListDevicesChild(executor exec.Executor, device string) ([]string, error) {
 	childListRaw, err := executor.ExecuteCommandWithOutput("lsblk", "--noheadings", "--pairs", path.Join("/dev", device))
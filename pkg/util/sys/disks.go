@@ -28,10 +28,17 @@ import (
 )
 
 const (
-	diskPrefix = "/dev/"
+	diskPrefix    = "/dev/"
+	sysClassBlock = "/sys/class/block"
 )
 
 func GetAllDevices(dcontext *cluster.Context) ([]*LocalDiskAppendInfo, error) {
+	return GetAllDevicesWith(NewLsblkEnumerator(dcontext.Executor), DefaultDeviceRules())
+}
+
+// GetAllDevicesWith lists the devices found by enumerator along with whether
+// they are available according to rules.
+func GetAllDevicesWith(enumerator DeviceEnumerator, rules *DeviceRules) ([]*LocalDiskAppendInfo, error) {
 
 	res := make([]*LocalDiskAppendInfo, 0)
 
@@ -39,75 +46,56 @@ func GetAllDevices(dcontext *cluster.Context) ([]*LocalDiskAppendInfo, error) {
 
 	var err error
 
-	if disks, err = DiscoverDevices(dcontext.Executor, true); err != nil {
+	if disks, err = enumerator.DiscoverDevices(true); err != nil {
 		return nil, err
 	}
 
 	for _, device := range disks {
-		// Ignore device with filesystem signature since c-v inventory
-		// cannot detect that correctly
-		if device.Size < uint64(2*(1<<30)) {
-			logger.Infof("skipping device %q because it size less than 2G", device.Name)
-			res = append(res, &LocalDiskAppendInfo{
-				LocalDisk: *device,
-				Available: false,
-				Message:   "size less than 2G",
-			})
-			continue
-		}
-
-		if device.Filesystem != "" {
-			res = append(res, &LocalDiskAppendInfo{
-				LocalDisk: *device,
-				Available: false,
-				Message:   fmt.Sprintf("containe a filesystem %s", device.Filesystem),
-			})
-			logger.Infof("skipping device %q because it contains a filesystem %q", device.Name, device.Filesystem)
-			continue
-		}
-		if device.MountPoint != "" {
-			res = append(res, &LocalDiskAppendInfo{
-				LocalDisk: *device,
-				Available: false,
-				Message:   fmt.Sprintf("has a mount point %s", device.MountPoint),
-			})
-			logger.Infof("skipping device %q because it has a mount point %q", device.Name, device.MountPoint)
-			continue
+		if info := CheckDevice(device, rules); info != nil {
+			res = append(res, info)
 		}
-		if device.Type == DiskType {
-			deviceChild, err := ListDevicesChild(dcontext.Executor, device.KernelName)
-			if err != nil {
-				logger.Warningf("failed to detect child devices for device %q, assuming they are none. %v", device.RealPath, err)
-			}
-			// lsblk will output at least 2 lines if they are partitions, one for the parent
-			// and N for the child
-			if len(deviceChild) > 1 {
-				logger.Infof("skipping device %q because it has child, considering the child instead.", device.RealPath)
-				res = append(res, &LocalDiskAppendInfo{
-					LocalDisk: *device,
-					Available: false,
-					Message:   "has child",
-				})
-				continue
-			}
-		}
-		if device.Type == LoopType {
-			logger.Infof("skip loop type device:%s", device.RealPath)
-			continue
-		}
-		logger.Debugf("device:%s is available", device.Name)
-		res = append(res, &LocalDiskAppendInfo{
-			LocalDisk: *device,
-			Available: true,
-		})
-
 	}
 
 	return res, nil
 
 }
 
+// CheckDevice tells whether the device can be used according to rules, it
+// returns nil for devices that should not be reported at all.
+func CheckDevice(device *LocalDisk, rules *DeviceRules) *LocalDiskAppendInfo {
+	if device.MultipathMember {
+		logger.Infof("skip device %q, it is a path of a multipath device", device.Name)
+		return nil
+	}
+	if findings := rules.Evaluate(device); len(findings) > 0 {
+		message := Messages(findings)
+		logger.Infof("skipping device %q because it %s", device.Name, message)
+		return &LocalDiskAppendInfo{
+			LocalDisk: *device,
+			Available: false,
+			Message:   message,
+			Reasons:   Reasons(findings),
+		}
+	}
+	if device.Type == LoopType {
+		logger.Infof("skip loop type device:%s", device.RealPath)
+		return nil
+	}
+	logger.Debugf("device:%s is available", device.Name)
+	return &LocalDiskAppendInfo{
+		LocalDisk:       *device,
+		Available:       true,
+		StaleSignatures: rules.StaleSignatures(device),
+	}
+}
+
 func GetAvailableDevices(dcontext *cluster.Context) (map[string]*LocalDisk, error) {
+	return GetAvailableDevicesWith(NewLsblkEnumerator(dcontext.Executor), DefaultDeviceRules())
+}
+
+// GetAvailableDevicesWith returns the devices found by enumerator that can be
+// used according to rules, keyed by their path.
+func GetAvailableDevicesWith(enumerator DeviceEnumerator, rules *DeviceRules) (map[string]*LocalDisk, error) {
 
 	availableDevices := make(map[string]*LocalDisk)
 
@@ -115,25 +103,13 @@ func GetAvailableDevices(dcontext *cluster.Context) (map[string]*LocalDisk, erro
 
 	var err error
 
-	if disks, err = DiscoverDevices(dcontext.Executor, false); err != nil {
+	if disks, err = enumerator.DiscoverDevices(false); err != nil {
 		return nil, err
 	}
 
 	for _, device := range disks {
-		// Ignore device with filesystem signature since c-v inventory
-		// cannot detect that correctly
-		if device.Size < uint64(2*(1<<30)) {
-			logger.Infof("skipping device %q because it size less than 2G", device.Name)
-			continue
-		}
-
-		if device.Filesystem != "" {
-			logger.Infof("skipping device %q because it contains a filesystem %q", device.Name, device.Filesystem)
-			continue
-		}
-
-		if device.MountPoint != "" {
-			logger.Infof("skipping device %q because it has a mount point %q", device.Name, device.MountPoint)
+		if message := rules.Check(device); message != "" {
+			logger.Infof("skipping device %q because it %s", device.Name, message)
 			continue
 		}
 
@@ -154,38 +130,22 @@ func DiscoverDevices(executor exec.Executor, listParent bool) ([]*LocalDisk, err
 		return nil, err
 	}
 
+	multipath := make(multipathFilter)
 	for _, d := range devices {
-
-		// Populate device information coming from lsblk
-		disk, err := populateDeviceInfo(d, executor)
+		disk, err := DiscoverDevice(executor, d)
 		if err != nil {
 			logger.Warningf("skipping device %q. %v", d, err)
 			continue
 		}
-
-		// Populate udev information coming from udev
-		disk, err = populateDeviceUdevInfo(d, executor, disk)
-		if err != nil {
-			// go on without udev info
-			// not ideal for our filesystem check later but we can't really fail either...
-			logger.Warningf("failed to get udev info for device %q. %v", d, err)
+		if multipath.hide(disk) {
+			continue
 		}
 
 		// Test if device has child, if so we skip it and only consider the partitions
 		// which will come in later iterations of the loop
-		// We only test if the type is 'disk', this is a property reported by lsblk
-		// and means it's a parent block device
-		if disk.Type == DiskType {
-			deviceChild, err := ListDevicesChild(executor, d)
-			if err != nil {
-				logger.Warningf("failed to detect child devices for device %q, assuming they are none. %v", d, err)
-			}
-			// lsblk will output at least 2 lines if they are partitions, one for the parent
-			// and N for the child
-			if !listParent && len(deviceChild) > 1 {
-				logger.Infof("skipping device %q because it has child, considering the child instead.", d)
-				continue
-			}
+		if !listParent && disk.Type == DiskType && disk.HasChildren {
+			logger.Infof("skipping device %q because it has child, considering the child instead.", d)
+			continue
 		}
 
 		disks = append(disks, disk)
@@ -195,6 +155,47 @@ func DiscoverDevices(executor exec.Executor, listParent bool) ([]*LocalDisk, err
 	return disks, nil
 }
 
+// DiscoverDevice returns the details of the device with the given kernel name
+func DiscoverDevice(executor exec.Executor, d string) (*LocalDisk, error) {
+	// Populate device information coming from lsblk
+	disk, err := populateDeviceInfo(d, executor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Populate udev information coming from udev
+	disk, err = populateDeviceUdevInfo(d, executor, disk)
+	if err != nil {
+		// go on without udev info
+		// not ideal for our filesystem check later but we can't really fail either...
+		logger.Warningf("failed to get udev info for device %q. %v", d, err)
+	}
+
+	// We only test if the type is 'disk', this is a property reported by lsblk
+	// and means it's a parent block device
+	if disk.Type == DiskType {
+		deviceChild, err := ListDevicesChild(executor, d)
+		if err != nil {
+			logger.Warningf("failed to detect child devices for device %q, assuming they are none. %v", d, err)
+		}
+		// lsblk will output at least 2 lines if they are partitions, one for the parent
+		// and N for the child
+		disk.HasChildren = len(deviceChild) > 1
+	}
+
+	if disk.Holders, err = readHolders(path.Join(sysClassBlock, d)); err != nil {
+		logger.Warningf("failed to read holders of device %q, assuming they are none. %v", d, err)
+	}
+	populateMultipath(sysClassBlock, d, disk)
+	if queueDir, err := sysfsQueueDir(sysClassBlock, d); err == nil {
+		populateTopology(queueDir, disk)
+	} else {
+		logger.Warningf("failed to read topology of device %q. %v", d, err)
+	}
+
+	return disk, nil
+}
+
 // PopulateDeviceInfo returns the information of the specified block device
 func populateDeviceInfo(d string, executor exec.Executor) (*LocalDisk, error) {
 	diskProps, err := GetDeviceProperties(d, executor)
@@ -309,6 +310,18 @@ func populateDeviceUdevInfo(d string, executor exec.Executor, disk *LocalDisk) (
 		disk.WWN = val
 	}
 
+	if val, ok := udevInfo["ID_PART_TABLE_TYPE"]; ok {
+		disk.PartitionTable = val
+	}
+
+	if val, ok := udevInfo["ID_PART_ENTRY_NAME"]; ok {
+		disk.PartitionName = val
+	}
+
+	if val, ok := udevInfo[udevMultipathPath]; ok && val == "1" {
+		disk.MultipathMember = true
+	}
+
 	return disk, nil
 }
 
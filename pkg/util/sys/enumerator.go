@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"fmt"
+
+	"github.com/alauda/nativestor/pkg/util/exec"
+)
+
+const (
+	// BackendLsblk enumerates devices with lsblk, udevadm and sgdisk
+	BackendLsblk = "lsblk"
+	// BackendSysfs enumerates devices by reading sysfs and the udev database
+	BackendSysfs = "sysfs"
+)
+
+// DeviceEnumerator lists the block devices of the node.
+type DeviceEnumerator interface {
+	// DiscoverDevices returns all the details of devices available on the
+	// node. Disks holding partitions or other devices are only returned when
+	// listParent is true.
+	DiscoverDevices(listParent bool) ([]*LocalDisk, error)
+	// DiscoverDevice returns the details of the device with the given kernel
+	// name, e.g. sdb.
+	DiscoverDevice(name string) (*LocalDisk, error)
+}
+
+// NewDeviceEnumerator returns the enumerator of the given backend.
+func NewDeviceEnumerator(backend string, executor exec.Executor) (DeviceEnumerator, error) {
+	switch backend {
+	case "", BackendLsblk:
+		return NewLsblkEnumerator(executor), nil
+	case BackendSysfs:
+		return NewSysfsEnumerator(), nil
+	default:
+		return nil, fmt.Errorf("unknown device enumerator backend %q", backend)
+	}
+}
+
+type lsblkEnumerator struct {
+	executor exec.Executor
+}
+
+// NewLsblkEnumerator returns an enumerator shelling out to lsblk, udevadm and sgdisk.
+func NewLsblkEnumerator(executor exec.Executor) DeviceEnumerator {
+	return &lsblkEnumerator{executor: executor}
+}
+
+func (e *lsblkEnumerator) DiscoverDevices(listParent bool) ([]*LocalDisk, error) {
+	return DiscoverDevices(e.executor, listParent)
+}
+
+func (e *lsblkEnumerator) DiscoverDevice(name string) (*LocalDisk, error) {
+	return DiscoverDevice(e.executor, name)
+}
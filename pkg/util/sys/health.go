@@ -0,0 +1,234 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	rawv1 "github.com/alauda/nativestor/apis/rawdevice/v1"
+	"github.com/alauda/nativestor/pkg/util/exec"
+)
+
+const (
+	// smartctl exit status bits telling the command line could not be parsed
+	// or the device could not be opened, the output is useless then
+	smartctlFatalBits = 0x3
+
+	// kelvinOffset converts the NVMe smart log temperature to Celsius
+	kelvinOffset = 273
+
+	ataReallocatedSectors = 5
+	ataPendingSectors     = 197
+)
+
+// HealthCollector reads the health a device reports about itself.
+type HealthCollector interface {
+	DeviceHealth(device *LocalDisk) (*rawv1.DeviceHealth, error)
+}
+
+// NewHealthCollector returns a collector reading SMART with smartctl and
+// falling back to the nvme smart-log for NVMe devices.
+func NewHealthCollector(executor exec.Executor) HealthCollector {
+	return &smartCollector{executor: executor}
+}
+
+type smartCollector struct {
+	executor exec.Executor
+}
+
+// smartctlOutput is the part of the smartctl --json output read for health
+type smartctlOutput struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String string `json:"string"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature *struct {
+		Current int64 `json:"current"`
+	} `json:"temperature"`
+	PowerOnTime *struct {
+		Hours int64 `json:"hours"`
+	} `json:"power_on_time"`
+	AtaSmartAttributes *struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	ScsiGrownDefectList *int64        `json:"scsi_grown_defect_list"`
+	NvmeSmartLog        *nvmeSmartLog `json:"nvme_smart_health_information_log"`
+}
+
+// nvmeSmartLog holds the fields shared by the smartctl nvme log and the
+// nvme-cli smart-log json output
+type nvmeSmartLog struct {
+	CriticalWarning *int64 `json:"critical_warning"`
+	Temperature     *int64 `json:"temperature"`
+	PercentageUsed  *int64 `json:"percentage_used"`
+	PercentUsed     *int64 `json:"percent_used"`
+	MediaErrors     *int64 `json:"media_errors"`
+	PowerOnHours    *int64 `json:"power_on_hours"`
+}
+
+func (c *smartCollector) DeviceHealth(device *LocalDisk) (*rawv1.DeviceHealth, error) {
+	devicePath := diskPrefix + device.Name
+	health, smartErr := c.smartctlHealth(devicePath)
+	if smartErr == nil {
+		return health, nil
+	}
+	if !strings.HasPrefix(device.Name, "nvme") {
+		return nil, smartErr
+	}
+	health, err := c.nvmeHealth(devicePath)
+	if err != nil {
+		return nil, fmt.Errorf("%v, and %v", smartErr, err)
+	}
+	return health, nil
+}
+
+func (c *smartCollector) smartctlHealth(devicePath string) (*rawv1.DeviceHealth, error) {
+	// smartctl exits non zero for failing disks too, the status is in the json
+	output, err := c.executor.ExecuteCommandWithCombinedOutput("smartctl", "--json", "--health", "--attributes", devicePath)
+	if output == "" && err != nil {
+		return nil, fmt.Errorf("failed to run smartctl on %s: %v", devicePath, err)
+	}
+	return parseSmartctlHealth(output)
+}
+
+func (c *smartCollector) nvmeHealth(devicePath string) (*rawv1.DeviceHealth, error) {
+	output, err := c.executor.ExecuteCommandWithOutput("nvme", "smart-log", "--output-format=json", devicePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read nvme smart log of %s: %v", devicePath, err)
+	}
+	log := &nvmeSmartLog{}
+	if err := json.Unmarshal([]byte(output), log); err != nil {
+		return nil, fmt.Errorf("failed to parse nvme smart log of %s: %v", devicePath, err)
+	}
+	// nvme-cli reports Kelvin while smartctl converts to Celsius
+	if log.Temperature != nil {
+		celsius := *log.Temperature - kelvinOffset
+		log.Temperature = &celsius
+	}
+	health := &rawv1.DeviceHealth{}
+	log.apply(health)
+	evaluateHealth(health)
+	return health, nil
+}
+
+func parseSmartctlHealth(output string) (*rawv1.DeviceHealth, error) {
+	out := &smartctlOutput{}
+	if err := json.Unmarshal([]byte(output), out); err != nil {
+		return nil, fmt.Errorf("failed to parse smartctl output: %v", err)
+	}
+	if out.Smartctl.ExitStatus&smartctlFatalBits != 0 {
+		var messages []string
+		for _, m := range out.Smartctl.Messages {
+			messages = append(messages, m.String)
+		}
+		return nil, fmt.Errorf("smartctl failed with status %d: %s", out.Smartctl.ExitStatus, strings.Join(messages, "; "))
+	}
+
+	health := &rawv1.DeviceHealth{}
+	if out.SmartStatus != nil {
+		passed := out.SmartStatus.Passed
+		health.SmartPassed = &passed
+	}
+	if out.Temperature != nil {
+		health.Temperature = int64Ptr(out.Temperature.Current)
+	}
+	if out.PowerOnTime != nil {
+		health.PowerOnHours = int64Ptr(out.PowerOnTime.Hours)
+	}
+	if out.AtaSmartAttributes != nil {
+		for _, attr := range out.AtaSmartAttributes.Table {
+			switch attr.ID {
+			case ataReallocatedSectors:
+				health.ReallocatedSectors = int64Ptr(attr.Raw.Value)
+			case ataPendingSectors:
+				health.PendingSectors = int64Ptr(attr.Raw.Value)
+			}
+		}
+	}
+	if out.ScsiGrownDefectList != nil {
+		health.ReallocatedSectors = int64Ptr(*out.ScsiGrownDefectList)
+	}
+	if out.NvmeSmartLog != nil {
+		out.NvmeSmartLog.apply(health)
+	}
+	if health.SmartPassed == nil && out.NvmeSmartLog == nil && out.AtaSmartAttributes == nil {
+		return nil, fmt.Errorf("smartctl reported no health for the device")
+	}
+	evaluateHealth(health)
+	return health, nil
+}
+
+func (l *nvmeSmartLog) apply(health *rawv1.DeviceHealth) {
+	health.CriticalWarning = l.CriticalWarning
+	if l.Temperature != nil {
+		health.Temperature = l.Temperature
+	}
+	health.PercentageUsed = l.PercentageUsed
+	if health.PercentageUsed == nil {
+		health.PercentageUsed = l.PercentUsed
+	}
+	health.MediaErrors = l.MediaErrors
+	if l.PowerOnHours != nil {
+		health.PowerOnHours = l.PowerOnHours
+	}
+}
+
+// evaluateHealth marks the device unhealthy when it failed its self
+// assessment or reports errors that only grow, the temperature and the
+// reallocated sectors are reported but do not fail a device on their own.
+func evaluateHealth(health *rawv1.DeviceHealth) {
+	var reasons []string
+	if health.SmartPassed != nil && !*health.SmartPassed {
+		reasons = append(reasons, "SMART self assessment failed")
+	}
+	if health.CriticalWarning != nil && *health.CriticalWarning != 0 {
+		reasons = append(reasons, fmt.Sprintf("critical warning 0x%x", *health.CriticalWarning))
+	}
+	if health.MediaErrors != nil && *health.MediaErrors > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d media errors", *health.MediaErrors))
+	}
+	if health.PercentageUsed != nil && *health.PercentageUsed >= 100 {
+		reasons = append(reasons, fmt.Sprintf("%d%% of endurance used", *health.PercentageUsed))
+	}
+	if health.PendingSectors != nil && *health.PendingSectors > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d pending sectors", *health.PendingSectors))
+	}
+	health.Healthy = len(reasons) == 0
+	health.Reason = strings.Join(reasons, ", ")
+}
+
+// SupportsHealth tells whether a device reports its own health, only whole
+// disks do.
+func SupportsHealth(device *LocalDisk) bool {
+	return device.Type == DiskType || device.Type == SSDType
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"errors"
+	"testing"
+
+	exectest "github.com/alauda/nativestor/pkg/util/exec/test"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	smartctlATAOutput = `{
+  "smartctl": {"version": [7, 2], "exit_status": 0},
+  "device": {"name": "/dev/sda", "type": "sat"},
+  "smart_status": {"passed": true},
+  "ata_smart_attributes": {
+    "revision": 16,
+    "table": [
+      {"id": 5, "name": "Reallocated_Sector_Ct", "raw": {"value": 8, "string": "8"}},
+      {"id": 9, "name": "Power_On_Hours", "raw": {"value": 21034, "string": "21034"}},
+      {"id": 197, "name": "Current_Pending_Sector", "raw": {"value": 0, "string": "0"}}
+    ]
+  },
+  "power_on_time": {"hours": 21034},
+  "temperature": {"current": 34}
+}`
+	smartctlNVMeOutput = `{
+  "smartctl": {"version": [7, 2], "exit_status": 4},
+  "device": {"name": "/dev/nvme0", "type": "nvme"},
+  "smart_status": {"passed": true, "nvme": {"value": 0}},
+  "nvme_smart_health_information_log": {
+    "critical_warning": 0,
+    "temperature": 41,
+    "available_spare": 100,
+    "percentage_used": 12,
+    "power_on_hours": 5012,
+    "media_errors": 3
+  },
+  "temperature": {"current": 41},
+  "power_on_time": {"hours": 5012}
+}`
+	smartctlOpenFailedOutput = `{
+  "smartctl": {"version": [7, 2], "exit_status": 2,
+    "messages": [{"string": "Smartctl open device: /dev/nvme1n1 failed: Permission denied", "severity": "error"}]}
+}`
+	nvmeSmartLogOutput = `{
+  "critical_warning" : 4,
+  "temperature" : 318,
+  "avail_spare" : 100,
+  "spare_thresh" : 10,
+  "percent_used" : 100,
+  "media_errors" : 0,
+  "num_err_log_entries" : 0,
+  "power_on_hours" : 40213
+}`
+)
+
+func TestParseSmartctlHealth(t *testing.T) {
+	health, err := parseSmartctlHealth(smartctlATAOutput)
+	assert.NoError(t, err)
+	assert.True(t, health.Healthy)
+	assert.True(t, *health.SmartPassed)
+	assert.Equal(t, int64(34), *health.Temperature)
+	assert.Equal(t, int64(8), *health.ReallocatedSectors)
+	assert.Equal(t, int64(0), *health.PendingSectors)
+	assert.Equal(t, int64(21034), *health.PowerOnHours)
+	assert.Nil(t, health.MediaErrors)
+
+	health, err = parseSmartctlHealth(smartctlNVMeOutput)
+	assert.NoError(t, err)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, "3 media errors", health.Reason)
+	assert.Equal(t, int64(12), *health.PercentageUsed)
+	assert.Equal(t, int64(0), *health.CriticalWarning)
+
+	_, err = parseSmartctlHealth(smartctlOpenFailedOutput)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Permission denied")
+}
+
+func TestHealthCollectorNVMeFallback(t *testing.T) {
+	executor := &exectest.MockExecutor{
+		MockExecuteCommandWithCombinedOutput: func(command string, arg ...string) (string, error) {
+			assert.Equal(t, "smartctl", command)
+			return "", errors.New("executable file not found in $PATH")
+		},
+		MockExecuteCommandWithOutput: func(command string, arg ...string) (string, error) {
+			assert.Equal(t, "nvme", command)
+			assert.Equal(t, "/dev/nvme1n1", arg[len(arg)-1])
+			return nvmeSmartLogOutput, nil
+		},
+	}
+	collector := NewHealthCollector(executor)
+
+	health, err := collector.DeviceHealth(&LocalDisk{Name: "nvme1n1", Type: DiskType})
+	assert.NoError(t, err)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, "critical warning 0x4, 100% of endurance used", health.Reason)
+	assert.Equal(t, int64(45), *health.Temperature)
+	assert.Equal(t, int64(40213), *health.PowerOnHours)
+
+	_, err = collector.DeviceHealth(&LocalDisk{Name: "sdb", Type: DiskType})
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UsageReason is a machine readable reason why a device is not available.
+type UsageReason string
+
+const (
+	// ReasonTooSmall is reported for devices below the minimum size
+	ReasonTooSmall UsageReason = "TooSmall"
+	// ReasonExcluded is reported for devices matching an exclude rule
+	ReasonExcluded UsageReason = "ExcludedByRule"
+	// ReasonNotIncluded is reported for devices matching no include rule
+	ReasonNotIncluded UsageReason = "NotIncluded"
+	// ReasonUnhealthy is reported for devices failing their health check
+	ReasonUnhealthy UsageReason = "Unhealthy"
+
+	// ReasonFilesystem is a filesystem signature on the device
+	ReasonFilesystem UsageReason = "Filesystem"
+	// ReasonLVMPhysicalVolume is a LVM physical volume label on the device
+	ReasonLVMPhysicalVolume UsageReason = "LVMPhysicalVolume"
+	// ReasonPartitionTable is a partition table without partitions
+	ReasonPartitionTable UsageReason = "PartitionTable"
+	// ReasonRAIDMember is a md or firmware raid superblock on the device
+	ReasonRAIDMember UsageReason = "RAIDMember"
+	// ReasonCeph is a ceph bluestore label or ceph partition on the device
+	ReasonCeph UsageReason = "Ceph"
+	// ReasonSwapSignature is a swap signature of a device not used as swap
+	ReasonSwapSignature UsageReason = "SwapSignature"
+
+	// ReasonMounted is reported for mounted devices
+	ReasonMounted UsageReason = "Mounted"
+	// ReasonSwap is reported for devices used as swap
+	ReasonSwap UsageReason = "Swap"
+	// ReasonPartitioned is reported for disks holding partitions
+	ReasonPartitioned UsageReason = "Partitioned"
+	// ReasonDeviceMapperHolder is reported for devices a device mapper
+	// target, e.g. a LVM logical volume or a dm-crypt mapping, is built on
+	ReasonDeviceMapperHolder UsageReason = "DeviceMapperHolder"
+	// ReasonRAIDActive is reported for members of an assembled md array
+	ReasonRAIDActive UsageReason = "RAIDActive"
+	// ReasonHolder is reported for devices held by any other kernel device
+	ReasonHolder UsageReason = "Holder"
+)
+
+// signatureReasons are left on disk by a previous owner, they may be stale
+// and can be accepted for volume groups since the signature is wiped before
+// the physical volume is created.
+var signatureReasons = map[UsageReason]bool{
+	ReasonFilesystem:        true,
+	ReasonLVMPhysicalVolume: true,
+	ReasonPartitionTable:    true,
+	ReasonRAIDMember:        true,
+	ReasonCeph:              true,
+	ReasonSwapSignature:     true,
+}
+
+// IsSignatureReason tells whether the reason is an on-disk signature rather
+// than a consumer currently using the device.
+func IsSignatureReason(reason UsageReason) bool {
+	return signatureReasons[reason]
+}
+
+// Finding is one reason a device is not available.
+type Finding struct {
+	Reason  UsageReason
+	Message string
+}
+
+// Reasons returns the reason codes of the findings.
+func Reasons(findings []Finding) []UsageReason {
+	var reasons []UsageReason
+	for _, f := range findings {
+		reasons = append(reasons, f.Reason)
+	}
+	return reasons
+}
+
+// Messages joins the messages of the findings.
+func Messages(findings []Finding) string {
+	var messages []string
+	for _, f := range findings {
+		messages = append(messages, f.Message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+// UsageFindings lists the signatures and consumers found on the device.
+// Consumers are only seen through the mounts and the holders in sysfs, the
+// device is never opened since an exclusive open races the pvcreate and
+// vgextend of volume group jobs. A process using the device directly, e.g. a
+// virtual machine, is not found.
+func UsageFindings(device *LocalDisk) []Finding {
+	var findings []Finding
+	add := func(reason UsageReason, format string, args ...interface{}) {
+		findings = append(findings, Finding{Reason: reason, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch fs := device.Filesystem; {
+	case fs == "":
+	case fs == "LVM2_member":
+		add(ReasonLVMPhysicalVolume, "is a lvm physical volume")
+	case strings.HasSuffix(fs, "_raid_member"):
+		add(ReasonRAIDMember, "is a raid member %s", fs)
+	case strings.HasPrefix(fs, "ceph"):
+		add(ReasonCeph, "contains a ceph label %s", fs)
+	case fs == "swap":
+		if device.MountPoint != swapMountPoint {
+			add(ReasonSwapSignature, "contains a swap signature")
+		}
+	default:
+		add(ReasonFilesystem, "contains a filesystem %s", fs)
+	}
+	if strings.HasPrefix(strings.ToLower(device.PartitionName), "ceph") {
+		add(ReasonCeph, "is a ceph partition %s", device.PartitionName)
+	}
+
+	switch device.MountPoint {
+	case "":
+	case swapMountPoint:
+		add(ReasonSwap, "is used as swap")
+	default:
+		add(ReasonMounted, "has a mount point %s", device.MountPoint)
+	}
+
+	var dm, md, others []string
+	for _, holder := range device.Holders {
+		switch {
+		case strings.HasPrefix(holder, "dm-"):
+			dm = append(dm, holder)
+		case strings.HasPrefix(holder, "md"):
+			md = append(md, holder)
+		default:
+			others = append(others, holder)
+		}
+	}
+	if len(dm) > 0 {
+		add(ReasonDeviceMapperHolder, "is held by %s", strings.Join(dm, " "))
+	}
+	if len(md) > 0 {
+		add(ReasonRAIDActive, "is a member of %s", strings.Join(md, " "))
+	}
+	if len(others) > 0 {
+		add(ReasonHolder, "is held by %s", strings.Join(others, " "))
+	}
+
+	// the holders of a disk are children too, only report partitions when
+	// they are something else
+	if device.HasChildren && (len(device.Holders) == 0 || device.PartitionTable != "") {
+		add(ReasonPartitioned, "has child")
+	} else if device.PartitionTable != "" {
+		add(ReasonPartitionTable, "contains a %s partition table", device.PartitionTable)
+	}
+	return findings
+}
+
+// readHolders lists the kernel devices built on top of the device.
+func readHolders(devDir string) ([]string, error) {
+	holders, err := listDir(filepath.Join(devDir, "holders"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return holders, nil
+}
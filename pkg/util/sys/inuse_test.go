@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageFindings(t *testing.T) {
+	tests := []struct {
+		disk    LocalDisk
+		reasons []UsageReason
+	}{
+		{LocalDisk{Name: "sdb", Type: DiskType}, nil},
+		{LocalDisk{Name: "sdb", Type: DiskType, Filesystem: "xfs"}, []UsageReason{ReasonFilesystem}},
+		{LocalDisk{Name: "sdb", Type: DiskType, Filesystem: "LVM2_member"}, []UsageReason{ReasonLVMPhysicalVolume}},
+		{LocalDisk{Name: "sdb", Type: DiskType, Filesystem: "LVM2_member", HasChildren: true, Holders: []string{"dm-3", "dm-4"}},
+			[]UsageReason{ReasonLVMPhysicalVolume, ReasonDeviceMapperHolder}},
+		{LocalDisk{Name: "sdb", Type: DiskType, Filesystem: "linux_raid_member"}, []UsageReason{ReasonRAIDMember}},
+		{LocalDisk{Name: "sdb", Type: DiskType, Filesystem: "linux_raid_member", HasChildren: true, Holders: []string{"md127"}},
+			[]UsageReason{ReasonRAIDMember, ReasonRAIDActive}},
+		{LocalDisk{Name: "sdb", Type: DiskType, Filesystem: "ceph_bluestore"}, []UsageReason{ReasonCeph}},
+		{LocalDisk{Name: "sdb1", Type: PartType, PartitionName: "ceph data"}, []UsageReason{ReasonCeph}},
+		{LocalDisk{Name: "sdb", Type: DiskType, Filesystem: "swap"}, []UsageReason{ReasonSwapSignature}},
+		{LocalDisk{Name: "sdb", Type: DiskType, Filesystem: "swap", MountPoint: "[SWAP]"}, []UsageReason{ReasonSwap}},
+		{LocalDisk{Name: "sdb", Type: DiskType, PartitionTable: "gpt"}, []UsageReason{ReasonPartitionTable}},
+		{LocalDisk{Name: "sdb", Type: DiskType, PartitionTable: "gpt", HasChildren: true}, []UsageReason{ReasonPartitioned}},
+		{LocalDisk{Name: "sdb", Type: DiskType, HasChildren: true, Holders: []string{"bcache0"}}, []UsageReason{ReasonHolder}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.reasons, Reasons(UsageFindings(&tt.disk)), "%+v", tt.disk)
+	}
+}
+
+func TestEvaluateIgnoresSignatures(t *testing.T) {
+	disk := &LocalDisk{Name: "sdb", Type: DiskType, Size: 10 << 30, PartitionTable: "gpt", Filesystem: "LVM2_member"}
+
+	rules := &DeviceRules{IgnoreReasons: []UsageReason{ReasonPartitionTable}}
+	assert.Equal(t, []UsageReason{ReasonLVMPhysicalVolume}, Reasons(rules.Evaluate(disk)))
+
+	rules = &DeviceRules{AllowStaleSignatures: true}
+	assert.Empty(t, rules.Evaluate(disk))
+	info := CheckDevice(disk, rules)
+	assert.True(t, info.Available)
+	assert.Equal(t, []UsageReason{ReasonLVMPhysicalVolume, ReasonPartitionTable}, info.StaleSignatures)
+	assert.Empty(t, CheckDevice(&LocalDisk{Name: "sdc", Type: DiskType, Size: 10 << 30}, rules).StaleSignatures)
+
+	// consumers are never ignored
+	disk.PartitionTable = ""
+	disk.Holders = []string{"dm-0"}
+	disk.HasChildren = true
+	assert.Equal(t, []UsageReason{ReasonDeviceMapperHolder}, Reasons(rules.Evaluate(disk)))
+
+	_, err := ParseDeviceRules("ignoreReasons: [PartitionTable, Ceph]")
+	assert.NoError(t, err)
+	_, err = ParseDeviceRules("ignoreReasons: [Mounted]")
+	assert.Error(t, err)
+}
@@ -90,6 +90,208 @@ func GetPhysicalVolume(executor exec.Executor, vgname string) (LVInfo, error) {
 
 }
 
+// PhysicalVolumeState is a physical volume of a volume group, lvm names a
+// missing one [unknown], its uuid tells them apart.
+type PhysicalVolumeState struct {
+	Name    string
+	UUID    string
+	Missing bool
+}
+
+// GetPhysicalVolumeStates lists the physical volumes of the volume group
+// along with whether lvm misses them.
+func GetPhysicalVolumeStates(executor exec.Executor, vgname string) ([]PhysicalVolumeState, error) {
+
+	infoList, err := parseOutput(executor, "vgs", "pv_name,pv_uuid,pv_missing", vgname)
+	if err != nil {
+		return nil, perrors.Wrapf(err, "parse out failed cmd:%s %s %s", "vgs", "pv_name,pv_uuid,pv_missing", vgname)
+	}
+	var states []PhysicalVolumeState
+	for _, info := range infoList {
+		states = append(states, PhysicalVolumeState{
+			Name:    info["pv_name"],
+			UUID:    info["pv_uuid"],
+			Missing: info["pv_missing"] != "",
+		})
+	}
+	return states, nil
+}
+
+// ThinPool is a thin pool logical volume and its usage.
+type ThinPool struct {
+	Name            string
+	Size            uint64
+	DataPercent     string
+	MetadataPercent string
+}
+
+// GetThinPool returns the thin pool of the volume group, or nil if there is
+// no logical volume of that name.
+func GetThinPool(executor exec.Executor, vgname string, pool string) (*ThinPool, error) {
+
+	field := "lv_name,lv_attr,lv_size,data_percent,metadata_percent"
+	infoList, err := parseOutput(executor, "lvs", field, vgname)
+	if err != nil {
+		return nil, perrors.Wrapf(err, "parse out failed cmd:%s %s %s", "lvs", field, vgname)
+	}
+	for _, info := range infoList {
+		if info["lv_name"] != pool {
+			continue
+		}
+		// the volume type is the first attribute, t for thin pools
+		if !strings.HasPrefix(info["lv_attr"], "t") {
+			return nil, fmt.Errorf("lv %s/%s is not a thin pool", vgname, pool)
+		}
+		size, err := strconv.ParseUint(info["lv_size"], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &ThinPool{Name: pool, Size: size, DataPercent: info["data_percent"], MetadataPercent: info["metadata_percent"]}, nil
+	}
+	return nil, nil
+}
+
+// CreateThinPool creates a thin pool whose data takes sizePercent of the
+// volume group, lvm sizes its metadata.
+func CreateThinPool(executor exec.Executor, vgname string, pool string, sizePercent uint) error {
+
+	return wrapExecCommand(executor, lvm, "lvcreate", "--type", "thin-pool", "-l", fmt.Sprintf("%d%%VG", sizePercent), "-n", pool, vgname)
+}
+
+// ExtendThinPool grows the thin pool data to sizePercent of the volume group.
+func ExtendThinPool(executor exec.Executor, vgname string, pool string, sizePercent uint) error {
+
+	return wrapExecCommand(executor, lvm, "lvextend", "-l", fmt.Sprintf("%d%%VG", sizePercent), vgname+"/"+pool)
+}
+
+// RaidVolume is a raid logical volume and its health.
+type RaidVolume struct {
+	Name        string
+	SegType     string
+	Health      string
+	SyncPercent string
+}
+
+// GetRaidVolumes lists the raid logical volumes of the volume group.
+func GetRaidVolumes(executor exec.Executor, vgname string) ([]RaidVolume, error) {
+
+	field := "lv_name,segtype,lv_health_status,sync_percent"
+	infoList, err := parseOutput(executor, "lvs", field, vgname)
+	if err != nil {
+		return nil, perrors.Wrapf(err, "parse out failed cmd:%s %s %s", "lvs", field, vgname)
+	}
+	var volumes []RaidVolume
+	for _, info := range infoList {
+		if !strings.HasPrefix(info["segtype"], "raid") {
+			continue
+		}
+		volumes = append(volumes, RaidVolume{
+			Name:        info["lv_name"],
+			SegType:     info["segtype"],
+			Health:      info["lv_health_status"],
+			SyncPercent: info["sync_percent"],
+		})
+	}
+	return volumes, nil
+}
+
+// RepairRaidVolume replaces the failed images of the raid volume with free
+// space of the given physical volume.
+func RepairRaidVolume(executor exec.Executor, vgname string, lv string, pv string) error {
+
+	return wrapExecCommand(executor, lvm, "lvconvert", "--repair", "-y", vgname+"/"+lv, pv)
+}
+
+// RemoveMissingPhysicalVolumes drops the missing physical volumes no logical
+// volume uses any more from the volume group.
+func RemoveMissingPhysicalVolumes(executor exec.Executor, vgname string) error {
+
+	return wrapExecCommand(executor, lvm, "vgreduce", "--removemissing", vgname)
+}
+
+func GetVolumeGroupExtentSize(executor exec.Executor, vgname string) (uint64, error) {
+
+	infoList, err := parseOutput(executor, "vgs", "vg_extent_size", vgname)
+	if err != nil {
+		return 0, err
+	}
+	if len(infoList) != 1 {
+		return 0, errors.New("volume group not found: " + vgname)
+	}
+	return strconv.ParseUint(infoList[0]["vg_extent_size"], 10, 64)
+}
+
+// LogicalVolume is a logical volume and the statistics of its dm-cache, if
+// it has one.
+type LogicalVolume struct {
+	Name             string
+	Size             uint64
+	SegType          string
+	CacheReadHits    uint64
+	CacheReadMisses  uint64
+	CacheWriteHits   uint64
+	CacheWriteMisses uint64
+}
+
+// GetLogicalVolumes lists the visible logical volumes of the volume group.
+func GetLogicalVolumes(executor exec.Executor, vgname string) ([]LogicalVolume, error) {
+
+	field := "lv_name,lv_size,segtype,cache_read_hits,cache_read_misses,cache_write_hits,cache_write_misses"
+	infoList, err := parseOutput(executor, "lvs", field, vgname)
+	if err != nil {
+		return nil, perrors.Wrapf(err, "parse out failed cmd:%s %s %s", "lvs", field, vgname)
+	}
+	var volumes []LogicalVolume
+	for _, info := range infoList {
+		lv := LogicalVolume{Name: info["lv_name"], SegType: info["segtype"]}
+		values := []*uint64{&lv.Size, &lv.CacheReadHits, &lv.CacheReadMisses, &lv.CacheWriteHits, &lv.CacheWriteMisses}
+		for i, key := range []string{"lv_size", "cache_read_hits", "cache_read_misses", "cache_write_hits", "cache_write_misses"} {
+			// the cache statistics are empty for volumes without dm-cache
+			if info[key] == "" {
+				continue
+			}
+			if *values[i], err = strconv.ParseUint(info[key], 10, 64); err != nil {
+				return nil, perrors.Wrapf(err, "parse %s of lv %s/%s failed", key, vgname, lv.Name)
+			}
+		}
+		volumes = append(volumes, lv)
+	}
+	return volumes, nil
+}
+
+// CreateLogicalVolume creates a logical volume of size bytes on the physical
+// volumes.
+func CreateLogicalVolume(executor exec.Executor, vgname string, lv string, size uint64, pvs []string) error {
+
+	args := []string{"lvcreate", "-y", "-n", lv, "-L", fmt.Sprintf("%db", size), vgname}
+	args = append(args, pvs...)
+	return wrapExecCommand(executor, lvm, args...)
+}
+
+// AttachCacheVolume makes cacheVol the cache of the logical volume, mode is
+// only given to dm-cache.
+func AttachCacheVolume(executor exec.Executor, vgname string, lv string, cacheVol string, cacheType string, mode string) error {
+
+	args := []string{"lvconvert", "-y", "--type", cacheType, "--cachevol", cacheVol}
+	if mode != "" {
+		args = append(args, "--cachemode", mode)
+	}
+	args = append(args, vgname+"/"+lv)
+	return wrapExecCommand(executor, lvm, args...)
+}
+
+// SetPhysicalVolumesAllocatable allows or forbids allocating extents of the
+// physical volumes.
+func SetPhysicalVolumesAllocatable(executor exec.Executor, pvs []string, allocatable bool) error {
+
+	flag := "n"
+	if allocatable {
+		flag = "y"
+	}
+	args := append([]string{"pvchange", "-x", flag}, pvs...)
+	return wrapExecCommand(executor, lvm, args...)
+}
+
 func CheckPVHasLogicalVolume(executor exec.Executor, pvname string) (bool, error) {
 
 	field := "+lv_name"
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/alauda/nativestor/pkg/util/exec"
+	perrors "github.com/pkg/errors"
+)
+
+const (
+	// hostLvmLockDir is the default locking_dir of lvm on the host, seen
+	// through the root of the host pid 1
+	hostLvmLockDir = "/proc/1/root/run/lock/lvm"
+	// noLocking makes an lvm command skip the file lock of the volume group,
+	// it is only passed while the caller holds that lock
+	noLocking = "--nolocking"
+)
+
+// LockVolumeGroup takes the lvm file lock of the volume group on the host the
+// way lvm does, so lvm commands on the volume group, e.g. the ones of lvmd,
+// wait until unlock is called. Commands run meanwhile must not lock the
+// volume group themselves.
+func LockVolumeGroup(vgname string) (unlock func(), err error) {
+	return lockFile(filepath.Join(hostLvmLockDir, "V_"+vgname))
+}
+
+// lockFile takes an exclusive flock of the file. lvm removes lock files it
+// finds unused, so the lock is taken again until it is held on the file
+// which is still in place.
+func lockFile(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, perrors.Wrapf(err, "create lock dir of %s failed", path)
+	}
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, perrors.Wrapf(err, "open lock file %s failed", path)
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			f.Close()
+			return nil, perrors.Wrapf(err, "lock %s failed", path)
+		}
+		held, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, perrors.Wrapf(err, "stat lock file %s failed", path)
+		}
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(held, current) {
+			return func() {
+				if err := f.Close(); err != nil {
+					logger.Warningf("unlock %s failed %v", path, err)
+				}
+			}, nil
+		}
+		f.Close()
+	}
+}
+
+// CreateCacheVolume creates the cache volume lv on the cache devices pvs of
+// the volume group. The cache devices are only allocatable while the volume
+// group is locked, so no other volume is ever placed on them.
+func CreateCacheVolume(executor exec.Executor, lock func(vgname string) (func(), error), vgname string, lv string, size uint64, pvs []string) error {
+
+	unlock, err := lock(vgname)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	allow := append([]string{"pvchange", noLocking, "-x", "y"}, pvs...)
+	if err := wrapExecCommand(executor, lvm, allow...); err != nil {
+		return err
+	}
+	create := append([]string{"lvcreate", noLocking, "-y", "-n", lv, "-L", fmt.Sprintf("%db", size), vgname}, pvs...)
+	err = wrapExecCommand(executor, lvm, create...)
+	forbid := append([]string{"pvchange", noLocking, "-x", "n"}, pvs...)
+	if resetErr := wrapExecCommand(executor, lvm, forbid...); resetErr != nil {
+		return perrors.Wrapf(resetErr, "forbid allocating on cache devices of vg %s failed", vgname)
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// multipathUUIDPrefix prefixes the device mapper uuid of multipath maps
+	multipathUUIDPrefix = "mpath-"
+	// PathStateRunning is the scsi device state of a usable path
+	PathStateRunning = "running"
+	// pathStateUnknown is reported for paths without a scsi device state
+	pathStateUnknown = "unknown"
+	// udevMultipathPath is set by the multipath udev rules on the paths of a
+	// map, before the map is assembled too
+	udevMultipathPath = "DM_MULTIPATH_DEVICE_PATH"
+)
+
+// MultipathPath is one path of a multipath map.
+type MultipathPath struct {
+	// Name is the kernel name of the path, e.g. sdb
+	Name string `json:"name"`
+	// State is the scsi device state of the path, e.g. running or offline
+	State string `json:"state"`
+}
+
+// ActivePaths returns the number of usable paths of a multipath map.
+func (d *LocalDisk) ActivePaths() int {
+	active := 0
+	for _, p := range d.MultipathPaths {
+		if p.State == PathStateRunning {
+			active++
+		}
+	}
+	return active
+}
+
+// isMultipathMap tells whether the device mapper device is a multipath map.
+func isMultipathMap(classBlock, name string) bool {
+	dmUUID, err := readSysfsString(filepath.Join(classBlock, name, "dm", "uuid"))
+	return err == nil && IsMultipathUUID(dmUUID)
+}
+
+// IsMultipathMap tells whether the device mapper device of the given kernel
+// name, e.g. dm-0, is a multipath map.
+func IsMultipathMap(name string) bool {
+	return isMultipathMap(sysClassBlock, name)
+}
+
+// IsMultipathUUID tells whether a device mapper uuid belongs to a multipath
+// map.
+func IsMultipathUUID(dmUUID string) bool {
+	return strings.HasPrefix(dmUUID, multipathUUIDPrefix)
+}
+
+// populateMultipath marks the paths held by a multipath map and lists the
+// paths of a map along with their state. classBlock is the /sys/class/block
+// directory.
+func populateMultipath(classBlock, name string, disk *LocalDisk) {
+	for _, holder := range disk.Holders {
+		if isMultipathMap(classBlock, holder) {
+			disk.MultipathMember = true
+		}
+	}
+	if disk.Type != MultiPath {
+		return
+	}
+	slaves, err := listDir(filepath.Join(classBlock, name, "slaves"))
+	if err != nil {
+		logger.Warningf("failed to list paths of multipath device %q. %v", name, err)
+		return
+	}
+	for _, slave := range slaves {
+		state, err := readSysfsString(filepath.Join(classBlock, slave, "device", "state"))
+		if err != nil {
+			state = pathStateUnknown
+		}
+		disk.MultipathPaths = append(disk.MultipathPaths, MultipathPath{Name: slave, State: state})
+	}
+}
+
+// multipathFilter hides the paths of multipath maps and their partitions, the
+// map is offered instead. Parents must be passed before their partitions.
+type multipathFilter map[string]bool
+
+func (f multipathFilter) hide(disk *LocalDisk) bool {
+	if !disk.MultipathMember && !(disk.Type == PartType && f[disk.Parent]) {
+		return false
+	}
+	f[disk.Name] = true
+	logger.Infof("skipping device %q because it is a path of a multipath device, considering the multipath device instead.", disk.Name)
+	return true
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DeviceRulesEnv holds the device eligibility rules in yaml
+	DeviceRulesEnv = "DEVICE_ELIGIBILITY_RULES"
+)
+
+// defaultMinSize is the smallest device size accepted unless the rules say otherwise
+var defaultMinSize = resource.MustParse("2Gi")
+
+// DeviceRule matches a device when every field that is set matches.
+// Fields holding a list match if any of their entries matches.
+type DeviceRule struct {
+	// Paths are globs matched against the device path, e.g. /dev/sd*
+	Paths []string `json:"paths,omitempty"`
+	// ByIDs are globs matched against the persistent links of the device,
+	// e.g. /dev/disk/by-id/wwn-*
+	ByIDs []string `json:"byIds,omitempty"`
+	// Types are device types as reported by lsblk, e.g. disk or part
+	Types      []string           `json:"types,omitempty"`
+	MinSize    *resource.Quantity `json:"minSize,omitempty"`
+	MaxSize    *resource.Quantity `json:"maxSize,omitempty"`
+	Rotational *bool              `json:"rotational,omitempty"`
+	// Vendors and Models are globs matched against the udev vendor and model
+	Vendors []string `json:"vendors,omitempty"`
+	Models  []string `json:"models,omitempty"`
+}
+
+// DeviceRules decides which devices may be published as raw devices or used
+// to build volume groups.
+type DeviceRules struct {
+	// Include restricts the devices to the ones matching any rule, all
+	// devices are included if empty
+	Include []DeviceRule `json:"include,omitempty"`
+	// Exclude rejects the devices matching any rule
+	Exclude []DeviceRule `json:"exclude,omitempty"`
+	// MinSize rejects smaller devices, defaults to 2Gi
+	MinSize *resource.Quantity `json:"minSize,omitempty"`
+	// AllowStaleSignatures accepts unmounted devices still carrying a
+	// filesystem or other signature for volume groups, the signature is
+	// wiped before the physical volume is created. Such devices are never
+	// published as raw devices.
+	AllowStaleSignatures bool `json:"allowStaleSignatures,omitempty"`
+	// IgnoreReasons accepts devices carrying only the listed signatures,
+	// e.g. PartitionTable, for volume groups the same way
+	IgnoreReasons []UsageReason `json:"ignoreReasons,omitempty"`
+}
+
+// DefaultDeviceRules returns the rules used when none are configured.
+func DefaultDeviceRules() *DeviceRules {
+	return &DeviceRules{}
+}
+
+// ParseDeviceRules parses rules from yaml, empty data yields the default rules.
+func ParseDeviceRules(data string) (*DeviceRules, error) {
+	rules := DefaultDeviceRules()
+	if strings.TrimSpace(data) == "" {
+		return rules, nil
+	}
+	if err := yaml.UnmarshalStrict([]byte(data), rules); err != nil {
+		return nil, fmt.Errorf("failed to parse device rules: %v", err)
+	}
+	for i := range rules.Include {
+		if err := rules.Include[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid include rule %d: %v", i, err)
+		}
+	}
+	for i := range rules.Exclude {
+		if err := rules.Exclude[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid exclude rule %d: %v", i, err)
+		}
+	}
+	for _, reason := range rules.IgnoreReasons {
+		if !IsSignatureReason(reason) {
+			return nil, fmt.Errorf("reason %q can not be ignored, only signatures can", reason)
+		}
+	}
+	return rules, nil
+}
+
+func (r *DeviceRule) validate() error {
+	for _, patterns := range [][]string{r.Paths, r.ByIDs, r.Vendors, r.Models} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("bad pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	if r.MinSize != nil && r.MaxSize != nil && r.MinSize.Cmp(*r.MaxSize) > 0 {
+		return fmt.Errorf("minSize %s is greater than maxSize %s", r.MinSize.String(), r.MaxSize.String())
+	}
+	return nil
+}
+
+// Check returns why the device is not eligible, or "" if it is.
+func (r *DeviceRules) Check(device *LocalDisk) string {
+	return Messages(r.Evaluate(device))
+}
+
+// StaleSignatures returns the signatures of the device the rules accept,
+// they are wiped before a volume group is built on the device.
+func (r *DeviceRules) StaleSignatures(device *LocalDisk) []UsageReason {
+	var reasons []UsageReason
+	for _, f := range UsageFindings(device) {
+		if IsSignatureReason(f.Reason) && r.ignores(f.Reason) {
+			reasons = append(reasons, f.Reason)
+		}
+	}
+	return reasons
+}
+
+// Evaluate returns every reason the device is not eligible, it is eligible
+// when there is none.
+func (r *DeviceRules) Evaluate(device *LocalDisk) []Finding {
+	var findings []Finding
+	minSize := defaultMinSize
+	if r.MinSize != nil {
+		minSize = *r.MinSize
+	}
+	if device.Size < uint64(minSize.Value()) {
+		findings = append(findings, Finding{Reason: ReasonTooSmall, Message: fmt.Sprintf("size less than %s", minSize.String())})
+	}
+	for _, f := range UsageFindings(device) {
+		if IsSignatureReason(f.Reason) && r.ignores(f.Reason) {
+			continue
+		}
+		findings = append(findings, f)
+	}
+	// loop devices are backed by files the operator creates itself
+	if device.Type == LoopType {
+		return findings
+	}
+	for i := range r.Exclude {
+		if r.Exclude[i].Match(device) {
+			return append(findings, Finding{Reason: ReasonExcluded, Message: fmt.Sprintf("excluded by rule %d", i)})
+		}
+	}
+	if len(r.Include) == 0 {
+		return findings
+	}
+	for i := range r.Include {
+		if r.Include[i].Match(device) {
+			return findings
+		}
+	}
+	return append(findings, Finding{Reason: ReasonNotIncluded, Message: "not included by any rule"})
+}
+
+func (r *DeviceRules) ignores(reason UsageReason) bool {
+	if r.AllowStaleSignatures {
+		return true
+	}
+	for _, ignored := range r.IgnoreReasons {
+		if ignored == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the device matches the rule.
+func (r *DeviceRule) Match(device *LocalDisk) bool {
+	if len(r.Paths) > 0 && !matchAny(r.Paths, device.RealPath, diskPrefix+device.Name) {
+		return false
+	}
+	if len(r.ByIDs) > 0 && !matchAny(r.ByIDs, strings.Fields(device.DevLinks)...) {
+		return false
+	}
+	if len(r.Types) > 0 && !containsString(r.Types, device.Type) {
+		return false
+	}
+	if r.MinSize != nil && device.Size < uint64(r.MinSize.Value()) {
+		return false
+	}
+	if r.MaxSize != nil && device.Size > uint64(r.MaxSize.Value()) {
+		return false
+	}
+	if r.Rotational != nil && *r.Rotational != device.Rotational {
+		return false
+	}
+	if len(r.Vendors) > 0 && !matchAny(r.Vendors, device.Vendor) {
+		return false
+	}
+	if len(r.Models) > 0 && !matchAny(r.Models, device.Model) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if ok, _ := filepath.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
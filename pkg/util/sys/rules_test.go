@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRules = `
+minSize: 1Gi
+allowStaleSignatures: true
+include:
+  - paths: ["/dev/sd*"]
+    rotational: false
+  - byIds: ["/dev/disk/by-id/nvme-*"]
+    maxSize: 2Ti
+exclude:
+  - vendors: ["QEMU*"]
+  - paths: ["/dev/sda"]
+`
+
+func TestParseDeviceRules(t *testing.T) {
+	rules, err := ParseDeviceRules("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultDeviceRules(), rules)
+
+	rules, err = ParseDeviceRules(testRules)
+	assert.NoError(t, err)
+	assert.Len(t, rules.Include, 2)
+	assert.Len(t, rules.Exclude, 2)
+	assert.True(t, rules.AllowStaleSignatures)
+	assert.Equal(t, int64(1<<30), rules.MinSize.Value())
+	assert.Equal(t, int64(2<<40), rules.Include[1].MaxSize.Value())
+
+	_, err = ParseDeviceRules("include:\n  - pathz: [\"/dev/sd*\"]\n")
+	assert.Error(t, err)
+	_, err = ParseDeviceRules("include:\n  - paths: [\"/dev/sd[\"]\n")
+	assert.Error(t, err)
+	_, err = ParseDeviceRules("exclude:\n  - minSize: 2Gi\n    maxSize: 1Gi\n")
+	assert.Error(t, err)
+}
+
+func TestDeviceRulesCheck(t *testing.T) {
+	defaults := DefaultDeviceRules()
+	disk := &LocalDisk{Name: "sdb", RealPath: "/dev/sdb", Type: DiskType, Size: 10 << 30}
+	assert.Equal(t, "", defaults.Check(disk))
+	assert.Equal(t, "size less than 2Gi", defaults.Check(&LocalDisk{Name: "sdc", Size: 1 << 30}))
+	assert.Equal(t, "contains a filesystem xfs", defaults.Check(&LocalDisk{Name: "sdd", Size: 10 << 30, Filesystem: "xfs"}))
+
+	rules, err := ParseDeviceRules(testRules)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		disk    LocalDisk
+		message string
+	}{
+		{LocalDisk{Name: "sdb", Type: DiskType, Size: 1 << 30}, ""},
+		{LocalDisk{Name: "sdb", Type: DiskType, Size: 10 << 30, Rotational: true}, "not included by any rule"},
+		{LocalDisk{Name: "sdb", Type: DiskType, Size: 10 << 30, Filesystem: "xfs"}, ""},
+		{LocalDisk{Name: "sdb", Type: DiskType, Size: 10 << 30, Filesystem: "xfs", MountPoint: "data"}, "has a mount point data"},
+		{LocalDisk{Name: "sdb", Type: DiskType, Size: 10 << 30, Vendor: "QEMU"}, "excluded by rule 0"},
+		{LocalDisk{Name: "sda", Type: DiskType, Size: 10 << 30}, "excluded by rule 1"},
+		{LocalDisk{Name: "nvme0n1", Type: DiskType, Size: 1 << 40, DevLinks: "/dev/disk/by-path/pci-0000:00:04.0-nvme-1 /dev/disk/by-id/nvme-eui.0025385b71b07e2f"}, ""},
+		{LocalDisk{Name: "nvme1n1", Type: DiskType, Size: 4 << 40, DevLinks: "/dev/disk/by-id/nvme-eui.0025385b71b07e30"}, "not included by any rule"},
+		{LocalDisk{Name: "loop0", Type: LoopType, Size: 4 << 30, Rotational: true}, ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.message, rules.Check(&tt.disk), tt.disk.Name)
+	}
+}
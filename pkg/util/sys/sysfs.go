@@ -0,0 +1,425 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	sectorSize = 512
+	romType    = "rom"
+	dmType     = "dm"
+	// scsi peripheral device type of CD/DVD drives
+	scsiTypeROM  = "5"
+	gptSignature = "EFI PART"
+	// swapMountPoint is the mount point lsblk reports for active swap
+	swapMountPoint = "[SWAP]"
+)
+
+type sysfsEnumerator struct {
+	sysRoot  string
+	devRoot  string
+	udevRoot string
+	procRoot string
+}
+
+// NewSysfsEnumerator returns an enumerator reading /sys, the udev database
+// and partition tables directly. Mount points are read from the mount
+// namespace of the host init process, so the pod needs the host PID namespace.
+func NewSysfsEnumerator() DeviceEnumerator {
+	return newSysfsEnumeratorAt("/sys", "/dev", "/run/udev", "/proc/1")
+}
+
+func newSysfsEnumeratorAt(sysRoot, devRoot, udevRoot, procRoot string) *sysfsEnumerator {
+	return &sysfsEnumerator{
+		sysRoot:  sysRoot,
+		devRoot:  devRoot,
+		udevRoot: udevRoot,
+		procRoot: procRoot,
+	}
+}
+
+func (e *sysfsEnumerator) DiscoverDevices(listParent bool) ([]*LocalDisk, error) {
+	entries, err := os.ReadDir(filepath.Join(e.sysRoot, "class", "block"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list block devices: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	mounts, err := e.readMountPoints()
+	if err != nil {
+		logger.Warningf("failed to read mount points, assuming there are none. %v", err)
+	}
+
+	var disks []*LocalDisk
+	multipath := make(multipathFilter)
+	for _, name := range names {
+		disk, err := e.populateDevice(name, mounts)
+		if err != nil {
+			logger.Warningf("skipping device %q. %v", name, err)
+			continue
+		}
+		if multipath.hide(disk) {
+			continue
+		}
+		if disk.Type == DiskType && !listParent && disk.HasChildren {
+			logger.Infof("skipping device %q because it has child, considering the child instead.", name)
+			continue
+		}
+		disks = append(disks, disk)
+	}
+	logger.Debugf("discovered disks are %v", disks)
+
+	return disks, nil
+}
+
+func (e *sysfsEnumerator) DiscoverDevice(name string) (*LocalDisk, error) {
+	mounts, err := e.readMountPoints()
+	if err != nil {
+		logger.Warningf("failed to read mount points, assuming there are none. %v", err)
+	}
+	return e.populateDevice(name, mounts)
+}
+
+func (e *sysfsEnumerator) populateDevice(name string, mounts map[string]string) (*LocalDisk, error) {
+	devDir, err := filepath.EvalSymlinks(filepath.Join(e.sysRoot, "class", "block", name))
+	if err != nil {
+		return nil, err
+	}
+
+	diskType, err := e.deviceType(name, devDir)
+	if err != nil {
+		return nil, err
+	}
+	if !supportedDeviceType(diskType) {
+		return nil, fmt.Errorf("unsupported diskType %+s", diskType)
+	}
+
+	disk := &LocalDisk{Name: name, KernelName: name, Type: diskType, RealPath: filepath.Join("/dev", name)}
+
+	dev, err := readSysfsString(filepath.Join(devDir, "dev"))
+	if err != nil {
+		return nil, err
+	}
+	if disk.Major, disk.Minor, err = parseMajorMinor(dev); err != nil {
+		return nil, err
+	}
+
+	sectors, err := readSysfsUint(filepath.Join(devDir, "size"))
+	if err != nil {
+		return nil, err
+	}
+	disk.Size = sectors * sectorSize
+
+	if ro, err := readSysfsString(filepath.Join(devDir, "ro")); err == nil {
+		disk.Readonly = ro == "1"
+	}
+
+	// partitions have no queue of their own and inherit the parent's
+	queueDir := devDir
+	if diskType == PartType && !strings.HasPrefix(name, "dm-") {
+		queueDir = filepath.Dir(devDir)
+		disk.Parent = filepath.Base(queueDir)
+	}
+	if rota, err := readSysfsString(filepath.Join(queueDir, "queue", "rotational")); err == nil {
+		disk.Rotational = rota == "1"
+	}
+	populateTopology(queueDir, disk)
+
+	if strings.HasPrefix(name, "dm-") {
+		if dmName, err := readSysfsString(filepath.Join(devDir, "dm", "name")); err == nil && dmName != "" {
+			disk.RealPath = filepath.Join("/dev", "mapper", dmName)
+		}
+		if slaves, err := listDir(filepath.Join(devDir, "slaves")); err == nil && len(slaves) > 0 {
+			disk.Parent = slaves[0]
+		}
+	}
+
+	disk.HasChildren, err = hasChildren(devDir)
+	if err != nil {
+		logger.Warningf("failed to detect child devices for device %q, assuming they are none. %v", name, err)
+	}
+	if disk.Holders, err = readHolders(devDir); err != nil {
+		logger.Warningf("failed to read holders of device %q, assuming they are none. %v", name, err)
+	}
+	populateMultipath(filepath.Join(e.sysRoot, "class", "block"), name, disk)
+	disk.MountPoint = mounts[dev]
+
+	if diskType != PartType {
+		if disk.UUID, err = e.readGPTDiskGUID(name); err != nil {
+			logger.Debugf("no gpt disk guid for device %q. %v", name, err)
+		}
+	}
+
+	if err := e.populateUdevData(dev, disk); err != nil {
+		// go on without udev info
+		// not ideal for our filesystem check later but we can't really fail either...
+		logger.Warningf("failed to get udev info for device %q. %v", name, err)
+	}
+
+	return disk, nil
+}
+
+// deviceType returns the device type the way lsblk reports it.
+func (e *sysfsEnumerator) deviceType(name, devDir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(devDir, "partition")); err == nil {
+		return PartType, nil
+	}
+	if strings.HasPrefix(name, "loop") {
+		return LoopType, nil
+	}
+	if strings.HasPrefix(name, "dm-") {
+		dmUUID, err := readSysfsString(filepath.Join(devDir, "dm", "uuid"))
+		if err != nil {
+			return "", err
+		}
+		// the uuid prefix names the subsystem owning the mapping, e.g. LVM-xxx,
+		// kpartx partitions are prefixed with part<N>-
+		if strings.HasPrefix(dmUUID, "part") {
+			return PartType, nil
+		}
+		if i := strings.Index(dmUUID, "-"); i > 0 {
+			return strings.ToLower(dmUUID[:i]), nil
+		}
+		return dmType, nil
+	}
+	if scsiType, err := readSysfsString(filepath.Join(devDir, "device", "type")); err == nil && scsiType == scsiTypeROM {
+		return romType, nil
+	}
+	return DiskType, nil
+}
+
+// hasChildren reports whether the device has partitions or is held by
+// another device, e.g. a LVM physical volume or a multipath member.
+func hasChildren(devDir string) (bool, error) {
+	holders, err := readHolders(devDir)
+	if err != nil {
+		return false, err
+	}
+	if len(holders) > 0 {
+		return true, nil
+	}
+	entries, err := os.ReadDir(devDir)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(devDir, entry.Name(), "partition")); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// populateUdevData fills the disk with the properties udev recorded in its
+// database file /run/udev/data/b<major>:<minor>.
+func (e *sysfsEnumerator) populateUdevData(dev string, disk *LocalDisk) error {
+	f, err := os.Open(filepath.Join(e.udevRoot, "data", "b"+dev))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var links []string
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "S:"):
+			links = append(links, filepath.Join("/dev", line[2:]))
+		case strings.HasPrefix(line, "E:"):
+			kv := strings.SplitN(line[2:], "=", 2)
+			if len(kv) == 2 {
+				props[kv[0]] = kv[1]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	disk.DevLinks = strings.Join(links, " ")
+	disk.Filesystem = props["ID_FS_TYPE"]
+	disk.Serial = props["ID_SERIAL"]
+	disk.Vendor = props["ID_VENDOR"]
+	disk.Model = props["ID_MODEL"]
+	disk.WWNVendorExtension = props["ID_WWN_WITH_EXTENSION"]
+	disk.WWN = props["ID_WWN"]
+	disk.PartitionTable = props["ID_PART_TABLE_TYPE"]
+	disk.PartitionName = props["ID_PART_ENTRY_NAME"]
+	if props[udevMultipathPath] == "1" {
+		disk.MultipathMember = true
+	}
+	return nil
+}
+
+// readMountPoints maps "major:minor" to the base name of the first mount
+// point of the device, like lsblk does. Active swap devices map to [SWAP].
+func (e *sysfsEnumerator) readMountPoints() (map[string]string, error) {
+	mounts := make(map[string]string)
+
+	f, err := os.Open(filepath.Join(e.procRoot, "mountinfo"))
+	if err != nil {
+		return mounts, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if _, ok := mounts[fields[2]]; !ok {
+			mounts[fields[2]] = filepath.Base(fields[4])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return mounts, err
+	}
+
+	swaps, err := os.ReadFile(filepath.Join(e.procRoot, "swaps"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return mounts, nil
+		}
+		return mounts, err
+	}
+	for i, line := range strings.Split(string(swaps), "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) == 0 {
+			continue
+		}
+		dev, err := e.devNumberOf(fields[0])
+		if err != nil {
+			continue
+		}
+		mounts[dev] = swapMountPoint
+	}
+	return mounts, nil
+}
+
+// devNumberOf resolves a /dev path to "major:minor" through /sys/dev/block.
+func (e *sysfsEnumerator) devNumberOf(devPath string) (string, error) {
+	name := filepath.Base(devPath)
+	dev, err := readSysfsString(filepath.Join(e.sysRoot, "class", "block", name, "dev"))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(e.sysRoot, "dev", "block", dev)); err != nil {
+		return "", err
+	}
+	return dev, nil
+}
+
+// readGPTDiskGUID reads the disk GUID from the GPT header of the device.
+func (e *sysfsEnumerator) readGPTDiskGUID(name string) (string, error) {
+	blockSize := uint64(sectorSize)
+	if size, err := readSysfsUint(filepath.Join(e.sysRoot, "class", "block", name, "queue", "logical_block_size")); err == nil && size > 0 {
+		blockSize = size
+	}
+
+	f, err := os.Open(filepath.Join(e.devRoot, name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// the primary header lives in the second logical block
+	header := make([]byte, 92)
+	if _, err := f.ReadAt(header, int64(blockSize)); err != nil {
+		if err == io.EOF {
+			return "", fmt.Errorf("device too small for a gpt header")
+		}
+		return "", err
+	}
+	if !bytes.Equal(header[:8], []byte(gptSignature)) {
+		return "", fmt.Errorf("no gpt signature")
+	}
+	return decodeGUID(header[56:72]), nil
+}
+
+// decodeGUID converts the mixed endian on-disk GUID layout to its text form.
+func decodeGUID(b []byte) string {
+	var u uuid.UUID
+	binary.BigEndian.PutUint32(u[0:4], binary.LittleEndian.Uint32(b[0:4]))
+	binary.BigEndian.PutUint16(u[4:6], binary.LittleEndian.Uint16(b[4:6]))
+	binary.BigEndian.PutUint16(u[6:8], binary.LittleEndian.Uint16(b[6:8]))
+	copy(u[8:], b[8:16])
+	return u.String()
+}
+
+func parseMajorMinor(dev string) (uint32, uint32, error) {
+	parts := strings.Split(dev, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid device number %q", dev)
+	}
+	major, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	minor, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(major), uint32(minor), nil
+}
+
+func readSysfsString(p string) (string, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func readSysfsUint(p string) (uint64, error) {
+	s, err := readSysfsString(p)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func listDir(p string) ([]string, error) {
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
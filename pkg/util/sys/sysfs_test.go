@@ -0,0 +1,278 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixtureDevice describes a block device of the fixture sysfs tree.
+type fixtureDevice struct {
+	// path below /sys/devices
+	path  string
+	dev   string
+	size  string
+	files map[string]string
+}
+
+var fixtureDevices = []fixtureDevice{
+	{path: "pci0000:00/0000:00:10.0/host2/target2:0:0/2:0:0:0/block/sda", dev: "8:0", size: "20971520",
+		files: map[string]string{"queue/rotational": "1", "device/type": "0"}},
+	{path: "pci0000:00/0000:00:10.0/host2/target2:0:0/2:0:0:0/block/sda/sda1", dev: "8:1", size: "20969472",
+		files: map[string]string{"partition": "1"}},
+	{path: "pci0000:00/0000:00:10.0/host2/target2:0:1/2:0:1:0/block/sdb", dev: "8:16", size: "41943040",
+		files: map[string]string{"queue/rotational": "0", "device/type": "0", "device/queue_depth": "32",
+			"queue/logical_block_size": "512", "queue/physical_block_size": "4096", "queue/discard_max_bytes": "0"}},
+	{path: "pci0000:00/0000:00:10.0/host2/target2:0:2/2:0:2:0/block/sdc", dev: "8:32", size: "41943040",
+		files: map[string]string{"queue/rotational": "0", "device/type": "0", "holders/dm-0": ""}},
+	{path: "pci0000:00/0000:00:04.0/nvme/nvme0/nvme0n1", dev: "259:0", size: "8388608",
+		files: map[string]string{"queue/rotational": "0", "nsid": "1", "queue/nr_requests": "1023",
+			"queue/logical_block_size": "4096", "queue/physical_block_size": "4096", "queue/discard_max_bytes": "2199023255040"}},
+	{path: "virtual/block/dm-0", dev: "253:0", size: "41934848",
+		files: map[string]string{"dm/name": "vg0-lv0", "dm/uuid": "LVM-Xq8OmbqdrcA1W0rPvz9AkYDQCkA2mSB", "slaves/sdc": ""}},
+	{path: "virtual/block/dm-1", dev: "253:1", size: "8388608",
+		files: map[string]string{"dm/name": "luks-data", "dm/uuid": "CRYPT-LUKS2-8c6b2a0f6d7b4d30a2f5cbd1b1e0f1aa-luks-data", "ro": "1"}},
+	{path: "pci0000:00/0000:00:10.0/host2/target2:0:3/2:0:3:0/block/sdd", dev: "8:48", size: "41943040",
+		files: map[string]string{"queue/rotational": "0", "device/type": "0", "device/state": "running", "holders/dm-2": ""}},
+	{path: "pci0000:00/0000:00:11.0/host3/target3:0:3/3:0:3:0/block/sde", dev: "8:64", size: "41943040",
+		files: map[string]string{"queue/rotational": "0", "device/type": "0", "device/state": "offline", "holders/dm-2": ""}},
+	{path: "virtual/block/dm-2", dev: "253:2", size: "41943040",
+		files: map[string]string{"dm/name": "mpatha", "dm/uuid": "mpath-36001405a1b2c3d4e5f60718293a4b5c6", "slaves/sdd": "", "slaves/sde": ""}},
+	{path: "virtual/block/loop0", dev: "7:0", size: "4194304",
+		files: map[string]string{"queue/rotational": "0"}},
+	{path: "pci0000:00/0000:00:01.1/ata2/host1/target1:0:0/1:0:0:0/block/sr0", dev: "11:0", size: "2097152",
+		files: map[string]string{"device/type": "5"}},
+}
+
+const (
+	fixtureMountInfo = `22 1 8:1 / /boot rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 1 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:2 - proc proc rw
+`
+	fixtureSwaps = `Filename				Type		Size		Used		Priority
+/dev/nvme0n1                            partition	4194300		0		-2
+`
+	fixtureUdevSdb = `S:disk/by-id/wwn-0x6001405d27e5d898829468b90ce4ef8c
+S:disk/by-id/scsi-36001405d27e5d898829468b90ce4ef8c
+W:1
+I:15981915740802
+E:ID_FS_TYPE=
+E:ID_MODEL=disk01
+E:ID_SERIAL=36001405d27e5d898829468b90ce4ef8c
+E:ID_VENDOR=LIO-ORG
+E:ID_WWN=0x6001405d27e5d898
+E:ID_WWN_WITH_EXTENSION=0x6001405d27e5d898829468b90ce4ef8c
+G:systemd
+`
+	fixtureUdevSda1 = `S:disk/by-uuid/f2d38cba-37da-411d-b7ba-9a6696c58174
+E:ID_FS_TYPE=ext4
+`
+)
+
+func writeFixtureFile(t *testing.T, p, content string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	assert.NoError(t, os.WriteFile(p, []byte(content+"\n"), 0644))
+}
+
+// newFixtureEnumerator lays out a sysfs tree, udev database and proc files
+// like the kernel and udev do and returns an enumerator reading them.
+func newFixtureEnumerator(t *testing.T) *sysfsEnumerator {
+	root := t.TempDir()
+	sysRoot := filepath.Join(root, "sys")
+	devRoot := filepath.Join(root, "dev")
+	udevRoot := filepath.Join(root, "run", "udev")
+	procRoot := filepath.Join(root, "proc")
+
+	for _, d := range fixtureDevices {
+		devDir := filepath.Join(sysRoot, "devices", d.path)
+		name := filepath.Base(d.path)
+		writeFixtureFile(t, filepath.Join(devDir, "dev"), d.dev)
+		writeFixtureFile(t, filepath.Join(devDir, "size"), d.size)
+		if _, ok := d.files["ro"]; !ok {
+			writeFixtureFile(t, filepath.Join(devDir, "ro"), "0")
+		}
+		for f, content := range d.files {
+			writeFixtureFile(t, filepath.Join(devDir, f), content)
+		}
+		rel := filepath.Join("..", "..", "devices", d.path)
+		assert.NoError(t, os.MkdirAll(filepath.Join(sysRoot, "class", "block"), 0755))
+		assert.NoError(t, os.Symlink(rel, filepath.Join(sysRoot, "class", "block", name)))
+		assert.NoError(t, os.MkdirAll(filepath.Join(sysRoot, "dev", "block"), 0755))
+		assert.NoError(t, os.Symlink(rel, filepath.Join(sysRoot, "dev", "block", d.dev)))
+	}
+
+	writeFixtureFile(t, filepath.Join(udevRoot, "data", "b8:16"), fixtureUdevSdb)
+	writeFixtureFile(t, filepath.Join(udevRoot, "data", "b8:1"), fixtureUdevSda1)
+	writeFixtureFile(t, filepath.Join(procRoot, "mountinfo"), fixtureMountInfo)
+	writeFixtureFile(t, filepath.Join(procRoot, "swaps"), fixtureSwaps)
+	writeFixtureFile(t, filepath.Join(sysRoot, "devices", "pci0000:00", "0000:00:04.0", "numa_node"), "1")
+	writeFixtureFile(t, filepath.Join(sysRoot, "devices", "pci0000:00", "0000:00:10.0", "numa_node"), "-1")
+
+	// sdb carries a gpt partition table
+	header := make([]byte, 2*sectorSize)
+	copy(header[sectorSize:], gptSignature)
+	copy(header[sectorSize+56:], []byte{
+		0x96, 0x2f, 0x24, 0x46, 0xf7, 0x6c, 0x5d, 0x4e,
+		0xb4, 0xbd, 0x9d, 0x04, 0x6e, 0x6a, 0xd9, 0x20,
+	})
+	assert.NoError(t, os.MkdirAll(devRoot, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(devRoot, "sdb"), header, 0644))
+
+	return newSysfsEnumeratorAt(sysRoot, devRoot, udevRoot, procRoot)
+}
+
+func diskNames(disks []*LocalDisk) []string {
+	var names []string
+	for _, d := range disks {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func TestSysfsDiscoverDevices(t *testing.T) {
+	e := newFixtureEnumerator(t)
+
+	disks, err := e.DiscoverDevices(true)
+	assert.NoError(t, err)
+	// dm-0 is a lvm volume and sr0 a cdrom, both unsupported, sdd and sde are
+	// the paths of the multipath device dm-2
+	assert.Equal(t, []string{"dm-1", "dm-2", "loop0", "nvme0n1", "sda", "sda1", "sdb", "sdc"}, diskNames(disks))
+
+	byName := make(map[string]*LocalDisk)
+	for _, d := range disks {
+		byName[d.Name] = d
+	}
+
+	sda := byName["sda"]
+	assert.Equal(t, DiskType, sda.Type)
+	assert.Equal(t, uint64(10<<30), sda.Size)
+	assert.True(t, sda.Rotational)
+	assert.True(t, sda.HasChildren)
+	assert.Equal(t, "/dev/sda", sda.RealPath)
+	assert.Equal(t, "", sda.UUID)
+
+	sda1 := byName["sda1"]
+	assert.Equal(t, PartType, sda1.Type)
+	assert.Equal(t, "sda", sda1.Parent)
+	assert.True(t, sda1.Rotational)
+	assert.Equal(t, "boot", sda1.MountPoint)
+	assert.Equal(t, "ext4", sda1.Filesystem)
+	assert.Equal(t, uint32(8), sda1.Major)
+	assert.Equal(t, uint32(1), sda1.Minor)
+
+	sdb := byName["sdb"]
+	assert.False(t, sdb.Rotational)
+	assert.False(t, sdb.HasChildren)
+	assert.Equal(t, "46242f96-6cf7-4e5d-b4bd-9d046e6ad920", sdb.UUID)
+	assert.Equal(t, "/dev/disk/by-id/wwn-0x6001405d27e5d898829468b90ce4ef8c /dev/disk/by-id/scsi-36001405d27e5d898829468b90ce4ef8c", sdb.DevLinks)
+	assert.Equal(t, "", sdb.Filesystem)
+	assert.Equal(t, "36001405d27e5d898829468b90ce4ef8c", sdb.Serial)
+	assert.Equal(t, "LIO-ORG", sdb.Vendor)
+	assert.Equal(t, "disk01", sdb.Model)
+	assert.Equal(t, "0x6001405d27e5d898", sdb.WWN)
+	assert.Equal(t, "0x6001405d27e5d898829468b90ce4ef8c", sdb.WWNVendorExtension)
+
+	// sdc is a lvm physical volume
+	assert.True(t, byName["sdc"].HasChildren)
+	assert.Equal(t, []string{"dm-0"}, byName["sdc"].Holders)
+	assert.Empty(t, sda.Holders)
+
+	assert.Equal(t, uint64(512), sdb.LogicalBlockSize)
+	assert.Equal(t, uint64(4096), sdb.PhysicalBlockSize)
+	assert.False(t, sdb.Discard)
+	assert.Equal(t, uint64(32), sdb.QueueDepth)
+	assert.Equal(t, "0000:00:10.0", sdb.PCIAddress)
+	assert.Nil(t, sdb.NUMANode)
+	assert.Equal(t, uint32(0), sdb.NVMeNamespaceID)
+
+	nvme := byName["nvme0n1"]
+	assert.Equal(t, "[SWAP]", nvme.MountPoint)
+	assert.Equal(t, uint32(259), nvme.Major)
+	assert.Equal(t, uint32(1), nvme.NVMeNamespaceID)
+	assert.Equal(t, "nvme0", nvme.NVMeController)
+	assert.Equal(t, "0000:00:04.0", nvme.PCIAddress)
+	assert.Equal(t, 1, *nvme.NUMANode)
+	assert.Equal(t, uint64(4096), nvme.LogicalBlockSize)
+	assert.True(t, nvme.Discard)
+	assert.Equal(t, uint64(1023), nvme.QueueDepth)
+
+	// partitions report the topology of their disk
+	assert.Equal(t, "0000:00:10.0", sda1.PCIAddress)
+
+	dm1 := byName["dm-1"]
+	assert.Equal(t, CryptType, dm1.Type)
+	assert.Equal(t, "/dev/mapper/luks-data", dm1.RealPath)
+	assert.Equal(t, "dm-1", dm1.KernelName)
+	assert.True(t, dm1.Readonly)
+
+	assert.Equal(t, LoopType, byName["loop0"].Type)
+
+	dm2 := byName["dm-2"]
+	assert.Equal(t, MultiPath, dm2.Type)
+	assert.Equal(t, "/dev/mapper/mpatha", dm2.RealPath)
+	assert.Equal(t, []MultipathPath{{Name: "sdd", State: PathStateRunning}, {Name: "sde", State: "offline"}}, dm2.MultipathPaths)
+	assert.Equal(t, 1, dm2.ActivePaths())
+
+	disk, err := e.DiscoverDevice("sdd")
+	assert.NoError(t, err)
+	assert.True(t, disk.MultipathMember)
+	assert.Nil(t, CheckDevice(disk, DefaultDeviceRules()))
+
+	disks, err = e.DiscoverDevices(false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dm-1", "dm-2", "loop0", "nvme0n1", "sda1", "sdb"}, diskNames(disks))
+
+	disk, err = e.DiscoverDevice("sda1")
+	assert.NoError(t, err)
+	assert.Equal(t, "boot", disk.MountPoint)
+	_, err = e.DiscoverDevice("sdz")
+	assert.Error(t, err)
+}
+
+func TestSysfsAvailableDevices(t *testing.T) {
+	e := newFixtureEnumerator(t)
+
+	all, err := GetAllDevicesWith(e, DefaultDeviceRules())
+	assert.NoError(t, err)
+	messages := make(map[string]string)
+	reasons := make(map[string][]UsageReason)
+	for _, d := range all {
+		if !d.Available {
+			messages[d.Name] = d.Message
+			reasons[d.Name] = d.Reasons
+		}
+	}
+	assert.Equal(t, "is held by dm-0", messages["sdc"])
+	assert.Equal(t, []UsageReason{ReasonDeviceMapperHolder}, reasons["sdc"])
+	assert.Equal(t, []UsageReason{ReasonPartitioned}, reasons["sda"])
+	assert.True(t, strings.HasPrefix(messages["sda1"], "contains a filesystem"))
+	assert.Equal(t, []UsageReason{ReasonFilesystem, ReasonMounted}, reasons["sda1"])
+	assert.Equal(t, []UsageReason{ReasonSwap}, reasons["nvme0n1"])
+
+	available, err := GetAvailableDevicesWith(e, DefaultDeviceRules())
+	assert.NoError(t, err)
+	// nvme0n1 is used as swap, only the multipath device of sdd and sde is offered
+	assert.Len(t, available, 4)
+	assert.Contains(t, available, "/dev/dm-1")
+	assert.Contains(t, available, "/dev/dm-2")
+	assert.Contains(t, available, "/dev/loop0")
+	assert.Contains(t, available, "/dev/sdb")
+}
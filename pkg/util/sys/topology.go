@@ -0,0 +1,114 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sys
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// pciAddressRegexp matches a PCI function address like 0000:00:04.0
+	pciAddressRegexp = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+	// nvmeNamespaceRegexp matches the kernel name of a NVMe namespace
+	nvmeNamespaceRegexp = regexp.MustCompile(`^nvme\d+n(\d+)$`)
+)
+
+// populateTopology fills where the device is attached and how it does IO.
+// queueDir is the sysfs directory of the device, or the one of the disk for
+// partitions, they share the queue and the hardware of their disk.
+func populateTopology(queueDir string, disk *LocalDisk) {
+	if size, err := readSysfsUint(filepath.Join(queueDir, "queue", "logical_block_size")); err == nil {
+		disk.LogicalBlockSize = size
+	}
+	if size, err := readSysfsUint(filepath.Join(queueDir, "queue", "physical_block_size")); err == nil {
+		disk.PhysicalBlockSize = size
+	}
+	if discard, err := readSysfsUint(filepath.Join(queueDir, "queue", "discard_max_bytes")); err == nil {
+		disk.Discard = discard > 0
+	}
+	// scsi devices report the depth of their hardware queue, other devices
+	// only the number of requests the block layer queues
+	if depth, err := readSysfsUint(filepath.Join(queueDir, "device", "queue_depth")); err == nil {
+		disk.QueueDepth = depth
+	} else if depth, err := readSysfsUint(filepath.Join(queueDir, "queue", "nr_requests")); err == nil {
+		disk.QueueDepth = depth
+	}
+
+	resolved, err := filepath.EvalSymlinks(queueDir)
+	if err != nil {
+		resolved = queueDir
+	}
+	if m := nvmeNamespaceRegexp.FindStringSubmatch(filepath.Base(resolved)); m != nil {
+		populateNVMe(resolved, m[1], disk)
+	}
+	if pciDir := pciDeviceDir(resolved); pciDir != "" {
+		disk.PCIAddress = filepath.Base(pciDir)
+		if node, err := readSysfsString(filepath.Join(pciDir, "numa_node")); err == nil {
+			// -1 when the platform has no NUMA
+			if n, err := strconv.Atoi(node); err == nil && n >= 0 {
+				disk.NUMANode = &n
+			}
+		}
+	}
+}
+
+// populateNVMe fills the namespace id and the controller of a NVMe namespace.
+func populateNVMe(nsDir, nameNSID string, disk *LocalDisk) {
+	if nsid, err := readSysfsUint(filepath.Join(nsDir, "nsid")); err == nil {
+		disk.NVMeNamespaceID = uint32(nsid)
+	} else if nsid, err := strconv.ParseUint(nameNSID, 10, 32); err == nil {
+		// the kernel name counts namespaces of the controller from 1, which
+		// is the namespace id unless namespaces were deleted
+		disk.NVMeNamespaceID = uint32(nsid)
+	}
+	// namespaces of a multipath capable subsystem live below the subsystem,
+	// the controller is not known then
+	if ctrl, err := filepath.EvalSymlinks(filepath.Join(nsDir, "device")); err == nil && strings.HasPrefix(filepath.Base(ctrl), "nvme") &&
+		!strings.HasPrefix(filepath.Base(ctrl), "nvme-subsys") {
+		disk.NVMeController = filepath.Base(ctrl)
+	} else if parent := filepath.Base(filepath.Dir(nsDir)); strings.HasPrefix(parent, "nvme") && !strings.HasPrefix(parent, "nvme-subsys") {
+		disk.NVMeController = parent
+	}
+}
+
+// pciDeviceDir returns the deepest PCI function on the sysfs path of the
+// device, which is its controller.
+func pciDeviceDir(devDir string) string {
+	for dir := devDir; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if pciAddressRegexp.MatchString(filepath.Base(dir)) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// sysfsQueueDir resolves the sysfs directory of the device, or the one of
+// its disk for partitions. classBlock is the /sys/class/block directory.
+func sysfsQueueDir(classBlock, name string) (string, error) {
+	devDir, err := filepath.EvalSymlinks(filepath.Join(classBlock, name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(devDir, "partition")); err == nil && !strings.HasPrefix(name, "dm-") {
+		return filepath.Dir(devDir), nil
+	}
+	return devDir, nil
+}
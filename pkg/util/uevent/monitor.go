@@ -0,0 +1,162 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uevent
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+	// maxFailures is how many times in a row the source may fail before the
+	// monitor gives up, about a quarter of an hour with the default delays
+	maxFailures = 20
+)
+
+// Source yields raw uevent messages.
+type Source interface {
+	// Read blocks until the next message arrives.
+	Read() ([]byte, error)
+	Close() error
+}
+
+// Dialer opens a new Source.
+type Dialer func() (Source, error)
+
+// Monitor reads uevents from a Source and reopens it whenever it fails.
+type Monitor struct {
+	dial        Dialer
+	subsystems  map[string]bool
+	minDelay    time.Duration
+	maxDelay    time.Duration
+	maxFailures int
+}
+
+// NewMonitor returns a Monitor listening on the kernel netlink socket. Only
+// events of the given subsystems are delivered, all events when none is given.
+func NewMonitor(subsystems ...string) *Monitor {
+	return NewMonitorWithDialer(NewNetlinkSource, subsystems...)
+}
+
+// NewMonitorWithDialer returns a Monitor reading from the sources made by dial.
+func NewMonitorWithDialer(dial Dialer, subsystems ...string) *Monitor {
+	m := &Monitor{
+		dial:        dial,
+		subsystems:  make(map[string]bool, len(subsystems)),
+		minDelay:    minReconnectDelay,
+		maxDelay:    maxReconnectDelay,
+		maxFailures: maxFailures,
+	}
+	for _, s := range subsystems {
+		m.subsystems[s] = true
+	}
+	return m
+}
+
+// WithRetry sets the delays between reconnects and how many times in a row
+// the source may fail before Run gives up.
+func (m *Monitor) WithRetry(minDelay, maxDelay time.Duration, failures int) *Monitor {
+	m.minDelay, m.maxDelay, m.maxFailures = minDelay, maxDelay, failures
+	return m
+}
+
+// Run sends events to c until ctx is done, then closes c. It returns an error
+// when the source can not be opened or fails without delivering anything too
+// many times in a row, nil once ctx is done.
+func (m *Monitor) Run(ctx context.Context, c chan<- *Event) error {
+	defer close(c)
+	delay := m.minDelay
+	failures := 0
+	for {
+		src, err := m.dial()
+		if err != nil {
+			logger.Warningf("failed to open uevent source, retry in %s. %v", delay, err)
+		} else {
+			logger.Info("listening to uevents")
+			var received bool
+			received, err = m.read(ctx, src, c)
+			if received {
+				// the source worked for a while, start over with a short delay
+				delay = m.minDelay
+				failures = 0
+			}
+			if ctx.Err() == nil {
+				logger.Warningf("uevent source failed, reconnect in %s", delay)
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		failures++
+		if failures >= m.maxFailures {
+			return fmt.Errorf("uevent source failed %d times in a row: %v", failures, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > m.maxDelay {
+			delay = m.maxDelay
+		}
+	}
+}
+
+// read forwards events from src until it fails or ctx is done. It reports
+// whether at least one message was read, and why the source failed.
+func (m *Monitor) read(ctx context.Context, src Source, c chan<- *Event) (bool, error) {
+	// Read blocks, closing the source is the only way to interrupt it
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		src.Close()
+	}()
+
+	received := false
+	for {
+		msg, err := src.Read()
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warningf("read uevent failed. %v", err)
+			}
+			return received, err
+		}
+		received = true
+		event, err := ParseEvent(msg)
+		if err != nil {
+			logger.Debugf("skip uevent. %v", err)
+			continue
+		}
+		if len(m.subsystems) > 0 && !m.subsystems[event.Subsystem] {
+			continue
+		}
+		select {
+		case c <- event:
+		case <-ctx.Done():
+			return received, nil
+		}
+	}
+}
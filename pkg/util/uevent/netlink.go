@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uevent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// udevGroup is the multicast group udev rebroadcasts the kernel uevents
+	// to once it processed its rules and updated its database
+	udevGroup  = 2
+	bufferSize = 64 * 1024
+	// socketBufferSize avoids ENOBUFS when many devices appear at once
+	socketBufferSize = 4 * 1024 * 1024
+	// pollTimeout bounds how long Read blocks before it notices Close
+	pollTimeout = 500 * time.Millisecond
+)
+
+var errClosed = errors.New("netlink source closed")
+
+type netlinkSource struct {
+	fd  int
+	buf []byte
+	oob []byte
+
+	// a blocked recvfrom is not interrupted by close, so Close only flags the
+	// source and the reader releases the socket on its next wakeup
+	mu      sync.Mutex
+	closed  bool
+	reading bool
+}
+
+// NewNetlinkSource opens a NETLINK_KOBJECT_UEVENT socket subscribed to the
+// uevents udev broadcasts, so the udev properties of the device, e.g. its
+// by-id links, are in place when its event is read. udev only broadcasts to
+// the network namespace it runs in, the host one.
+func NewNetlinkSource() (Source, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netlink socket: %v", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, socketBufferSize); err != nil {
+		// needs CAP_NET_ADMIN, the default buffer still works
+		logger.Debugf("failed to enlarge netlink receive buffer. %v", err)
+	}
+	tv := unix.NsecToTimeval(pollTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to set netlink receive timeout: %v", err)
+	}
+	// the credentials tell whether udev running as root sent a message
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_PASSCRED, 1); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to pass credentials on netlink socket: %v", err)
+	}
+	addr := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Pid:    0,
+		Groups: udevGroup,
+	}
+	if err := unix.Bind(fd, addr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind netlink socket: %v", err)
+	}
+	return &netlinkSource{fd: fd, buf: make([]byte, bufferSize), oob: make([]byte, unix.CmsgSpace(unix.SizeofUcred))}, nil
+}
+
+func (s *netlinkSource) Read() ([]byte, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errClosed
+	}
+	s.reading = true
+	s.mu.Unlock()
+	defer s.doneReading()
+
+	for {
+		n, oobn, _, from, err := unix.Recvmsg(s.fd, s.buf, s.oob, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err == unix.EAGAIN {
+			if s.isClosed() {
+				return nil, errClosed
+			}
+			continue
+		}
+		if err != nil {
+			return nil, os.NewSyscallError("recvmsg", err)
+		}
+		// only trust messages sent by udev, which runs as root
+		if nl, ok := from.(*unix.SockaddrNetlink); !ok || nl.Pid == 0 || !sentByRoot(s.oob[:oobn]) {
+			continue
+		}
+		msg := make([]byte, n)
+		copy(msg, s.buf[:n])
+		return msg, nil
+	}
+}
+
+func (s *netlinkSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.reading {
+		return nil
+	}
+	return unix.Close(s.fd)
+}
+
+func (s *netlinkSource) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *netlinkSource) doneReading() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reading = false
+	if s.closed {
+		unix.Close(s.fd)
+	}
+}
+
+// sentByRoot tells whether the credentials of a message are the ones of root.
+func sentByRoot(oob []byte) bool {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return false
+	}
+	for i := range msgs {
+		cred, err := unix.ParseUnixCredentials(&msgs[i])
+		if err == nil {
+			return cred.Uid == 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package uevent listens to kernel object uevents over netlink.
+package uevent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/coreos/pkg/capnslog"
+)
+
+var logger = capnslog.NewPackageLogger("topolvm/operator", "uevent")
+
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ActionChange = "change"
+
+	SubsystemBlock = "block"
+)
+
+const (
+	// udevPrefix starts the messages udev broadcasts
+	udevPrefix = "libudev\x00"
+	// udevMagic follows the prefix in network byte order
+	udevMagic = 0xfeedcafe
+	// udevHeaderSize is the size of the header in front of the properties
+	udevHeaderSize = 40
+)
+
+// nativeEndian is the byte order udev writes the header offsets in.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Event is a kernel uevent, as udev broadcasts it.
+type Event struct {
+	Action    string
+	DevPath   string
+	Subsystem string
+	// DevName is the device node name relative to /dev, e.g. sda1
+	DevName string
+	// DevType is disk or partition for block devices
+	DevType string
+	Major   uint32
+	Minor   uint32
+	SeqNum  uint64
+	// Env holds every key of the event, including the ones above
+	Env map[string]string
+}
+
+func (e *Event) String() string {
+	return fmt.Sprintf("%s %s (%s %s %d:%d)", e.Action, e.DevPath, e.Subsystem, e.DevName, e.Major, e.Minor)
+}
+
+// ParseEvent parses a kernel uevent message, a "ACTION@DEVPATH" header
+// followed by NUL separated KEY=VALUE pairs, or a udev one, a binary header
+// pointing at the NUL separated KEY=VALUE pairs.
+func ParseEvent(msg []byte) (*Event, error) {
+	if bytes.HasPrefix(msg, []byte(udevPrefix)) {
+		properties, err := udevProperties(msg)
+		if err != nil {
+			return nil, err
+		}
+		e, err := parseProperties(bytes.Split(properties, []byte{0}), nil)
+		if err != nil {
+			return nil, err
+		}
+		if e.Action == "" || e.DevPath == "" {
+			return nil, fmt.Errorf("udev message lacks ACTION or DEVPATH")
+		}
+		return e, nil
+	}
+
+	fields := bytes.Split(msg, []byte{0})
+	if len(fields) == 0 || !bytes.Contains(fields[0], []byte("@")) {
+		return nil, fmt.Errorf("invalid uevent header %q", firstField(fields))
+	}
+	return parseProperties(fields[1:], fields[0])
+}
+
+// udevProperties returns the properties of a udev message.
+func udevProperties(msg []byte) ([]byte, error) {
+	if len(msg) < udevHeaderSize || binary.BigEndian.Uint32(msg[8:12]) != udevMagic {
+		return nil, fmt.Errorf("invalid udev message header")
+	}
+	offset, length := uint64(nativeEndian.Uint32(msg[16:20])), uint64(nativeEndian.Uint32(msg[20:24]))
+	if offset < udevHeaderSize || offset+length > uint64(len(msg)) {
+		return nil, fmt.Errorf("invalid udev properties at %d of length %d", offset, length)
+	}
+	return msg[offset : offset+length], nil
+}
+
+// parseProperties parses the KEY=VALUE pairs of an event, the header of a
+// kernel event fills in ACTION and DEVPATH if they are missing.
+func parseProperties(fields [][]byte, header []byte) (*Event, error) {
+	e := &Event{Env: make(map[string]string, len(fields))}
+	for _, field := range fields {
+		if len(field) == 0 {
+			continue
+		}
+		kv := strings.SplitN(string(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		e.Env[kv[0]] = kv[1]
+	}
+
+	e.Action = e.Env["ACTION"]
+	e.DevPath = e.Env["DEVPATH"]
+	e.Subsystem = e.Env["SUBSYSTEM"]
+	// udev passes the device node path
+	e.DevName = strings.TrimPrefix(e.Env["DEVNAME"], "/dev/")
+	e.DevType = e.Env["DEVTYPE"]
+	if header != nil && (e.Action == "" || e.DevPath == "") {
+		// fall back to the header when the environment lacks them
+		parts := strings.SplitN(string(header), "@", 2)
+		if e.Action == "" {
+			e.Action = parts[0]
+		}
+		if e.DevPath == "" {
+			e.DevPath = parts[1]
+		}
+	}
+
+	var err error
+	if e.Major, err = parseUint32(e.Env, "MAJOR"); err != nil {
+		return nil, err
+	}
+	if e.Minor, err = parseUint32(e.Env, "MINOR"); err != nil {
+		return nil, err
+	}
+	if seq, ok := e.Env["SEQNUM"]; ok {
+		if e.SeqNum, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid SEQNUM %q: %v", seq, err)
+		}
+	}
+	return e, nil
+}
+
+func parseUint32(env map[string]string, key string) (uint32, error) {
+	val, ok := env[key]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", key, val, err)
+	}
+	return uint32(n), nil
+}
+
+func firstField(fields [][]byte) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	return string(fields[0])
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2021 The Topolvm-Operator Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uevent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func message(header string, env ...string) []byte {
+	return []byte(header + "\x00" + strings.Join(env, "\x00") + "\x00")
+}
+
+var addSdb = message("add@/devices/pci0000:00/0000:00:10.0/host2/target2:0:1/2:0:1:0/block/sdb",
+	"ACTION=add",
+	"DEVPATH=/devices/pci0000:00/0000:00:10.0/host2/target2:0:1/2:0:1:0/block/sdb",
+	"SUBSYSTEM=block",
+	"MAJOR=8",
+	"MINOR=16",
+	"DEVNAME=sdb",
+	"DEVTYPE=disk",
+	"SEQNUM=4242")
+
+// udevMessage wraps the properties in the header udev broadcasts them with.
+func udevMessage(env ...string) []byte {
+	properties := []byte(strings.Join(env, "\x00") + "\x00")
+	header := make([]byte, udevHeaderSize)
+	copy(header, udevPrefix)
+	binary.BigEndian.PutUint32(header[8:12], udevMagic)
+	nativeEndian.PutUint32(header[12:16], udevHeaderSize)
+	nativeEndian.PutUint32(header[16:20], udevHeaderSize)
+	nativeEndian.PutUint32(header[20:24], uint32(len(properties)))
+	return append(header, properties...)
+}
+
+func TestParseUdevEvent(t *testing.T) {
+	e, err := ParseEvent(udevMessage("ACTION=add",
+		"DEVPATH=/devices/pci0000:00/0000:00:10.0/host2/target2:0:1/2:0:1:0/block/sdb",
+		"SUBSYSTEM=block",
+		"MAJOR=8",
+		"MINOR=16",
+		"DEVNAME=/dev/sdb",
+		"DEVTYPE=disk",
+		"DEVLINKS=/dev/disk/by-id/wwn-0x5000c500a1b2c3d4",
+		"SEQNUM=4242"))
+	assert.NoError(t, err)
+	assert.Equal(t, ActionAdd, e.Action)
+	assert.Equal(t, SubsystemBlock, e.Subsystem)
+	assert.Equal(t, uint32(8), e.Major)
+	assert.Equal(t, uint32(16), e.Minor)
+	assert.Equal(t, "sdb", e.DevName)
+	assert.Equal(t, "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4", e.Env["DEVLINKS"])
+
+	_, err = ParseEvent(udevMessage("SUBSYSTEM=block"))
+	assert.Error(t, err)
+
+	truncated := udevMessage("ACTION=add", "DEVPATH=/block/sdb")
+	_, err = ParseEvent(truncated[:len(truncated)-4])
+	assert.Error(t, err)
+}
+
+func TestParseEvent(t *testing.T) {
+	e, err := ParseEvent(addSdb)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionAdd, e.Action)
+	assert.Equal(t, SubsystemBlock, e.Subsystem)
+	assert.Equal(t, "sdb", e.DevName)
+	assert.Equal(t, "disk", e.DevType)
+	assert.Equal(t, uint32(8), e.Major)
+	assert.Equal(t, uint32(16), e.Minor)
+	assert.Equal(t, uint64(4242), e.SeqNum)
+
+	e, err = ParseEvent(message("remove@/devices/virtual/block/loop0"))
+	assert.NoError(t, err)
+	assert.Equal(t, ActionRemove, e.Action)
+	assert.Equal(t, "/devices/virtual/block/loop0", e.DevPath)
+
+	_, err = ParseEvent([]byte("libudev\x00garbage"))
+	assert.Error(t, err)
+
+	_, err = ParseEvent(message("add@/block/sdc", "MAJOR=x"))
+	assert.Error(t, err)
+}
+
+// fakeSource replays messages and then fails, like a dropped socket.
+type fakeSource struct {
+	msgs   chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newFakeSource(msgs ...[]byte) *fakeSource {
+	s := &fakeSource{msgs: make(chan []byte, len(msgs)), closed: make(chan struct{})}
+	for _, m := range msgs {
+		s.msgs <- m
+	}
+	close(s.msgs)
+	return s
+}
+
+func (s *fakeSource) Read() ([]byte, error) {
+	select {
+	case msg, ok := <-s.msgs:
+		if !ok {
+			return nil, errors.New("connection lost")
+		}
+		return msg, nil
+	case <-s.closed:
+		return nil, errors.New("closed")
+	}
+}
+
+func (s *fakeSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func TestMonitorReconnects(t *testing.T) {
+	usbAdd := message("add@/devices/usb1", "ACTION=add", "SUBSYSTEM=usb")
+	sdbRemove := message("remove@/block/sdb", "ACTION=remove", "SUBSYSTEM=block", "DEVNAME=sdb", "MAJOR=8", "MINOR=16")
+	sources := []Source{
+		newFakeSource(addSdb, usbAdd),
+		nil, // dial failure
+		newFakeSource(sdbRemove),
+	}
+	var mu sync.Mutex
+	dials := 0
+	dial := func() (Source, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dials++
+		if len(sources) == 0 {
+			// block until the monitor is stopped
+			return &fakeSource{msgs: make(chan []byte), closed: make(chan struct{})}, nil
+		}
+		src := sources[0]
+		sources = sources[1:]
+		if src == nil {
+			return nil, errors.New("socket: permission denied")
+		}
+		return src, nil
+	}
+
+	m := NewMonitorWithDialer(dial, SubsystemBlock)
+	m.minDelay, m.maxDelay = time.Millisecond, 2*time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan *Event)
+	go m.Run(ctx, c)
+
+	e := <-c
+	assert.Equal(t, ActionAdd, e.Action)
+	assert.Equal(t, "sdb", e.DevName)
+	e = <-c
+	assert.Equal(t, ActionRemove, e.Action)
+	assert.Equal(t, uint32(16), e.Minor)
+
+	cancel()
+	for range c {
+	}
+	mu.Lock()
+	assert.GreaterOrEqual(t, dials, 3)
+	mu.Unlock()
+}
+
+func TestMonitorGivesUp(t *testing.T) {
+	dials := 0
+	dial := func() (Source, error) {
+		dials++
+		if dials == 2 {
+			// a source which fails right away counts as a failure as well
+			return newFakeSource(), nil
+		}
+		return nil, errors.New("socket: protocol not supported")
+	}
+
+	m := NewMonitorWithDialer(dial, SubsystemBlock).WithRetry(time.Millisecond, 2*time.Millisecond, 3)
+	c := make(chan *Event)
+	err := m.Run(context.Background(), c)
+	assert.EqualError(t, err, "uevent source failed 3 times in a row: socket: protocol not supported")
+	assert.Equal(t, 3, dials)
+	_, ok := <-c
+	assert.False(t, ok)
+}